@@ -0,0 +1,66 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package assets provides the templates, stylesheets, and other static files
+// used by bagoup's rich exports. The files are embedded in the binary, and
+// each of them can be overridden by a file of the same name in an override
+// directory.
+package assets
+
+import (
+	"embed"
+	"html/template"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+//go:embed static
+var _embedded embed.FS
+
+// Assets reads static files, preferring those in the override directory, if
+// any, to the embedded ones.
+type Assets struct {
+	fs          afero.Fs
+	overrideDir string
+}
+
+// New returns Assets which read overrides from the given directory on the
+// given filesystem. An empty directory disables overrides.
+func New(fs afero.Fs, overrideDir string) Assets {
+	return Assets{fs: fs, overrideDir: overrideDir}
+}
+
+// ReadFile returns the contents of the named asset, e.g. "style.css".
+func (a Assets) ReadFile(name string) ([]byte, error) {
+	if a.overrideDir != "" {
+		b, err := afero.ReadFile(a.fs, path.Join(a.overrideDir, name))
+		if err == nil {
+			return b, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "read asset %q from %q", name, a.overrideDir)
+		}
+	}
+	b, err := _embedded.ReadFile(path.Join("static", name))
+	return b, errors.Wrapf(err, "read built-in asset %q", name)
+}
+
+// HTMLTemplate parses the named asset as an HTML template. Templates can
+// inline CSS assets with {{asset "style.css"}}.
+func (a Assets) HTMLTemplate(name string) (*template.Template, error) {
+	b, err := a.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	funcs := template.FuncMap{
+		"asset": func(name string) (template.CSS, error) {
+			b, err := a.ReadFile(name)
+			return template.CSS(b), err
+		},
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Parse(string(b))
+	return tmpl, errors.Wrapf(err, "parse template %q", name)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package assets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		msg         string
+		overrideDir string
+		roFs        bool
+		name        string
+		wantPrefix  string
+		wantErr     string
+	}{
+		{
+			msg:        "built-in asset",
+			name:       "style.css",
+			wantPrefix: "body {",
+		},
+		{
+			msg:         "overridden asset",
+			overrideDir: "custom",
+			name:        "style.css",
+			wantPrefix:  "body { color: red; }",
+		},
+		{
+			msg:         "asset missing from override directory",
+			overrideDir: "custom",
+			name:        "stats.html",
+			wantPrefix:  "<!DOCTYPE html>",
+		},
+		{
+			msg:     "unknown asset",
+			name:    "nope.css",
+			wantErr: `read built-in asset "nope.css": open static/nope.css: file does not exist`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "custom/style.css", []byte("body { color: red; }"), 0644)
+			a := New(fs, tt.overrideDir)
+
+			b, err := a.ReadFile(tt.name)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Assert(t, strings.HasPrefix(string(b), tt.wantPrefix), string(b))
+		})
+	}
+}
+
+func TestHTMLTemplate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "custom/page.html", []byte(`<style>{{asset "style.css"}}</style><p>{{.}}</p>`), 0644)
+	afero.WriteFile(fs, "custom/style.css", []byte("p { color: red; }"), 0644)
+	afero.WriteFile(fs, "custom/bad.html", []byte("{{.Title"), 0644)
+	a := New(fs, "custom")
+
+	tmpl, err := a.HTMLTemplate("page.html")
+	assert.NilError(t, err)
+	var b bytes.Buffer
+	assert.NilError(t, tmpl.Execute(&b, "<hi>"))
+	assert.Equal(t, "<style>p { color: red; }</style><p>&lt;hi&gt;</p>", b.String())
+
+	_, err = a.HTMLTemplate("bad.html")
+	assert.ErrorContains(t, err, `parse template "bad.html"`)
+
+	_, err = a.HTMLTemplate("nope.html")
+	assert.ErrorContains(t, err, `read built-in asset "nope.html"`)
+}
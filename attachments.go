@@ -0,0 +1,475 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-vcard"
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _objectReplacementChar is the placeholder that Messages stores in the text
+// of a message for each attachment included in the message.
+const _objectReplacementChar = "\ufffc"
+
+// _attachmentReportFilename is the name of the report of missing and corrupt
+// attachments, written to the export folder.
+const _attachmentReportFilename = "attachment-report.csv"
+
+// _expiredAudioSummary replaces the placeholder of an audio message whose
+// attachment was deleted when it expired.
+const _expiredAudioSummary = "Audio message (expired, not kept)"
+
+// attachmentRef is an attachment of an exported message.
+type attachmentRef struct {
+	chat      string
+	messageID int
+	att       chatdb.Attachment
+}
+
+// exportAttachments queues the given attachments for copying into the
+// attachments folder of the given chat directory (if a copier is given), and
+// replaces their placeholders in the given message with summaries of any
+// shared contact cards and calendar invites, and with the text recognized in
+// images if ocr is set. The video of a Live Photo is
+// copied with its photo, and its placeholder is removed from the message.
+func exportAttachments(s opsys.OS, msg string, attachments []chatdb.Attachment, chatDirPath string, copier *attachmentCopier, ocr bool) (string, error) {
+	pairs := livePhotoPairs(attachments)
+	videos := make(map[int]bool, len(pairs))
+	for _, v := range pairs {
+		videos[v] = true
+	}
+	summaries := make([]string, len(attachments))
+	for i, att := range attachments {
+		if att.Filename == "" {
+			continue
+		}
+		if leavesFolder(att.Filename) {
+			logging.Warnf("attachment path %q leaves its folder - skipping it", att.Filename)
+			continue
+		}
+		if videos[i] {
+			continue
+		}
+		attPath, err := s.ExpandHome(att.Filename)
+		if err != nil {
+			return "", errors.Wrapf(err, "expand attachment path %q", att.Filename)
+		}
+		if copier != nil {
+			attDirPath := path.Join(chatDirPath, "attachments")
+			if err := s.MkdirAll(attDirPath, 0755); err != nil {
+				return "", errors.Wrapf(err, "create directory %q", attDirPath)
+			}
+			if v, ok := pairs[i]; ok {
+				videoPath, err := s.ExpandHome(attachments[v].Filename)
+				if err != nil {
+					return "", errors.Wrapf(err, "expand attachment path %q", attachments[v].Filename)
+				}
+				copier.addLivePhoto(attPath, videoPath, attDirPath, att.TotalBytes+attachments[v].TotalBytes)
+			} else {
+				copier.add(attPath, attDirPath, att.TotalBytes)
+			}
+		}
+		summaries[i], err = summarizeAttachment(s, att, attPath, ocr)
+		if os.IsNotExist(err) {
+			logging.Warnf("attachment %q does not exist locally", attPath)
+		} else if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.Wrap(err, "recognize text in images - FIX: install tesseract, e.g. with 'brew install tesseract', or remove --ocr")
+		} else if err != nil {
+			return "", errors.Wrapf(err, "summarize attachment %q", attPath)
+		}
+	}
+	msg, summaries = dropPlaceholders(msg, summaries, videos)
+	return insertSummaries(msg, summaries), nil
+}
+
+// livePhotoPairs pairs the photos of Live Photos among the given attachments
+// of a message with their videos, by their indexes. The photo and the video
+// of a Live Photo arrive as two attachments with the same base name, e.g.
+// IMG_1234.HEIC and IMG_1234.MOV. Only attachments which are copied are
+// paired, so that the video of a photo which is not copied is copied on its
+// own.
+func livePhotoPairs(attachments []chatdb.Attachment) map[int]int {
+	photos := map[string]int{}
+	for i, att := range attachments {
+		if copyable(att) && isLivePhotoStill(att) {
+			photos[livePhotoKey(att)] = i
+		}
+	}
+	pairs := map[int]int{}
+	for i, att := range attachments {
+		if !copyable(att) || !isLivePhotoVideo(att) {
+			continue
+		}
+		p, ok := photos[livePhotoKey(att)]
+		if _, paired := pairs[p]; ok && !paired {
+			pairs[p] = i
+		}
+	}
+	return pairs
+}
+
+// copyable reports whether the given attachment is copied by
+// exportAttachments, i.e. it is downloaded and its path stays in its folder.
+func copyable(att chatdb.Attachment) bool {
+	return att.Filename != "" && !leavesFolder(att.Filename)
+}
+
+func isLivePhotoStill(att chatdb.Attachment) bool {
+	switch strings.ToLower(path.Ext(attachmentName(att))) {
+	case ".heic", ".heif", ".jpg", ".jpeg":
+		return true
+	}
+	return att.MIMEType == "image/heic" || att.MIMEType == "image/jpeg"
+}
+
+func isLivePhotoVideo(att chatdb.Attachment) bool {
+	return strings.EqualFold(path.Ext(attachmentName(att)), ".mov") || att.MIMEType == "video/quicktime"
+}
+
+// livePhotoKey is the base name of the given attachment without its
+// extension, which the photo and the video of a Live Photo share.
+func livePhotoKey(att chatdb.Attachment) string {
+	name := attachmentName(att)
+	return strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+}
+
+// attachmentName is the name of the given attachment as it was sent, or the
+// name of its file.
+func attachmentName(att chatdb.Attachment) string {
+	if att.TransferName != "" {
+		return att.TransferName
+	}
+	return path.Base(att.Filename)
+}
+
+func leavesFolder(attPath string) bool {
+	for _, elem := range strings.Split(attPath, "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isExpiredAudio checks whether the given message is an audio message which
+// was not kept, and none of whose attachments exist anymore.
+func isExpiredAudio(s opsys.OS, msg chatdb.Message, attachments []chatdb.Attachment) (bool, error) {
+	if !msg.UnkeptAudio {
+		return false, nil
+	}
+	for _, att := range attachments {
+		if att.Filename == "" {
+			continue
+		}
+		attPath, err := s.ExpandHome(att.Filename)
+		if err != nil {
+			return false, errors.Wrapf(err, "expand attachment path %q", att.Filename)
+		}
+		if _, err := s.Stat(attPath); err == nil {
+			return false, nil
+		} else if !os.IsNotExist(err) {
+			return false, errors.Wrapf(err, "check attachment %q", attPath)
+		}
+	}
+	return true, nil
+}
+
+// summarizeAttachment returns a one-line summary of the given attachment if it
+// is a contact card, a calendar invite, an audio message with a transcript, or
+// an image with text in it, if ocr is set, and an empty string otherwise.
+func summarizeAttachment(s opsys.OS, att chatdb.Attachment, attPath string, ocr bool) (string, error) {
+	var summarize func(io.Reader) (string, error)
+	switch ext := strings.ToLower(path.Ext(attPath)); {
+	case att.Transcript != "":
+		return fmt.Sprintf("Audio transcript: %s", strings.Join(strings.Fields(att.Transcript), " ")), nil
+	case ocr && isOCRImage(att, ext):
+		return summarizeImageText(s, attPath)
+	case att.MIMEType == "text/vcard" || att.MIMEType == "text/x-vcard" || ext == ".vcf":
+		summarize = summarizeVCard
+	case att.MIMEType == "text/calendar" || ext == ".ics":
+		summarize = summarizeICS
+	default:
+		return "", nil
+	}
+	f, err := s.Open(attPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return summarize(f)
+}
+
+// isOCRImage checks if the given attachment is an image in a format which
+// tesseract reads. HEIC photos are not, but screenshots are PNG.
+func isOCRImage(att chatdb.Attachment, ext string) bool {
+	switch att.MIMEType {
+	case "image/png", "image/jpeg", "image/tiff", "image/gif", "image/bmp", "image/webp":
+		return true
+	}
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".tif", ".tiff", ".gif", ".bmp", ".webp":
+		return true
+	}
+	return false
+}
+
+// summarizeImageText returns the text recognized in the image at the given
+// path, e.g. "Text in image: Dinner at 8 Zuni Cafe", or an empty string if it
+// has none. Images in which text cannot be recognized are logged and left
+// unsummarized.
+func summarizeImageText(s opsys.OS, attPath string) (string, error) {
+	if _, err := s.Stat(attPath); err != nil {
+		return "", err
+	}
+	text, err := s.RecognizeText(attPath)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", err
+	} else if err != nil {
+		logging.Warnf("%s", err)
+		return "", nil
+	}
+	if words := strings.Fields(text); len(words) > 0 {
+		return fmt.Sprintf("Text in image: %s", strings.Join(words, " ")), nil
+	}
+	return "", nil
+}
+
+// summarizeVCard summarizes the cards in a vCard file like
+// "Shared contact: Jane Doe, +1 415 555 5555, jane@example.com".
+func summarizeVCard(r io.Reader) (string, error) {
+	dec := vcard.NewDecoder(r)
+	var contacts []string
+	for {
+		card, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "decode vcard")
+		}
+		fields := []string{card.PreferredValue(vcard.FieldFormattedName)}
+		fields = append(fields, card.Values(vcard.FieldTelephone)...)
+		fields = append(fields, card.Values(vcard.FieldEmail)...)
+		contacts = append(contacts, strings.Join(nonEmpty(fields), ", "))
+	}
+	if len(contacts) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("Shared contact: %s", strings.Join(contacts, "; ")), nil
+}
+
+// summarizeICS summarizes the events in an iCalendar file like
+// "Invite: Dinner, Fri Mar 6 2020 7:00PM, Zuni Cafe".
+func summarizeICS(r io.Reader) (string, error) {
+	var events []string
+	var inEvent bool
+	var fields map[string]string
+	var startParams string
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return "", errors.Wrap(err, "read iCalendar file")
+	}
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		name, value := strings.ToUpper(line[:colon]), line[colon+1:]
+		var params string
+		if semicolon := strings.Index(name, ";"); semicolon >= 0 {
+			name, params = name[:semicolon], line[semicolon+1:colon]
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent, fields, startParams = true, map[string]string{}, ""
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+			event := []string{fields["SUMMARY"], formatICSTime(fields["DTSTART"], startParams), fields["LOCATION"]}
+			events = append(events, strings.Join(nonEmpty(event), ", "))
+		case inEvent:
+			fields[name] = unescapeICS(value)
+			if name == "DTSTART" {
+				startParams = params
+			}
+		}
+	}
+	if len(events) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("Invite: %s", strings.Join(events, "; ")), nil
+}
+
+// unfoldICS reads the content lines of an iCalendar file, joining lines which
+// were folded per RFC 5545 section 3.1.
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func unescapeICS(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// formatICSTime formats an iCalendar DATE or DATE-TIME value in local time,
+// returning the raw value if it cannot be parsed.
+func formatICSTime(value, params string) string {
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Format("Mon Jan 2 2006")
+	}
+	loc := time.Local
+	for _, param := range strings.Split(params, ";") {
+		if strings.HasPrefix(strings.ToUpper(param), "TZID=") {
+			if l, err := time.LoadLocation(strings.Trim(param[len("TZID="):], `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation("20060102T150405", strings.TrimSuffix(value, "Z"), loc)
+	if err != nil {
+		return value
+	}
+	return t.In(time.Local).Format("Mon Jan 2 2006 3:04PM")
+}
+
+// verifyAttachments checks that each of the given attachments exists with the
+// size recorded in the database, returning a report record for each
+// attachment which does not.
+func verifyAttachments(s opsys.OS, refs []attachmentRef) ([][]string, error) {
+	var problems [][]string
+	for _, ref := range refs {
+		var problem string
+		var actualBytes int64
+		if ref.att.Filename == "" {
+			problem = "no path recorded"
+		} else if leavesFolder(ref.att.Filename) {
+			problem = "unsafe path"
+		} else {
+			attPath, err := s.ExpandHome(ref.att.Filename)
+			if err != nil {
+				return nil, errors.Wrapf(err, "expand attachment path %q", ref.att.Filename)
+			}
+			info, err := s.Stat(attPath)
+			switch {
+			case os.IsNotExist(err):
+				problem = "missing"
+			case err != nil:
+				return nil, errors.Wrapf(err, "check attachment %q", attPath)
+			case ref.att.TotalBytes > 0 && info.Size() != ref.att.TotalBytes:
+				problem, actualBytes = "size mismatch", info.Size()
+			}
+		}
+		if problem != "" {
+			problems = append(problems, []string{
+				ref.chat,
+				fmt.Sprint(ref.messageID),
+				ref.att.Filename,
+				problem,
+				fmt.Sprint(ref.att.TotalBytes),
+				fmt.Sprint(actualBytes),
+			})
+		}
+	}
+	return problems, nil
+}
+
+// writeAttachmentReport writes the given problems to a CSV file in the export
+// folder, returning the path of the file.
+func writeAttachmentReport(s opsys.OS, exportPath string, problems [][]string) (string, error) {
+	reportPath := path.Join(exportPath, _attachmentReportFilename)
+	records := append([][]string{{"chat", "message_id", "path", "problem", "expected_bytes", "actual_bytes"}}, problems...)
+	return reportPath, exporter.WriteFile(s, reportPath, func(w io.Writer) error {
+		return csv.NewWriter(w).WriteAll(records)
+	})
+}
+
+// dropPlaceholders removes the placeholders of the attachments with the given
+// indexes from the message, along with their summaries.
+func dropPlaceholders(msg string, summaries []string, drop map[int]bool) (string, []string) {
+	if len(drop) == 0 {
+		return msg, summaries
+	}
+	parts := strings.Split(msg, _objectReplacementChar)
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if !drop[i-1] {
+			b.WriteString(_objectReplacementChar)
+		}
+		b.WriteString(parts[i])
+	}
+	kept := make([]string, 0, len(summaries))
+	for i, summary := range summaries {
+		if !drop[i] {
+			kept = append(kept, summary)
+		}
+	}
+	return b.String(), kept
+}
+
+// insertSummaries replaces the attachment placeholders in the given message
+// with the corresponding non-empty summaries. Summaries without a matching
+// placeholder are appended to the end of the message.
+func insertSummaries(msg string, summaries []string) string {
+	body := strings.TrimSuffix(msg, "\n")
+	parts := strings.Split(body, _objectReplacementChar)
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if i-1 < len(summaries) && summaries[i-1] != "" {
+			b.WriteString(summaries[i-1])
+		} else {
+			b.WriteString(_objectReplacementChar)
+		}
+		b.WriteString(parts[i])
+	}
+	var extra []string
+	for i := len(parts) - 1; i < len(summaries); i++ {
+		if summaries[i] != "" {
+			extra = append(extra, summaries[i])
+		}
+	}
+	if len(extra) > 0 {
+		b.WriteString(" " + strings.Join(extra, " "))
+	}
+	if strings.HasSuffix(msg, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func nonEmpty(strs []string) []string {
+	var out []string
+	for _, s := range strs {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
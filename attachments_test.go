@@ -0,0 +1,524 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestExportAttachments(t *testing.T) {
+	tests := []struct {
+		msg         string
+		attachments []chatdb.Attachment
+		copyAtts    bool
+		roFs        bool
+		wantMessage string
+		wantFiles   map[string]string
+		wantErr     string
+	}{
+		{
+			msg: "summaries without copying",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/jane.vcf", MIMEType: "text/vcard"},
+				{ID: 2, Filename: "/attachments/dinner.ics", MIMEType: "text/calendar"},
+			},
+			wantMessage: "[2020-03-01 15:34:05] Novak: Shared contact: Jane Doe, +14155555555 and Invite: Dinner, Zuni Cafe\n",
+		},
+		{
+			msg: "copy attachments",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/jane.vcf", MIMEType: "text/vcard"},
+				{ID: 3, Filename: "/attachments/photo.jpeg", MIMEType: "image/jpeg"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: Shared contact: Jane Doe, +14155555555 and \ufffc\n",
+			wantFiles: map[string]string{
+				"backup/Novak/attachments/jane.vcf":   "BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n",
+				"backup/Novak/attachments/photo.jpeg": "jpeg data",
+			},
+		},
+		{
+			msg: "live photo",
+			attachments: []chatdb.Attachment{
+				{ID: 6, Filename: "/attachments/IMG_1234.HEIC", MIMEType: "image/heic", TransferName: "IMG_1234.HEIC"},
+				{ID: 7, Filename: "/attachments/IMG_1234.MOV", MIMEType: "video/quicktime", TransferName: "IMG_1234.MOV"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \n",
+			wantFiles: map[string]string{
+				"backup/Novak/attachments/IMG_1234.HEIC": "heic data",
+				"backup/Novak/attachments/IMG_1234.MOV":  "mov data",
+			},
+		},
+		{
+			msg: "live photo leaving its folder",
+			attachments: []chatdb.Attachment{
+				{ID: 6, Filename: "/attachments/../secrets/IMG_1234.HEIC", MIMEType: "image/heic", TransferName: "IMG_1234.HEIC"},
+				{ID: 7, Filename: "/attachments/IMG_1234.MOV", MIMEType: "video/quicktime", TransferName: "IMG_1234.MOV"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+			wantFiles: map[string]string{
+				"backup/Novak/attachments/IMG_1234.MOV": "mov data",
+			},
+		},
+		{
+			msg: "audio transcript",
+			attachments: []chatdb.Attachment{
+				{ID: 8, Filename: "/attachments/Audio Message.caf", MIMEType: "audio/x-caf", Transcript: "See you at the club\nat 4"},
+				{ID: 9, Filename: "/attachments/missing.caf", MIMEType: "audio/x-caf", Transcript: "Running late"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: Audio transcript: See you at the club at 4 and Audio transcript: Running late\n",
+			wantFiles: map[string]string{
+				"backup/Novak/attachments/Audio Message.caf": "caf data",
+			},
+		},
+		{
+			msg: "missing attachment",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/missing.vcf", MIMEType: "text/vcard"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+		},
+		{
+			msg: "path leaving its folder",
+			attachments: []chatdb.Attachment{
+				{ID: 5, Filename: "/attachments/../secrets/jane.vcf", MIMEType: "text/vcard"},
+			},
+			copyAtts:    true,
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+		},
+		{
+			msg: "copy error",
+			attachments: []chatdb.Attachment{
+				{ID: 3, Filename: "/attachments/photo.jpeg", MIMEType: "image/jpeg"},
+			},
+			copyAtts: true,
+			roFs:     true,
+			wantErr:  `create directory "backup/Novak/attachments": operation not permitted`,
+		},
+		{
+			msg: "bad vcard",
+			attachments: []chatdb.Attachment{
+				{ID: 4, Filename: "/attachments/bad.vcf", MIMEType: "text/vcard"},
+			},
+			wantErr: `summarize attachment "/attachments/bad.vcf": decode vcard: vcard: invalid BEGIN value`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/attachments/jane.vcf", []byte("BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n"), 0644)
+			afero.WriteFile(fs, "/attachments/dinner.ics", []byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Dinner\nLOCATION:Zuni Cafe\nEND:VEVENT\nEND:VCALENDAR\n"), 0644)
+			afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			afero.WriteFile(fs, "/attachments/IMG_1234.HEIC", []byte("heic data"), 0644)
+			afero.WriteFile(fs, "/attachments/IMG_1234.MOV", []byte("mov data"), 0644)
+			afero.WriteFile(fs, "/attachments/Audio Message.caf", []byte("caf data"), 0644)
+			afero.WriteFile(fs, "/attachments/bad.vcf", []byte("BEGIN::VCARD\n"), 0644)
+			afero.WriteFile(fs, "/secrets/jane.vcf", []byte("BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n"), 0644)
+			if tt.roFs {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+			s := opsys.NewOS(fs, nil, nil)
+
+			var copier *attachmentCopier
+			if tt.copyAtts {
+				copier = newAttachmentCopier(s, 2, 0, 0, false)
+			}
+			msg, err := exportAttachments(s, "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n", tt.attachments, "backup/Novak", copier, false)
+			if copier != nil {
+				assert.NilError(t, copier.wait())
+			}
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantMessage, msg)
+			for filename, expected := range tt.wantFiles {
+				actual, err := afero.ReadFile(fs, filename)
+				assert.NilError(t, err)
+				assert.Equal(t, expected, string(actual))
+			}
+		})
+	}
+}
+
+func TestExportAttachmentsOCR(t *testing.T) {
+	tests := []struct {
+		msg         string
+		attachments []chatdb.Attachment
+		ocrText     string
+		ocrErr      error
+		wantMessage string
+		wantErr     string
+	}{
+		{
+			msg: "text in images",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/screenshot.png", MIMEType: "image/png"},
+				{ID: 2, Filename: "/attachments/photo.jpeg"},
+			},
+			ocrText:     "Dinner at 8\n\nZuni Cafe\n",
+			wantMessage: "[2020-03-01 15:34:05] Novak: Text in image: Dinner at 8 Zuni Cafe and Text in image: Dinner at 8 Zuni Cafe\n",
+		},
+		{
+			msg: "no text",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/screenshot.png", MIMEType: "image/png"},
+			},
+			ocrText:     " \n",
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+		},
+		{
+			msg: "HEIC photo and missing image",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/IMG_1234.HEIC", MIMEType: "image/heic"},
+				{ID: 2, Filename: "/attachments/missing.png", MIMEType: "image/png"},
+			},
+			ocrText:     "Dinner at 8",
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+		},
+		{
+			msg: "tesseract error",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/screenshot.png", MIMEType: "image/png"},
+			},
+			ocrErr:      errors.New("this is a tesseract error"),
+			wantMessage: "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n",
+		},
+		{
+			msg: "no tesseract",
+			attachments: []chatdb.Attachment{
+				{ID: 1, Filename: "/attachments/screenshot.png", MIMEType: "image/png"},
+			},
+			ocrErr:  exec.ErrNotFound,
+			wantErr: "recognize text in images - FIX: install tesseract, e.g. with 'brew install tesseract', or remove --ocr: executable file not found in $PATH",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/attachments/screenshot.png", []byte("png data"), 0644)
+			afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			afero.WriteFile(fs, "/attachments/IMG_1234.HEIC", []byte("heic data"), 0644)
+			s := ocrOS{OS: opsys.NewOS(fs, nil, nil), text: tt.ocrText, err: tt.ocrErr}
+
+			msg, err := exportAttachments(s, "[2020-03-01 15:34:05] Novak: \ufffc and \ufffc\n", tt.attachments, "backup/Novak", nil, true)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantMessage, msg)
+		})
+	}
+}
+
+// ocrOS is an OS which recognizes the given text in every image.
+type ocrOS struct {
+	opsys.OS
+	text string
+	err  error
+}
+
+func (s ocrOS) RecognizeText(string) (string, error) {
+	return s.text, s.err
+}
+
+func TestSummarizeVCard(t *testing.T) {
+	tests := []struct {
+		msg         string
+		vcf         string
+		wantSummary string
+		wantErr     string
+	}{
+		{
+			msg: "one contact",
+			vcf: `BEGIN:VCARD
+VERSION:3.0
+FN:Novak Djokovic
+N:Djokovic;Novak;;;
+TEL;TYPE=CELL:+3815555555
+EMAIL;TYPE=INTERNET:info@novakdjokovic.com
+END:VCARD
+`,
+			wantSummary: "Shared contact: Novak Djokovic, +3815555555, info@novakdjokovic.com",
+		},
+		{
+			msg: "two contacts",
+			vcf: `BEGIN:VCARD
+VERSION:3.0
+FN:Novak Djokovic
+TEL;TYPE=CELL:+3815555555
+END:VCARD
+BEGIN:VCARD
+VERSION:3.0
+FN:Jelena Djokovic
+END:VCARD
+`,
+			wantSummary: "Shared contact: Novak Djokovic, +3815555555; Jelena Djokovic",
+		},
+		{
+			msg: "empty file",
+		},
+		{
+			msg:     "bad vcard",
+			vcf:     "BEGIN::VCARD\n",
+			wantErr: "decode vcard: vcard: invalid BEGIN value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			summary, err := summarizeVCard(strings.NewReader(tt.vcf))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantSummary, summary)
+		})
+	}
+}
+
+func TestSummarizeICS(t *testing.T) {
+	localTime := time.Date(2020, time.March, 6, 19, 0, 0, 0, time.UTC).In(time.Local).Format("Mon Jan 2 2006 3:04PM")
+
+	tests := []struct {
+		msg         string
+		ics         string
+		wantSummary string
+	}{
+		{
+			msg:         "UTC start time",
+			ics:         "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nSUMMARY:Dinner\\, drinks\r\nDTSTART:20200306T190000Z\r\nLOCATION:Zuni \r\n Cafe\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+			wantSummary: "Invite: Dinner, drinks, " + localTime + ", Zuni Cafe",
+		},
+		{
+			msg:         "all-day event",
+			ics:         "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Dubai Open\nDTSTART;VALUE=DATE:20200224\nEND:VEVENT\nEND:VCALENDAR\n",
+			wantSummary: "Invite: Dubai Open, Mon Feb 24 2020",
+		},
+		{
+			msg:         "unparseable start time",
+			ics:         "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Tennis\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n",
+			wantSummary: "Invite: Tennis, tomorrow",
+		},
+		{
+			msg: "no events",
+			ics: "BEGIN:VCALENDAR\nEND:VCALENDAR\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			summary, err := summarizeICS(strings.NewReader(tt.ics))
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantSummary, summary)
+		})
+	}
+}
+
+func TestInsertSummaries(t *testing.T) {
+	tests := []struct {
+		msg       string
+		message   string
+		summaries []string
+		want      string
+	}{
+		{
+			msg:     "no attachments",
+			message: "[2020-03-01 15:34:05] Me: Want to play tennis?\n",
+			want:    "[2020-03-01 15:34:05] Me: Want to play tennis?\n",
+		},
+		{
+			msg:       "partial summaries",
+			message:   "[2020-03-01 15:34:05] Me: \ufffc\ufffc\n",
+			summaries: []string{"", "Invite: Tennis"},
+			want:      "[2020-03-01 15:34:05] Me: \ufffcInvite: Tennis\n",
+		},
+		{
+			msg:       "missing placeholder",
+			message:   "[2020-03-01 15:34:05] Me: Here you go\n",
+			summaries: []string{"Shared contact: Jane Doe"},
+			want:      "[2020-03-01 15:34:05] Me: Here you go Shared contact: Jane Doe\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, insertSummaries(tt.message, tt.summaries))
+		})
+	}
+}
+
+func TestLivePhotoPairs(t *testing.T) {
+	tests := []struct {
+		msg         string
+		attachments []chatdb.Attachment
+		want        map[int]int
+	}{
+		{
+			msg: "live photo",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/jane.vcf"},
+				{Filename: "/attachments/a/IMG_1234.HEIC"},
+				{Filename: "/attachments/b/IMG_1234.MOV"},
+			},
+			want: map[int]int{1: 2},
+		},
+		{
+			msg: "transfer names and mime types",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/b/video", MIMEType: "video/quicktime", TransferName: "img_1234.mov"},
+				{Filename: "/attachments/a/photo", MIMEType: "image/jpeg", TransferName: "IMG_1234.JPG"},
+			},
+			want: map[int]int{1: 0},
+		},
+		{
+			msg: "different names",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/IMG_1234.HEIC"},
+				{Filename: "/attachments/IMG_1235.MOV"},
+			},
+			want: map[int]int{},
+		},
+		{
+			msg: "two videos",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/IMG_1234.HEIC"},
+				{Filename: "/attachments/a/IMG_1234.MOV"},
+				{Filename: "/attachments/b/IMG_1234.MOV"},
+			},
+			want: map[int]int{0: 1},
+		},
+		{
+			msg: "attachment not downloaded",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/IMG_1234.HEIC"},
+				{TransferName: "IMG_1234.MOV", MIMEType: "video/quicktime"},
+			},
+			want: map[int]int{},
+		},
+		{
+			msg: "photo leaving its folder",
+			attachments: []chatdb.Attachment{
+				{Filename: "/attachments/../IMG_1234.HEIC"},
+				{Filename: "/attachments/IMG_1234.MOV"},
+			},
+			want: map[int]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, livePhotoPairs(tt.attachments))
+		})
+	}
+}
+
+func TestDropPlaceholders(t *testing.T) {
+	msg, summaries := dropPlaceholders("[2020-03-01 15:34:05] Me: \ufffc\ufffc\ufffc\n", []string{"", "", "Invite: Tennis"}, map[int]bool{1: true})
+	assert.Equal(t, "[2020-03-01 15:34:05] Me: \ufffc\ufffc\n", msg)
+	assert.DeepEqual(t, []string{"", "Invite: Tennis"}, summaries)
+}
+
+func TestIsExpiredAudio(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/attachments/kept.caf", []byte("audio data"), 0644)
+	s := opsys.NewOS(fs, nil, nil)
+
+	tests := []struct {
+		msg         string
+		unkept      bool
+		attachments []chatdb.Attachment
+		want        bool
+	}{
+		{
+			msg:         "regular message",
+			attachments: []chatdb.Attachment{{Filename: "/attachments/missing.caf"}},
+		},
+		{
+			msg:         "attachment deleted",
+			unkept:      true,
+			attachments: []chatdb.Attachment{{Filename: "/attachments/missing.caf"}},
+			want:        true,
+		},
+		{
+			msg:    "no attachment",
+			unkept: true,
+			want:   true,
+		},
+		{
+			msg:         "attachment not deleted yet",
+			unkept:      true,
+			attachments: []chatdb.Attachment{{}, {Filename: "/attachments/kept.caf"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			expired, err := isExpiredAudio(s, chatdb.Message{UnkeptAudio: tt.unkept}, tt.attachments)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, expired)
+		})
+	}
+}
+
+func TestVerifyAttachments(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+	s := opsys.NewOS(fs, nil, nil)
+
+	problems, err := verifyAttachments(s, []attachmentRef{
+		{chat: "Novak", messageID: 1, att: chatdb.Attachment{Filename: "/attachments/photo.jpeg", TotalBytes: 9}},
+		{chat: "Novak", messageID: 2, att: chatdb.Attachment{Filename: "/attachments/photo.jpeg"}},
+		{chat: "Novak", messageID: 3, att: chatdb.Attachment{Filename: "/attachments/photo.jpeg", TotalBytes: 1024}},
+		{chat: "Novak", messageID: 4, att: chatdb.Attachment{Filename: "/attachments/missing.jpeg", TotalBytes: 1024}},
+		{chat: "Jelena", messageID: 5, att: chatdb.Attachment{}},
+		{chat: "Jelena", messageID: 6, att: chatdb.Attachment{Filename: "/attachments/../photo.jpeg"}},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, [][]string{
+		{"Novak", "3", "/attachments/photo.jpeg", "size mismatch", "1024", "9"},
+		{"Novak", "4", "/attachments/missing.jpeg", "missing", "1024", "0"},
+		{"Jelena", "5", "", "no path recorded", "0", "0"},
+		{"Jelena", "6", "/attachments/../photo.jpeg", "unsafe path", "0", "0"},
+	}, problems)
+}
+
+func TestWriteAttachmentReport(t *testing.T) {
+	problems := [][]string{{"Novak", "4", "/attachments/missing.jpeg", "missing", "1024", "0"}}
+
+	t.Run("success", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		s := opsys.NewOS(fs, nil, nil)
+		reportPath, err := writeAttachmentReport(s, "backup", problems)
+		assert.NilError(t, err)
+		assert.Equal(t, "backup/attachment-report.csv", reportPath)
+		report, err := afero.ReadFile(fs, reportPath)
+		assert.NilError(t, err)
+		assert.Equal(t, "chat,message_id,path,problem,expected_bytes,actual_bytes\nNovak,4,/attachments/missing.jpeg,missing,1024,0\n", string(report))
+	})
+
+	t.Run("create error", func(t *testing.T) {
+		s := opsys.NewOS(afero.NewReadOnlyFs(afero.NewMemMapFs()), nil, nil)
+		_, err := writeAttachmentReport(s, "backup", problems)
+		assert.ErrorContains(t, err, `create file "backup/attachment-report.csv.partial"`)
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
+)
+
+// _mentionAttribute is the attribute of the characters of a message which
+// mention a participant of a group chat, with the participant's handle.
+const _mentionAttribute = "__kIMMentionConfirmedMention"
+
+// Mention is a mention of a participant in a message, e.g. "@Novak" in a group
+// chat, with the text of the mention, e.g. "Novak", and the participant's
+// display handle.
+type Mention struct {
+	Text   string
+	Handle string
+}
+
+// Attributes of the characters of a message which are styled, since iOS 18,
+// with the number 1.
+const (
+	_boldAttribute          = "__kIMTextBoldAttributeName"
+	_italicAttribute        = "__kIMTextItalicAttributeName"
+	_underlineAttribute     = "__kIMTextUnderlineAttributeName"
+	_strikethroughAttribute = "__kIMTextStrikethroughAttributeName"
+)
+
+// TextStyle is a part of the text of a message which is styled, e.g. bold.
+type TextStyle struct {
+	Text          string
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+}
+
+// plain checks if the text is not styled.
+func (s TextStyle) plain() bool {
+	return !s.Bold && !s.Italic && !s.Underline && !s.Strikethrough
+}
+
+// sameStyle checks if the text has the same style as the given text.
+func (s TextStyle) sameStyle(other TextStyle) bool {
+	s.Text, other.Text = "", ""
+	return s == other
+}
+
+// attributeRun is a run of the characters of an attributed string which have
+// the same attributes.
+type attributeRun struct {
+	text  string
+	attrs map[string]interface{}
+}
+
+// decodeAttributedBody decodes the attributed body of a message, an
+// NSAttributedString archived in the typedstream format, into its runs. The
+// string is followed by the length of each run in UTF-16 code units, with the
+// index of its attributes; the attributes follow the first run which has them.
+func decodeAttributedBody(body []byte) ([]attributeRun, error) {
+	root, err := decodeTypedStream(body)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := root.(*archivedObject)
+	if !ok || len(obj.values) == 0 {
+		return nil, errors.New("not an attributed string")
+	}
+	text, ok := obj.values[0].(string)
+	if !ok {
+		return nil, errors.New("attributed string without text")
+	}
+	units := utf16.Encode([]rune(text))
+	attrs := map[int64]map[string]interface{}{}
+	var runs []attributeRun
+	start := 0
+	for i := 1; i < len(obj.values); {
+		if i+1 >= len(obj.values) {
+			return nil, errors.New("incomplete attribute run")
+		}
+		index, ok1 := obj.values[i].(int64)
+		length, ok2 := obj.values[i+1].(int64)
+		if !ok1 || !ok2 || length < 0 || length > int64(len(units)-start) {
+			return nil, errors.Errorf("invalid attribute run %v", obj.values[i:i+2])
+		}
+		i += 2
+		if i < len(obj.values) {
+			if dictObj, ok := obj.values[i].(*archivedObject); ok {
+				dict, ok := dictObj.dictionary()
+				if !ok {
+					return nil, errors.Errorf("invalid attributes of class %q", dictObj.class)
+				}
+				attrs[index] = dict
+				i++
+			}
+		}
+		end := start + int(length)
+		runs = append(runs, attributeRun{text: string(utf16.Decode(units[start:end])), attrs: attrs[index]})
+		start = end
+	}
+	return runs, nil
+}
+
+// getFormatting gets the mentions of participants and the styled text in the
+// attributed body of the message with the given ID. Attributed bodies which
+// cannot be decoded have neither.
+func (d *chatDB) getFormatting(messageID int, attributedBody []byte, handleMap map[int]string) ([]Mention, []TextStyle) {
+	if len(attributedBody) == 0 {
+		return nil, nil
+	}
+	runs, err := decodeAttributedBody(attributedBody)
+	if err != nil {
+		logging.Debugf("decode attributed body of message ID %d: %s", messageID, err)
+		return nil, nil
+	}
+	return d.getMentions(runs, handleMap), getTextStyles(runs)
+}
+
+// getMentions gets the mentions of participants in the given runs, with the
+// handles of the participants resolved to their display handles. Adjacent runs
+// mentioning the same participant are one mention.
+func (d *chatDB) getMentions(runs []attributeRun, handleMap map[int]string) []Mention {
+	var mentions []Mention
+	prev := ""
+	for _, run := range runs {
+		handle, _ := run.attrs[_mentionAttribute].(string)
+		switch {
+		case handle == "":
+		case handle == prev:
+			mentions[len(mentions)-1].Text += run.text
+		default:
+			mentions = append(mentions, Mention{Text: run.text, Handle: d.displayHandle(handle, handleMap)})
+		}
+		prev = handle
+	}
+	return mentions
+}
+
+// getTextStyles gets the styled text in the given runs. Adjacent runs with the
+// same style are one styled text.
+func getTextStyles(runs []attributeRun) []TextStyle {
+	var styles []TextStyle
+	var prev TextStyle
+	for _, run := range runs {
+		style := TextStyle{
+			Text:          run.text,
+			Bold:          hasAttribute(run.attrs, _boldAttribute),
+			Italic:        hasAttribute(run.attrs, _italicAttribute),
+			Underline:     hasAttribute(run.attrs, _underlineAttribute),
+			Strikethrough: hasAttribute(run.attrs, _strikethroughAttribute),
+		}
+		switch {
+		case style.plain():
+		case prev.Text != "" && style.sameStyle(prev):
+			styles[len(styles)-1].Text += run.text
+		default:
+			styles = append(styles, style)
+		}
+		prev = style
+	}
+	return styles
+}
+
+// hasAttribute checks if the given attributes set the given attribute, to a
+// non-zero number.
+func hasAttribute(attrs map[string]interface{}, name string) bool {
+	obj, ok := attrs[name].(*archivedObject)
+	if !ok {
+		return false
+	}
+	n, ok := obj.number()
+	return ok && n != 0
+}
+
+// displayHandle returns the display handle of the given phone number or email
+// address, if it is one of the handles in the database.
+func (d *chatDB) displayHandle(handle string, handleMap map[int]string) string {
+	if handleID, ok := d.handleIDs[canonicalIdentity(handle)]; ok {
+		if display, ok := handleMap[handleID]; ok {
+			return display
+		}
+	}
+	return handle
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDecodeAttributedBody(t *testing.T) {
+	part := map[string]interface{}{"__kIMMessagePartAttributeName": 0}
+	mention := map[string]interface{}{"__kIMMessagePartAttributeName": 0, _mentionAttribute: "+14155555555"}
+
+	tests := []struct {
+		msg       string
+		body      []byte
+		wantTexts []string
+		wantErr   string
+	}{
+		{
+			msg:       "message",
+			body:      []byte(_testMessageBody),
+			wantTexts: []string{"Hello"},
+		},
+		{
+			msg: "runs",
+			body: testAttributedBody("Hi Novak, tennis?",
+				testRun{length: 3, index: 1, attrs: part},
+				testRun{length: 5, index: 2, attrs: mention},
+				testRun{length: 9, index: 1},
+			),
+			wantTexts: []string{"Hi ", "Novak", ", tennis?"},
+		},
+		{
+			msg: "surrogate pairs",
+			body: testAttributedBody("🎾 Novak",
+				testRun{length: 3, index: 1, attrs: part},
+				testRun{length: 5, index: 2, attrs: mention},
+			),
+			wantTexts: []string{"🎾 ", "Novak"},
+		},
+		{
+			msg:     "run too long",
+			body:    testAttributedBody("Hello", testRun{length: 6, index: 1, attrs: part}),
+			wantErr: "invalid attribute run [1 6]",
+		},
+		{
+			msg:     "not an attributed string",
+			body:    []byte("\x04\x0bstreamtyped\x81\xe8\x03\x84\x01i\x01"),
+			wantErr: "not an attributed string",
+		},
+		{
+			msg:     "not a typedstream",
+			body:    []byte("Hello"),
+			wantErr: "not a typedstream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			runs, err := decodeAttributedBody(tt.body)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			texts := make([]string, len(runs))
+			for i, run := range runs {
+				texts[i] = run.text
+			}
+			assert.DeepEqual(t, tt.wantTexts, texts)
+		})
+	}
+}
+
+func TestGetFormatting(t *testing.T) {
+	part := map[string]interface{}{"__kIMMessagePartAttributeName": 0}
+	mentionNovak := map[string]interface{}{"__kIMMessagePartAttributeName": 0, _mentionAttribute: "+1 (415) 555-5555"}
+	mentionJelena := map[string]interface{}{"__kIMMessagePartAttributeName": 1, _mentionAttribute: "jelena@example.com"}
+	bold := map[string]interface{}{"__kIMMessagePartAttributeName": 0, _boldAttribute: 1}
+	handleMap := map[int]string{10: "Novak"}
+	d := &chatDB{handleIDs: map[string]int{"+14155555555": 10}}
+
+	tests := []struct {
+		msg          string
+		body         []byte
+		wantMentions []Mention
+		wantStyles   []TextStyle
+	}{
+		{
+			msg: "mentions",
+			body: testAttributedBody("Novak and Jelena, tennis?",
+				testRun{length: 5, index: 1, attrs: mentionNovak},
+				testRun{length: 5, index: 2, attrs: part},
+				testRun{length: 6, index: 3, attrs: mentionJelena},
+				testRun{length: 9, index: 2},
+			),
+			wantMentions: []Mention{
+				{Text: "Novak", Handle: "Novak"},
+				{Text: "Jelena", Handle: "jelena@example.com"},
+			},
+		},
+		{
+			msg: "mention split into runs",
+			body: testAttributedBody("Hi Novak",
+				testRun{length: 3, index: 1, attrs: part},
+				testRun{length: 2, index: 2, attrs: mentionNovak},
+				testRun{length: 3, index: 3, attrs: map[string]interface{}{_mentionAttribute: "+1 (415) 555-5555", _boldAttribute: 1}},
+			),
+			wantMentions: []Mention{{Text: "Novak", Handle: "Novak"}},
+			wantStyles:   []TextStyle{{Text: "vak", Bold: true}},
+		},
+		{
+			msg: "styles",
+			body: testAttributedBody("Tennis today at noon? Sure",
+				testRun{length: 7, index: 1, attrs: bold},
+				testRun{length: 6, index: 2, attrs: map[string]interface{}{_boldAttribute: 1, _italicAttribute: 1}},
+				testRun{length: 3, index: 3, attrs: part},
+				testRun{length: 5, index: 4, attrs: map[string]interface{}{_underlineAttribute: 1, _strikethroughAttribute: 1}},
+				testRun{length: 1, index: 3},
+				testRun{length: 4, index: 1},
+				testRun{length: 0, index: 5, attrs: map[string]interface{}{_boldAttribute: 0}},
+			),
+			wantStyles: []TextStyle{
+				{Text: "Tennis ", Bold: true},
+				{Text: "today ", Bold: true, Italic: true},
+				{Text: "noon?", Underline: true, Strikethrough: true},
+				{Text: "Sure", Bold: true},
+			},
+		},
+		{
+			msg: "adjacent runs with the same style",
+			body: testAttributedBody("Tennis today",
+				testRun{length: 7, index: 1, attrs: bold},
+				testRun{length: 5, index: 2, attrs: map[string]interface{}{"__kIMMessagePartAttributeName": 1, _boldAttribute: 1}},
+			),
+			wantStyles: []TextStyle{{Text: "Tennis today", Bold: true}},
+		},
+		{
+			msg:  "no formatting",
+			body: []byte(_testMessageBody),
+		},
+		{
+			msg:  "invalid attributed body",
+			body: []byte("Hello"),
+		},
+		{
+			msg: "no attributed body",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			mentions, styles := d.getFormatting(1, tt.body, handleMap)
+			assert.DeepEqual(t, tt.wantMentions, mentions)
+			assert.DeepEqual(t, tt.wantStyles, styles)
+		})
+	}
+}
@@ -10,22 +10,58 @@
 package chatdb
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/binary"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/Masterminds/semver"
 	"github.com/emersion/go-vcard"
 	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
 )
 
 const _githubIssueMsg = "open an issue at https://github.com/tagatac/bagoup/issues"
 
-// Adapted from https://apple.stackexchange.com/a/300997/267331
+// Adapted from https://apple.stackexchange.com/a/300997/267331. The formulas
+// are formatted with the SQL expression for the timestamp to convert. Mac OS
+// 10.13 switched from seconds to nanoseconds since 2001-01-01, but databases
+// upgraded from earlier versions still contain dates in seconds, so the
+// modern formula decides the unit for each row by the magnitude of its date.
 const (
-	_datetimeFormulaLegacy = "date + STRFTIME('%s', '2001-01-01 00:00:00'), 'unixepoch', 'localtime'"
-	_datetimeFormula       = "(date/1000000000) + STRFTIME('%s', '2001-01-01 00:00:00'), 'unixepoch', 'localtime'"
+	_datetimeFormulaLegacy = "%s + STRFTIME('%%s', '2001-01-01 00:00:00'), 'unixepoch', 'localtime'"
+	_datetimeFormula       = "(CASE WHEN %[1]s > " + _nanosecondThreshold + " THEN %[1]s/1000000000 ELSE %[1]s END) + STRFTIME('%%s', '2001-01-01 00:00:00'), 'unixepoch', 'localtime'"
 )
 
+// _nanosecondThreshold separates dates in nanoseconds from dates in seconds.
+// It is about 17 minutes after 2001-01-01 in nanoseconds, and tens of
+// thousands of years later in seconds.
+const _nanosecondThreshold = "1000000000000"
+
+// Audio messages expire some time after being played unless they are kept,
+// which sets their expire_state to 3. The attachments of expired messages are
+// deleted.
+const _unkeptAudio = "(is_audio_message = 1 AND is_expirable = 1 AND expire_state != 3)"
+
+// Some messages have a zero or otherwise invalid date, which would sort them
+// to the beginning of their chat. For these messages, fall back to the date
+// delivered or the date read.
+const (
+	_effectiveDate = "(CASE WHEN date > 0 THEN date WHEN date_delivered > 0 THEN date_delivered ELSE date_read END)"
+	_dateSource    = "(CASE WHEN date > 0 THEN 0 WHEN date_delivered > 0 THEN 1 WHEN date_read > 0 THEN 2 ELSE 3 END)"
+	// _sortDate is the effective date in nanoseconds, for ordering messages
+	// with dates in either unit.
+	_sortDate = "(CASE WHEN " + _effectiveDate + " > " + _nanosecondThreshold + " THEN " + _effectiveDate + " ELSE " + _effectiveDate + " * 1000000000 END)"
+)
+
+// _datetimeLayout is the layout of the datetimes returned by SQLite's DATETIME
+// function.
+const _datetimeLayout = "2006-01-02 15:04:05"
+
 var _modernVersion = semver.MustParse("10.13")
 
 // Chat represents a row from the chat table.
@@ -33,60 +69,360 @@ type Chat struct {
 	ID          int
 	GUID        string
 	DisplayName string
+	// Service is the service of the chat, e.g. "iMessage" or "SMS", and
+	// Identifier identifies the chat within it, e.g. the handle of the other
+	// participant of a one-to-one chat, both parsed from its GUID with
+	// ParseGUID, so that they can name files without the rest of the GUID.
+	Service    string
+	Identifier string
+	// Pinned is set for chats pinned in Messages, where the properties of
+	// the chat record it.
+	Pinned bool
+	// Archived is set for chats which Messages marks as archived.
+	Archived bool
+	// MessageCount is the number of messages of the chat.
+	MessageCount int
+	// LastMessageDate is the date of the chat's last message, in local
+	// time, or the zero time if it has no messages.
+	LastMessageDate time.Time
+}
+
+// DateSource identifies the timestamp from which a message's date was taken.
+type DateSource int
+
+const (
+	// DateSent is the date the message was sent.
+	DateSent DateSource = iota
+	// DateDelivered is the date the message was delivered, used when the date
+	// sent is invalid.
+	DateDelivered
+	// DateRead is the date the message was read, used when the dates sent and
+	// delivered are invalid.
+	DateRead
+	// DateUnknown means that the message has no valid timestamp.
+	DateUnknown
+)
+
+// GroupAction is a change to the participants of a group chat, recorded as a
+// message.
+type GroupAction int
+
+const (
+	// NoGroupAction means the message is a regular message.
+	NoGroupAction GroupAction = iota
+	// ParticipantAdded means the sender added the other handle to the chat.
+	ParticipantAdded
+	// ParticipantRemoved means the sender removed the other handle from the
+	// chat.
+	ParticipantRemoved
+	// ParticipantLeft means the sender left the chat.
+	ParticipantLeft
+)
+
+// Message represents a row from the message table, with its sender resolved
+// to a display handle. For group actions, OtherHandleID is the participant
+// added or removed, and Text describes the action. UnkeptAudio is set for
+// audio messages which expire, so their attachments may have been deleted.
+// Edited is set for messages which were edited after they were sent. ReplyTo
+// is set for inline replies to other messages.
+type Message struct {
+	ID            int
+	Date          time.Time
+	DateSource    DateSource
+	HandleID      int
+	Handle        string
+	FromMe        bool
+	Text          string
+	Service       string
+	GroupAction   GroupAction
+	OtherHandleID int
+	UnkeptAudio   bool
+	Edited        bool
+	ReplyTo       *Reply
+	// Hints describe how the message was sent, where the database records
+	// it, e.g. "sent with Slam effect".
+	Hints []string
+	// Payment is the money sent with Apple Pay in the message, if any.
+	Payment *Payment
+	// Undecodable is set for messages whose attributed body or payload is
+	// malformed, so that some of their content may be missing.
+	Undecodable bool
+	// Mentions are the mentions of participants in the message, and Styles
+	// the styled parts of its text, e.g. bold text, each in the order in
+	// which they appear in its text.
+	Mentions []Mention
+	Styles   []TextStyle
+	// Reaction is set for tapbacks, which add or remove a reaction to
+	// another message.
+	Reaction bool
+	// RawText is the text of the message as stored in the database, with
+	// an object replacement character (U+FFFC) in place of each attachment,
+	// before attachments are summarized or any text is made up, e.g. for
+	// group actions. It is empty for messages without text.
+	RawText string
+}
+
+// Reply describes the message which a message replied to inline, e.g. a
+// photo, with the message's ID, its sender's display handle, whether you sent
+// it, and a summary of its content.
+type Reply struct {
+	ID     int
+	Handle string
+	FromMe bool
+	Text   string
+}
+
+// String formats the message for writing to a chat file, e.g.
+// "[2020-03-01 15:34:05] Novak: Want to play tennis?\n". Dates which are not
+// the date sent are flagged, e.g. "[2020-03-01 15:34:05 (delivered)]", and
+// edited messages end with "(edited)", followed by any hints, e.g. "(sent with
+// Digital Touch)". Replies are preceded by a line
+// summarizing the message replied to, e.g. "> In reply to Me: [photo]\n".
+func (m Message) String() string {
+	date := m.Date.Format(_datetimeLayout)
+	switch m.DateSource {
+	case DateDelivered:
+		date += " (delivered)"
+	case DateRead:
+		date += " (read)"
+	case DateUnknown:
+		date = "date unknown"
+	}
+	text := m.Text
+	if m.Edited {
+		text += " (edited)"
+	}
+	if len(m.Hints) > 0 {
+		text += fmt.Sprintf(" (%s)", strings.Join(m.Hints, ", "))
+	}
+	var reply string
+	if m.ReplyTo != nil {
+		reply = fmt.Sprintf("> In reply to %s: %s\n", m.ReplyTo.Handle, m.ReplyTo.Text)
+	}
+	return fmt.Sprintf("%s[%s] %s: %s\n", reply, date, m.Handle, text)
+}
+
+// RawMessage holds the fields of a row from the message table as stored, e.g.
+// for forensic exports. Text is nil if the row has no text. The dates are
+// the stored integers, in seconds or nanoseconds since 2001-01-01 depending on
+// the release of Mac OS which wrote them, and zero if unset.
+type RawMessage struct {
+	ROWID           int     `json:"rowid"`
+	GUID            string  `json:"guid"`
+	HandleID        int     `json:"handle_id"`
+	Text            *string `json:"text"`
+	AttributedBody  []byte  `json:"attributed_body,omitempty"`
+	Service         string  `json:"service"`
+	Account         string  `json:"account"`
+	Date            int64   `json:"date"`
+	DateRead        int64   `json:"date_read"`
+	DateDelivered   int64   `json:"date_delivered"`
+	IsFromMe        bool    `json:"is_from_me"`
+	IsRead          bool    `json:"is_read"`
+	IsDelivered     bool    `json:"is_delivered"`
+	IsSent          bool    `json:"is_sent"`
+	ItemType        int     `json:"item_type"`
+	GroupActionType int     `json:"group_action_type"`
+	Error           int     `json:"error"`
+}
+
+// Attachment represents a row from the attachment table.
+type Attachment struct {
+	ID           int
+	Filename     string
+	MIMEType     string
+	TransferName string
+	TotalBytes   int64
+	// Transcript is the transcript of an audio message which Mac OS 14 and
+	// later record with its attachment.
+	Transcript string
+}
+
+// ChatSize is the size of the contents of a chat in the database.
+type ChatSize struct {
+	Messages int
+	// TextBytes is the size of the text of the messages, or of their
+	// attributed bodies for messages whose text is only stored there.
+	TextBytes int64
+	// AttachmentBytes is the size of the attachments of the messages.
+	AttachmentBytes int64
+}
+
+// NameOrder specifies the order in which the parts of contacts' full names are
+// displayed.
+type NameOrder string
+
+const (
+	// GivenNameFirst displays full names as formatted in the contact card,
+	// e.g. "Novak Djokovic".
+	GivenNameFirst NameOrder = "given-first"
+	// FamilyNameFirst displays full names with the family name first, e.g.
+	// "Djokovic Novak". CJK names are joined without a space.
+	FamilyNameFirst NameOrder = "family-first"
+	// AutoNameOrder displays full names with the family name first only for
+	// contacts with phonetic name fields, as is common for CJK contacts.
+	AutoNameOrder NameOrder = "auto"
+)
+
+// NameFormat controls how contact names are displayed.
+type NameFormat struct {
+	Order NameOrder
+	// Honorifics includes honorific prefixes and suffixes, e.g. "Dr." and
+	// "Jr.", in full names.
+	Honorifics bool
 }
 
 //go:generate mockgen -destination=mock_chatdb/mock_chatdb.go github.com/tagatac/bagoup/chatdb ChatDB
 
 type (
-	// ChatDB extracts data from a Mac OS Messages database on disk.
+	// ChatDB extracts data from a Mac OS Messages database on disk. Its
+	// errors wrap ErrSchemaMismatch, ErrRowCorrupt, or ErrPermission when
+	// they are due to those causes.
 	ChatDB interface {
 		// GetHandleMap returns a mapping from handle ID to phone number or email
-		// address. If a contact map is supplied, it will attempt to resolve these
-		// handles to formatted names.
-		GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string, error)
+		// address. If a contact resolver is supplied, it will attempt to
+		// resolve these handles to formatted names.
+		GetHandleMap(contacts ContactResolver) (map[int]string, error)
+		// CanonicalHandle returns the ID of the first handle with the same
+		// phone number or email address as the given handle, since the same
+		// person may have a handle for each service, e.g. iMessage and SMS.
+		// It must be called after GetHandleMap.
+		CanonicalHandle(handleID int) int
+		// DisambiguateHandles returns the given handle map with the names
+		// of the participants of a chat, with the given handle IDs, told
+		// apart where different people have the same name, e.g. the given
+		// name "Alex". They are named by their full names, e.g. "Alex
+		// Smith", or else by the end of their phone numbers, e.g. "Alex
+		// (1234)". It must be called after GetHandleMap.
+		DisambiguateHandles(handleMap map[int]string, handleIDs []int) map[int]string
 		// GetChats returns a slice of Chat, effectively a table scan of the chat
 		// table.
-		GetChats(contactMap map[string]*vcard.Card) ([]Chat, error)
+		GetChats(contacts ContactResolver) ([]Chat, error)
+		// GetChatsForHandle returns the chats in which a given phone number or
+		// email address participates, resolving their display names like
+		// GetChats.
+		GetChatsForHandle(handle string, contacts ContactResolver) ([]Chat, error)
 		// GetMessageIDs returns a slice of message IDs corresponding to a given
 		// chat ID, in the order that the messages are timestamped.
 		GetMessageIDs(chatID int) ([]int, error)
-		// GetMessage returns a message retrieved from the database formatted for
-		// writing to a chat file.
-		GetMessage(messageID int, handleMap map[int]string, macOSVersion *semver.Version) (string, error)
+		// GetMessagesPage returns up to limit messages of a given chat, in the
+		// order that they are timestamped, starting after the given page token,
+		// and the page token of the next page. Pass an empty page token for the
+		// first page. The next page token is empty after the last page.
+		GetMessagesPage(chatID int, pageToken string, limit int, handleMap map[int]string, macOSVersion *semver.Version) ([]Message, string, error)
+		// GetMessage returns a message retrieved from the database, with its
+		// date in local time. If macOSVersion is nil, the date format is
+		// detected from the database contents.
+		GetMessage(messageID int, handleMap map[int]string, macOSVersion *semver.Version) (Message, error)
+		// GetMessageByGUID returns the message with the given GUID, e.g. as
+		// recorded by Apple's tools or in the forensic fields of an export,
+		// like GetMessage. Its error wraps ErrNotFound if there is no such
+		// message.
+		GetMessageByGUID(guid string, handleMap map[int]string, macOSVersion *semver.Version) (Message, error)
+		// GetRawMessage returns the fields of a message as stored in the
+		// database, without resolving its sender or converting its dates.
+		GetRawMessage(messageID int) (RawMessage, error)
+		// GetParticipants returns the IDs of the handles currently
+		// participating in a given chat, excluding the owner of the database.
+		GetParticipants(chatID int) ([]int, error)
+		// GetAttachmentPaths returns a mapping from message ID to the
+		// attachments included in that message, in the order that they were
+		// attached.
+		GetAttachmentPaths() (map[int][]Attachment, error)
+		// GetChatSizes returns a mapping from chat ID to the size of the
+		// contents of that chat, for estimating the size of an export.
+		GetChatSizes() (map[int]ChatSize, error)
+		// GetSyncedMessageCount returns the number of messages which
+		// Messages in iCloud has synced, which is zero if Messages in iCloud
+		// has never been enabled for the database.
+		GetSyncedMessageCount() (int, error)
+		// AddIndexes adds indexes on the columns by which exports look up
+		// rows, e.g. the chat IDs of chat_message_join, where the database
+		// has none, and returns their names, even if adding one fails. It
+		// changes the database, so it is for copies of chat.db.
+		AddIndexes() ([]string, error)
+		// DropIndexes drops the indexes with the given names, e.g. those
+		// added by AddIndexes.
+		DropIndexes(names []string) error
+		// GetDanglingJoins returns the rows of the join tables which point
+		// at messages, attachments, or handles missing from the database.
+		GetDanglingJoins() (DanglingJoins, error)
 	}
 
 	chatDB struct {
 		*sql.DB
+		// datetimeFormula is guarded by formulaMu, since it may be detected
+		// by concurrent queries.
 		datetimeFormula string
-		selfHandle      string
+		formulaMu       sync.Mutex
+		// schema is guarded by schemaMu, since queries may load it
+		// concurrently.
+		schema     *schema
+		schemaMu   sync.Mutex
+		selfHandle string
+		nameFormat NameFormat
+		// canonicalHandles maps handle IDs to the IDs of the first handles
+		// with the same identity.
+		canonicalHandles map[int]int
+		// handleIDs maps the identities of handles to the IDs of the first
+		// handles with them.
+		handleIDs map[string]int
+		// handleNames maps handle IDs to the handles and contacts naming
+		// them.
+		handleNames map[int]handleName
+		// retries is the number of times queries are retried while the
+		// database is locked, first after backoff and then after twice as
+		// long each time, waiting with sleep.
+		retries int
+		backoff time.Duration
+		sleep   func(time.Duration)
+		// guidIndex checks once whether messages can be looked up by GUID
+		// without scanning the message table.
+		guidIndex sync.Once
 	}
 )
 
-// NewChatDB returns a ChatDB interface using the given DB.
-func NewChatDB(db *sql.DB, selfHandle string) ChatDB {
+// NewChatDB returns a ChatDB interface using the given DB, with its connection
+// pool sized by the given options.
+func NewChatDB(db *sql.DB, selfHandle string, nameFormat NameFormat, pool PoolOptions) ChatDB {
+	pool.configure(db)
 	return &chatDB{
 		DB:         db,
 		selfHandle: selfHandle,
+		nameFormat: nameFormat,
+		retries:    _busyRetries,
+		backoff:    _busyBackoff,
+		sleep:      time.Sleep,
 	}
 }
 
-func (d chatDB) GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string, error) {
+func (d *chatDB) GetHandleMap(contacts ContactResolver) (map[int]string, error) {
 	handleMap := make(map[int]string)
-	handles, err := d.DB.Query("SELECT ROWID, id FROM handle")
+	canonicalHandles := make(map[int]int)
+	identities := make(map[string]int)
+	handleNames := make(map[int]handleName)
+	handles, err := d.queryRetry("SELECT ROWID, id FROM handle ORDER BY ROWID")
 	if err != nil {
-		return nil, errors.Wrap(err, "get handles from DB")
+		return nil, errors.Wrap(classify(err), "get handles from DB")
 	}
 	defer handles.Close()
 	for handles.Next() {
 		var handleID int
 		var handle string
 		if err := handles.Scan(&handleID, &handle); err != nil {
-			return nil, errors.Wrap(err, "read handle")
+			return nil, errors.Wrap(corrupt(err), "read handle")
 		}
 		if _, ok := handleMap[handleID]; ok {
-			return nil, fmt.Errorf("multiple handles with the same ID: %d - handle ID uniqueness assumption violated - %s", handleID, _githubIssueMsg)
+			return nil, corrupt(fmt.Errorf("multiple handles with the same ID: %d - handle ID uniqueness assumption violated - %s", handleID, _githubIssueMsg))
 		}
-		if card, ok := contactMap[handle]; ok {
+		identity := canonicalIdentity(handle)
+		if _, ok := identities[identity]; !ok {
+			identities[identity] = handleID
+		}
+		canonicalHandles[handleID] = identities[identity]
+		card := contact(contacts, handle)
+		handleNames[handleID] = handleName{handle: handle, card: card}
+		if card != nil {
 			name := card.Name()
 			if name != nil && name.GivenName != "" {
 				handle = name.GivenName
@@ -94,42 +430,192 @@ func (d chatDB) GetHandleMap(contactMap map[string]*vcard.Card) (map[int]string,
 		}
 		handleMap[handleID] = handle
 	}
+	d.canonicalHandles = canonicalHandles
+	d.handleIDs = identities
+	d.handleNames = handleNames
 	return handleMap, nil
 }
 
-func (d chatDB) GetChats(contactMap map[string]*vcard.Card) ([]Chat, error) {
-	chatRows, err := d.DB.Query("SELECT ROWID, guid, chat_identifier, COALESCE(display_name, '') FROM chat")
+func (d *chatDB) CanonicalHandle(handleID int) int {
+	if canonical, ok := d.canonicalHandles[handleID]; ok {
+		return canonical
+	}
+	return handleID
+}
+
+// canonicalIdentity returns the phone number or email address of a handle in
+// a form which is the same for all of the services it is used with.
+func canonicalIdentity(handle string) string {
+	return strings.Map(
+		func(r rune) rune {
+			if strings.ContainsRune("()-.", r) && !strings.ContainsRune(handle, '@') || unicode.IsSpace(r) {
+				return -1
+			}
+			return unicode.ToLower(r)
+		},
+		handle,
+	)
+}
+
+func (d *chatDB) GetChats(contacts ContactResolver) ([]Chat, error) {
+	chatRows, err := d.query(func(s *schema) string {
+		return fmt.Sprintf("SELECT c.ROWID, c.guid, c.chat_identifier, COALESCE(c.display_name, ''), COALESCE(c.is_archived, 0), c.properties, COALESCE(act.message_count, 0), act.last_date FROM chat AS c LEFT JOIN (%s) AS act ON act.chat_id = c.ROWID ORDER BY c.ROWID", chatActivityQuery(s))
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "query chats table")
 	}
 	defer chatRows.Close()
+	return d.readChats(chatRows, contacts)
+}
+
+func (d *chatDB) GetChatsForHandle(handle string, contacts ContactResolver) ([]Chat, error) {
+	chatRows, err := d.query(func(s *schema) string {
+		return fmt.Sprintf("SELECT DISTINCT c.ROWID, c.guid, c.chat_identifier, COALESCE(c.display_name, ''), COALESCE(c.is_archived, 0), c.properties, COALESCE(act.message_count, 0), act.last_date FROM chat AS c JOIN chat_handle_join AS chj ON chj.chat_id = c.ROWID JOIN handle AS h ON chj.handle_id = h.ROWID LEFT JOIN (%s) AS act ON act.chat_id = c.ROWID WHERE h.id = ? ORDER BY c.ROWID", chatActivityQuery(s))
+	}, handle)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query chats for handle %q", handle)
+	}
+	defer chatRows.Close()
+	return d.readChats(chatRows, contacts)
+}
+
+// chatMessages returns the column of the chat IDs of messages, and the tables
+// joining chats to their messages, aliasing the message table as m.
+func chatMessages(s *schema) (string, string) {
+	if !s.hasTable("chat_message_join") {
+		// Without the join table, the messages of a chat are those of its
+		// participants, as in GetMessageIDs.
+		return "chj.chat_id", "chat_handle_join AS chj JOIN message AS m ON m.handle_id = chj.handle_id"
+	}
+	return "cmj.chat_id", "chat_message_join AS cmj JOIN message AS m ON cmj.message_id = m.ROWID"
+}
+
+// chatActivityQuery returns a query of the chat_id, message_count, and
+// last_date, the datetime of the last message, of each chat with messages, so
+// that chats are read with their activity in a single query.
+func chatActivityQuery(s *schema) string {
+	chatID, messages := chatMessages(s)
+	return fmt.Sprintf("SELECT %[1]s AS chat_id, COUNT(*) AS message_count, DATETIME(%[3]s) AS last_date FROM %[2]s GROUP BY %[1]s", chatID, messages, fmt.Sprintf(_datetimeFormula, "MAX(m.date)"))
+}
+
+// readChats reads chats from rows of ROWID, guid, chat_identifier,
+// display_name, is_archived, properties, message_count, and last_date,
+// resolving their display names using the given contact resolver.
+// Group chats without display names are named after their participants.
+func (d *chatDB) readChats(chatRows *sql.Rows, contacts ContactResolver) ([]Chat, error) {
 	chats := []Chat{}
+	var unnamed []int
 	for chatRows.Next() {
-		var id int
+		var id, messageCount int
 		var guid, name, displayName string
-		if err := chatRows.Scan(&id, &guid, &name, &displayName); err != nil {
-			return nil, errors.Wrap(err, "read chat")
+		var archived bool
+		var properties []byte
+		var lastDate sql.NullString
+		if err := chatRows.Scan(&id, &guid, &name, &displayName, &archived, &properties, &messageCount, &lastDate); err != nil {
+			return nil, errors.Wrap(corrupt(err), "read chat")
+		}
+		var lastMessageDate time.Time
+		if lastDate.Valid {
+			var err error
+			if lastMessageDate, err = time.ParseInLocation(_datetimeLayout, lastDate.String, time.Local); err != nil {
+				return nil, errors.Wrapf(corrupt(err), "parse last message date %q of chat ID %d", lastDate.String, id)
+			}
 		}
 		if displayName == "" {
 			displayName = name
+			if strings.Contains(guid, ";+;") {
+				unnamed = append(unnamed, len(chats))
+			}
 		}
-		if card, ok := contactMap[displayName]; ok {
-			contactName := card.PreferredValue(vcard.FieldFormattedName)
+		if card := contact(contacts, displayName); card != nil {
+			contactName := d.nameFormat.FullName(card)
 			if contactName != "" {
 				displayName = contactName
 			}
 		}
+		service, identifier, _ := ParseGUID(guid)
 		chats = append(chats, Chat{
-			ID:          id,
-			GUID:        guid,
-			DisplayName: displayName,
+			ID:              id,
+			GUID:            guid,
+			DisplayName:     displayName,
+			Service:         service,
+			Identifier:      identifier,
+			Pinned:          isPinned(properties),
+			Archived:        archived,
+			MessageCount:    messageCount,
+			LastMessageDate: lastMessageDate,
 		})
 	}
+	chatRows.Close()
+	for _, i := range unnamed {
+		name, err := d.groupName(chats[i].ID, contacts)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			chats[i].DisplayName = name
+		}
+	}
 	return chats, nil
 }
 
-func (d chatDB) GetMessageIDs(chatID int) ([]int, error) {
-	rows, err := d.DB.Query(fmt.Sprintf("SELECT message_id FROM chat_message_join WHERE chat_id=%d", chatID))
+// groupName synthesizes a name for the group chat with the given ID from the
+// first names of its participants, e.g. "Alice, Bob & 2 others", or returns
+// an empty string if it has no participants.
+func (d *chatDB) groupName(chatID int, contacts ContactResolver) (string, error) {
+	rows, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT h.id FROM chat_handle_join AS chj JOIN handle AS h ON chj.handle_id = h.ROWID WHERE chj.chat_id=%d ORDER BY h.ROWID", chatID)
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "query participants of chat ID %d", chatID)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var handle string
+		if err := rows.Scan(&handle); err != nil {
+			return "", errors.Wrapf(corrupt(err), "read participant of chat ID %d", chatID)
+		}
+		names = append(names, d.firstName(handle, contacts))
+	}
+	switch len(names) {
+	case 0:
+		return "", nil
+	case 1:
+		return names[0], nil
+	case 2:
+		return names[0] + " & " + names[1], nil
+	case 3:
+		return fmt.Sprintf("%s, %s & %s", names[0], names[1], names[2]), nil
+	}
+	return fmt.Sprintf("%s, %s & %d others", names[0], names[1], len(names)-2), nil
+}
+
+// firstName returns the given name of the contact with the given handle, or
+// their full name if it has no given name, or else the handle.
+func (d *chatDB) firstName(handle string, contacts ContactResolver) string {
+	card := contact(contacts, handle)
+	if card == nil {
+		return handle
+	}
+	if name := card.Name(); name != nil && name.GivenName != "" {
+		return name.GivenName
+	}
+	if name := d.nameFormat.FullName(card); name != "" {
+		return name
+	}
+	return handle
+}
+
+func (d *chatDB) GetMessageIDs(chatID int) ([]int, error) {
+	rows, err := d.query(func(s *schema) string {
+		if !s.hasTable("chat_message_join") {
+			// Without the join table, the messages of a chat are those
+			// exchanged with its participants.
+			return fmt.Sprintf("SELECT ROWID FROM message WHERE handle_id IN (SELECT handle_id FROM chat_handle_join WHERE chat_id=%d) ORDER BY %s, ROWID", chatID, _sortDate)
+		}
+		return fmt.Sprintf("SELECT message_id FROM chat_message_join JOIN message ON message_id = message.ROWID WHERE chat_id=%d ORDER BY %s, message_id", chatID, _sortDate)
+	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "query chat_message_join table for chat ID %d", chatID)
 	}
@@ -138,41 +624,562 @@ func (d chatDB) GetMessageIDs(chatID int) ([]int, error) {
 	for rows.Next() {
 		var messageID int
 		if err := rows.Scan(&messageID); err != nil {
-			return nil, errors.Wrapf(err, "read message ID for chat ID %d", chatID)
+			return nil, errors.Wrapf(corrupt(err), "read message ID for chat ID %d", chatID)
 		}
 		messageIDs = append(messageIDs, messageID)
 	}
 	return messageIDs, nil
 }
 
-func (d *chatDB) GetMessage(messageID int, handleMap map[int]string, macOSVersion *semver.Version) (string, error) {
-	messages, err := d.DB.Query(fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s) FROM message WHERE ROWID=%d", d.getDatetimeFormula(macOSVersion), messageID))
+func (d *chatDB) GetMessagesPage(chatID int, pageToken string, limit int, handleMap map[int]string, macOSVersion *semver.Version) ([]Message, string, error) {
+	if limit < 1 {
+		return nil, "", fmt.Errorf("invalid page size %d", limit)
+	}
+	var afterDate int64
+	afterID := -1
+	if pageToken != "" {
+		if _, err := fmt.Sscanf(pageToken, "%d-%d", &afterDate, &afterID); err != nil {
+			return nil, "", errors.Wrapf(err, "parse page token %q", pageToken)
+		}
+	}
+	// An extra message is queried to find out if there is another page.
+	rows, err := d.query(func(s *schema) string {
+		chatMessages := fmt.Sprintf("chat_message_join JOIN message ON message_id = message.ROWID WHERE chat_id=%d", chatID)
+		if !s.hasTable("chat_message_join") {
+			chatMessages = fmt.Sprintf("message WHERE handle_id IN (SELECT handle_id FROM chat_handle_join WHERE chat_id=%d)", chatID)
+		}
+		return fmt.Sprintf("SELECT message.ROWID, %[1]s FROM %[2]s AND (%[1]s > ? OR (%[1]s = ? AND message.ROWID > ?)) ORDER BY %[1]s, message.ROWID LIMIT %[3]d", _sortDate, chatMessages, limit+1)
+	}, afterDate, afterDate, afterID)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "query page of messages for chat ID %d", chatID)
+	}
+	type messageKey struct {
+		id   int
+		date int64
+	}
+	var keys []messageKey
+	for rows.Next() {
+		var key messageKey
+		if err := rows.Scan(&key.id, &key.date); err != nil {
+			rows.Close()
+			return nil, "", errors.Wrapf(corrupt(err), "read message ID for chat ID %d", chatID)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	var nextPageToken string
+	if len(keys) > limit {
+		keys = keys[:limit]
+		last := keys[len(keys)-1]
+		nextPageToken = fmt.Sprintf("%d-%d", last.date, last.id)
+	}
+	msgs := make([]Message, 0, len(keys))
+	for _, key := range keys {
+		msg, err := d.GetMessage(key.id, handleMap, macOSVersion)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "get message with ID %d", key.id)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nextPageToken, nil
+}
+
+func (d *chatDB) GetMessage(messageID int, handleMap map[int]string, macOSVersion *semver.Version) (Message, error) {
+	datetimeFormula, err := d.getDatetimeFormula(macOSVersion)
 	if err != nil {
-		return "", errors.Wrapf(err, "query message table for ID %d", messageID)
+		return Message{}, err
+	}
+	datetimeFormula = fmt.Sprintf(datetimeFormula, _effectiveDate)
+	d.useRelease(macOSVersion)
+	messages, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s), %s, item_type, group_action_type, other_handle, COALESCE(service, ''), %s, date_edited > 0, date_retracted > 0, attributedBody, COALESCE(thread_originator_guid, ''), COALESCE(expressive_send_style_id, ''), COALESCE(balloon_bundle_id, ''), %s FROM message WHERE ROWID=%d", datetimeFormula, _dateSource, _unkeptAudio, _reaction, messageID)
+	})
+	if err != nil {
+		return Message{}, errors.Wrapf(err, "query message table for ID %d", messageID)
 	}
 	defer messages.Close()
 	messages.Next()
-	var fromMe, handleID int
-	var text, date string
-	if err := messages.Scan(&fromMe, &handleID, &text, &date); err != nil {
-		return "", errors.Wrapf(err, "read data for message ID %d", messageID)
+	var fromMe, handleID, itemType, groupActionType, otherHandleID int
+	var text, date, service, replyGUID, effect, balloon string
+	var dateSource DateSource
+	var unkeptAudio, edited, unsent, reaction bool
+	var attributedBody []byte
+	if err := messages.Scan(&fromMe, &handleID, &text, &date, &dateSource, &itemType, &groupActionType, &otherHandleID, &service, &unkeptAudio, &edited, &unsent, &attributedBody, &replyGUID, &effect, &balloon, &reaction); err != nil {
+		return Message{}, errors.Wrapf(corrupt(err), "read data for message ID %d", messageID)
+	}
+	if messages.Next() {
+		return Message{}, corrupt(fmt.Errorf("multiple messages with the same ID: %d - message ID uniqeness assumption violated - %s", messageID, _githubIssueMsg))
+	}
+	datetime, err := time.ParseInLocation(_datetimeLayout, date, time.Local)
+	if err != nil {
+		return Message{}, errors.Wrapf(corrupt(err), "parse date %q for message ID %d", date, messageID)
+	}
+	text, textErr := legacyText(text)
+	msg := Message{
+		ID:          messageID,
+		Date:        datetime,
+		DateSource:  dateSource,
+		HandleID:    handleID,
+		Handle:      handleMap[handleID],
+		Text:        text,
+		RawText:     text,
+		Service:     service,
+		UnkeptAudio: unkeptAudio,
+		Edited:      edited,
+		Reaction:    reaction,
+		Hints:       messageHints(effect, balloon),
+	}
+	if textErr != nil {
+		msg.markUndecodable("text", textErr)
+	}
+	if msg.Text == "" {
+		// Since Mac OS 13, the text of many messages is only stored in the
+		// attributed body.
+		msg.Text, err = attributedBodyText(attributedBody)
+		msg.RawText = msg.Text
+		if err != nil {
+			msg.markUndecodable("attributed body", err)
+		}
+	}
+	msg.Mentions, msg.Styles = d.getFormatting(messageID, attributedBody, handleMap)
+	if unsent && msg.Text == "" {
+		msg.Text = "unsent a message"
+	}
+	if replyGUID != "" {
+		if msg.ReplyTo, err = d.getReply(replyGUID, handleMap); err != nil {
+			return Message{}, errors.Wrapf(err, "get reply context for message ID %d", messageID)
+		}
+	}
+	if fromMe == 1 {
+		msg.FromMe = true
+		msg.Handle = d.selfHandle
+	}
+	if hasPayloadText(balloon, msg.Text) {
+		payload, err := d.getPayloadData(messageID)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.decodePayload(balloon, payload)
+	}
+	msg.GroupAction = getGroupAction(itemType, groupActionType)
+	if msg.GroupAction != NoGroupAction {
+		msg.OtherHandleID = otherHandleID
+		if msg.Text == "" {
+			msg.Text = describeGroupAction(msg.GroupAction, handleMap[otherHandleID])
+		}
+	}
+	return msg, nil
+}
+
+func (d *chatDB) GetMessageByGUID(guid string, handleMap map[int]string, macOSVersion *semver.Version) (Message, error) {
+	d.guidIndex.Do(d.checkGUIDIndex)
+	rows, err := d.query(func(*schema) string {
+		return "SELECT ROWID FROM message WHERE guid = ?"
+	}, guid)
+	if err != nil {
+		return Message{}, errors.Wrapf(err, "query message table for GUID %q", guid)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Message{}, errors.Wrapf(err, "query message table for GUID %q", guid)
+		}
+		return Message{}, causeError{err: fmt.Errorf("no message with GUID %q", guid), cause: ErrNotFound}
+	}
+	var messageID int
+	if err := rows.Scan(&messageID); err != nil {
+		return Message{}, errors.Wrapf(corrupt(err), "read ID of message GUID %q", guid)
+	}
+	rows.Close()
+	return d.GetMessage(messageID, handleMap, macOSVersion)
+}
+
+// checkGUIDIndex warns if the message table has no index on its guid column,
+// e.g. because it was copied with a tool which does not copy indexes, since
+// each lookup by GUID then scans the whole table. Messages declares the
+// column unique, which indexes it.
+func (d *chatDB) checkGUIDIndex() {
+	rows, err := d.queryRetry("SELECT COUNT(*) FROM sqlite_master AS m JOIN pragma_index_info(m.name) AS i WHERE m.type = 'index' AND m.tbl_name = 'message' AND i.seqno = 0 AND i.name = 'guid'")
+	if err != nil {
+		logging.Debugf("check index of message GUIDs: %s", err)
+		return
+	}
+	defer rows.Close()
+	indexes := 0
+	if rows.Next() {
+		if err := rows.Scan(&indexes); err != nil {
+			logging.Debugf("read index of message GUIDs: %s", err)
+			return
+		}
+	}
+	if indexes == 0 {
+		logging.Warnf("the message table has no index on guid, so looking up messages by GUID is slow - FIX: index a copy of chat.db with: sqlite3 chat.db 'CREATE INDEX message_guid ON message(guid)'")
+	}
+}
+
+func (d *chatDB) GetRawMessage(messageID int) (RawMessage, error) {
+	messages, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT guid, handle_id, text, attributedBody, COALESCE(service, ''), COALESCE(account, ''), COALESCE(date, 0), COALESCE(date_read, 0), COALESCE(date_delivered, 0), is_from_me, COALESCE(is_read, 0), COALESCE(is_delivered, 0), COALESCE(is_sent, 0), COALESCE(item_type, 0), COALESCE(group_action_type, 0), COALESCE(error, 0) FROM message WHERE ROWID=%d", messageID)
+	})
+	if err != nil {
+		return RawMessage{}, errors.Wrapf(err, "query message table for ID %d", messageID)
+	}
+	defer messages.Close()
+	if !messages.Next() {
+		return RawMessage{}, corrupt(fmt.Errorf("no message with ID %d", messageID))
+	}
+	msg := RawMessage{ROWID: messageID}
+	var text sql.NullString
+	if err := messages.Scan(&msg.GUID, &msg.HandleID, &text, &msg.AttributedBody, &msg.Service, &msg.Account, &msg.Date, &msg.DateRead, &msg.DateDelivered, &msg.IsFromMe, &msg.IsRead, &msg.IsDelivered, &msg.IsSent, &msg.ItemType, &msg.GroupActionType, &msg.Error); err != nil {
+		return RawMessage{}, errors.Wrapf(corrupt(err), "read data for message ID %d", messageID)
 	}
 	if messages.Next() {
-		return "", fmt.Errorf("multiple messages with the same ID: %d - message ID uniqeness assumption violated - %s", messageID, _githubIssueMsg)
+		return RawMessage{}, corrupt(fmt.Errorf("multiple messages with the same ID: %d - message ID uniqeness assumption violated - %s", messageID, _githubIssueMsg))
+	}
+	if text.Valid {
+		msg.Text = &text.String
+	}
+	return msg, nil
+}
+
+var (
+	// _effectNames names the effects which messages can be sent with, by
+	// their expressive send style IDs.
+	_effectNames = map[string]string{
+		"com.apple.MobileSMS.expressivesend.impact":       "Slam",
+		"com.apple.MobileSMS.expressivesend.loud":         "Loud",
+		"com.apple.MobileSMS.expressivesend.gentle":       "Gentle",
+		"com.apple.MobileSMS.expressivesend.invisibleink": "Invisible Ink",
+		"com.apple.messages.effect.CKConfettiEffect":      "Confetti",
+		"com.apple.messages.effect.CKEchoEffect":          "Echo",
+		"com.apple.messages.effect.CKFireworksEffect":     "Fireworks",
+		"com.apple.messages.effect.CKHappyBirthdayEffect": "Balloons",
+		"com.apple.messages.effect.CKHeartEffect":         "Love",
+		"com.apple.messages.effect.CKLasersEffect":        "Lasers",
+		"com.apple.messages.effect.CKShootingStarEffect":  "Shooting Star",
+		"com.apple.messages.effect.CKSparklesEffect":      "Celebration",
+		"com.apple.messages.effect.CKSpotlightEffect":     "Spotlight",
 	}
-	handle := handleMap[handleID]
+	// _balloonHints describe messages sent with the Messages apps which
+	// record how they were sent, by their balloon bundle IDs. Digital Touch
+	// and handwriting are often sent from an Apple Watch.
+	_balloonHints = map[string]string{
+		"com.apple.DigitalTouchBalloonProvider":     "sent with Digital Touch",
+		"com.apple.Handwriting.HandwritingProvider": "sent as handwriting",
+	}
+)
+
+// messageHints describes how a message was sent from its expressive send
+// style ID and balloon bundle ID, either of which may be empty.
+func messageHints(effect, balloon string) []string {
+	var hints []string
+	if hint, ok := _balloonHints[balloon]; ok {
+		hints = append(hints, hint)
+	}
+	if effect != "" {
+		name, ok := _effectNames[effect]
+		if !ok {
+			name = effect[strings.LastIndex(effect, ".")+1:]
+		}
+		hints = append(hints, fmt.Sprintf("sent with %s effect", name))
+	}
+	return hints
+}
+
+// _replySummaryLength is the maximum number of characters of the text of a
+// message replied to which is included in the reply's summary.
+const _replySummaryLength = 60
+
+// getReply gets the message with the given GUID, which was replied to, or nil
+// if it is no longer in the database.
+func (d *chatDB) getReply(guid string, handleMap map[int]string) (*Reply, error) {
+	rows, err := d.query(func(*schema) string {
+		return "SELECT m.ROWID, m.is_from_me, m.handle_id, COALESCE(m.text, ''), m.attributedBody, COALESCE((SELECT a.mime_type FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID WHERE maj.message_id = m.ROWID ORDER BY a.ROWID LIMIT 1), '') FROM message AS m WHERE m.guid = ?"
+	}, guid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "query message table for GUID %q", guid)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var id, fromMe, handleID int
+	var text, mimeType string
+	var attributedBody []byte
+	if err := rows.Scan(&id, &fromMe, &handleID, &text, &attributedBody, &mimeType); err != nil {
+		return nil, errors.Wrapf(corrupt(err), "read data for message GUID %q", guid)
+	}
+	if text == "" {
+		// The summary of a reply is a best effort, so the start of the text of
+		// malformed bodies will do.
+		text, _ = attributedBodyText(attributedBody)
+	}
+	reply := &Reply{ID: id, Handle: handleMap[handleID], Text: summarizeReply(text, mimeType)}
 	if fromMe == 1 {
-		handle = d.selfHandle
+		reply.Handle = d.selfHandle
+		reply.FromMe = true
 	}
-	return fmt.Sprintf("[%s] %s: %s\n", date, handle, text), nil
+	return reply, nil
 }
 
-func (d *chatDB) getDatetimeFormula(macOSVersion *semver.Version) string {
+// summarizeReply summarizes the text of a message replied to, or its first
+// attachment if it has no other text, e.g. "[photo]".
+func summarizeReply(text, mimeType string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\ufffc", ""))
+	if text == "" {
+		switch strings.SplitN(mimeType, "/", 2)[0] {
+		case "":
+			return "[message]"
+		case "image":
+			return "[photo]"
+		case "video":
+			return "[video]"
+		case "audio":
+			return "[audio message]"
+		default:
+			return "[attachment]"
+		}
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > _replySummaryLength {
+		text = string(runes[:_replySummaryLength]) + "…"
+	}
+	return text
+}
+
+// attributedBodyText extracts the text from the attributed body of a message,
+// an NSAttributedString archived in the typedstream format. The text follows
+// the NSString class name and a "+" type tag, prefixed with its length in
+// bytes. Lengths of 128 bytes or more are flagged by 0x81 or 0x82, followed by
+// the length as a little-endian 16- or 32-bit integer. Malformed bodies return
+// an error, with the start of the text if the body is truncated.
+func attributedBodyText(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	i := bytes.Index(body, []byte("NSString"))
+	if i < 0 {
+		return "", errors.New("no text in attributed body")
+	}
+	body = body[i+len("NSString"):]
+	if i = bytes.IndexByte(body, '+'); i < 0 || i+1 >= len(body) {
+		return "", errors.New("no text in attributed body")
+	}
+	body = body[i+1:]
+	length, body := int(body[0]), body[1:]
+	switch {
+	case length == 0x81 && len(body) >= 2:
+		length, body = int(binary.LittleEndian.Uint16(body)), body[2:]
+	case length == 0x82 && len(body) >= 4:
+		length, body = int(binary.LittleEndian.Uint32(body)), body[4:]
+	}
+	if length > len(body) {
+		return strings.ToValidUTF8(string(body), ""), errors.Errorf("attributed body truncated after %d of %d bytes of text", len(body), length)
+	}
+	return string(body[:length]), nil
+}
+
+// getGroupAction decodes the item_type and group_action_type columns of the
+// message table.
+func getGroupAction(itemType, groupActionType int) GroupAction {
+	switch {
+	case itemType == 1 && groupActionType == 0:
+		return ParticipantAdded
+	case itemType == 1 && groupActionType == 1:
+		return ParticipantRemoved
+	case itemType == 3 && groupActionType == 0:
+		return ParticipantLeft
+	}
+	return NoGroupAction
+}
+
+func describeGroupAction(action GroupAction, otherHandle string) string {
+	switch action {
+	case ParticipantAdded:
+		return fmt.Sprintf("added %s to the conversation", otherHandle)
+	case ParticipantRemoved:
+		return fmt.Sprintf("removed %s from the conversation", otherHandle)
+	case ParticipantLeft:
+		return "left the conversation"
+	}
+	return ""
+}
+
+func (d *chatDB) GetParticipants(chatID int) ([]int, error) {
+	rows, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT handle_id FROM chat_handle_join WHERE chat_id=%d ORDER BY handle_id", chatID)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "query chat_handle_join table for chat ID %d", chatID)
+	}
+	defer rows.Close()
+	handleIDs := []int{}
+	for rows.Next() {
+		var handleID int
+		if err := rows.Scan(&handleID); err != nil {
+			return nil, errors.Wrapf(corrupt(err), "read handle ID for chat ID %d", chatID)
+		}
+		handleIDs = append(handleIDs, handleID)
+	}
+	return handleIDs, nil
+}
+
+func (d *chatDB) GetAttachmentPaths() (map[int][]Attachment, error) {
+	rows, err := d.query(func(*schema) string {
+		return "SELECT maj.message_id, a.ROWID, COALESCE(a.filename, ''), COALESCE(a.mime_type, ''), COALESCE(a.transfer_name, ''), COALESCE(a.total_bytes, 0), a.user_info FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID ORDER BY maj.message_id, a.ROWID"
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "query attachments")
+	}
+	defer rows.Close()
+	attachments := make(map[int][]Attachment)
+	for rows.Next() {
+		var messageID int
+		var att Attachment
+		var userInfo []byte
+		if err := rows.Scan(&messageID, &att.ID, &att.Filename, &att.MIMEType, &att.TransferName, &att.TotalBytes, &userInfo); err != nil {
+			return nil, errors.Wrap(corrupt(err), "read attachment")
+		}
+		att.Transcript = audioTranscript(userInfo)
+		attachments[messageID] = append(attachments[messageID], att)
+	}
+	return attachments, nil
+}
+
+func (d *chatDB) GetChatSizes() (map[int]ChatSize, error) {
+	rows, err := d.query(func(s *schema) string {
+		chatID, messages := chatMessages(s)
+		return fmt.Sprintf("SELECT %[1]s, COUNT(*), COALESCE(SUM(COALESCE(LENGTH(m.text), LENGTH(m.attributedBody), 0)), 0), COALESCE(SUM((SELECT SUM(a.total_bytes) FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID WHERE maj.message_id = m.ROWID)), 0) FROM %[2]s GROUP BY %[1]s", chatID, messages)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "query chat sizes")
+	}
+	defer rows.Close()
+	sizes := make(map[int]ChatSize)
+	for rows.Next() {
+		var chatID int
+		var size ChatSize
+		if err := rows.Scan(&chatID, &size.Messages, &size.TextBytes, &size.AttachmentBytes); err != nil {
+			return nil, errors.Wrap(corrupt(err), "read chat size")
+		}
+		sizes[chatID] = size
+	}
+	return sizes, nil
+}
+
+func (d *chatDB) GetSyncedMessageCount() (int, error) {
+	rows, err := d.query(func(s *schema) string {
+		// Messages in iCloud marks the messages which it has synced with a
+		// nonzero CloudKit sync state.
+		return "SELECT COUNT(*) FROM message WHERE ck_sync_state != 0"
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "query message sync states")
+	}
+	defer rows.Close()
+	count := 0
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, errors.Wrap(corrupt(err), "read synced message count")
+		}
+	}
+	return count, errors.Wrap(rows.Err(), "read synced message count")
+}
+
+// FullName returns the full name of the contact on the given card, falling
+// back to the card's formatted name if its name parts are not needed or not
+// present.
+func (f NameFormat) FullName(card *vcard.Card) string {
+	formattedName := card.PreferredValue(vcard.FieldFormattedName)
+	familyFirst := f.Order == FamilyNameFirst || (f.Order == AutoNameOrder && hasPhoneticName(card))
+	name := card.Name()
+	if name == nil || (!familyFirst && !f.Honorifics) {
+		return formattedName
+	}
+	parts := []string{name.GivenName, name.AdditionalName, name.FamilyName}
+	sep := " "
+	if familyFirst {
+		parts = []string{name.FamilyName, name.GivenName, name.AdditionalName}
+		if isCJK(name.FamilyName + name.GivenName + name.AdditionalName) {
+			sep = ""
+		}
+	}
+	fullName := joinNonEmpty(parts, sep)
+	if fullName == "" {
+		return formattedName
+	}
+	if f.Honorifics {
+		fullName = joinNonEmpty([]string{name.HonorificPrefix, fullName, name.HonorificSuffix}, " ")
+	}
+	return fullName
+}
+
+func hasPhoneticName(card *vcard.Card) bool {
+	return card.Value("X-PHONETIC-FIRST-NAME") != "" || card.Value("X-PHONETIC-LAST-NAME") != ""
+}
+
+func isCJK(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return false
+		}
+	}
+	return true
+}
+
+func joinNonEmpty(strs []string, sep string) string {
+	var nonEmpty []string
+	for _, s := range strs {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+func (d *chatDB) getDatetimeFormula(macOSVersion *semver.Version) (string, error) {
+	d.formulaMu.Lock()
+	defer d.formulaMu.Unlock()
 	if d.datetimeFormula != "" {
-		return d.datetimeFormula
+		return d.datetimeFormula, nil
+	}
+	if macOSVersion != nil {
+		if macOSVersion.LessThan(_modernVersion) {
+			return _datetimeFormulaLegacy, nil
+		}
+		return _datetimeFormula, nil
+	}
+	formula, err := d.detectDatetimeFormula()
+	if err != nil {
+		return "", err
+	}
+	d.datetimeFormula = formula
+	return formula, nil
+}
+
+// detectDatetimeFormula chooses the datetime formula from the database
+// contents, for databases copied from an unknown version of Mac OS. Only
+// databases with dates in nanoseconds need the modern formula.
+func (d *chatDB) detectDatetimeFormula() (string, error) {
+	rows, err := d.queryRetry(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM message WHERE date > %s)", _nanosecondThreshold))
+	if err != nil {
+		return "", errors.Wrap(classify(err), "detect date format")
+	}
+	defer rows.Close()
+	var nanoseconds bool
+	if rows.Next() {
+		if err := rows.Scan(&nanoseconds); err != nil {
+			return "", errors.Wrap(corrupt(err), "detect date format")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", errors.Wrap(classify(err), "detect date format")
 	}
-	if macOSVersion != nil && macOSVersion.LessThan(_modernVersion) {
-		return _datetimeFormulaLegacy
+	if nanoseconds {
+		return _datetimeFormula, nil
 	}
-	return _datetimeFormula
+	return _datetimeFormulaLegacy, nil
 }
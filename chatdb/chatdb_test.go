@@ -5,7 +5,11 @@ package chatdb
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/semver"
 
@@ -17,7 +21,7 @@ import (
 func TestGetHandleMap(t *testing.T) {
 	tests := []struct {
 		msg        string
-		contactMap map[string]*vcard.Card
+		contactMap ContactMap
 		setupQuery func(*sqlmock.ExpectedQuery)
 		wantMap    map[int]string
 		wantErr    string
@@ -92,7 +96,7 @@ func TestGetHandleMap(t *testing.T) {
 			query := sMock.ExpectQuery("SELECT ROWID, id FROM handle")
 			tt.setupQuery(query)
 
-			cdb := NewChatDB(db, "Me")
+			cdb := NewChatDB(db, "Me", NameFormat{}, PoolOptions{})
 			handleMap, err := cdb.GetHandleMap(tt.contactMap)
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
@@ -104,20 +108,48 @@ func TestGetHandleMap(t *testing.T) {
 	}
 }
 
+func TestCanonicalHandle(t *testing.T) {
+	db, sMock, err := sqlmock.New()
+	assert.NilError(t, err)
+	defer db.Close()
+	rows := sqlmock.NewRows([]string{"ROWID", "id"}).
+		AddRow(1, "+14155555555").
+		AddRow(2, "Novak@Example.com").
+		AddRow(3, "+1 (415) 555-5555").
+		AddRow(4, "novak@example.com").
+		AddRow(5, "first.last@example.com").
+		AddRow(6, "firstlast@example.com")
+	sMock.ExpectQuery("SELECT ROWID, id FROM handle ORDER BY ROWID").WillReturnRows(rows)
+
+	cdb := NewChatDB(db, "Me", NameFormat{}, PoolOptions{})
+	assert.Equal(t, 3, cdb.CanonicalHandle(3), "canonical handle before GetHandleMap")
+	_, err = cdb.GetHandleMap(nil)
+	assert.NilError(t, err)
+
+	for handleID, want := range map[int]int{1: 1, 2: 2, 3: 1, 4: 2, 5: 5, 6: 6, 7: 7} {
+		assert.Equal(t, want, cdb.CanonicalHandle(handleID), "handle ID %d", handleID)
+	}
+}
+
+// _testActivityQuery is the query of the activity of chats with which they
+// are read.
+var _testActivityQuery = "SELECT cmj.chat_id AS chat_id, COUNT(*) AS message_count, DATETIME(" + fmt.Sprintf(_datetimeFormula, "MAX(m.date)") + ") AS last_date FROM chat_message_join AS cmj JOIN message AS m ON cmj.message_id = m.ROWID GROUP BY cmj.chat_id"
+
 func TestGetChats(t *testing.T) {
 	tests := []struct {
 		msg        string
-		contactMap map[string]*vcard.Card
+		contactMap ContactMap
 		setupQuery func(*sqlmock.ExpectedQuery)
+		setupNames func(sqlmock.Sqlmock)
 		wantChats  []Chat
 		wantErr    string
 	}{
 		{
 			msg: "empty contact map",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", "")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 0, nil, 0, nil).
+					AddRow(2, "testguid2", "testchatname2", "", 0, nil, 0, nil)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []Chat{
@@ -125,11 +157,13 @@ func TestGetChats(t *testing.T) {
 					ID:          1,
 					GUID:        "testguid1",
 					DisplayName: "testdisplayname1",
+					Identifier:  "testguid1",
 				},
 				{
 					ID:          2,
 					GUID:        "testguid2",
 					DisplayName: "testchatname2",
+					Identifier:  "testguid2",
 				},
 			},
 		},
@@ -143,9 +177,9 @@ func TestGetChats(t *testing.T) {
 				},
 			},
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", "")
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 0, nil, 0, nil).
+					AddRow(2, "testguid2", "testchatname2", "", 0, nil, 0, nil)
 				query.WillReturnRows(rows)
 			},
 			wantChats: []Chat{
@@ -153,13 +187,103 @@ func TestGetChats(t *testing.T) {
 					ID:          1,
 					GUID:        "testguid1",
 					DisplayName: "testdisplayname1",
+					Identifier:  "testguid1",
 				},
 				{
 					ID:          2,
 					GUID:        "testguid2",
 					DisplayName: "Contactgiven Contactsurname",
+					Identifier:  "testguid2",
+				},
+			},
+		},
+		{
+			msg: "unnamed group chats",
+			contactMap: map[string]*vcard.Card{
+				"+14155555555": {
+					"FN": []*vcard.Field{{Value: "Novak Djokovic"}},
+					"N":  []*vcard.Field{{Value: "Djokovic;Novak;;;"}},
+				},
+				"jelena@example.com": {
+					"FN": []*vcard.Field{{Value: "Jelena"}},
 				},
 			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "iMessage;+;chat123", "chat123", "", 0, nil, 0, nil).
+					AddRow(2, "iMessage;+;chat456", "chat456", "", 0, nil, 0, nil).
+					AddRow(3, "iMessage;+;chat789", "chat789", "", 0, nil, 0, nil).
+					AddRow(4, "iMessage;+;chat000", "chat000", "Tennis", 0, nil, 0, nil)
+				query.WillReturnRows(rows)
+			},
+			setupNames: func(sMock sqlmock.Sqlmock) {
+				participantsQuery := func(chatID int) string {
+					return regexp.QuoteMeta(fmt.Sprintf("SELECT h.id FROM chat_handle_join AS chj JOIN handle AS h ON chj.handle_id = h.ROWID WHERE chj.chat_id=%d ORDER BY h.ROWID", chatID))
+				}
+				sMock.ExpectQuery(participantsQuery(1)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+					AddRow("+14155555555").
+					AddRow("jelena@example.com"))
+				sMock.ExpectQuery(participantsQuery(2)).WillReturnRows(sqlmock.NewRows([]string{"id"}).
+					AddRow("+14155555555").
+					AddRow("jelena@example.com").
+					AddRow("+14155555556").
+					AddRow("+14155555557").
+					AddRow("+14155555558"))
+				sMock.ExpectQuery(participantsQuery(3)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			wantChats: []Chat{
+				{ID: 1, GUID: "iMessage;+;chat123", Service: "iMessage", Identifier: "chat123", DisplayName: "Novak & Jelena"},
+				{ID: 2, GUID: "iMessage;+;chat456", Service: "iMessage", Identifier: "chat456", DisplayName: "Novak, Jelena & 3 others"},
+				{ID: 3, GUID: "iMessage;+;chat789", Service: "iMessage", Identifier: "chat789", DisplayName: "chat789"},
+				{ID: 4, GUID: "iMessage;+;chat000", Service: "iMessage", Identifier: "chat000", DisplayName: "Tennis"},
+			},
+		},
+		{
+			msg: "pinned and archived",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "Tennis", 0, testBPlist(bplistDict(1, 2), bplistString("isPinned"), []byte{0x09}), 0, nil).
+					AddRow(2, "testguid2", "testchatname2", "Golf", 1, []byte("not a plist"), 0, nil)
+				query.WillReturnRows(rows)
+			},
+			wantChats: []Chat{
+				{ID: 1, GUID: "testguid1", Identifier: "testguid1", DisplayName: "Tennis", Pinned: true},
+				{ID: 2, GUID: "testguid2", Identifier: "testguid2", DisplayName: "Golf", Archived: true},
+			},
+		},
+		{
+			msg: "activity",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "Tennis", 0, nil, 192, "2020-03-01 15:34:05").
+					AddRow(2, "testguid2", "testchatname2", "Golf", 0, nil, 0, nil)
+				query.WillReturnRows(rows)
+			},
+			wantChats: []Chat{
+				{ID: 1, GUID: "testguid1", Identifier: "testguid1", DisplayName: "Tennis", MessageCount: 192, LastMessageDate: time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)},
+				{ID: 2, GUID: "testguid2", Identifier: "testguid2", DisplayName: "Golf"},
+			},
+		},
+		{
+			msg: "bad last message date",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "Tennis", 0, nil, 1, "")
+				query.WillReturnRows(rows)
+			},
+			wantErr: `parse last message date "" of chat ID 1`,
+		},
+		{
+			msg: "participants DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "iMessage;+;chat123", "chat123", "", 0, nil, 0, nil)
+				query.WillReturnRows(rows)
+			},
+			setupNames: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT h.id FROM chat_handle_join").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query participants of chat ID 1: this is a DB error",
 		},
 		{
 			msg: "DB error",
@@ -171,9 +295,9 @@ func TestGetChats(t *testing.T) {
 		{
 			msg: "row scan error",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name"}).
-					AddRow(1, "testguid1", "testchatname1", "testdisplayname1").
-					AddRow(2, "testguid2", "testchatname2", nil)
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "testguid1", "testchatname1", "testdisplayname1", 0, nil, 0, nil).
+					AddRow(2, "testguid2", "testchatname2", nil, 0, nil, 0, nil)
 				query.WillReturnRows(rows)
 			},
 			wantErr: "read chat: sql: Scan error on column index 3, name \"display_name\": converting NULL to string is unsupported",
@@ -185,9 +309,12 @@ func TestGetChats(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery(`SELECT ROWID, guid, chat_identifier, COALESCE\(display_name, ''\) FROM chat`)
+			query := sMock.ExpectQuery(regexp.QuoteMeta("SELECT c.ROWID, c.guid, c.chat_identifier, COALESCE(c.display_name, ''), COALESCE(c.is_archived, 0), c.properties, COALESCE(act.message_count, 0), act.last_date FROM chat AS c LEFT JOIN (" + _testActivityQuery + ") AS act ON act.chat_id = c.ROWID ORDER BY c.ROWID"))
 			tt.setupQuery(query)
-			cdb := NewChatDB(db, "Me")
+			if tt.setupNames != nil {
+				tt.setupNames(sMock)
+			}
+			cdb := NewChatDB(db, "Me", NameFormat{}, PoolOptions{})
 
 			chats, err := cdb.GetChats(tt.contactMap)
 			if tt.wantErr != "" {
@@ -200,6 +327,83 @@ func TestGetChats(t *testing.T) {
 	}
 }
 
+func TestGetChatsForHandle(t *testing.T) {
+	tests := []struct {
+		msg        string
+		contactMap ContactMap
+		setupQuery func(*sqlmock.ExpectedQuery)
+		wantChats  []Chat
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			contactMap: map[string]*vcard.Card{
+				"+14155555555": {
+					"FN": []*vcard.Field{{Value: "Novak Djokovic"}},
+				},
+			},
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(1, "iMessage;-;+14155555555", "+14155555555", "", 0, nil, 0, nil).
+					AddRow(3, "iMessage;+;chat123", "chat123", "Tennis", 0, nil, 0, nil)
+				query.WillReturnRows(rows)
+			},
+			wantChats: []Chat{
+				{
+					ID:          1,
+					GUID:        "iMessage;-;+14155555555",
+					DisplayName: "Novak Djokovic",
+					Service:     "iMessage",
+					Identifier:  "+14155555555",
+				},
+				{
+					ID:          3,
+					GUID:        "iMessage;+;chat123",
+					DisplayName: "Tennis",
+					Service:     "iMessage",
+					Identifier:  "chat123",
+				},
+			},
+		},
+		{
+			msg: "DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: `query chats for handle "+14155555555": this is a DB error`,
+		},
+		{
+			msg: "row scan error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(nil, "iMessage;-;+14155555555", "+14155555555", "", 0, nil, 0, nil)
+				query.WillReturnRows(rows)
+			},
+			wantErr: "read chat: sql: Scan error on column index 0, name \"ROWID\": converting NULL to int is unsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			query := sMock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT c.ROWID, c.guid, c.chat_identifier, COALESCE(c.display_name, ''), COALESCE(c.is_archived, 0), c.properties, COALESCE(act.message_count, 0), act.last_date FROM chat AS c JOIN chat_handle_join AS chj ON chj.chat_id = c.ROWID JOIN handle AS h ON chj.handle_id = h.ROWID LEFT JOIN (" + _testActivityQuery + ") AS act ON act.chat_id = c.ROWID WHERE h.id = ? ORDER BY c.ROWID")).
+				WithArgs("+14155555555")
+			tt.setupQuery(query)
+			cdb := NewChatDB(db, "Me", NameFormat{}, PoolOptions{})
+
+			chats, err := cdb.GetChatsForHandle("+14155555555", tt.contactMap)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantChats, chats)
+		})
+	}
+}
+
 func TestGetMessageIDs(t *testing.T) {
 	tests := []struct {
 		msg        string
@@ -241,7 +445,7 @@ func TestGetMessageIDs(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery("SELECT message_id FROM chat_message_join WHERE chat_id=42")
+			query := sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT message_id FROM chat_message_join JOIN message ON message_id = message.ROWID WHERE chat_id=42 ORDER BY %s, message_id", _sortDate)))
 			tt.setupQuery(query)
 			cdb := &chatDB{DB: db}
 
@@ -256,34 +460,368 @@ func TestGetMessageIDs(t *testing.T) {
 	}
 }
 
+func TestGetMessagesPage(t *testing.T) {
+	pageQuery := regexp.QuoteMeta(fmt.Sprintf("SELECT message.ROWID, %[1]s FROM chat_message_join JOIN message ON message_id = message.ROWID WHERE chat_id=42 AND (%[1]s > ? OR (%[1]s = ? AND message.ROWID > ?)) ORDER BY %[1]s, message.ROWID LIMIT 3", _sortDate))
+	messageRow := func(text string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+			AddRow(0, 10, text, "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+	}
+
+	tests := []struct {
+		msg          string
+		pageToken    string
+		limit        int
+		setupMock    func(sqlmock.Sqlmock)
+		wantTexts    []string
+		wantNextPage string
+		wantErr      string
+	}{
+		{
+			msg:   "first page",
+			limit: 2,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(pageQuery).WithArgs(0, 0, -1).WillReturnRows(sqlmock.NewRows([]string{"ROWID", "sort_date"}).
+					AddRow(192, 591900000000000000).
+					AddRow(168, 591900000000000000).
+					AddRow(200, 591900060000000000))
+				sMock.ExpectQuery("SELECT is_from_me").WillReturnRows(messageRow("message192"))
+				sMock.ExpectQuery("SELECT is_from_me").WillReturnRows(messageRow("message168"))
+			},
+			wantTexts:    []string{"message192", "message168"},
+			wantNextPage: "591900000000000000-168",
+		},
+		{
+			msg:       "last page",
+			pageToken: "591900000000000000-168",
+			limit:     2,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(pageQuery).WithArgs(591900000000000000, 591900000000000000, 168).WillReturnRows(sqlmock.NewRows([]string{"ROWID", "sort_date"}).
+					AddRow(200, 591900060000000000))
+				sMock.ExpectQuery("SELECT is_from_me").WillReturnRows(messageRow("message200"))
+			},
+			wantTexts: []string{"message200"},
+		},
+		{
+			msg:       "bad page token",
+			pageToken: "page2",
+			limit:     2,
+			setupMock: func(sqlmock.Sqlmock) {},
+			wantErr:   `parse page token "page2"`,
+		},
+		{
+			msg:       "bad page size",
+			setupMock: func(sqlmock.Sqlmock) {},
+			wantErr:   "invalid page size 0",
+		},
+		{
+			msg:   "DB error",
+			limit: 2,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(pageQuery).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query page of messages for chat ID 42: this is a DB error",
+		},
+		{
+			msg:   "message error",
+			limit: 2,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(pageQuery).WillReturnRows(sqlmock.NewRows([]string{"ROWID", "sort_date"}).
+					AddRow(192, 591900000000000000))
+				sMock.ExpectQuery("SELECT is_from_me").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "get message with ID 192: query message table for ID 192: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+
+			msgs, nextPage, err := cdb.GetMessagesPage(42, tt.pageToken, tt.limit, nil, semver.MustParse("13.0"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			var texts []string
+			for _, msg := range msgs {
+				texts = append(texts, msg.Text)
+			}
+			assert.DeepEqual(t, tt.wantTexts, texts)
+			assert.Equal(t, tt.wantNextPage, nextPage)
+		})
+	}
+}
+
 func TestGetMessage(t *testing.T) {
 	handleMap := map[int]string{
 		10: "testhandle1",
+		11: "testhandle2",
 	}
 
 	tests := []struct {
-		msg         string
-		setupQuery  func(*sqlmock.ExpectedQuery)
-		wantMessage string
-		wantErr     string
+		msg          string
+		macOSVersion string
+		setupQuery   func(*sqlmock.ExpectedQuery)
+		wantMessage  Message
+		wantErr      string
 	}{
 		{
 			msg: "message to me",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date"}).
-					AddRow(0, 10, "message text", "2019-10-04 18:26:31")
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+			},
+		},
+		{
+			msg: "reaction",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "Loved “message text”", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", true)
 				query.WillReturnRows(rows)
 			},
-			wantMessage: "[2019-10-04 18:26:31] testhandle1: message text\n",
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "Loved “message text”",
+				RawText:  "Loved “message text”",
+				Service:  "iMessage",
+				Reaction: true,
+			},
+		},
+		{
+			msg: "legacy SMS text",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "\x93caf\xe9\x94", "2019-10-04 18:26:31", 0, 0, 0, 0, "SMS", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:          42,
+				Date:        time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID:    10,
+				Handle:      "testhandle1",
+				Text:        "\ufffdcafé\ufffd [undecodable content]",
+				RawText:     "\ufffdcafé\ufffd",
+				Service:     "SMS",
+				Undecodable: true,
+			},
+		},
+		{
+			msg: "unkept audio message",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "\ufffc", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", true, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:          42,
+				Date:        time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID:    10,
+				Handle:      "testhandle1",
+				Text:        "\ufffc",
+				RawText:     "\ufffc",
+				Service:     "iMessage",
+				UnkeptAudio: true,
+			},
 		},
 		{
 			msg: "message from me",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date"}).
-					AddRow(1, 10, "message text", "2019-10-04 18:26:31")
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(1, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "Me",
+				FromMe:   true,
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+			},
+		},
+		{
+			msg: "date delivered",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 1, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:         42,
+				Date:       time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				DateSource: DateDelivered,
+				HandleID:   10,
+				Handle:     "testhandle1",
+				Text:       "message text",
+				RawText:    "message text",
+				Service:    "iMessage",
+			},
+		},
+		{
+			msg: "participant added",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "", "2019-10-04 18:26:31", 0, 1, 0, 11, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:            42,
+				Date:          time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID:      10,
+				Handle:        "testhandle1",
+				Text:          "added testhandle2 to the conversation",
+				Service:       "iMessage",
+				GroupAction:   ParticipantAdded,
+				OtherHandleID: 11,
+			},
+		},
+		{
+			msg:          "Mac OS 10.15",
+			macOSVersion: "10.15",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+			},
+		},
+		{
+			msg: "edited message",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, true, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+				Edited:   true,
+			},
+		},
+		{
+			msg: "unsent message",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, true, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "unsent a message",
+				Service:  "iMessage",
+			},
+		},
+		{
+			msg: "effect and Digital Touch",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "com.apple.MobileSMS.expressivesend.impact", "com.apple.DigitalTouchBalloonProvider", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+				Hints:    []string{"sent with Digital Touch", "sent with Slam effect"},
+			},
+		},
+		{
+			msg: "text in attributed body",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, attributedBody("message text"), "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "message text",
+				RawText:  "message text",
+				Service:  "iMessage",
+			},
+		},
+		{
+			msg: "truncated attributed body",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				body := attributedBody("message text")
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, body[:len(body)-13], "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantMessage: Message{
+				ID:          42,
+				Date:        time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID:    10,
+				Handle:      "testhandle1",
+				Text:        "message [undecodable content]",
+				RawText:     "message",
+				Service:     "iMessage",
+				Undecodable: true,
+			},
+		},
+		{
+			msg: "mention",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				body := testAttributedBody("Novak, tennis?",
+					testRun{length: 5, index: 1, attrs: map[string]interface{}{_mentionAttribute: "novak@example.com"}},
+					testRun{length: 9, index: 2, attrs: map[string]interface{}{}},
+				)
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "Novak, tennis?", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, body, "", "", "", false)
 				query.WillReturnRows(rows)
 			},
-			wantMessage: "[2019-10-04 18:26:31] Me: message text\n",
+			wantMessage: Message{
+				ID:       42,
+				Date:     time.Date(2019, time.October, 4, 18, 26, 31, 0, time.Local),
+				HandleID: 10,
+				Handle:   "testhandle1",
+				Text:     "Novak, tennis?",
+				RawText:  "Novak, tennis?",
+				Service:  "iMessage",
+				Mentions: []Mention{{Text: "Novak", Handle: "novak@example.com"}},
+			},
 		},
 		{
 			msg: "DB error",
@@ -295,18 +833,27 @@ func TestGetMessage(t *testing.T) {
 		{
 			msg: "row scan error",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date"}).
-					AddRow(0, nil, "message text", "2019-10-04 18:26:31")
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, nil, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
 				query.WillReturnRows(rows)
 			},
 			wantErr: "read data for message ID 42: sql: Scan error on column index 1, name \"handle_id\": converting NULL to int is unsupported",
 		},
+		{
+			msg: "bad date",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "not a date", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+				query.WillReturnRows(rows)
+			},
+			wantErr: `parse date "not a date" for message ID 42`,
+		},
 		{
 			msg: "duplicate message ID",
 			setupQuery: func(query *sqlmock.ExpectedQuery) {
-				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date"}).
-					AddRow(0, 10, "message text", "2019-10-04 18:26:31").
-					AddRow(1, 10, "response message text", "2019-10-04 18:26:54")
+				rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+					AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false).
+					AddRow(1, 10, "response message text", "2019-10-04 18:26:54", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
 				query.WillReturnRows(rows)
 			},
 			wantErr: "multiple messages with the same ID: 42 - message ID uniqeness assumption violated - open an issue at https://github.com/tagatac/bagoup/issues",
@@ -318,58 +865,936 @@ func TestGetMessage(t *testing.T) {
 			db, sMock, err := sqlmock.New()
 			assert.NilError(t, err)
 			defer db.Close()
-			query := sMock.ExpectQuery(`SELECT is_from_me, handle_id, COALESCE\(text, ''\), DATETIME\(\(date\/1000000000\) \+ STRFTIME\('%s', '2001\-01\-01 00\:00\:00'\), 'unixepoch', 'localtime'\) FROM message WHERE ROWID\=42`)
+			v := semver.MustParse("13.0")
+			editedColumns := "date_edited > 0, date_retracted > 0, attributedBody, COALESCE(thread_originator_guid, ''), COALESCE(expressive_send_style_id, ''), COALESCE(balloon_bundle_id, ''), associated_message_type >= 2000"
+			if tt.macOSVersion != "" {
+				v = semver.MustParse(tt.macOSVersion)
+				editedColumns = "0 > 0, 0 > 0, attributedBody, COALESCE(NULL, ''), COALESCE(expressive_send_style_id, ''), COALESCE(balloon_bundle_id, ''), associated_message_type >= 2000"
+			}
+			query := sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s), %s, item_type, group_action_type, other_handle, COALESCE(service, ''), %s, %s FROM message WHERE ROWID=42", fmt.Sprintf(_datetimeFormula, _effectiveDate), _dateSource, _unkeptAudio, editedColumns)))
 			tt.setupQuery(query)
 			cdb := &chatDB{DB: db, selfHandle: "Me"}
 
-			message, err := cdb.GetMessage(42, handleMap, nil)
+			message, err := cdb.GetMessage(42, handleMap, v)
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
 				return
 			}
 			assert.NilError(t, err)
-			assert.Equal(t, tt.wantMessage, message)
+			assert.DeepEqual(t, tt.wantMessage, message)
 		})
 	}
 }
 
-func TestGetDatetimeFormula(t *testing.T) {
+func TestGetMessageReply(t *testing.T) {
+	handleMap := map[int]string{
+		10: "testhandle1",
+	}
+	replyQuery := regexp.QuoteMeta("SELECT m.ROWID, m.is_from_me, m.handle_id, COALESCE(m.text, ''), m.attributedBody, COALESCE((SELECT a.mime_type FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID WHERE maj.message_id = m.ROWID ORDER BY a.ROWID LIMIT 1), '') FROM message AS m WHERE m.guid = ?")
+	replyColumns := []string{"ROWID", "is_from_me", "handle_id", "text", "attributedBody", "mime_type"}
+
 	tests := []struct {
-		msg         string
-		v           *semver.Version
-		prevFormula string
-		wantFormula string
+		msg        string
+		setupQuery func(*sqlmock.ExpectedQuery)
+		wantReply  *Reply
+		wantErr    string
 	}{
 		{
-			msg:         "catalina",
-			v:           semver.MustParse("10.15.3"),
-			wantFormula: _datetimeFormula,
-		},
-		{
-			msg:         "missing version",
-			wantFormula: _datetimeFormula,
+			msg: "reply to text",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(replyColumns).AddRow(41, 1, 0, "Want to play tennis?", nil, ""))
+			},
+			wantReply: &Reply{ID: 41, Handle: "Me", FromMe: true, Text: "Want to play tennis?"},
 		},
 		{
-			msg:         "high sierra",
-			v:           semver.MustParse("10.13"),
-			wantFormula: _datetimeFormula,
+			msg: "reply to photo",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(replyColumns).AddRow(41, 0, 10, "\ufffc", nil, "image/jpeg"))
+			},
+			wantReply: &Reply{ID: 41, Handle: "testhandle1", Text: "[photo]"},
 		},
 		{
-			msg:         "sierra",
-			v:           semver.MustParse("10.12.6"),
-			wantFormula: _datetimeFormulaLegacy,
+			msg: "reply to deleted message",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(replyColumns))
+			},
 		},
 		{
-			msg:         "previously saved formula",
-			prevFormula: "previous formula",
-			wantFormula: "previous formula",
+			msg: "DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: `get reply context for message ID 42: query message table for GUID "testguid": this is a DB error`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.msg, func(t *testing.T) {
-			cdb := &chatDB{datetimeFormula: tt.prevFormula}
-			assert.Equal(t, tt.wantFormula, cdb.getDatetimeFormula(tt.v))
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+				AddRow(0, 10, "Sure", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "testguid", "", "", false)
+			sMock.ExpectQuery("SELECT is_from_me").WillReturnRows(rows)
+			tt.setupQuery(sMock.ExpectQuery(replyQuery).WithArgs("testguid"))
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+
+			message, err := cdb.GetMessage(42, handleMap, semver.MustParse("13.0"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantReply, message.ReplyTo)
+		})
+	}
+}
+
+func TestGetRawMessage(t *testing.T) {
+	columns := []string{"guid", "handle_id", "text", "attributedBody", "service", "account", "date", "date_read", "date_delivered", "is_from_me", "is_read", "is_delivered", "is_sent", "item_type", "group_action_type", "error"}
+	text := "message text"
+
+	tests := []struct {
+		msg        string
+		setupQuery func(*sqlmock.ExpectedQuery)
+		wantMsg    RawMessage
+		wantErr    string
+	}{
+		{
+			msg: "message with text",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(columns).
+					AddRow("testguid", 10, "message text", nil, "iMessage", "e:me@example.com", 591935191000000000, 591935200000000000, 591935195000000000, false, true, true, false, 0, 0, 0))
+			},
+			wantMsg: RawMessage{
+				ROWID:         42,
+				GUID:          "testguid",
+				HandleID:      10,
+				Text:          &text,
+				Service:       "iMessage",
+				Account:       "e:me@example.com",
+				Date:          591935191000000000,
+				DateRead:      591935200000000000,
+				DateDelivered: 591935195000000000,
+				IsRead:        true,
+				IsDelivered:   true,
+			},
+		},
+		{
+			msg: "message without text",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(columns).
+					AddRow("testguid", 0, nil, []byte("body"), "SMS", "", 591935191, 0, 0, true, false, false, true, 0, 0, 22))
+			},
+			wantMsg: RawMessage{
+				ROWID:          42,
+				GUID:           "testguid",
+				AttributedBody: []byte("body"),
+				Service:        "SMS",
+				Date:           591935191,
+				IsFromMe:       true,
+				IsSent:         true,
+				Error:          22,
+			},
+		},
+		{
+			msg: "DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query message table for ID 42: this is a DB error",
+		},
+		{
+			msg: "missing message",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(columns))
+			},
+			wantErr: "no message with ID 42",
+		},
+		{
+			msg: "scan error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(nil, 10, "message text", nil, "iMessage", "", 0, 0, 0, false, false, false, false, 0, 0, 0))
+			},
+			wantErr: "read data for message ID 42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupQuery(sMock.ExpectQuery("SELECT guid, handle_id, text, attributedBody"))
+			cdb := &chatDB{DB: db}
+
+			msg, err := cdb.GetRawMessage(42)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantMsg, msg)
+		})
+	}
+}
+
+func TestSummarizeReply(t *testing.T) {
+	tests := []struct {
+		msg      string
+		text     string
+		mimeType string
+		want     string
+	}{
+		{
+			msg:  "text",
+			text: "Want to play\ntennis?",
+			want: "Want to play tennis?",
+		},
+		{
+			msg:  "long text",
+			text: strings.Repeat("tennis ", 10),
+			want: "tennis tennis tennis tennis tennis tennis tennis tennis tenn…",
+		},
+		{
+			msg:      "text with attachment",
+			text:     "\ufffcLook at this",
+			mimeType: "image/jpeg",
+			want:     "Look at this",
+		},
+		{
+			msg:      "video",
+			text:     "\ufffc",
+			mimeType: "video/quicktime",
+			want:     "[video]",
+		},
+		{
+			msg:      "audio message",
+			text:     "\ufffc",
+			mimeType: "audio/x-caf",
+			want:     "[audio message]",
+		},
+		{
+			msg:      "other attachment",
+			text:     "\ufffc",
+			mimeType: "application/pdf",
+			want:     "[attachment]",
+		},
+		{
+			msg:  "no content",
+			want: "[message]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, summarizeReply(tt.text, tt.mimeType))
+		})
+	}
+}
+
+func TestMessageHints(t *testing.T) {
+	tests := []struct {
+		msg     string
+		effect  string
+		balloon string
+		want    []string
+	}{
+		{
+			msg: "none",
+		},
+		{
+			msg:    "screen effect",
+			effect: "com.apple.messages.effect.CKHappyBirthdayEffect",
+			want:   []string{"sent with Balloons effect"},
+		},
+		{
+			msg:    "unknown effect",
+			effect: "com.apple.messages.effect.CKNewEffect",
+			want:   []string{"sent with CKNewEffect effect"},
+		},
+		{
+			msg:     "handwriting",
+			balloon: "com.apple.Handwriting.HandwritingProvider",
+			want:    []string{"sent as handwriting"},
+		},
+		{
+			msg:     "other app",
+			balloon: "com.apple.messages.URLBalloonProvider",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, messageHints(tt.effect, tt.balloon))
+		})
+	}
+}
+
+func TestGetDatetimeFormula(t *testing.T) {
+	detectQuery := regexp.QuoteMeta(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM message WHERE date > %s)", _nanosecondThreshold))
+
+	tests := []struct {
+		msg         string
+		v           *semver.Version
+		prevFormula string
+		setupMock   func(sqlmock.Sqlmock)
+		wantFormula string
+		wantErr     string
+	}{
+		{
+			msg:         "catalina",
+			v:           semver.MustParse("10.15.3"),
+			wantFormula: _datetimeFormula,
+		},
+		{
+			msg:         "high sierra",
+			v:           semver.MustParse("10.13"),
+			wantFormula: _datetimeFormula,
+		},
+		{
+			msg:         "sierra",
+			v:           semver.MustParse("10.12.6"),
+			wantFormula: _datetimeFormulaLegacy,
+		},
+		{
+			msg: "missing version, dates in nanoseconds",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(detectQuery).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			wantFormula: _datetimeFormula,
+		},
+		{
+			msg: "missing version, dates in seconds",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(detectQuery).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+			wantFormula: _datetimeFormulaLegacy,
+		},
+		{
+			msg: "missing version, database locked",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(detectQuery).WillReturnError(errors.New("database is locked"))
+				sMock.ExpectQuery(detectQuery).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+			wantFormula: _datetimeFormula,
+		},
+		{
+			msg: "detection error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(detectQuery).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "detect date format: this is a DB error",
+		},
+		{
+			msg:         "previously saved formula",
+			prevFormula: "previous formula",
+			wantFormula: "previous formula",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			if tt.setupMock != nil {
+				tt.setupMock(sMock)
+			}
+			cdb := &chatDB{DB: db, datetimeFormula: tt.prevFormula, retries: 1, sleep: func(time.Duration) {}}
+
+			formula, err := cdb.getDatetimeFormula(tt.v)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantFormula, formula)
+			if tt.v == nil {
+				assert.Equal(t, tt.wantFormula, cdb.datetimeFormula)
+			}
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetGroupAction(t *testing.T) {
+	tests := []struct {
+		itemType        int
+		groupActionType int
+		want            GroupAction
+	}{
+		{itemType: 0, groupActionType: 0, want: NoGroupAction},
+		{itemType: 1, groupActionType: 0, want: ParticipantAdded},
+		{itemType: 1, groupActionType: 1, want: ParticipantRemoved},
+		{itemType: 2, groupActionType: 0, want: NoGroupAction},
+		{itemType: 3, groupActionType: 0, want: ParticipantLeft},
+		{itemType: 3, groupActionType: 1, want: NoGroupAction},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.itemType, tt.groupActionType), func(t *testing.T) {
+			assert.Equal(t, tt.want, getGroupAction(tt.itemType, tt.groupActionType))
+		})
+	}
+}
+
+func TestGetParticipants(t *testing.T) {
+	tests := []struct {
+		msg        string
+		setupQuery func(*sqlmock.ExpectedQuery)
+		wantIDs    []int
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"handle_id"}).
+					AddRow(10).
+					AddRow(11)
+				query.WillReturnRows(rows)
+			},
+			wantIDs: []int{10, 11},
+		},
+		{
+			msg: "DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat_handle_join table for chat ID 42: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"handle_id"}).
+					AddRow(nil)
+				query.WillReturnRows(rows)
+			},
+			wantErr: "read handle ID for chat ID 42: sql: Scan error on column index 0, name \"handle_id\": converting NULL to int is unsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			query := sMock.ExpectQuery("SELECT handle_id FROM chat_handle_join WHERE chat_id=42 ORDER BY handle_id")
+			tt.setupQuery(query)
+			cdb := &chatDB{DB: db}
+
+			ids, err := cdb.GetParticipants(42)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestGetAttachmentPaths(t *testing.T) {
+	tests := []struct {
+		msg             string
+		setupQuery      func(*sqlmock.ExpectedQuery)
+		wantAttachments map[int][]Attachment
+		wantErr         string
+	}{
+		{
+			msg: "success",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"message_id", "ROWID", "filename", "mime_type", "transfer_name", "total_bytes", "user_info"}).
+					AddRow(1, 1, "~/Library/Messages/Attachments/ab/11/photo.jpeg", "image/jpeg", "photo.jpeg", 1024, nil).
+					AddRow(1, 2, "~/Library/Messages/Attachments/cd/12/jane.vcf", "text/vcard", "Jane Doe.vcf", 90, nil).
+					AddRow(2, 3, "~/Library/Messages/Attachments/ef/13/invite.ics", "text/calendar", "invite.ics", 0, nil).
+					AddRow(3, 4, "~/Library/Messages/Attachments/01/14/Audio Message.caf", "audio/x-caf", "Audio Message.caf", 2048, testBPlist(bplistDict(1, 2), bplistString("audio-transcription"), bplistString("See you at 4")))
+				query.WillReturnRows(rows)
+			},
+			wantAttachments: map[int][]Attachment{
+				1: {
+					{ID: 1, Filename: "~/Library/Messages/Attachments/ab/11/photo.jpeg", MIMEType: "image/jpeg", TransferName: "photo.jpeg", TotalBytes: 1024},
+					{ID: 2, Filename: "~/Library/Messages/Attachments/cd/12/jane.vcf", MIMEType: "text/vcard", TransferName: "Jane Doe.vcf", TotalBytes: 90},
+				},
+				2: {
+					{ID: 3, Filename: "~/Library/Messages/Attachments/ef/13/invite.ics", MIMEType: "text/calendar", TransferName: "invite.ics"},
+				},
+				3: {
+					{ID: 4, Filename: "~/Library/Messages/Attachments/01/14/Audio Message.caf", MIMEType: "audio/x-caf", TransferName: "Audio Message.caf", TotalBytes: 2048, Transcript: "See you at 4"},
+				},
+			},
+		},
+		{
+			msg: "DB error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query attachments: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				rows := sqlmock.NewRows([]string{"message_id", "ROWID", "filename", "mime_type", "transfer_name", "total_bytes", "user_info"}).
+					AddRow(nil, 1, "~/Library/Messages/Attachments/ab/11/photo.jpeg", "image/jpeg", "photo.jpeg", 1024, nil)
+				query.WillReturnRows(rows)
+			},
+			wantErr: "read attachment: sql: Scan error on column index 0, name \"message_id\": converting NULL to int is unsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			query := sMock.ExpectQuery(`SELECT maj.message_id, a.ROWID, COALESCE\(a.filename, ''\), COALESCE\(a.mime_type, ''\), COALESCE\(a.transfer_name, ''\), COALESCE\(a.total_bytes, 0\), a.user_info FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID ORDER BY maj.message_id, a.ROWID`)
+			tt.setupQuery(query)
+			cdb := &chatDB{DB: db}
+
+			attachments, err := cdb.GetAttachmentPaths()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantAttachments, attachments)
+		})
+	}
+}
+
+func TestGetChatSizes(t *testing.T) {
+	sizesQuery := "SELECT cmj.chat_id, COUNT(*), COALESCE(SUM(COALESCE(LENGTH(m.text), LENGTH(m.attributedBody), 0)), 0), COALESCE(SUM((SELECT SUM(a.total_bytes) FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID WHERE maj.message_id = m.ROWID)), 0) FROM chat_message_join AS cmj JOIN message AS m ON cmj.message_id = m.ROWID GROUP BY cmj.chat_id"
+
+	tests := []struct {
+		msg       string
+		setupMock func(sqlmock.Sqlmock)
+		wantSizes map[int]ChatSize
+		wantErr   string
+	}{
+		{
+			msg: "success",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "messages", "text_bytes", "attachment_bytes"}).
+					AddRow(1, 192, 4096, 1048576).
+					AddRow(2, 1, 20, 0)
+				sMock.ExpectQuery(regexp.QuoteMeta(sizesQuery)).WillReturnRows(rows)
+			},
+			wantSizes: map[int]ChatSize{
+				1: {Messages: 192, TextBytes: 4096, AttachmentBytes: 1048576},
+				2: {Messages: 1, TextBytes: 20},
+			},
+		},
+		{
+			msg: "no chat_message_join table",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(sizesQuery)).WillReturnError(errors.New("no such table: chat_message_join"))
+				schemaRows := sqlmock.NewRows([]string{"table", "column"}).
+					AddRow("message", "ROWID").
+					AddRow("message", "text").
+					AddRow("message", "handle_id").
+					AddRow("chat_handle_join", "chat_id").
+					AddRow("chat_handle_join", "handle_id").
+					AddRow("attachment", "ROWID")
+				sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnRows(schemaRows)
+				rows := sqlmock.NewRows([]string{"chat_id", "messages", "text_bytes", "attachment_bytes"}).AddRow(1, 2, 40, 0)
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT chj.chat_id, COUNT(*), COALESCE(SUM(COALESCE(LENGTH(m.text), LENGTH(NULL), 0)), 0), COALESCE(SUM((SELECT SUM(0) FROM message_attachment_join AS maj JOIN attachment AS a ON maj.attachment_id = a.ROWID WHERE maj.message_id = m.ROWID)), 0) FROM chat_handle_join AS chj JOIN message AS m ON m.handle_id = chj.handle_id GROUP BY chj.chat_id")).
+					WillReturnRows(rows)
+			},
+			wantSizes: map[int]ChatSize{1: {Messages: 2, TextBytes: 40}},
+		},
+		{
+			msg: "DB error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(sizesQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat sizes: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "messages", "text_bytes", "attachment_bytes"}).AddRow(nil, 1, 20, 0)
+				sMock.ExpectQuery(regexp.QuoteMeta(sizesQuery)).WillReturnRows(rows)
+			},
+			wantErr: "read chat size: sql: Scan error on column index 0, name \"chat_id\": converting NULL to int is unsupported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := &chatDB{DB: db}
+
+			sizes, err := cdb.GetChatSizes()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantSizes, sizes)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSyncedMessageCount(t *testing.T) {
+	syncedQuery := "SELECT COUNT(*) FROM message WHERE ck_sync_state != 0"
+
+	tests := []struct {
+		msg       string
+		setupMock func(sqlmock.Sqlmock)
+		wantCount int
+		wantErr   string
+	}{
+		{
+			msg: "success",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"count"}).AddRow(192)
+				sMock.ExpectQuery(regexp.QuoteMeta(syncedQuery)).WillReturnRows(rows)
+			},
+			wantCount: 192,
+		},
+		{
+			msg: "no ck_sync_state column",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(syncedQuery)).WillReturnError(errors.New("no such column: ck_sync_state"))
+				schemaRows := sqlmock.NewRows([]string{"table", "column"}).
+					AddRow("message", "ROWID").
+					AddRow("message", "text")
+				sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnRows(schemaRows)
+				rows := sqlmock.NewRows([]string{"count"}).AddRow(0)
+				sMock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM message WHERE 0 != 0")).WillReturnRows(rows)
+			},
+		},
+		{
+			msg: "DB error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(syncedQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query message sync states: this is a DB error",
+		},
+		{
+			msg: "row scan error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"count"}).AddRow("many")
+				sMock.ExpectQuery(regexp.QuoteMeta(syncedQuery)).WillReturnRows(rows)
+			},
+			wantErr: "read synced message count: sql: Scan error on column index 0, name \"count\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := &chatDB{DB: db}
+
+			count, err := cdb.GetSyncedMessageCount()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantCount, count)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetMessageByGUID(t *testing.T) {
+	indexQuery := "SELECT COUNT(*) FROM sqlite_master AS m JOIN pragma_index_info(m.name) AS i WHERE m.type = 'index' AND m.tbl_name = 'message' AND i.seqno = 0 AND i.name = 'guid'"
+	idQuery := "SELECT ROWID FROM message WHERE guid = ?"
+	messageQuery := fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s), %s, item_type, group_action_type, other_handle, COALESCE(service, ''), %s, date_edited > 0, date_retracted > 0, attributedBody, COALESCE(thread_originator_guid, ''), COALESCE(expressive_send_style_id, ''), COALESCE(balloon_bundle_id, ''), associated_message_type >= 2000 FROM message WHERE ROWID=42", fmt.Sprintf(_datetimeFormula, _effectiveDate), _dateSource, _unkeptAudio)
+	expectMessage := func(sMock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+			AddRow(0, 10, "message text", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false)
+		sMock.ExpectQuery(regexp.QuoteMeta(messageQuery)).WillReturnRows(rows)
+	}
+
+	tests := []struct {
+		msg          string
+		setupMock    func(sqlmock.Sqlmock)
+		wantErr      string
+		wantNotFound bool
+	}{
+		{
+			msg: "indexed",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnRows(sqlmock.NewRows([]string{"ROWID"}).AddRow(42))
+				expectMessage(sMock)
+			},
+		},
+		{
+			msg: "not indexed",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnRows(sqlmock.NewRows([]string{"ROWID"}).AddRow(42))
+				expectMessage(sMock)
+			},
+		},
+		{
+			msg: "index check error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnError(errors.New("no such table: pragma_index_info"))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnRows(sqlmock.NewRows([]string{"ROWID"}).AddRow(42))
+				expectMessage(sMock)
+			},
+		},
+		{
+			msg: "not found",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnRows(sqlmock.NewRows([]string{"ROWID"}))
+			},
+			wantErr:      `no message with GUID "testguid"`,
+			wantNotFound: true,
+		},
+		{
+			msg: "DB error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: `query message table for GUID "testguid": this is a DB error`,
+		},
+		{
+			msg: "row scan error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(indexQuery)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				sMock.ExpectQuery(regexp.QuoteMeta(idQuery)).WithArgs("testguid").WillReturnRows(sqlmock.NewRows([]string{"ROWID"}).AddRow("forty-two"))
+			},
+			wantErr: `read ID of message GUID "testguid": sql: Scan error on column index 0, name "ROWID"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+
+			message, err := cdb.GetMessageByGUID("testguid", map[int]string{10: "testhandle1"}, semver.MustParse("13.0"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantNotFound, errors.Is(err, ErrNotFound))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, 42, message.ID)
+			assert.Equal(t, "testhandle1", message.Handle)
+			assert.Equal(t, "message text", message.Text)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestFullName(t *testing.T) {
+	novak := vcard.Card{
+		"FN": []*vcard.Field{{Value: "Novak Djokovic"}},
+		"N":  []*vcard.Field{{Value: "Djokovic;Novak;;Dr.;Jr."}},
+	}
+	xiaoming := vcard.Card{
+		"FN":                    []*vcard.Field{{Value: "小明 王"}},
+		"N":                     []*vcard.Field{{Value: "王;小明;;;"}},
+		"X-PHONETIC-LAST-NAME":  []*vcard.Field{{Value: "Wang"}},
+		"X-PHONETIC-FIRST-NAME": []*vcard.Field{{Value: "Xiaoming"}},
+	}
+	fnOnly := vcard.Card{
+		"FN": []*vcard.Field{{Value: "Novak Djokovic"}},
+	}
+
+	tests := []struct {
+		msg    string
+		format NameFormat
+		card   vcard.Card
+		want   string
+	}{
+		{
+			msg:  "default",
+			card: novak,
+			want: "Novak Djokovic",
+		},
+		{
+			msg:    "family first",
+			format: NameFormat{Order: FamilyNameFirst},
+			card:   novak,
+			want:   "Djokovic Novak",
+		},
+		{
+			msg:    "honorifics",
+			format: NameFormat{Order: GivenNameFirst, Honorifics: true},
+			card:   novak,
+			want:   "Dr. Novak Djokovic Jr.",
+		},
+		{
+			msg:    "family first with honorifics",
+			format: NameFormat{Order: FamilyNameFirst, Honorifics: true},
+			card:   novak,
+			want:   "Dr. Djokovic Novak Jr.",
+		},
+		{
+			msg:    "auto without phonetic name",
+			format: NameFormat{Order: AutoNameOrder},
+			card:   novak,
+			want:   "Novak Djokovic",
+		},
+		{
+			msg:    "auto with phonetic name",
+			format: NameFormat{Order: AutoNameOrder},
+			card:   xiaoming,
+			want:   "王小明",
+		},
+		{
+			msg:    "no name parts",
+			format: NameFormat{Order: FamilyNameFirst},
+			card:   fnOnly,
+			want:   "Novak Djokovic",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.format.FullName(&tt.card))
+		})
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	tests := []struct {
+		msg        string
+		dateSource DateSource
+		edited     bool
+		hints      []string
+		replyTo    *Reply
+		want       string
+	}{
+		{
+			msg:  "date sent",
+			want: "[2020-03-01 15:34:05] Novak: Want to play tennis?\n",
+		},
+		{
+			msg:        "date delivered",
+			dateSource: DateDelivered,
+			want:       "[2020-03-01 15:34:05 (delivered)] Novak: Want to play tennis?\n",
+		},
+		{
+			msg:        "date read",
+			dateSource: DateRead,
+			want:       "[2020-03-01 15:34:05 (read)] Novak: Want to play tennis?\n",
+		},
+		{
+			msg:        "no valid date",
+			dateSource: DateUnknown,
+			want:       "[date unknown] Novak: Want to play tennis?\n",
+		},
+		{
+			msg:    "edited",
+			edited: true,
+			want:   "[2020-03-01 15:34:05] Novak: Want to play tennis? (edited)\n",
+		},
+		{
+			msg:    "hints",
+			edited: true,
+			hints:  []string{"sent as handwriting", "sent with Slam effect"},
+			want:   "[2020-03-01 15:34:05] Novak: Want to play tennis? (edited) (sent as handwriting, sent with Slam effect)\n",
+		},
+		{
+			msg:     "reply",
+			replyTo: &Reply{ID: 41, Handle: "Me", Text: "[photo]"},
+			want:    "> In reply to Me: [photo]\n[2020-03-01 15:34:05] Novak: Want to play tennis?\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			msg := Message{
+				Date:       time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local),
+				DateSource: tt.dateSource,
+				Handle:     "Novak",
+				Text:       "Want to play tennis?",
+				Edited:     tt.edited,
+				Hints:      tt.hints,
+				ReplyTo:    tt.replyTo,
+			}
+			assert.Equal(t, tt.want, msg.String())
+		})
+	}
+}
+
+// attributedBody archives the given text like the attributedBody column of the
+// message table.
+func attributedBody(text string) []byte {
+	body := []byte("\x04\x0bstreamtyped\x81\xe8\x03\x84\x01@\x84\x84\x84\x12NSAttributedString\x00\x84\x84\x08NSObject\x00\x85\x92\x84\x84\x84\x08NSString\x01\x94\x84\x01+")
+	switch {
+	case len(text) >= 0x10000:
+		body = append(body, 0x82, byte(len(text)), byte(len(text)>>8), byte(len(text)>>16), byte(len(text)>>24))
+	case len(text) >= 0x80:
+		body = append(body, 0x81, byte(len(text)), byte(len(text)>>8))
+	default:
+		body = append(body, byte(len(text)))
+	}
+	body = append(body, text...)
+	return append(body, "\x86\x84\x02iI\x01\x0c\x92"...)
+}
+
+func TestAttributedBodyText(t *testing.T) {
+	long := strings.Repeat("Want to play tennis? ", 10)
+	truncated := attributedBody("Want to play tennis?")
+	truncated = truncated[:len(truncated)-10]
+	tests := []struct {
+		msg     string
+		body    []byte
+		want    string
+		wantErr string
+	}{
+		{
+			msg:  "short text",
+			body: attributedBody("Want to play tennis?"),
+			want: "Want to play tennis?",
+		},
+		{
+			msg:  "long text",
+			body: attributedBody(long),
+			want: long,
+		},
+		{
+			msg: "no attributed body",
+		},
+		{
+			msg:     "truncated",
+			body:    truncated,
+			want:    "Want to play tenni",
+			wantErr: "attributed body truncated after 18 of 20 bytes of text",
+		},
+		{
+			msg:     "truncated in a character",
+			body:    attributedBody("🎾")[:len(attributedBody(""))-6],
+			wantErr: "attributed body truncated after 2 of 4 bytes of text",
+		},
+		{
+			msg:     "no text",
+			body:    []byte("garbage"),
+			wantErr: "no text in attributed body",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			text, err := attributedBodyText(tt.body)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, tt.want, text)
 		})
 	}
 }
@@ -0,0 +1,268 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/emersion/go-vcard"
+)
+
+type (
+	// ContactResolver finds the contacts of handles, i.e. phone numbers and
+	// email addresses, e.g. in a vCard file or an address book.
+	ContactResolver interface {
+		// Contact returns the contact with the given handle, or nil if
+		// there is none.
+		Contact(handle string) *vcard.Card
+	}
+
+	// ContactMap resolves handles with a map of contacts indexed by phone
+	// number and email address, e.g. as read from a vCard file.
+	ContactMap map[string]*vcard.Card
+
+	contactCache struct {
+		resolver ContactResolver
+		mu       sync.Mutex
+		// found maps the canonical identities of handles to their
+		// contacts.
+		found map[string]*vcard.Card
+		// missing records the handles without contacts. They are recorded
+		// as given, since another form of the same handle may match.
+		missing map[string]bool
+	}
+
+	aliasResolver struct {
+		resolver ContactResolver
+		// aliases maps the canonical identities of old handles to the
+		// handles of the same people now.
+		aliases map[string]string
+		// current are the canonical identities of the handles which old
+		// handles are aliases of.
+		current map[string]bool
+		mu      sync.Mutex
+		// placeholders are the contacts of the people with aliases who have
+		// no contact of their own, keyed by the canonical identities of
+		// their current handles.
+		placeholders map[string]*vcard.Card
+	}
+
+	// handleName is a handle as stored in the database, with its contact,
+	// if any.
+	handleName struct {
+		handle string
+		card   *vcard.Card
+	}
+)
+
+// Contact returns the contact with exactly the given handle, if any.
+func (m ContactMap) Contact(handle string) *vcard.Card {
+	return m[handle]
+}
+
+// NewContactCache returns a ContactResolver which looks up each handle with
+// the given resolver only once. Handles which are the same for all services,
+// e.g. "+1 (415) 555-5555" and "+14155555555", share their contact. It is safe
+// for concurrent use.
+func NewContactCache(resolver ContactResolver) ContactResolver {
+	return &contactCache{
+		resolver: resolver,
+		found:    map[string]*vcard.Card{},
+		missing:  map[string]bool{},
+	}
+}
+
+func (c *contactCache) Contact(handle string) *vcard.Card {
+	identity := canonicalIdentity(handle)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if card, ok := c.found[identity]; ok {
+		return card
+	}
+	if c.missing[handle] {
+		return nil
+	}
+	card := c.resolver.Contact(handle)
+	if card == nil && identity != handle {
+		card = c.resolver.Contact(identity)
+	}
+	if card == nil {
+		c.missing[handle] = true
+		return nil
+	}
+	c.found[identity] = card
+	return card
+}
+
+// NewAliasResolver returns a ContactResolver which resolves the handles which
+// a person used before, e.g. an old phone number, to the contact of the handle
+// they use now, given by the alias map from old handles to current handles,
+// and resolves other handles with the given resolver, if any. People without
+// a contact of their own are given one named after their current handle, so
+// that their chats under all of their handles are named alike and exported
+// into the same folder. It is safe for concurrent use if the given resolver
+// is.
+func NewAliasResolver(resolver ContactResolver, aliases map[string]string) ContactResolver {
+	r := &aliasResolver{
+		resolver:     resolver,
+		aliases:      make(map[string]string, len(aliases)),
+		current:      make(map[string]bool, len(aliases)),
+		placeholders: map[string]*vcard.Card{},
+	}
+	for old, handle := range aliases {
+		r.aliases[canonicalIdentity(old)] = handle
+		r.current[canonicalIdentity(handle)] = true
+	}
+	return r
+}
+
+func (r *aliasResolver) Contact(handle string) *vcard.Card {
+	identity := canonicalIdentity(handle)
+	if current, ok := r.aliases[identity]; ok {
+		handle, identity = current, canonicalIdentity(current)
+	}
+	if card := contact(r.resolver, handle); card != nil || !r.current[identity] {
+		return card
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	card, ok := r.placeholders[identity]
+	if !ok {
+		card = &vcard.Card{}
+		card.SetValue(vcard.FieldFormattedName, handle)
+		card.AddName(&vcard.Name{GivenName: handle})
+		r.placeholders[identity] = card
+	}
+	return card
+}
+
+// contact returns the contact with the given handle from the given resolver,
+// if any.
+func contact(contacts ContactResolver, handle string) *vcard.Card {
+	if contacts == nil {
+		return nil
+	}
+	return contacts.Contact(handle)
+}
+
+func (d *chatDB) DisambiguateHandles(handleMap map[int]string, handleIDs []int) map[int]string {
+	ids := append([]int(nil), handleIDs...)
+	sort.Ints(ids)
+	var names []string
+	byName := map[string][]int{}
+	for _, id := range ids {
+		name := handleMap[id]
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], id)
+	}
+	var chatMap map[int]string
+	for _, name := range names {
+		for id, distinct := range d.distinctNames(name, byName[name]) {
+			if chatMap == nil {
+				chatMap = make(map[int]string, len(handleMap))
+				for k, v := range handleMap {
+					chatMap[k] = v
+				}
+			}
+			chatMap[id] = distinct
+		}
+	}
+	if chatMap == nil {
+		return handleMap
+	}
+	return chatMap
+}
+
+// distinctNames tells apart the people with the given handle IDs, in
+// ascending order, who have the given name. Handles of the same person, i.e.
+// with the same contact or identity, keep the same name. People whose full
+// names are distinct are named by them, and the others by the end of their
+// phone numbers, or by their email addresses.
+func (d *chatDB) distinctNames(name string, ids []int) map[int]string {
+	var people [][]int
+	index := map[interface{}]int{}
+	for _, id := range ids {
+		var key interface{} = d.CanonicalHandle(id)
+		if card := d.handleNames[id].card; card != nil {
+			key = card
+		}
+		if i, ok := index[key]; ok {
+			people[i] = append(people[i], id)
+			continue
+		}
+		index[key] = len(people)
+		people = append(people, []int{id})
+	}
+	if len(people) < 2 {
+		return nil
+	}
+
+	labels := make([]string, len(people))
+	fullNames := map[string]int{}
+	for i, person := range people {
+		labels[i] = d.fullName(person[0])
+		fullNames[labels[i]]++
+	}
+	suffixes := map[int]string{}
+	suffixCounts := map[string]int{}
+	for i, person := range people {
+		if labels[i] == "" || labels[i] == name || fullNames[labels[i]] > 1 {
+			suffixes[i] = handleSuffix(d.handleNames[person[0]].handle)
+			suffixCounts[suffixes[i]]++
+		}
+	}
+	distinct := map[int]string{}
+	for i, person := range people {
+		if suffix, ok := suffixes[i]; ok {
+			if suffixCounts[suffix] > 1 {
+				suffix = d.handleNames[person[0]].handle
+			}
+			labels[i] = fmt.Sprintf("%s (%s)", name, suffix)
+		}
+		for _, id := range person {
+			distinct[id] = labels[i]
+		}
+	}
+	return distinct
+}
+
+// fullName returns the full name of the contact of the handle with the given
+// ID, e.g. "Alex Smith", or an empty string if it has no contact.
+func (d *chatDB) fullName(handleID int) string {
+	card := d.handleNames[handleID].card
+	if card == nil {
+		return ""
+	}
+	if fullName := d.nameFormat.FullName(card); fullName != "" {
+		return fullName
+	}
+	if name := card.Name(); name != nil {
+		return joinNonEmpty([]string{name.GivenName, name.FamilyName}, " ")
+	}
+	return ""
+}
+
+// handleSuffix returns the last four digits of the given phone number, or the
+// given email address or short code.
+func handleSuffix(handle string) string {
+	if strings.Contains(handle, "@") {
+		return handle
+	}
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, handle)
+	if len(digits) <= 4 {
+		return handle
+	}
+	return digits[len(digits)-4:]
+}
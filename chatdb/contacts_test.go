@@ -0,0 +1,205 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"gotest.tools/v3/assert"
+)
+
+// countingResolver resolves handles with a contact map, counting the lookups
+// of each handle.
+type countingResolver struct {
+	contacts ContactMap
+	lookups  map[string]int
+}
+
+func (r *countingResolver) Contact(handle string) *vcard.Card {
+	r.lookups[handle]++
+	return r.contacts.Contact(handle)
+}
+
+func TestContactCache(t *testing.T) {
+	novak := &vcard.Card{"FN": []*vcard.Field{{Value: "Novak Djokovic"}}}
+	rafa := &vcard.Card{"FN": []*vcard.Field{{Value: "Rafael Nadal"}}}
+
+	tests := []struct {
+		msg         string
+		handles     []string
+		wantCards   []*vcard.Card
+		wantLookups map[string]int
+	}{
+		{
+			msg:         "repeated handle",
+			handles:     []string{"testhandle1", "testhandle1", "testhandle1"},
+			wantCards:   []*vcard.Card{novak, novak, novak},
+			wantLookups: map[string]int{"testhandle1": 1},
+		},
+		{
+			msg:         "formatted handle falls back to canonical form",
+			handles:     []string{"+1 (415) 555-5555", "+14155555555"},
+			wantCards:   []*vcard.Card{rafa, rafa},
+			wantLookups: map[string]int{"+1 (415) 555-5555": 1, "+14155555555": 1},
+		},
+		{
+			msg:         "unknown handle",
+			handles:     []string{"unknown", "unknown"},
+			wantCards:   []*vcard.Card{nil, nil},
+			wantLookups: map[string]int{"unknown": 1},
+		},
+		{
+			msg:         "missing form does not hide another form",
+			handles:     []string{"+16505555555", "+1 (650) 555-5555", "+16505555555"},
+			wantCards:   []*vcard.Card{nil, rafa, rafa},
+			wantLookups: map[string]int{"+16505555555": 1, "+1 (650) 555-5555": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			resolver := &countingResolver{
+				contacts: ContactMap{"testhandle1": novak, "+14155555555": rafa, "+1 (650) 555-5555": rafa},
+				lookups:  map[string]int{},
+			}
+			cache := NewContactCache(resolver)
+			for i, handle := range tt.handles {
+				assert.Equal(t, tt.wantCards[i], cache.Contact(handle), "handle %q", handle)
+			}
+			assert.DeepEqual(t, tt.wantLookups, resolver.lookups)
+		})
+	}
+}
+
+func TestContactMap(t *testing.T) {
+	novak := &vcard.Card{"FN": []*vcard.Field{{Value: "Novak Djokovic"}}}
+	contacts := ContactMap{"testhandle1": novak}
+	assert.Equal(t, novak, contacts.Contact("testhandle1"))
+	assert.Assert(t, contacts.Contact("TestHandle1") == nil)
+	assert.Assert(t, contact(nil, "testhandle1") == nil)
+	assert.Equal(t, novak, contact(contacts, "testhandle1"))
+}
+
+func TestAliasResolver(t *testing.T) {
+	novak := &vcard.Card{"FN": []*vcard.Field{{Value: "Novak Djokovic"}}}
+	contacts := ContactMap{"+14155555555": novak}
+	aliases := map[string]string{
+		"+1 (415) 555-0000":   "+14155555555",
+		"novak@example.com":   "+14155555555",
+		"+381111111111":       "+381222222222",
+		"Jelena@Example.com ": "jelena@example.com",
+	}
+
+	r := NewAliasResolver(contacts, aliases)
+	assert.Equal(t, novak, r.Contact("+14155555555"))
+	assert.Equal(t, novak, r.Contact("+14155550000"))
+	assert.Equal(t, novak, r.Contact("novak@example.com"))
+	assert.Assert(t, r.Contact("+16505555555") == nil)
+
+	placeholder := r.Contact("+381111111111")
+	assert.Assert(t, placeholder != nil)
+	assert.Equal(t, "+381222222222", placeholder.PreferredValue(vcard.FieldFormattedName))
+	assert.Equal(t, "+381222222222", placeholder.Name().GivenName)
+	assert.Equal(t, placeholder, r.Contact("+381222222222"))
+	assert.Equal(t, "jelena@example.com", r.Contact("jelena@example.com").Name().GivenName)
+
+	r = NewAliasResolver(nil, map[string]string{"+14155550000": "+14155555555"})
+	assert.Equal(t, "+14155555555", r.Contact("+14155550000").Name().GivenName)
+	assert.Assert(t, r.Contact("+16505555555") == nil)
+}
+
+func TestDisambiguateHandles(t *testing.T) {
+	card := func(fn, n string) *vcard.Card {
+		c := vcard.Card{"N": []*vcard.Field{{Value: n}}}
+		if fn != "" {
+			c["FN"] = []*vcard.Field{{Value: fn}}
+		}
+		return &c
+	}
+	smith := card("Alex Smith", "Smith;Alex;;;")
+	jones := card("", "Jones;Alex;;;")
+	alex1 := card("", ";Alex;;;")
+	alex2 := card("", ";Alex;;;")
+	smith2 := card("Alex Smith", "Smith;Alex;;;")
+	d := &chatDB{
+		canonicalHandles: map[int]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 6: 6, 7: 7, 8: 7, 9: 9},
+		handleNames: map[int]handleName{
+			1: {handle: "+14155551234", card: smith},
+			2: {handle: "alex.jones@example.com", card: jones},
+			3: {handle: "+14155555678", card: alex1},
+			4: {handle: "+381605678", card: alex2},
+			5: {handle: "alex.smith@example.com", card: smith},
+			6: {handle: "+14155559999", card: smith2},
+			7: {handle: "+14155550000"},
+			8: {handle: "+1 (415) 555-0000"},
+			9: {handle: "+14155551111", card: card("Novak Djokovic", "Djokovic;Novak;;;")},
+		},
+	}
+	handleMap := map[int]string{1: "Alex", 2: "Alex", 3: "Alex", 4: "Alex", 5: "Alex", 6: "Alex", 7: "+14155550000", 8: "+14155550000", 9: "Novak"}
+
+	tests := []struct {
+		msg       string
+		handleIDs []int
+		want      map[int]string
+	}{
+		{
+			msg:       "no shared names",
+			handleIDs: []int{1, 9},
+		},
+		{
+			msg:       "full names",
+			handleIDs: []int{9, 2, 1},
+			want:      map[int]string{1: "Alex Smith", 2: "Alex Jones"},
+		},
+		{
+			msg:       "handles of the same contact",
+			handleIDs: []int{1, 5},
+		},
+		{
+			msg:       "handles with the same identity",
+			handleIDs: []int{7, 8},
+		},
+		{
+			msg:       "phone numbers",
+			handleIDs: []int{3, 1},
+			want:      map[int]string{1: "Alex Smith", 3: "Alex (5678)"},
+		},
+		{
+			msg:       "phone numbers ending with the same digits",
+			handleIDs: []int{3, 4, 1},
+			want:      map[int]string{1: "Alex Smith", 3: "Alex (+14155555678)", 4: "Alex (+381605678)"},
+		},
+		{
+			msg:       "same full names",
+			handleIDs: []int{1, 5, 6, 2},
+			want:      map[int]string{1: "Alex (1234)", 2: "Alex Jones", 5: "Alex (1234)", 6: "Alex (9999)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			want := map[int]string{}
+			for id, name := range handleMap {
+				want[id] = name
+			}
+			for id, name := range tt.want {
+				want[id] = name
+			}
+			assert.DeepEqual(t, want, d.DisambiguateHandles(handleMap, tt.handleIDs))
+			assert.Equal(t, "Alex", handleMap[1])
+		})
+	}
+}
+
+func TestHandleSuffix(t *testing.T) {
+	for handle, want := range map[string]string{
+		"+1 (415) 555-1234": "1234",
+		"alex@example.com":  "alex@example.com",
+		"12345":             "2345",
+		"1234":              "1234",
+	} {
+		assert.Equal(t, want, handleSuffix(handle), handle)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DanglingJoins are the rows of the join tables which point at rows missing
+// from the database, e.g. in databases restored from backups.
+type DanglingJoins struct {
+	// Messages maps chat IDs to the messages joined to them which are
+	// missing, in the order that they are timestamped.
+	Messages map[int][]MissingMessage
+	// Attachments is the number of attachments joined to messages which are
+	// missing.
+	Attachments int
+	// Handles is the number of handles joined to chats which are missing.
+	Handles int
+}
+
+// MissingMessage is a message joined to a chat which is missing from the
+// message table. Its date is the one recorded in the join, in local time, or
+// the zero time if the join records none.
+type MissingMessage struct {
+	ID   int
+	Date time.Time
+}
+
+// Count returns the number of dangling joins.
+func (j DanglingJoins) Count() int {
+	count := j.Attachments + j.Handles
+	for _, missing := range j.Messages {
+		count += len(missing)
+	}
+	return count
+}
+
+func (d *chatDB) GetDanglingJoins() (DanglingJoins, error) {
+	joins := DanglingJoins{Messages: map[int][]MissingMessage{}}
+	if d.currentSchema().hasTable("chat_message_join") {
+		rows, err := d.query(func(*schema) string {
+			return fmt.Sprintf("SELECT cmj.chat_id, cmj.message_id, CASE WHEN cmj.message_date > 0 THEN DATETIME(%s) END FROM chat_message_join AS cmj LEFT JOIN message AS m ON cmj.message_id = m.ROWID WHERE m.ROWID IS NULL ORDER BY cmj.chat_id, cmj.message_date, cmj.message_id", fmt.Sprintf(_datetimeFormula, "cmj.message_date"))
+		})
+		if err != nil {
+			return joins, errors.Wrap(err, "query chat_message_join table for missing messages")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var chatID int
+			var msg MissingMessage
+			var date sql.NullString
+			if err := rows.Scan(&chatID, &msg.ID, &date); err != nil {
+				return joins, errors.Wrap(corrupt(err), "read missing message")
+			}
+			if date.Valid {
+				if msg.Date, err = time.ParseInLocation(_datetimeLayout, date.String, time.Local); err != nil {
+					return joins, errors.Wrapf(corrupt(err), "parse date %q of missing message with ID %d", date.String, msg.ID)
+				}
+			}
+			joins.Messages[chatID] = append(joins.Messages[chatID], msg)
+		}
+	}
+	var err error
+	if joins.Attachments, err = d.countDangling("message_attachment_join", "attachment_id", "attachment"); err != nil {
+		return joins, err
+	}
+	joins.Handles, err = d.countDangling("chat_handle_join", "handle_id", "handle")
+	return joins, err
+}
+
+// countDangling counts the rows of the given join table whose given column
+// points at a row missing from the given table.
+func (d *chatDB) countDangling(join, column, table string) (int, error) {
+	rows, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT COUNT(*) FROM %[1]s AS j LEFT JOIN %[3]s AS t ON j.%[2]s = t.ROWID WHERE t.ROWID IS NULL", join, column, table)
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "query %s table for missing rows of the %s table", join, table)
+	}
+	defer rows.Close()
+	count := 0
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, errors.Wrapf(corrupt(err), "read missing rows of the %s table", table)
+		}
+	}
+	return count, nil
+}
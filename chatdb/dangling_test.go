@@ -0,0 +1,120 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetDanglingJoins(t *testing.T) {
+	messagesQuery := fmt.Sprintf("SELECT cmj.chat_id, cmj.message_id, CASE WHEN cmj.message_date > 0 THEN DATETIME(%s) END FROM chat_message_join AS cmj LEFT JOIN message AS m ON cmj.message_id = m.ROWID WHERE m.ROWID IS NULL ORDER BY cmj.chat_id, cmj.message_date, cmj.message_id", fmt.Sprintf(_datetimeFormula, "cmj.message_date"))
+	attachmentsQuery := "SELECT COUNT(*) FROM message_attachment_join AS j LEFT JOIN attachment AS t ON j.attachment_id = t.ROWID WHERE t.ROWID IS NULL"
+	handlesQuery := "SELECT COUNT(*) FROM chat_handle_join AS j LEFT JOIN handle AS t ON j.handle_id = t.ROWID WHERE t.ROWID IS NULL"
+	count := func(n int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"count"}).AddRow(n)
+	}
+
+	tests := []struct {
+		msg       string
+		legacy    bool
+		setupMock func(sqlmock.Sqlmock)
+		wantJoins DanglingJoins
+		wantCount int
+		wantErr   string
+	}{
+		{
+			msg: "none",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(messagesQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "message_id", "date"}))
+				sMock.ExpectQuery(regexp.QuoteMeta(attachmentsQuery)).WillReturnRows(count(0))
+				sMock.ExpectQuery(regexp.QuoteMeta(handlesQuery)).WillReturnRows(count(0))
+			},
+			wantJoins: DanglingJoins{Messages: map[int][]MissingMessage{}},
+		},
+		{
+			msg: "dangling",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "message_id", "date"}).
+					AddRow(1, 100, nil).
+					AddRow(1, 101, "2020-03-01 15:34:05").
+					AddRow(2, 200, "2020-03-02 15:34:05")
+				sMock.ExpectQuery(regexp.QuoteMeta(messagesQuery)).WillReturnRows(rows)
+				sMock.ExpectQuery(regexp.QuoteMeta(attachmentsQuery)).WillReturnRows(count(2))
+				sMock.ExpectQuery(regexp.QuoteMeta(handlesQuery)).WillReturnRows(count(1))
+			},
+			wantJoins: DanglingJoins{
+				Messages: map[int][]MissingMessage{
+					1: {{ID: 100}, {ID: 101, Date: time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)}},
+					2: {{ID: 200, Date: time.Date(2020, time.March, 2, 15, 34, 5, 0, time.Local)}},
+				},
+				Attachments: 2,
+				Handles:     1,
+			},
+			wantCount: 6,
+		},
+		{
+			msg:    "without chat_message_join",
+			legacy: true,
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(attachmentsQuery)).WillReturnRows(count(0))
+				sMock.ExpectQuery(regexp.QuoteMeta(handlesQuery)).WillReturnRows(count(3))
+			},
+			wantJoins: DanglingJoins{Messages: map[int][]MissingMessage{}, Handles: 3},
+			wantCount: 3,
+		},
+		{
+			msg: "messages query error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(messagesQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat_message_join table for missing messages: this is a DB error",
+		},
+		{
+			msg: "invalid date",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"chat_id", "message_id", "date"}).AddRow(1, 100, "invalid")
+				sMock.ExpectQuery(regexp.QuoteMeta(messagesQuery)).WillReturnRows(rows)
+			},
+			wantErr: `parse date "invalid" of missing message with ID 100: parsing time "invalid" as "2006-01-02 15:04:05": cannot parse "invalid" as "2006"`,
+		},
+		{
+			msg: "count query error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(messagesQuery)).WillReturnRows(sqlmock.NewRows([]string{"chat_id", "message_id", "date"}))
+				sMock.ExpectQuery(regexp.QuoteMeta(attachmentsQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query message_attachment_join table for missing rows of the attachment table: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+			if tt.legacy {
+				cdb.schema = newSchema(map[string]map[string]bool{})
+			}
+			joins, err := cdb.GetDanglingJoins()
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantJoins, joins)
+			assert.Equal(t, tt.wantCount, joins.Count())
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// legacyText transcodes the text of a message which is not valid UTF-8, as
+// for some very old SMS imported from other phones, into UTF-8. Bytes which
+// are not UTF-8 are read as Latin-1, and NUL bytes next to other bytes as
+// fragments of UTF-16 text, e.g. "h\x00i\x00". The transcoded text is
+// returned with an error if some of the bytes could not be decoded cleanly,
+// e.g. because they are C1 control characters, which are never Latin-1 text.
+func legacyText(text string) (string, error) {
+	if utf8.ValidString(text) && !strings.ContainsRune(text, 0) {
+		return text, nil
+	}
+	var b strings.Builder
+	clean := true
+	for i := 0; i < len(text); {
+		switch {
+		case i+1 < len(text) && text[i] != 0 && text[i+1] == 0:
+			// A little-endian UTF-16 code unit of Latin-1 text.
+			b.WriteRune(rune(text[i]))
+			i += 2
+			continue
+		case i+1 < len(text) && text[i] == 0 && text[i+1] != 0:
+			// A big-endian UTF-16 code unit of Latin-1 text.
+			b.WriteRune(rune(text[i+1]))
+			i += 2
+			continue
+		case text[i] == 0:
+			// NUL characters, e.g. terminating UTF-16 text.
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = rune(text[i])
+			if r >= 0x80 && r <= 0x9F {
+				r, clean = utf8.RuneError, false
+			}
+		}
+		b.WriteRune(r)
+		i += size
+	}
+	if !clean {
+		return b.String(), errors.New("text is neither UTF-8, UTF-16, nor Latin-1")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,63 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLegacyText(t *testing.T) {
+	tests := []struct {
+		msg      string
+		text     string
+		wantText string
+		wantErr  string
+	}{
+		{
+			msg:      "UTF-8",
+			text:     "Café at 5? 🎾",
+			wantText: "Café at 5? 🎾",
+		},
+		{
+			msg:      "Latin-1",
+			text:     "Caf\xe9 at 5?",
+			wantText: "Café at 5?",
+		},
+		{
+			msg:      "Latin-1 within UTF-8",
+			text:     "Café or caf\xe9?",
+			wantText: "Café or café?",
+		},
+		{
+			msg:      "UTF-16 little-endian",
+			text:     "C\x00a\x00f\x00\xe9\x00\x00\x00",
+			wantText: "Café",
+		},
+		{
+			msg:      "UTF-16 big-endian fragment",
+			text:     "Game: \x00s\x00e\x00t",
+			wantText: "Game: set",
+		},
+		{
+			msg:      "C1 control characters",
+			text:     "\x93Game\x94",
+			wantText: "\ufffdGame\ufffd",
+			wantErr:  "text is neither UTF-8, UTF-16, nor Latin-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			text, err := legacyText(tt.text)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.Equal(t, tt.wantText, text)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The errors returned by ChatDB wrap these errors according to their causes,
+// which can be checked with errors.Is, e.g. to suggest a fix.
+var (
+	// ErrSchemaMismatch is the cause of failures due to tables or columns
+	// which the database does not have, e.g. because it is from a newer
+	// release of Mac OS than bagoup knows.
+	ErrSchemaMismatch = errors.New("database schema mismatch")
+	// ErrRowCorrupt is the cause of failures due to rows with unexpected
+	// contents, e.g. NULL values, duplicate IDs, or invalid dates.
+	ErrRowCorrupt = errors.New("corrupt database row")
+	// ErrPermission is the cause of failures due to the database not being
+	// readable, e.g. because the terminal does not have Full Disk Access.
+	ErrPermission = errors.New("permission denied")
+	// ErrBusy is the cause of failures due to the database staying locked by
+	// another process, e.g. Messages, after retrying.
+	ErrBusy = errors.New("database busy")
+	// ErrNotFound is the cause of failures due to a message missing from
+	// the database, e.g. because it was deleted.
+	ErrNotFound = errors.New("not found")
+)
+
+// _permissionErrors are the messages of SQLite errors due to the database not
+// being readable.
+var _permissionErrors = []string{
+	"unable to open database file",
+	"authorization denied",
+	"operation not permitted",
+	"permission denied",
+}
+
+// causeError marks an error as having one of the causes above, without
+// changing its message.
+type causeError struct {
+	err   error
+	cause error
+}
+
+func (e causeError) Error() string        { return e.err.Error() }
+func (e causeError) Unwrap() error        { return e.err }
+func (e causeError) Is(target error) bool { return target == e.cause }
+
+// classify marks an error from querying the database with its cause, if it is
+// due to the schema, to permissions, or to the database being locked.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if isSchemaError(err) {
+		return causeError{err: err, cause: ErrSchemaMismatch}
+	}
+	if isBusyError(err) {
+		return causeError{err: err, cause: ErrBusy}
+	}
+	if os.IsPermission(err) {
+		return causeError{err: err, cause: ErrPermission}
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permissionErr := range _permissionErrors {
+		if strings.Contains(msg, permissionErr) {
+			return causeError{err: err, cause: ErrPermission}
+		}
+	}
+	return err
+}
+
+// corrupt marks an error from reading a row as caused by its contents.
+func corrupt(err error) error {
+	return causeError{err: err, cause: ErrRowCorrupt}
+}
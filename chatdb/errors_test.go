@@ -0,0 +1,115 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		msg       string
+		err       error
+		wantCause error
+	}{
+		{
+			msg:       "missing column",
+			err:       errors.New("no such column: is_archived"),
+			wantCause: ErrSchemaMismatch,
+		},
+		{
+			msg:       "missing table",
+			err:       errors.New("no such table: chat_message_join"),
+			wantCause: ErrSchemaMismatch,
+		},
+		{
+			msg:       "no Full Disk Access",
+			err:       errors.New("unable to open database file: operation not permitted"),
+			wantCause: ErrPermission,
+		},
+		{
+			msg:       "SQLite authorization",
+			err:       errors.New("authorization denied"),
+			wantCause: ErrPermission,
+		},
+		{
+			msg:       "file permissions",
+			err:       &os.PathError{Op: "open", Path: "chat.db", Err: os.ErrPermission},
+			wantCause: ErrPermission,
+		},
+		{
+			msg:       "locked database",
+			err:       errors.New("database is locked"),
+			wantCause: ErrBusy,
+		},
+		{
+			msg: "other error",
+			err: errors.New("disk I/O error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			err := classify(tt.err)
+			assert.Equal(t, tt.err.Error(), err.Error())
+			assert.Assert(t, errors.Is(err, tt.err), "original error not wrapped")
+			for _, cause := range []error{ErrSchemaMismatch, ErrRowCorrupt, ErrPermission, ErrBusy} {
+				assert.Equal(t, cause == tt.wantCause, errors.Is(err, cause), cause.Error())
+			}
+		})
+	}
+	assert.NilError(t, classify(nil))
+}
+
+func TestErrorCauses(t *testing.T) {
+	tests := []struct {
+		msg       string
+		setupMock func(sqlmock.Sqlmock)
+		wantCause error
+	}{
+		{
+			msg: "permission",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT c.ROWID, c.guid").WillReturnError(errors.New("unable to open database file"))
+			},
+			wantCause: ErrPermission,
+		},
+		{
+			msg: "schema",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT c.ROWID, c.guid").WillReturnError(errors.New("no such column: guid"))
+				sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnRows(sqlmock.NewRows([]string{"table", "column"}).AddRow("chat", "ROWID"))
+				sMock.ExpectQuery("SELECT c.ROWID, c.guid").WillReturnError(errors.New("no such column: guid"))
+			},
+			wantCause: ErrSchemaMismatch,
+		},
+		{
+			msg: "corrupt row",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT c.ROWID, c.guid").WillReturnRows(sqlmock.NewRows([]string{"ROWID", "guid", "chat_identifier", "display_name", "is_archived", "properties", "message_count", "last_date"}).
+					AddRow(nil, "testguid", "testchatname", "", 0, nil, 0, nil))
+			},
+			wantCause: ErrRowCorrupt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			cdb := NewChatDB(db, "Me", NameFormat{}, PoolOptions{})
+
+			_, err = cdb.GetChats(nil)
+			assert.Assert(t, errors.Is(err, tt.wantCause), "%s is not caused by %s", err, tt.wantCause)
+		})
+	}
+}
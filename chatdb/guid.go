@@ -0,0 +1,32 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import "strings"
+
+// _services maps the services in chat GUIDs, in lower case, to their names.
+var _services = map[string]string{
+	"imessage": "iMessage",
+	"sms":      "SMS",
+	"rcs":      "RCS",
+}
+
+// ParseGUID splits the GUID of a chat, e.g. "SMS;-;+14155555555", into the
+// service of the chat, e.g. "SMS", and its identifier within the service, e.g.
+// the handle of the other participant of a one-to-one chat or "chat123456"
+// for a group chat, and reports whether it is a group chat, marked by a "+"
+// rather than a "-" between them. The names of known services are normalized,
+// e.g. "imessage" to "iMessage". GUIDs of other forms are returned whole as
+// the identifier.
+func ParseGUID(guid string) (service, identifier string, group bool) {
+	parts := strings.SplitN(guid, ";", 3)
+	if len(parts) != 3 || (parts[1] != "-" && parts[1] != "+") {
+		return "", guid, false
+	}
+	service = parts[0]
+	if name, ok := _services[strings.ToLower(service)]; ok {
+		service = name
+	}
+	return service, parts[2], parts[1] == "+"
+}
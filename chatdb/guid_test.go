@@ -0,0 +1,38 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseGUID(t *testing.T) {
+	tests := []struct {
+		guid           string
+		wantService    string
+		wantIdentifier string
+		wantGroup      bool
+	}{
+		{guid: "iMessage;-;+14155555555", wantService: "iMessage", wantIdentifier: "+14155555555"},
+		{guid: "SMS;-;+14155555555", wantService: "SMS", wantIdentifier: "+14155555555"},
+		{guid: "sms;-;12345", wantService: "SMS", wantIdentifier: "12345"},
+		{guid: "RCS;-;+14155555555", wantService: "RCS", wantIdentifier: "+14155555555"},
+		{guid: "any;-;novak@example.com", wantService: "any", wantIdentifier: "novak@example.com"},
+		{guid: "iMessage;+;chat123456", wantService: "iMessage", wantIdentifier: "chat123456", wantGroup: true},
+		{guid: "iMessage;-;odd;identifier", wantService: "iMessage", wantIdentifier: "odd;identifier"},
+		{guid: "testguid", wantIdentifier: "testguid"},
+		{guid: "iMessage;x;+14155555555", wantIdentifier: "iMessage;x;+14155555555"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.guid, func(t *testing.T) {
+			service, identifier, group := ParseGUID(tt.guid)
+			assert.Equal(t, tt.wantService, service)
+			assert.Equal(t, tt.wantIdentifier, identifier)
+			assert.Equal(t, tt.wantGroup, group)
+		})
+	}
+}
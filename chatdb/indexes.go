@@ -0,0 +1,73 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// _joinColumns are the columns by which exports look up rows, mostly those
+// of the join tables, which copies of chat.db may not have indexes on, e.g.
+// when they were rebuilt by other tools.
+var _joinColumns = []struct{ table, column string }{
+	{"chat_message_join", "chat_id"},
+	{"chat_message_join", "message_id"},
+	{"chat_handle_join", "chat_id"},
+	{"message_attachment_join", "message_id"},
+	{"message", "handle_id"},
+	{"message", "guid"},
+}
+
+// _unindexedQuery counts the columns with the given name of the given table,
+// unless an index of the table starts with the column. Tables and columns
+// which the database does not have are counted as indexed, so that they are
+// skipped.
+const _unindexedQuery = "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ? AND NOT EXISTS (SELECT 1 FROM sqlite_master AS m JOIN pragma_index_info(m.name) AS i WHERE m.type = 'index' AND m.tbl_name = ? AND i.seqno = 0 AND i.name = ?)"
+
+func (d *chatDB) AddIndexes() ([]string, error) {
+	var names []string
+	for _, c := range _joinColumns {
+		unindexed, err := d.unindexed(c.table, c.column)
+		if err != nil {
+			return names, err
+		}
+		if !unindexed {
+			continue
+		}
+		name := fmt.Sprintf("bagoup_%s_%s", c.table, c.column)
+		if _, err := d.DB.Exec(fmt.Sprintf("CREATE INDEX %s ON %s(%s)", name, c.table, c.column)); err != nil {
+			return names, errors.Wrapf(classify(err), "create index %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// unindexed checks if the given column of the given table has no index
+// starting with it.
+func (d *chatDB) unindexed(table, column string) (bool, error) {
+	rows, err := d.queryRetry(_unindexedQuery, table, column, table, column)
+	if err != nil {
+		return false, errors.Wrapf(classify(err), "check indexes of %s.%s", table, column)
+	}
+	defer rows.Close()
+	count := 0
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return false, errors.Wrapf(err, "read indexes of %s.%s", table, column)
+		}
+	}
+	return count > 0, errors.Wrapf(rows.Err(), "read indexes of %s.%s", table, column)
+}
+
+func (d *chatDB) DropIndexes(names []string) error {
+	for _, name := range names {
+		if _, err := d.DB.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", name)); err != nil {
+			return errors.Wrapf(classify(err), "drop index %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddIndexes(t *testing.T) {
+	expectUnindexed := func(sMock sqlmock.Sqlmock, table, column string, count int) {
+		sMock.ExpectQuery(regexp.QuoteMeta(_unindexedQuery)).
+			WithArgs(table, column, table, column).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	}
+	expectIndexed := func(sMock sqlmock.Sqlmock, n int) {
+		for _, c := range _joinColumns[len(_joinColumns)-n:] {
+			expectUnindexed(sMock, c.table, c.column, 0)
+		}
+	}
+
+	tests := []struct {
+		msg       string
+		setupMock func(sqlmock.Sqlmock)
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			msg: "all indexed",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				expectIndexed(sMock, 6)
+			},
+		},
+		{
+			msg: "join tables unindexed",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				expectUnindexed(sMock, "chat_message_join", "chat_id", 1)
+				sMock.ExpectExec(regexp.QuoteMeta("CREATE INDEX bagoup_chat_message_join_chat_id ON chat_message_join(chat_id)")).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectUnindexed(sMock, "chat_message_join", "message_id", 0)
+				expectUnindexed(sMock, "chat_handle_join", "chat_id", 0)
+				expectUnindexed(sMock, "message_attachment_join", "message_id", 1)
+				sMock.ExpectExec(regexp.QuoteMeta("CREATE INDEX bagoup_message_attachment_join_message_id ON message_attachment_join(message_id)")).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectIndexed(sMock, 2)
+			},
+			wantNames: []string{"bagoup_chat_message_join_chat_id", "bagoup_message_attachment_join_message_id"},
+		},
+		{
+			msg: "check error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(_unindexedQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "check indexes of chat_message_join.chat_id: this is a DB error",
+		},
+		{
+			msg: "create error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				expectUnindexed(sMock, "chat_message_join", "chat_id", 1)
+				sMock.ExpectExec(regexp.QuoteMeta("CREATE INDEX bagoup_chat_message_join_chat_id ON chat_message_join(chat_id)")).WillReturnResult(sqlmock.NewResult(0, 0))
+				expectUnindexed(sMock, "chat_message_join", "message_id", 1)
+				sMock.ExpectExec(regexp.QuoteMeta("CREATE INDEX bagoup_chat_message_join_message_id ON chat_message_join(message_id)")).WillReturnError(errors.New("attempt to write a readonly database"))
+			},
+			wantNames: []string{"bagoup_chat_message_join_chat_id"},
+			wantErr:   "create index bagoup_chat_message_join_message_id: attempt to write a readonly database",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+			names, err := cdb.AddIndexes()
+			assert.DeepEqual(t, tt.wantNames, names)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDropIndexes(t *testing.T) {
+	db, sMock, err := sqlmock.New()
+	assert.NilError(t, err)
+	defer db.Close()
+	sMock.ExpectExec(regexp.QuoteMeta("DROP INDEX IF EXISTS bagoup_chat_message_join_chat_id")).WillReturnResult(sqlmock.NewResult(0, 0))
+	sMock.ExpectExec(regexp.QuoteMeta("DROP INDEX IF EXISTS bagoup_message_guid")).WillReturnError(errors.New("this is a DB error"))
+
+	cdb := &chatDB{DB: db, selfHandle: "Me"}
+	assert.NilError(t, cdb.DropIndexes(nil))
+	err = cdb.DropIndexes([]string{"bagoup_chat_message_join_chat_id", "bagoup_message_guid"})
+	assert.Error(t, err, "drop index bagoup_message_guid: this is a DB error")
+	assert.NilError(t, sMock.ExpectationsWereMet())
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// _checkInBalloon is the balloon bundle ID of Check In messages, which
+	// tell friends when someone set off and when they expect to arrive.
+	_checkInBalloon = "com.apple.SafetyMonitorApp.SafetyMonitorMessages"
+	// _pollBalloon is the balloon bundle ID of polls.
+	_pollBalloon = "com.apple.messages.Polls"
+)
+
+// _checkInTimes are the parameters of the URLs of Check In messages which
+// record times, in seconds since 1970, with how they are described.
+var _checkInTimes = []struct {
+	param, label string
+}{
+	{"sendDate", "started"},
+	{"estimatedEndTime", "expected by"},
+	{"triggerTime", "was expected by"},
+}
+
+func isCheckIn(balloon string) bool {
+	return strings.HasSuffix(balloon, _checkInBalloon)
+}
+
+func isPoll(balloon string) bool {
+	return strings.HasSuffix(balloon, _pollBalloon)
+}
+
+// checkInText describes a Check In message from its unarchived payload, e.g.
+// "Check In: Timer Started (started 2023-10-01 18:00:00, expected by
+// 2023-10-01 19:00:00)". The summary, which names the destination of check
+// ins on arrival, is recorded under the "ldtext" key, and the times in the
+// query of the URL under the "URL" key.
+func checkInText(root interface{}) (string, error) {
+	summary, _ := findArchivedValue(root, _summaryKey, 0).(string)
+	var details []string
+	if u, ok := archivedURL(root); ok {
+		query := u.Query()
+		for _, t := range _checkInTimes {
+			seconds, err := strconv.ParseFloat(query.Get(t.param), 64)
+			if err != nil || seconds <= 0 {
+				continue
+			}
+			sec, frac := math.Modf(seconds)
+			date := time.Unix(int64(sec), int64(frac*1e9)).In(time.Local)
+			details = append(details, fmt.Sprintf("%s %s", t.label, date.Format(_datetimeLayout)))
+		}
+	}
+	if summary == "" && len(details) == 0 {
+		return "", errors.New("no check in details")
+	}
+	if summary == "" {
+		summary = "Check In"
+	}
+	if len(details) == 0 {
+		return summary, nil
+	}
+	return fmt.Sprintf("%s (%s)", summary, strings.Join(details, ", ")), nil
+}
+
+// pollText describes a poll from its unarchived payload, e.g. "Poll: Where
+// should we play? (options: Wimbledon, Roland Garros)". The question is
+// recorded under the "ldtext" key, and the options, if any, as an array of
+// strings under the "options" key.
+func pollText(root interface{}) (string, error) {
+	question, _ := findArchivedValue(root, _summaryKey, 0).(string)
+	if question == "" {
+		return "", errors.New("no poll question")
+	}
+	text := "Poll: " + strings.TrimPrefix(question, "Poll: ")
+	values, _ := findArchivedValue(root, "options", 0).([]interface{})
+	var options []string
+	for _, v := range values {
+		if option, ok := v.(string); ok && option != "" {
+			options = append(options, option)
+		}
+	}
+	if len(options) > 0 {
+		text += fmt.Sprintf(" (options: %s)", strings.Join(options, ", "))
+	}
+	return text, nil
+}
+
+// archivedURL returns the URL recorded under the "URL" key of an unarchived
+// payload, either as a string or as an archived NSURL.
+func archivedURL(root interface{}) (*url.URL, bool) {
+	v := findArchivedValue(root, "URL", 0)
+	if dict, ok := v.(map[string]interface{}); ok {
+		v = dict["NS.relative"]
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	u, err := url.Parse(s)
+	return u, err == nil
+}
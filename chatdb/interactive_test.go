@@ -0,0 +1,98 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckInText(t *testing.T) {
+	start := time.Date(2023, time.October, 1, 18, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	query := fmt.Sprintf("?messageType=1&sendDate=%d&estimatedEndTime=%d.5", start.Unix(), end.Unix())
+
+	tests := []struct {
+		msg      string
+		root     interface{}
+		wantText string
+		wantErr  string
+	}{
+		{
+			msg: "timer started",
+			root: map[string]interface{}{
+				"ldtext": "Check In: Timer Started",
+				"URL":    map[string]interface{}{"NS.base": nil, "NS.relative": query},
+			},
+			wantText: "Check In: Timer Started (started 2023-10-01 18:00:00, expected by 2023-10-01 19:00:00)",
+		},
+		{
+			msg:      "URL string without summary",
+			root:     map[string]interface{}{"URL": fmt.Sprintf("?triggerTime=%d", end.Unix())},
+			wantText: "Check In (was expected by 2023-10-01 19:00:00)",
+		},
+		{
+			msg:      "summary only",
+			root:     map[string]interface{}{"ldtext": "Check In: Arrived at Home", "URL": "?sendDate=notadate"},
+			wantText: "Check In: Arrived at Home",
+		},
+		{
+			msg:     "no details",
+			root:    map[string]interface{}{"URL": "?messageType=1"},
+			wantErr: "no check in details",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			text, err := checkInText(tt.root)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantText, text)
+		})
+	}
+}
+
+func TestPollText(t *testing.T) {
+	tests := []struct {
+		msg      string
+		root     interface{}
+		wantText string
+		wantErr  string
+	}{
+		{
+			msg:      "question and options",
+			root:     map[string]interface{}{"ldtext": "Where should we play?", "options": []interface{}{"Wimbledon", "", "Roland Garros"}},
+			wantText: "Poll: Where should we play? (options: Wimbledon, Roland Garros)",
+		},
+		{
+			msg:      "question only",
+			root:     map[string]interface{}{"ldtext": "Poll: Tennis tomorrow?"},
+			wantText: "Poll: Tennis tomorrow?",
+		},
+		{
+			msg:     "no question",
+			root:    map[string]interface{}{"options": []interface{}{"Yes", "No"}},
+			wantErr: "no poll question",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			text, err := pollText(tt.root)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantText, text)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"strings"
+	"unicode"
+)
+
+// _reaction selects the tapbacks, which add or remove a reaction to another
+// message, e.g. 'Loved “Want to play tennis?”'. Other associated messages, e.g.
+// stickers, have lower types.
+const _reaction = "associated_message_type >= 2000"
+
+// MessageKind classifies a message by its content, e.g. for statistics and for
+// filtering the messages to export.
+type MessageKind string
+
+const (
+	// TextMessage is a message with text, with or without attachments.
+	TextMessage MessageKind = "text"
+	// EmojiMessage is a message whose text is only emoji.
+	EmojiMessage MessageKind = "emoji"
+	// AttachmentMessage is a message with attachments and no text.
+	AttachmentMessage MessageKind = "attachment"
+	// ReactionMessage is a tapback reacting to another message.
+	ReactionMessage MessageKind = "reaction"
+	// SystemMessage is a change to a chat recorded as a message, e.g. a
+	// participant added to a group chat.
+	SystemMessage MessageKind = "system"
+)
+
+// MessageKinds are all the kinds of messages.
+var MessageKinds = []MessageKind{TextMessage, EmojiMessage, AttachmentMessage, ReactionMessage, SystemMessage}
+
+// Kind classifies the message by its content as stored in the database, so
+// that it is the same whatever its attachments are replaced with.
+func (m Message) Kind() MessageKind {
+	switch {
+	case m.GroupAction != NoGroupAction:
+		return SystemMessage
+	case m.Reaction:
+		return ReactionMessage
+	}
+	text := m.RawText
+	if text == "" {
+		text = m.Text
+	}
+	// Attachments are marked in the text with object replacement characters.
+	if strings.Contains(text, "\ufffc") && strings.TrimSpace(strings.ReplaceAll(text, "\ufffc", "")) == "" {
+		return AttachmentMessage
+	}
+	if isEmojiOnly(text) {
+		return EmojiMessage
+	}
+	return TextMessage
+}
+
+// isEmojiOnly checks if the given text has emoji, and nothing else but the
+// characters which join and modify them, and spaces.
+func isEmojiOnly(text string) bool {
+	emoji := false
+	for _, r := range text {
+		switch {
+		case isEmoji(r):
+			emoji = true
+		case r == 0x200D, r == 0xFE0E, r == 0xFE0F, r == 0x20E3, r >= 0xE0020 && r <= 0xE007F, unicode.IsSpace(r):
+			// Zero-width joiners, variation selectors, keycaps, and tags
+			// combine emoji into other emoji, e.g. flags.
+		default:
+			return false
+		}
+	}
+	return emoji
+}
+
+// isEmoji checks if the rune is in one of the emoji blocks, including skin tone
+// modifiers and the regional indicators of flags, or is one of the symbols
+// which are displayed as emoji.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF,
+		r >= 0x2600 && r <= 0x27BF,
+		r >= 0x2300 && r <= 0x23FF,
+		r >= 0x2B00 && r <= 0x2BFF,
+		r >= 0x2190 && r <= 0x21FF:
+		return true
+	}
+	switch r {
+	case 0xA9, 0xAE, 0x203C, 0x2049, 0x2122, 0x2139, 0x3030, 0x303D, 0x3297, 0x3299:
+		return true
+	}
+	return false
+}
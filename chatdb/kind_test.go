@@ -0,0 +1,70 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestMessageKind(t *testing.T) {
+	tests := []struct {
+		msg      string
+		message  Message
+		wantKind MessageKind
+	}{
+		{
+			msg:      "text",
+			message:  Message{Text: "Want to play tennis? 🎾", RawText: "Want to play tennis? 🎾"},
+			wantKind: TextMessage,
+		},
+		{
+			msg:      "text with attachment",
+			message:  Message{Text: "look\n<attached: photo.jpg>", RawText: "look\n\ufffc"},
+			wantKind: TextMessage,
+		},
+		{
+			msg:      "emoji",
+			message:  Message{Text: "🎾 👍🏽", RawText: "🎾 👍🏽"},
+			wantKind: EmojiMessage,
+		},
+		{
+			msg:      "joined emoji and flag",
+			message:  Message{Text: "👨‍👩‍👧 🇷🇸 ❤️", RawText: "👨‍👩‍👧 🇷🇸 ❤️"},
+			wantKind: EmojiMessage,
+		},
+		{
+			msg:      "attachments",
+			message:  Message{Text: "<attached: photo.jpg> <attached: movie.mov>", RawText: "\ufffc\ufffc"},
+			wantKind: AttachmentMessage,
+		},
+		{
+			msg:      "reaction",
+			message:  Message{Text: "Loved “Want to play tennis?”", RawText: "Loved “Want to play tennis?”", Reaction: true},
+			wantKind: ReactionMessage,
+		},
+		{
+			msg:      "group action",
+			message:  Message{Text: "added Rafael Nadal to the conversation", GroupAction: ParticipantAdded},
+			wantKind: SystemMessage,
+		},
+		{
+			msg:      "made up text",
+			message:  Message{Text: "unsent a message"},
+			wantKind: TextMessage,
+		},
+		{
+			msg:      "empty",
+			message:  Message{},
+			wantKind: TextMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.wantKind, tt.message.Kind())
+		})
+	}
+}
@@ -6,7 +6,6 @@ package mock_chatdb
 
 import (
 	semver "github.com/Masterminds/semver"
-	vcard "github.com/emersion/go-vcard"
 	gomock "github.com/golang/mock/gomock"
 	chatdb "github.com/tagatac/bagoup/chatdb"
 	reflect "reflect"
@@ -35,8 +34,95 @@ func (m *MockChatDB) EXPECT() *MockChatDBMockRecorder {
 	return m.recorder
 }
 
+// AddIndexes mocks base method
+func (m *MockChatDB) AddIndexes() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddIndexes")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddIndexes indicates an expected call of AddIndexes
+func (mr *MockChatDBMockRecorder) AddIndexes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddIndexes", reflect.TypeOf((*MockChatDB)(nil).AddIndexes))
+}
+
+// CanonicalHandle mocks base method
+func (m *MockChatDB) CanonicalHandle(arg0 int) int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CanonicalHandle", arg0)
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// CanonicalHandle indicates an expected call of CanonicalHandle
+func (mr *MockChatDBMockRecorder) CanonicalHandle(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CanonicalHandle", reflect.TypeOf((*MockChatDB)(nil).CanonicalHandle), arg0)
+}
+
+// DisambiguateHandles mocks base method
+func (m *MockChatDB) DisambiguateHandles(arg0 map[int]string, arg1 []int) map[int]string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisambiguateHandles", arg0, arg1)
+	ret0, _ := ret[0].(map[int]string)
+	return ret0
+}
+
+// DisambiguateHandles indicates an expected call of DisambiguateHandles
+func (mr *MockChatDBMockRecorder) DisambiguateHandles(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisambiguateHandles", reflect.TypeOf((*MockChatDB)(nil).DisambiguateHandles), arg0, arg1)
+}
+
+// DropIndexes mocks base method
+func (m *MockChatDB) DropIndexes(arg0 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DropIndexes", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DropIndexes indicates an expected call of DropIndexes
+func (mr *MockChatDBMockRecorder) DropIndexes(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DropIndexes", reflect.TypeOf((*MockChatDB)(nil).DropIndexes), arg0)
+}
+
+// GetAttachmentPaths mocks base method
+func (m *MockChatDB) GetAttachmentPaths() (map[int][]chatdb.Attachment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAttachmentPaths")
+	ret0, _ := ret[0].(map[int][]chatdb.Attachment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAttachmentPaths indicates an expected call of GetAttachmentPaths
+func (mr *MockChatDBMockRecorder) GetAttachmentPaths() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachmentPaths", reflect.TypeOf((*MockChatDB)(nil).GetAttachmentPaths))
+}
+
+// GetChatSizes mocks base method
+func (m *MockChatDB) GetChatSizes() (map[int]chatdb.ChatSize, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChatSizes")
+	ret0, _ := ret[0].(map[int]chatdb.ChatSize)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChatSizes indicates an expected call of GetChatSizes
+func (mr *MockChatDBMockRecorder) GetChatSizes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatSizes", reflect.TypeOf((*MockChatDB)(nil).GetChatSizes))
+}
+
 // GetChats mocks base method
-func (m *MockChatDB) GetChats(arg0 map[string]*vcard.Card) ([]chatdb.Chat, error) {
+func (m *MockChatDB) GetChats(arg0 chatdb.ContactResolver) ([]chatdb.Chat, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetChats", arg0)
 	ret0, _ := ret[0].([]chatdb.Chat)
@@ -50,8 +136,38 @@ func (mr *MockChatDBMockRecorder) GetChats(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChats", reflect.TypeOf((*MockChatDB)(nil).GetChats), arg0)
 }
 
+// GetChatsForHandle mocks base method
+func (m *MockChatDB) GetChatsForHandle(arg0 string, arg1 chatdb.ContactResolver) ([]chatdb.Chat, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChatsForHandle", arg0, arg1)
+	ret0, _ := ret[0].([]chatdb.Chat)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetChatsForHandle indicates an expected call of GetChatsForHandle
+func (mr *MockChatDBMockRecorder) GetChatsForHandle(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatsForHandle", reflect.TypeOf((*MockChatDB)(nil).GetChatsForHandle), arg0, arg1)
+}
+
+// GetDanglingJoins mocks base method
+func (m *MockChatDB) GetDanglingJoins() (chatdb.DanglingJoins, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDanglingJoins")
+	ret0, _ := ret[0].(chatdb.DanglingJoins)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDanglingJoins indicates an expected call of GetDanglingJoins
+func (mr *MockChatDBMockRecorder) GetDanglingJoins() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDanglingJoins", reflect.TypeOf((*MockChatDB)(nil).GetDanglingJoins))
+}
+
 // GetHandleMap mocks base method
-func (m *MockChatDB) GetHandleMap(arg0 map[string]*vcard.Card) (map[int]string, error) {
+func (m *MockChatDB) GetHandleMap(arg0 chatdb.ContactResolver) (map[int]string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetHandleMap", arg0)
 	ret0, _ := ret[0].(map[int]string)
@@ -66,10 +182,10 @@ func (mr *MockChatDBMockRecorder) GetHandleMap(arg0 interface{}) *gomock.Call {
 }
 
 // GetMessage mocks base method
-func (m *MockChatDB) GetMessage(arg0 int, arg1 map[int]string, arg2 *semver.Version) (string, error) {
+func (m *MockChatDB) GetMessage(arg0 int, arg1 map[int]string, arg2 *semver.Version) (chatdb.Message, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetMessage", arg0, arg1, arg2)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(chatdb.Message)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -80,6 +196,21 @@ func (mr *MockChatDBMockRecorder) GetMessage(arg0, arg1, arg2 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessage", reflect.TypeOf((*MockChatDB)(nil).GetMessage), arg0, arg1, arg2)
 }
 
+// GetMessageByGUID mocks base method
+func (m *MockChatDB) GetMessageByGUID(arg0 string, arg1 map[int]string, arg2 *semver.Version) (chatdb.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMessageByGUID", arg0, arg1, arg2)
+	ret0, _ := ret[0].(chatdb.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMessageByGUID indicates an expected call of GetMessageByGUID
+func (mr *MockChatDBMockRecorder) GetMessageByGUID(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessageByGUID", reflect.TypeOf((*MockChatDB)(nil).GetMessageByGUID), arg0, arg1, arg2)
+}
+
 // GetMessageIDs mocks base method
 func (m *MockChatDB) GetMessageIDs(arg0 int) ([]int, error) {
 	m.ctrl.T.Helper()
@@ -94,3 +225,64 @@ func (mr *MockChatDBMockRecorder) GetMessageIDs(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessageIDs", reflect.TypeOf((*MockChatDB)(nil).GetMessageIDs), arg0)
 }
+
+// GetMessagesPage mocks base method
+func (m *MockChatDB) GetMessagesPage(arg0 int, arg1 string, arg2 int, arg3 map[int]string, arg4 *semver.Version) ([]chatdb.Message, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMessagesPage", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].([]chatdb.Message)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetMessagesPage indicates an expected call of GetMessagesPage
+func (mr *MockChatDBMockRecorder) GetMessagesPage(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessagesPage", reflect.TypeOf((*MockChatDB)(nil).GetMessagesPage), arg0, arg1, arg2, arg3, arg4)
+}
+
+// GetParticipants mocks base method
+func (m *MockChatDB) GetParticipants(arg0 int) ([]int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetParticipants", arg0)
+	ret0, _ := ret[0].([]int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetParticipants indicates an expected call of GetParticipants
+func (mr *MockChatDBMockRecorder) GetParticipants(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetParticipants", reflect.TypeOf((*MockChatDB)(nil).GetParticipants), arg0)
+}
+
+// GetRawMessage mocks base method
+func (m *MockChatDB) GetRawMessage(arg0 int) (chatdb.RawMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRawMessage", arg0)
+	ret0, _ := ret[0].(chatdb.RawMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRawMessage indicates an expected call of GetRawMessage
+func (mr *MockChatDBMockRecorder) GetRawMessage(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRawMessage", reflect.TypeOf((*MockChatDB)(nil).GetRawMessage), arg0)
+}
+
+// GetSyncedMessageCount mocks base method
+func (m *MockChatDB) GetSyncedMessageCount() (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSyncedMessageCount")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSyncedMessageCount indicates an expected call of GetSyncedMessageCount
+func (mr *MockChatDBMockRecorder) GetSyncedMessageCount() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSyncedMessageCount", reflect.TypeOf((*MockChatDB)(nil).GetSyncedMessageCount))
+}
@@ -0,0 +1,206 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
+)
+
+// _summaryKey is the key of the text with which Messages apps summarize their
+// messages in their payloads, e.g. "Check In: Timer Started".
+const _summaryKey = "ldtext"
+
+// getPayloadData returns the payload data of the message with the given ID,
+// sent with a Messages app, or nil if it has none.
+func (d *chatDB) getPayloadData(messageID int) ([]byte, error) {
+	rows, err := d.query(func(*schema) string {
+		return fmt.Sprintf("SELECT payload_data FROM message WHERE ROWID=%d", messageID)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "query payload of message ID %d", messageID)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	var payload []byte
+	if err := rows.Scan(&payload); err != nil {
+		return nil, errors.Wrapf(corrupt(err), "read payload of message ID %d", messageID)
+	}
+	return payload, nil
+}
+
+// hasPayloadText checks if the text of a message sent with the Messages app
+// with the given balloon bundle ID is taken from its payload, either because
+// the app is known or because the message has no text of its own.
+func hasPayloadText(balloon, text string) bool {
+	if balloon == "" {
+		return false
+	}
+	if isApplePay(balloon) || isCheckIn(balloon) || isPoll(balloon) {
+		return true
+	}
+	return strings.TrimSpace(strings.ReplaceAll(text, "\ufffc", "")) == ""
+}
+
+// decodePayload sets the text of a message sent with the Messages app with the
+// given balloon bundle ID from the given payload data, and its payment if it
+// was sent with Apple Pay. Payloads of other apps are summarized with the text
+// with which the apps summarize them, if any. Payloads which cannot be
+// unarchived mark the message as undecodable.
+func (m *Message) decodePayload(balloon string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	root, err := unarchive(data)
+	if err != nil {
+		m.markUndecodable("payload", err)
+		return
+	}
+	var text string
+	switch {
+	case isApplePay(balloon):
+		if m.Payment, err = decodePayment(root); err == nil {
+			text = paymentText(*m.Payment, m.FromMe)
+		}
+	case isCheckIn(balloon):
+		text, err = checkInText(root)
+	case isPoll(balloon):
+		text, err = pollText(root)
+	default:
+		text, _ = findArchivedValue(root, _summaryKey, 0).(string)
+	}
+	if err != nil {
+		logging.Warnf("decode payload of message ID %d: %s", m.ID, err)
+		return
+	}
+	if text != "" {
+		m.Text = text
+	}
+}
+
+// unarchive decodes a keyed archive, a binary property list of objects which
+// refer to each other with UIDs, into its root object. Archived dictionaries,
+// arrays, and strings are resolved into map[string]interface{},
+// []interface{}, and string values.
+func unarchive(data []byte) (interface{}, error) {
+	v, err := decodeBPlist(data)
+	if err != nil {
+		return nil, err
+	}
+	archive, _ := v.(map[string]interface{})
+	objects, _ := archive["$objects"].([]interface{})
+	top, _ := archive["$top"].(map[string]interface{})
+	root, ok := top["root"].(uint64)
+	if objects == nil || !ok {
+		return nil, errors.New("not a keyed archive")
+	}
+	return resolveArchived(objects, root, 0)
+}
+
+// resolveArchived resolves the object with the given UID in the objects of a
+// keyed archive.
+func resolveArchived(objects []interface{}, uid uint64, depth int) (interface{}, error) {
+	if uid >= uint64(len(objects)) {
+		return nil, errors.Errorf("invalid archived object UID %d", uid)
+	}
+	if depth > _maxPlistDepth {
+		return nil, errors.New("archived objects nested too deeply")
+	}
+	resolve := func(v interface{}) (interface{}, error) {
+		if ref, ok := v.(uint64); ok {
+			return resolveArchived(objects, ref, depth+1)
+		}
+		return v, nil
+	}
+	switch o := objects[uid].(type) {
+	case string:
+		if o == "$null" {
+			return nil, nil
+		}
+		return o, nil
+	case map[string]interface{}:
+		keys, hasKeys := o["NS.keys"].([]interface{})
+		values, hasValues := o["NS.objects"].([]interface{})
+		switch {
+		case hasKeys && hasValues && len(keys) == len(values):
+			dict := make(map[string]interface{}, len(keys))
+			for i, key := range keys {
+				k, err := resolve(key)
+				if err != nil {
+					return nil, err
+				}
+				name, ok := k.(string)
+				if !ok {
+					return nil, errors.Errorf("invalid archived dictionary key %v", k)
+				}
+				if dict[name], err = resolve(values[i]); err != nil {
+					return nil, err
+				}
+			}
+			return dict, nil
+		case hasValues:
+			array := make([]interface{}, len(values))
+			for i, value := range values {
+				var err error
+				if array[i], err = resolve(value); err != nil {
+					return nil, err
+				}
+			}
+			return array, nil
+		}
+		if s, ok := o["NS.string"]; ok {
+			return resolve(s)
+		}
+		dict := make(map[string]interface{}, len(o))
+		for key, value := range o {
+			if key == "$class" {
+				continue
+			}
+			var err error
+			if dict[key], err = resolve(value); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	default:
+		return o, nil
+	}
+}
+
+// findArchivedValue returns the first value with the given key in the given
+// unarchived object or the objects it contains, or nil if there is none.
+func findArchivedValue(v interface{}, key string, depth int) interface{} {
+	if depth > _maxPlistDepth {
+		return nil
+	}
+	switch o := v.(type) {
+	case map[string]interface{}:
+		if value, ok := o[key]; ok && value != nil {
+			return value
+		}
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if found := findArchivedValue(o[k], key, depth+1); found != nil {
+				return found
+			}
+		}
+	case []interface{}:
+		for _, value := range o {
+			if found := findArchivedValue(value, key, depth+1); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
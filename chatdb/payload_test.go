@@ -0,0 +1,249 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/semver"
+	"gotest.tools/v3/assert"
+)
+
+// archiveUID is a reference to another object in a keyed archive.
+type archiveUID uint64
+
+// testKeyedArchive builds a keyed archive of the given objects, whose root is
+// the object with UID 1, from strings, integers, UIDs, arrays, and
+// dictionaries.
+func testKeyedArchive(objects ...interface{}) []byte {
+	encoded := [][]byte{nil}
+	var add func(v interface{}) byte
+	add = func(v interface{}) byte {
+		var b []byte
+		switch o := v.(type) {
+		case string:
+			b = bplistString(o)
+		case int:
+			b = []byte{0x10, byte(o)}
+		case archiveUID:
+			b = []byte{0x80, byte(o)}
+		case []interface{}:
+			b = []byte{0xa0 | byte(len(o))}
+			for _, elem := range o {
+				b = append(b, add(elem))
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(o))
+			for k := range o {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var refs []byte
+			for _, k := range keys {
+				refs = append(refs, add(k))
+			}
+			for _, k := range keys {
+				refs = append(refs, add(o[k]))
+			}
+			b = bplistDict(refs...)
+		}
+		encoded = append(encoded, b)
+		return byte(len(encoded) - 1)
+	}
+	archive := map[string]interface{}{
+		"$objects": append([]interface{}{"$null"}, objects...),
+		"$top":     map[string]interface{}{"root": archiveUID(1)},
+	}
+	top := add(archive)
+	encoded[0] = encoded[top]
+	return testBPlist(encoded...)
+}
+
+func TestUnarchive(t *testing.T) {
+	tests := []struct {
+		msg     string
+		data    []byte
+		want    interface{}
+		wantErr string
+	}{
+		{
+			msg: "dictionaries, arrays, and strings",
+			data: testKeyedArchive(
+				map[string]interface{}{"$class": archiveUID(2), "info": archiveUID(3), "list": archiveUID(4), "missing": archiveUID(0), "count": 3},
+				map[string]interface{}{"$classname": "Payload"},
+				map[string]interface{}{
+					"NS.keys":    []interface{}{archiveUID(5)},
+					"NS.objects": []interface{}{archiveUID(6)},
+				},
+				map[string]interface{}{"NS.objects": []interface{}{archiveUID(6), archiveUID(6)}},
+				"key",
+				map[string]interface{}{"NS.string": "value"},
+			),
+			want: map[string]interface{}{
+				"info":    map[string]interface{}{"key": "value"},
+				"list":    []interface{}{"value", "value"},
+				"missing": nil,
+				"count":   int64(3),
+			},
+		},
+		{
+			msg:     "invalid UID",
+			data:    testKeyedArchive(map[string]interface{}{"amount": archiveUID(99)}),
+			wantErr: "invalid archived object UID 99",
+		},
+		{
+			msg:     "object containing itself",
+			data:    testKeyedArchive(map[string]interface{}{"self": archiveUID(1)}),
+			wantErr: "archived objects nested too deeply",
+		},
+		{
+			msg:     "invalid dictionary key",
+			data:    testKeyedArchive(map[string]interface{}{"NS.keys": []interface{}{1}, "NS.objects": []interface{}{"value"}}),
+			wantErr: "invalid archived dictionary key 1",
+		},
+		{
+			msg:     "not a keyed archive",
+			data:    testBPlist(bplistDict(1, 2), bplistString("amount"), bplistString("25")),
+			wantErr: "not a keyed archive",
+		},
+		{
+			msg:     "not a property list",
+			data:    []byte("amount"),
+			wantErr: "not a binary property list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			root, err := unarchive(tt.data)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.want, root)
+		})
+	}
+}
+
+func TestHasPayloadText(t *testing.T) {
+	assert.Assert(t, !hasPayloadText("", ""))
+	assert.Assert(t, hasPayloadText("com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:"+_applePayBalloon, "Thanks!"))
+	assert.Assert(t, hasPayloadText(_checkInBalloon, "\ufffc"))
+	assert.Assert(t, hasPayloadText("com.example.game", " \ufffc"))
+	assert.Assert(t, !hasPayloadText("com.apple.DigitalTouchBalloonProvider", "❤️"))
+}
+
+func TestGetMessagePayload(t *testing.T) {
+	payloadQuery := regexp.QuoteMeta("SELECT payload_data FROM message WHERE ROWID=42")
+	payment := testKeyedArchive(map[string]interface{}{"amount": "25", "currency": "USD"})
+
+	tests := []struct {
+		msg         string
+		fromMe      int
+		balloon     string
+		setupQuery  func(*sqlmock.ExpectedQuery)
+		wantText    string
+		wantPayment *Payment
+		wantErr     string
+		// wantUndecodable is set if the payload cannot be decoded.
+		wantUndecodable bool
+	}{
+		{
+			msg:     "sent payment",
+			fromMe:  1,
+			balloon: "com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:" + _applePayBalloon,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(payment))
+			},
+			wantText:    "Sent $25.00 via Apple Pay",
+			wantPayment: &Payment{Amount: "25.00", Currency: "USD"},
+		},
+		{
+			msg:     "received payment",
+			balloon: "com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:" + _applePayBalloon,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(payment))
+			},
+			wantText:    "Received $25.00 via Apple Pay",
+			wantPayment: &Payment{Amount: "25.00", Currency: "USD"},
+		},
+		{
+			msg:     "payment without amount",
+			balloon: "com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:" + _applePayBalloon,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(testKeyedArchive(map[string]interface{}{"ldtext": "Apple Cash"})))
+			},
+			wantText: "\ufffc",
+		},
+		{
+			msg:     "check in",
+			balloon: "com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:" + _checkInBalloon,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(testKeyedArchive(map[string]interface{}{"ldtext": "Check In: Arrived at Home"})))
+			},
+			wantText: "Check In: Arrived at Home",
+		},
+		{
+			msg:     "other app",
+			balloon: "com.example.game",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(testKeyedArchive(map[string]interface{}{"ldtext": "Your move!"})))
+			},
+			wantText: "Your move!",
+		},
+		{
+			msg:     "undecodable payload",
+			balloon: "com.apple.messages.MSMessageExtensionBalloonPlugin:0000000000:" + _applePayBalloon,
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow([]byte("garbage")))
+			},
+			wantText:        "\ufffc [undecodable content]",
+			wantUndecodable: true,
+		},
+		{
+			msg:     "no payload",
+			balloon: "com.example.game",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnRows(sqlmock.NewRows([]string{"payload_data"}).AddRow(nil))
+			},
+			wantText: "\ufffc",
+		},
+		{
+			msg:     "DB error",
+			balloon: "com.example.game",
+			setupQuery: func(query *sqlmock.ExpectedQuery) {
+				query.WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query payload of message ID 42: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			rows := sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+				AddRow(tt.fromMe, 10, "\ufffc", "2019-10-04 18:26:31", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", tt.balloon, false)
+			sMock.ExpectQuery("SELECT is_from_me").WillReturnRows(rows)
+			tt.setupQuery(sMock.ExpectQuery(payloadQuery))
+			cdb := &chatDB{DB: db, selfHandle: "Me"}
+
+			message, err := cdb.GetMessage(42, map[int]string{10: "testhandle1"}, semver.MustParse("13.0"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantText, message.Text)
+			assert.DeepEqual(t, tt.wantPayment, message.Payment)
+			assert.Equal(t, tt.wantUndecodable, message.Undecodable)
+		})
+	}
+}
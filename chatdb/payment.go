@@ -0,0 +1,99 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// _applePayBalloon ends the balloon bundle IDs of messages sending or
+// requesting money with Apple Pay, i.e. Apple Cash.
+const _applePayBalloon = "com.apple.PassbookUIService.PeerPaymentMessagesExtension"
+
+var (
+	// _currencySymbols are the symbols with which amounts in the most
+	// common currencies of Apple Cash are written.
+	_currencySymbols = map[string]string{
+		"USD": "$",
+		"EUR": "€",
+		"GBP": "£",
+	}
+	// _paymentTextRE matches the amount in the text summarizing a payment,
+	// e.g. "$25 sent with Apple Cash", for payloads which only record it
+	// there.
+	_paymentTextRE = regexp.MustCompile(`([$€£])\s?([0-9][0-9,]*(?:\.[0-9]+)?)`)
+)
+
+// Payment is an amount of money sent or requested with Apple Pay. The amount
+// is a decimal number, e.g. "25.00", and the currency an ISO 4217 code, e.g.
+// "USD".
+type Payment struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// String formats the payment as an amount in its currency, e.g. "$25.00", or
+// "25.00 CHF" for currencies without a known symbol.
+func (p Payment) String() string {
+	if symbol, ok := _currencySymbols[p.Currency]; ok {
+		return symbol + p.Amount
+	}
+	return strings.TrimSpace(p.Amount + " " + p.Currency)
+}
+
+// isApplePay checks if a message with the given balloon bundle ID was sent
+// with Apple Pay.
+func isApplePay(balloon string) bool {
+	return strings.HasSuffix(balloon, _applePayBalloon)
+}
+
+// paymentText describes a payment for the text of its message, e.g. "Sent
+// $25.00 via Apple Pay".
+func paymentText(p Payment, fromMe bool) string {
+	if fromMe {
+		return fmt.Sprintf("Sent %s via Apple Pay", p)
+	}
+	return fmt.Sprintf("Received %s via Apple Pay", p)
+}
+
+// decodePayment decodes the payment from the unarchived payload of an Apple
+// Pay message. The amount and currency are recorded under "amount" and
+// "currency" keys, or only in the summary text under the "ldtext" key.
+func decodePayment(root interface{}) (*Payment, error) {
+	amount := findArchivedValue(root, "amount", 0)
+	currency, _ := findArchivedValue(root, "currency", 0).(string)
+	if amount == nil {
+		text, _ := findArchivedValue(root, "ldtext", 0).(string)
+		m := _paymentTextRE.FindStringSubmatch(text)
+		if m == nil {
+			return nil, errors.New("no amount in payment")
+		}
+		for code, symbol := range _currencySymbols {
+			if symbol == m[1] {
+				currency = code
+			}
+		}
+		amount = strings.ReplaceAll(m[2], ",", "")
+	}
+	var value float64
+	var err error
+	switch a := amount.(type) {
+	case string:
+		if value, err = strconv.ParseFloat(a, 64); err != nil {
+			return nil, errors.Wrapf(err, "parse payment amount %q", a)
+		}
+	case int64:
+		value = float64(a)
+	case float64:
+		value = a
+	default:
+		return nil, errors.Errorf("invalid payment amount %v", amount)
+	}
+	return &Payment{Amount: strconv.FormatFloat(value, 'f', 2, 64), Currency: strings.ToUpper(currency)}, nil
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDecodePayment(t *testing.T) {
+	tests := []struct {
+		msg         string
+		payload     []byte
+		wantPayment *Payment
+		wantErr     string
+	}{
+		{
+			msg: "amount and currency",
+			payload: testKeyedArchive(
+				map[string]interface{}{"userInfo": archiveUID(2)},
+				map[string]interface{}{
+					"NS.keys":    []interface{}{archiveUID(3), archiveUID(4)},
+					"NS.objects": []interface{}{archiveUID(5), archiveUID(6)},
+				},
+				"amount", "currency", "25", "usd",
+			),
+			wantPayment: &Payment{Amount: "25.00", Currency: "USD"},
+		},
+		{
+			msg: "integer amount",
+			payload: testKeyedArchive(
+				map[string]interface{}{"amount": 10, "currency": archiveUID(2)},
+				"EUR",
+			),
+			wantPayment: &Payment{Amount: "10.00", Currency: "EUR"},
+		},
+		{
+			msg: "summary text only",
+			payload: testKeyedArchive(
+				map[string]interface{}{"ldtext": archiveUID(2)},
+				map[string]interface{}{"NS.string": archiveUID(3)},
+				"$1,250.5 sent with Apple Cash",
+			),
+			wantPayment: &Payment{Amount: "1250.50", Currency: "USD"},
+		},
+		{
+			msg:     "no amount",
+			payload: testKeyedArchive(map[string]interface{}{"ldtext": "Apple Cash"}),
+			wantErr: "no amount in payment",
+		},
+		{
+			msg:     "invalid amount",
+			payload: testKeyedArchive(map[string]interface{}{"amount": "lots"}),
+			wantErr: `parse payment amount "lots"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			root, err := unarchive(tt.payload)
+			assert.NilError(t, err)
+			payment, err := decodePayment(root)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantPayment, payment)
+		})
+	}
+}
+
+func TestPaymentString(t *testing.T) {
+	assert.Equal(t, "$25.00", Payment{Amount: "25.00", Currency: "USD"}.String())
+	assert.Equal(t, "25.00 CHF", Payment{Amount: "25.00", Currency: "CHF"}.String())
+	assert.Equal(t, "Sent $25.00 via Apple Pay", paymentText(Payment{Amount: "25.00", Currency: "USD"}, true))
+	assert.Equal(t, "Received €5.00 via Apple Pay", paymentText(Payment{Amount: "5.00", Currency: "EUR"}, false))
+}
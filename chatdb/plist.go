@@ -0,0 +1,234 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_bplistMagic       = "bplist00"
+	_bplistTrailerSize = 32
+	// _maxPlistDepth limits the nesting of arrays and dictionaries, so that
+	// a malformed property list which contains itself cannot recurse forever.
+	_maxPlistDepth = 32
+	// _pinnedProperty is the key of the chat properties which marks chats
+	// pinned in Messages, where it is recorded.
+	_pinnedProperty = "isPinned"
+	// _transcriptionProperty is the key of the attachment user info in which
+	// Mac OS 14 and later record the transcripts of audio messages.
+	_transcriptionProperty = "audio-transcription"
+)
+
+// _plistEpoch is the time from which dates in property lists are counted.
+var _plistEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// bplist is a binary property list, e.g. the properties of a chat.
+type bplist struct {
+	data    []byte
+	offsets []uint64
+	refSize int
+}
+
+// decodeBPlist decodes a binary property list into nil, bool, int64, float64,
+// time.Time, []byte, string, []interface{}, and map[string]interface{} values.
+// UIDs, which refer to objects in keyed archives, are decoded as uint64.
+func decodeBPlist(data []byte) (interface{}, error) {
+	if len(data) < len(_bplistMagic)+_bplistTrailerSize || string(data[:len(_bplistMagic)]) != _bplistMagic {
+		return nil, errors.New("not a binary property list")
+	}
+	trailer := data[len(data)-_bplistTrailerSize:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	top := binary.BigEndian.Uint64(trailer[16:])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:])
+	end := uint64(len(data) - _bplistTrailerSize)
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 || top >= numObjects ||
+		tableOffset > end || numObjects > (end-tableOffset)/uint64(offsetSize) {
+		return nil, errors.New("invalid binary property list trailer")
+	}
+	p := bplist{data: data[:end], offsets: make([]uint64, numObjects), refSize: refSize}
+	for i := range p.offsets {
+		start := tableOffset + uint64(i*offsetSize)
+		p.offsets[i] = readUint(data[start : start+uint64(offsetSize)])
+	}
+	return p.object(top, 0)
+}
+
+// object decodes the object with the given reference.
+func (p bplist) object(ref uint64, depth int) (interface{}, error) {
+	if ref >= uint64(len(p.offsets)) {
+		return nil, errors.Errorf("invalid object reference %d", ref)
+	}
+	if depth > _maxPlistDepth {
+		return nil, errors.New("property list nested too deeply")
+	}
+	offset := p.offsets[ref]
+	if offset >= uint64(len(p.data)) {
+		return nil, errors.Errorf("invalid offset %d of object %d", offset, ref)
+	}
+	marker := p.data[offset]
+	body := p.data[offset+1:]
+	info := int(marker & 0xf)
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x00:
+			return nil, nil
+		case 0x08:
+			return false, nil
+		case 0x09:
+			return true, nil
+		}
+	case 0x1:
+		if size := 1 << info; size <= 8 && len(body) >= size {
+			return int64(readUint(body[:size])), nil
+		}
+	case 0x2:
+		switch size := 1 << info; {
+		case size == 4 && len(body) >= 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(body))), nil
+		case size == 8 && len(body) >= 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(body)), nil
+		}
+	case 0x3:
+		if marker == 0x33 && len(body) >= 8 {
+			seconds := math.Float64frombits(binary.BigEndian.Uint64(body))
+			return _plistEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+		}
+	case 0x4:
+		n, body, err := p.length(info, body, 1)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, body[:n]...), nil
+	case 0x5:
+		n, body, err := p.length(info, body, 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(body[:n]), nil
+	case 0x6:
+		n, body, err := p.length(info, body, 2)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(body[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	case 0x8:
+		if size := info + 1; len(body) >= size {
+			return readUint(body[:size]), nil
+		}
+	case 0xa:
+		n, body, err := p.length(info, body, p.refSize)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = p.object(p.ref(body, i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case 0xd:
+		n, body, err := p.length(info, body, 2*p.refSize)
+		if err != nil {
+			return nil, err
+		}
+		dict := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, err := p.object(p.ref(body, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.Errorf("invalid dictionary key %v", key)
+			}
+			if dict[k], err = p.object(p.ref(body, n+i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, errors.Errorf("invalid object marker 0x%02x", marker)
+}
+
+// length returns the number of elements of an object of elements of the given
+// size, and the elements. Lengths of 15 or more follow the marker as an
+// integer object.
+func (p bplist) length(info int, body []byte, elemSize int) (int, []byte, error) {
+	n := uint64(info)
+	if info == 0xf {
+		if len(body) == 0 || body[0]>>4 != 0x1 {
+			return 0, nil, errors.New("invalid object length")
+		}
+		size := 1 << int(body[0]&0xf)
+		if size > 8 || len(body) < 1+size {
+			return 0, nil, errors.New("invalid object length")
+		}
+		n = readUint(body[1 : 1+size])
+		body = body[1+size:]
+	}
+	if n > uint64(len(body)/elemSize) {
+		return 0, nil, errors.Errorf("object length %d exceeds property list", n)
+	}
+	return int(n), body, nil
+}
+
+// ref returns the i-th object reference in the given references.
+func (p bplist) ref(refs []byte, i int) uint64 {
+	return readUint(refs[i*p.refSize : (i+1)*p.refSize])
+}
+
+// readUint reads a big-endian unsigned integer of up to 8 bytes.
+func readUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// isPinned checks if the given properties of a chat, a binary property list,
+// mark it as pinned. Chats without properties, or with properties which cannot
+// be read, are treated as not pinned.
+func isPinned(properties []byte) bool {
+	if len(properties) == 0 {
+		return false
+	}
+	v, err := decodeBPlist(properties)
+	if err != nil {
+		return false
+	}
+	dict, _ := v.(map[string]interface{})
+	pinned, _ := dict[_pinnedProperty].(bool)
+	return pinned
+}
+
+// audioTranscript returns the transcript of an audio message recorded in the
+// given user info of its attachment, or an empty string if it has none.
+func audioTranscript(userInfo []byte) string {
+	if len(userInfo) == 0 {
+		return ""
+	}
+	v, err := decodeBPlist(userInfo)
+	if err != nil {
+		return ""
+	}
+	dict, _ := v.(map[string]interface{})
+	transcript, _ := dict[_transcriptionProperty].(string)
+	return strings.TrimSpace(transcript)
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// testBPlist builds a binary property list of the given encoded objects, with
+// one-byte offsets and object references, whose top object is the first one.
+func testBPlist(objects ...[]byte) []byte {
+	b := []byte(_bplistMagic)
+	var offsets []byte
+	for _, object := range objects {
+		offsets = append(offsets, byte(len(b)))
+		b = append(b, object...)
+	}
+	tableOffset := len(b)
+	b = append(b, offsets...)
+	trailer := make([]byte, _bplistTrailerSize)
+	trailer[6], trailer[7] = 1, 1
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(tableOffset))
+	return append(b, trailer...)
+}
+
+// bplistDict encodes a dictionary of the given key references followed by
+// the given value references.
+func bplistDict(refs ...byte) []byte {
+	return append([]byte{0xd0 | byte(len(refs)/2)}, refs...)
+}
+
+// bplistString encodes an ASCII string, with its length following the marker
+// if it is 15 or more.
+func bplistString(s string) []byte {
+	if len(s) >= 0xf {
+		return append([]byte{0x5f, 0x10, byte(len(s))}, s...)
+	}
+	return append([]byte{0x50 | byte(len(s))}, s...)
+}
+
+func TestDecodeBPlist(t *testing.T) {
+	float := make([]byte, 9)
+	float[0] = 0x23
+	binary.BigEndian.PutUint64(float[1:], math.Float64bits(2.5))
+	date := make([]byte, 9)
+	date[0] = 0x33
+	binary.BigEndian.PutUint64(date[1:], math.Float64bits(86400))
+
+	tests := []struct {
+		msg     string
+		data    []byte
+		want    interface{}
+		wantErr string
+	}{
+		{
+			msg: "dictionary",
+			data: testBPlist(
+				bplistDict(1, 2, 3, 4, 5, 6),
+				bplistString("isPinned"),
+				bplistString("count"),
+				bplistString("name"),
+				[]byte{0x09},
+				[]byte{0x11, 0x01, 0x00},
+				[]byte{0x62, 0x00, 'N', 0x00, 0xe9},
+			),
+			want: map[string]interface{}{"isPinned": true, "count": int64(256), "name": "Né"},
+		},
+		{
+			msg: "array",
+			data: testBPlist(
+				[]byte{0xa6, 1, 2, 3, 4, 5, 6},
+				[]byte{0x08},
+				[]byte{0x00},
+				float,
+				date,
+				[]byte{0x42, 0xbe, 0xef},
+				[]byte{0x80, 0x07},
+			),
+			want: []interface{}{false, nil, 2.5, time.Date(2001, time.January, 2, 0, 0, 0, 0, time.UTC), []byte{0xbe, 0xef}, uint64(7)},
+		},
+		{
+			msg:  "long string",
+			data: testBPlist(append([]byte{0x5f, 0x10, 0x10}, "sixteen bytes!!!"...)),
+			want: "sixteen bytes!!!",
+		},
+		{
+			msg:     "not a plist",
+			data:    []byte("not a plist"),
+			wantErr: "not a binary property list",
+		},
+		{
+			msg:     "invalid trailer",
+			data:    append([]byte(_bplistMagic), make([]byte, _bplistTrailerSize)...),
+			wantErr: "invalid binary property list trailer",
+		},
+		{
+			msg:     "invalid reference",
+			data:    testBPlist([]byte{0xa1, 1}),
+			wantErr: "invalid object reference 1",
+		},
+		{
+			msg:     "self reference",
+			data:    testBPlist([]byte{0xa1, 0}),
+			wantErr: "property list nested too deeply",
+		},
+		{
+			msg:     "invalid key",
+			data:    testBPlist(bplistDict(1, 1), []byte{0x09}),
+			wantErr: "invalid dictionary key true",
+		},
+		{
+			msg:     "truncated string",
+			data:    testBPlist([]byte{0x5f, 0x10, 0x7f}),
+			wantErr: "object length 127 exceeds property list",
+		},
+		{
+			msg:     "invalid marker",
+			data:    testBPlist([]byte{0x70}),
+			wantErr: "invalid object marker 0x70",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			v, err := decodeBPlist(tt.data)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.want, v)
+		})
+	}
+}
+
+func TestIsPinned(t *testing.T) {
+	tests := []struct {
+		msg        string
+		properties []byte
+		want       bool
+	}{
+		{
+			msg:        "pinned",
+			properties: testBPlist(bplistDict(1, 2), bplistString("isPinned"), []byte{0x09}),
+			want:       true,
+		},
+		{
+			msg:        "unpinned",
+			properties: testBPlist(bplistDict(1, 2), bplistString("isPinned"), []byte{0x08}),
+		},
+		{
+			msg:        "no pinned property",
+			properties: testBPlist(bplistDict(1, 2), bplistString("shouldForceToSMS"), []byte{0x09}),
+		},
+		{
+			msg:        "not a dictionary",
+			properties: testBPlist([]byte{0x09}),
+		},
+		{
+			msg: "no properties",
+		},
+		{
+			msg:        "unreadable properties",
+			properties: []byte("not a plist"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPinned(tt.properties))
+		})
+	}
+}
+
+func TestAudioTranscript(t *testing.T) {
+	tests := []struct {
+		msg      string
+		userInfo []byte
+		want     string
+	}{
+		{
+			msg:      "transcript",
+			userInfo: testBPlist(bplistDict(1, 2), bplistString("audio-transcription"), bplistString(" See you at 4 ")),
+			want:     "See you at 4",
+		},
+		{
+			msg:      "no transcript",
+			userInfo: testBPlist(bplistDict(1, 2), bplistString("pgens"), bplistString("abc")),
+		},
+		{
+			msg:      "not a dictionary",
+			userInfo: testBPlist(bplistString("See you at 4")),
+		},
+		{
+			msg: "no user info",
+		},
+		{
+			msg:      "unreadable user info",
+			userInfo: []byte("not a plist"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, audioTranscript(tt.userInfo))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import "database/sql"
+
+// PoolOptions size the pool of connections to the database, so that workers
+// querying it at the same time, e.g. the viewer serving several requests, do
+// not open so many connections that they keep each other, and Messages, out
+// of the database. The zero value leaves the pool unlimited.
+type PoolOptions struct {
+	// Workers is the number of goroutines which query the database at the
+	// same time.
+	Workers int
+	// ConnsPerWorker is the number of connections each worker may hold open
+	// at once, e.g. 2 to read handles while reading messages. Connections
+	// are read-only when the database is opened read-only.
+	ConnsPerWorker int
+	// MaxOpenConns is the maximum number of open connections in total. If it
+	// is 0, it is Workers times ConnsPerWorker.
+	MaxOpenConns int
+}
+
+// maxOpenConns returns the maximum number of open connections, or 0 if the
+// pool is unlimited.
+func (p PoolOptions) maxOpenConns() int {
+	if p.MaxOpenConns > 0 {
+		return p.MaxOpenConns
+	}
+	if p.Workers < 1 {
+		return 0
+	}
+	if p.ConnsPerWorker < 1 {
+		return p.Workers
+	}
+	return p.Workers * p.ConnsPerWorker
+}
+
+// configure sizes the connection pool of the given DB. Idle connections are
+// kept up to the same limit, so that workers do not reopen the database for
+// every query.
+func (p PoolOptions) configure(db *sql.DB) {
+	n := p.maxOpenConns()
+	if n == 0 {
+		return
+	}
+	db.SetMaxOpenConns(n)
+	db.SetMaxIdleConns(n)
+}
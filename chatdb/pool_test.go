@@ -0,0 +1,54 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/v3/assert"
+)
+
+func TestPoolOptions(t *testing.T) {
+	tests := []struct {
+		msg      string
+		pool     PoolOptions
+		wantOpen int
+	}{
+		{
+			msg:      "unlimited",
+			wantOpen: 0,
+		},
+		{
+			msg:      "one connection per worker",
+			pool:     PoolOptions{Workers: 4},
+			wantOpen: 4,
+		},
+		{
+			msg:      "several connections per worker",
+			pool:     PoolOptions{Workers: 4, ConnsPerWorker: 2},
+			wantOpen: 8,
+		},
+		{
+			msg:      "maximum takes precedence",
+			pool:     PoolOptions{Workers: 4, ConnsPerWorker: 2, MaxOpenConns: 3},
+			wantOpen: 3,
+		},
+		{
+			msg:      "connections per worker without workers",
+			pool:     PoolOptions{ConnsPerWorker: 2},
+			wantOpen: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, _, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			NewChatDB(db, "Me", NameFormat{}, tt.pool)
+			assert.Equal(t, tt.wantOpen, db.Stats().MaxOpenConnections)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/tagatac/bagoup/logging"
+)
+
+const (
+	// _busyRetries is the number of times a query is retried while the
+	// database is locked, e.g. by Messages writing to it.
+	_busyRetries = 4
+	// _busyBackoff is how long to wait before the first retry. The wait
+	// doubles with each retry.
+	_busyBackoff = 250 * time.Millisecond
+)
+
+// _busyErrors are the messages of SQLite errors due to the database being
+// locked by another process.
+var _busyErrors = []string{
+	"database is locked",
+	"database is busy",
+	"database table is locked",
+}
+
+func isBusyError(err error) bool {
+	msg := err.Error()
+	for _, busyErr := range _busyErrors {
+		if strings.Contains(msg, busyErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryRetry runs the given query, retrying with exponential backoff while the
+// database is locked.
+func (d *chatDB) queryRetry(query string, args ...interface{}) (*sql.Rows, error) {
+	backoff := d.backoff
+	for retry := 0; ; retry++ {
+		rows, err := d.DB.Query(query, args...)
+		if err == nil || retry >= d.retries || !isBusyError(err) {
+			return rows, err
+		}
+		logging.Warnf("%s - retrying in %s", err, backoff)
+		d.sleep(backoff)
+		backoff *= 2
+	}
+}
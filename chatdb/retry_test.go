@@ -0,0 +1,84 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pkg/errors"
+	"gotest.tools/v3/assert"
+)
+
+func TestQueryRetry(t *testing.T) {
+	tests := []struct {
+		msg         string
+		setupMock   func(sqlmock.Sqlmock)
+		wantSleeps  []time.Duration
+		wantHandles map[int]string
+		wantErr     string
+	}{
+		{
+			msg: "unlocked",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnRows(sqlmock.NewRows([]string{"ROWID", "id"}).AddRow(1, "testhandle"))
+			},
+			wantHandles: map[int]string{1: "testhandle"},
+		},
+		{
+			msg: "unlocked after retrying",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnError(errors.New("database is locked"))
+				sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnError(errors.New("database is locked"))
+				sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnRows(sqlmock.NewRows([]string{"ROWID", "id"}).AddRow(1, "testhandle"))
+			},
+			wantSleeps:  []time.Duration{time.Second, 2 * time.Second},
+			wantHandles: map[int]string{1: "testhandle"},
+		},
+		{
+			msg: "still locked",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				for i := 0; i < 3; i++ {
+					sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnError(errors.New("database is locked"))
+				}
+			},
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:    "get handles from DB: database is locked",
+		},
+		{
+			msg: "other error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT ROWID, id FROM handle").WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "get handles from DB: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+			var sleeps []time.Duration
+			cdb := &chatDB{
+				DB:      db,
+				retries: 2,
+				backoff: time.Second,
+				sleep:   func(d time.Duration) { sleeps = append(sleeps, d) },
+			}
+
+			handles, err := cdb.GetHandleMap(nil)
+			assert.DeepEqual(t, tt.wantSleeps, sleeps)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantHandles, handles)
+		})
+	}
+}
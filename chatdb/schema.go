@@ -0,0 +1,214 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+)
+
+// schema records the tables and columns used by the queries which are missing
+// from a Messages database, either because it is from an older release of Mac
+// OS, e.g. Mac OS X 10.8 through 10.11, or because it was found to be missing
+// them. A nil schema means that the database has all of them.
+type schema struct {
+	missingTables  map[string]bool
+	missingColumns map[string]bool
+	// fallbacks replace the missing columns in queries, in the order of
+	// _optionalColumns.
+	fallbacks []columnFallback
+	// loaded is set if the schema was read from the database rather than
+	// derived from the release of Mac OS.
+	loaded bool
+}
+
+// columnFallback replaces the references to a missing column with its
+// fallback value.
+type columnFallback struct {
+	re    *regexp.Regexp
+	value string
+}
+
+// _optionalTables lists the tables which are missing from some databases.
+var _optionalTables = []string{"chat_message_join"}
+
+// _optionalColumns lists the columns which are missing from some databases,
+// with the values used in their place, and the release of Mac OS which added
+// them. Columns without a release are present in the databases of all known
+// releases with a chat.db, but are missing from some copies.
+var _optionalColumns = []struct {
+	table, column, fallback string
+	since                   *semver.Version
+}{
+	{"chat", "display_name", "NULL", nil},
+	{"chat", "is_archived", "0", nil},
+	{"chat", "properties", "NULL", nil},
+	{"message", "date_delivered", "0", nil},
+	{"message", "date_read", "0", nil},
+	{"message", "item_type", "0", nil},
+	{"message", "group_action_type", "0", nil},
+	{"message", "other_handle", "0", nil},
+	{"message", "service", "NULL", nil},
+	{"message", "account", "NULL", nil},
+	{"message", "is_read", "0", nil},
+	{"message", "is_delivered", "0", nil},
+	{"message", "is_sent", "0", nil},
+	{"message", "error", "0", nil},
+	{"message", "attributedBody", "NULL", nil},
+	{"message", "is_audio_message", "0", semver.MustParse("10.10")},
+	{"message", "is_expirable", "0", semver.MustParse("10.10")},
+	{"message", "expire_state", "0", semver.MustParse("10.10")},
+	{"message", "expressive_send_style_id", "NULL", semver.MustParse("10.12")},
+	{"message", "balloon_bundle_id", "NULL", semver.MustParse("10.12")},
+	{"message", "payload_data", "NULL", semver.MustParse("10.12")},
+	{"message", "associated_message_type", "0", semver.MustParse("10.12")},
+	{"message", "ck_sync_state", "0", semver.MustParse("10.13.5")},
+	{"message", "thread_originator_guid", "NULL", semver.MustParse("11")},
+	{"message", "date_edited", "0", semver.MustParse("13")},
+	{"message", "date_retracted", "0", semver.MustParse("13")},
+	{"chat_message_join", "message_date", "0", nil},
+	{"attachment", "mime_type", "NULL", nil},
+	{"attachment", "transfer_name", "NULL", nil},
+	{"attachment", "total_bytes", "0", nil},
+	{"attachment", "user_info", "NULL", semver.MustParse("14")},
+}
+
+// newSchema records the optional tables and columns which are missing from
+// the given tables and their columns.
+func newSchema(tables map[string]map[string]bool) *schema {
+	s := &schema{missingTables: map[string]bool{}, missingColumns: map[string]bool{}, loaded: true}
+	for _, table := range _optionalTables {
+		if _, ok := tables[table]; !ok {
+			s.missingTables[table] = true
+		}
+	}
+	for _, c := range _optionalColumns {
+		if columns, ok := tables[c.table]; ok && !columns[c.column] {
+			s.missColumn(c.table, c.column, c.fallback)
+		}
+	}
+	return s
+}
+
+// releaseSchema returns the schema of databases from the given release of Mac
+// OS, or nil if it has all of the optional columns.
+func releaseSchema(macOSVersion *semver.Version) *schema {
+	var s *schema
+	for _, c := range _optionalColumns {
+		if c.since == nil || !macOSVersion.LessThan(c.since) {
+			continue
+		}
+		if s == nil {
+			s = &schema{missingTables: map[string]bool{}, missingColumns: map[string]bool{}}
+		}
+		s.missColumn(c.table, c.column, c.fallback)
+	}
+	return s
+}
+
+// missColumn records the given column of the given table as missing, to be
+// replaced in queries with the given fallback value.
+func (s *schema) missColumn(table, column, fallback string) {
+	s.missingColumns[table+"."+column] = true
+	s.fallbacks = append(s.fallbacks, columnFallback{
+		re:    regexp.MustCompile(fmt.Sprintf(`(\b\w+\.)?\b%s\b`, column)),
+		value: fallback,
+	})
+}
+
+// hasTable checks if the database has the given table.
+func (s *schema) hasTable(table string) bool {
+	return s == nil || !s.missingTables[table]
+}
+
+// adapt replaces the columns in the given query which are missing from the
+// database with their fallback values.
+func (s *schema) adapt(query string) string {
+	if s == nil {
+		return query
+	}
+	for _, f := range s.fallbacks {
+		query = f.re.ReplaceAllLiteralString(query, f.value)
+	}
+	return query
+}
+
+// query runs the query built for the database schema, retrying while the
+// database is locked. If the query fails because of a missing table or column,
+// the schema is loaded from the database, and the query is built and run again
+// for the loaded schema.
+func (d *chatDB) query(build func(*schema) string, args ...interface{}) (*sql.Rows, error) {
+	s := d.currentSchema()
+	rows, err := d.queryRetry(s.adapt(build(s)), args...)
+	if err == nil || (s != nil && s.loaded) || !isSchemaError(err) {
+		return rows, classify(err)
+	}
+	if s, err = d.reloadSchema(); err != nil {
+		return nil, err
+	}
+	rows, err = d.queryRetry(s.adapt(build(s)), args...)
+	return rows, classify(err)
+}
+
+// currentSchema returns the schema which queries are adapted to.
+func (d *chatDB) currentSchema() *schema {
+	d.schemaMu.Lock()
+	defer d.schemaMu.Unlock()
+	return d.schema
+}
+
+// reloadSchema loads the schema from the database, unless a concurrent query
+// already has.
+func (d *chatDB) reloadSchema() (*schema, error) {
+	d.schemaMu.Lock()
+	defer d.schemaMu.Unlock()
+	if d.schema != nil && d.schema.loaded {
+		return d.schema, nil
+	}
+	s, err := loadSchema(d.DB)
+	if err != nil {
+		return nil, err
+	}
+	d.schema = s
+	return s, nil
+}
+
+// useRelease adapts the queries to the given release of Mac OS, unless the
+// schema has already been loaded from the database.
+func (d *chatDB) useRelease(macOSVersion *semver.Version) {
+	d.schemaMu.Lock()
+	defer d.schemaMu.Unlock()
+	if macOSVersion != nil && d.schema == nil {
+		d.schema = releaseSchema(macOSVersion)
+	}
+}
+
+func isSchemaError(err error) bool {
+	return strings.Contains(err.Error(), "no such column") || strings.Contains(err.Error(), "no such table")
+}
+
+func loadSchema(db *sql.DB) (*schema, error) {
+	rows, err := db.Query("SELECT m.name, p.name FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS p WHERE m.type = 'table'")
+	if err != nil {
+		return nil, errors.Wrap(classify(err), "query database schema")
+	}
+	defer rows.Close()
+	tables := map[string]map[string]bool{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, errors.Wrap(corrupt(err), "read database schema")
+		}
+		if tables[table] == nil {
+			tables[table] = map[string]bool{}
+		}
+		tables[table][column] = true
+	}
+	return newSchema(tables), nil
+}
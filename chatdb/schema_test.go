@@ -0,0 +1,201 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Masterminds/semver"
+	"gotest.tools/v3/assert"
+)
+
+const _schemaQuery = "SELECT m.name, p.name FROM sqlite_master AS m JOIN pragma_table_info(m.name) AS p WHERE m.type = 'table'"
+
+func TestSchemaAdapt(t *testing.T) {
+	tests := []struct {
+		msg    string
+		tables map[string]map[string]bool
+		query  string
+		want   string
+	}{
+		{
+			msg:   "current schema",
+			query: "SELECT COALESCE(c.display_name, '') FROM chat AS c",
+			want:  "SELECT COALESCE(c.display_name, '') FROM chat AS c",
+		},
+		{
+			msg: "missing columns",
+			tables: map[string]map[string]bool{
+				"chat":    {"ROWID": true, "guid": true},
+				"message": {"ROWID": true, "date": true, "date_read": true},
+			},
+			query: "SELECT COALESCE(c.display_name, ''), date_delivered, date_read FROM chat AS c JOIN message",
+			want:  "SELECT COALESCE(NULL, ''), 0, date_read FROM chat AS c JOIN message",
+		},
+		{
+			msg: "missing table",
+			tables: map[string]map[string]bool{
+				"chat": {"ROWID": true},
+			},
+			query: "SELECT COALESCE(a.total_bytes, 0) FROM attachment AS a",
+			want:  "SELECT COALESCE(a.total_bytes, 0) FROM attachment AS a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var s *schema
+			if tt.tables != nil {
+				s = newSchema(tt.tables)
+			}
+			assert.Equal(t, tt.want, s.adapt(tt.query))
+		})
+	}
+}
+
+// _createTable matches the CREATE TABLE statements of the schema fixtures.
+var _createTable = regexp.MustCompile(`(?s)CREATE TABLE (\w+) \((.*?)\n\);`)
+
+// readSchemaFixture reads the tables and columns created by a schema fixture
+// in testdata/schema.
+func readSchemaFixture(t *testing.T, filename string) map[string]map[string]bool {
+	b, err := os.ReadFile(filename)
+	assert.NilError(t, err)
+	tables := map[string]map[string]bool{}
+	for _, match := range _createTable.FindAllStringSubmatch(string(b), -1) {
+		columns := map[string]bool{}
+		for _, line := range strings.Split(match[2], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 0 && fields[0] != "UNIQUE" && fields[0] != "PRIMARY" {
+				columns[fields[0]] = true
+			}
+		}
+		tables[match[1]] = columns
+	}
+	return tables
+}
+
+func TestReleaseSchema(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/schema/*.sql")
+	assert.NilError(t, err)
+	assert.Assert(t, len(fixtures) > 0, "no schema fixtures")
+	for _, fixture := range fixtures {
+		release := strings.TrimSuffix(filepath.Base(fixture), ".sql")
+		t.Run(release, func(t *testing.T) {
+			tables := readSchemaFixture(t, fixture)
+			loaded := newSchema(tables)
+			derived := releaseSchema(semver.MustParse(release))
+			for _, c := range _optionalColumns {
+				column := c.table + "." + c.column
+				assert.Equal(t, loaded.missingColumns[column], derived != nil && derived.missingColumns[column], column)
+			}
+			assert.Equal(t, 0, len(loaded.missingTables))
+		})
+	}
+}
+
+func TestLegacyGetMessageIDs(t *testing.T) {
+	tests := []struct {
+		msg         string
+		setupSchema func(sqlmock.Sqlmock)
+		wantIDs     []int
+		wantErr     string
+	}{
+		{
+			msg: "no chat_message_join table",
+			setupSchema: func(sMock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"table", "column"}).
+					AddRow("message", "ROWID").
+					AddRow("message", "date").
+					AddRow("message", "handle_id").
+					AddRow("chat_handle_join", "chat_id").
+					AddRow("chat_handle_join", "handle_id")
+				sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnRows(rows)
+				legacyDate := "(CASE WHEN date > 0 THEN date WHEN 0 > 0 THEN 0 ELSE 0 END)"
+				legacySortDate := fmt.Sprintf("(CASE WHEN %[1]s > 1000000000000 THEN %[1]s ELSE %[1]s * 1000000000 END)", legacyDate)
+				sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT ROWID FROM message WHERE handle_id IN (SELECT handle_id FROM chat_handle_join WHERE chat_id=42) ORDER BY %s, ROWID", legacySortDate))).
+					WillReturnRows(sqlmock.NewRows([]string{"ROWID"}).AddRow(192).AddRow(168))
+			},
+			wantIDs: []int{192, 168},
+		},
+		{
+			msg: "schema error",
+			setupSchema: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "query chat_message_join table for chat ID 42: query database schema: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT message_id FROM chat_message_join JOIN message ON message_id = message.ROWID WHERE chat_id=42 ORDER BY %s, message_id", _sortDate))).
+				WillReturnError(errors.New("no such table: chat_message_join"))
+			tt.setupSchema(sMock)
+			cdb := &chatDB{DB: db}
+
+			ids, err := cdb.GetMessageIDs(42)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantIDs, ids)
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestLegacyGetMessage(t *testing.T) {
+	db, sMock, err := sqlmock.New()
+	assert.NilError(t, err)
+	defer db.Close()
+	cdb := &chatDB{DB: db, datetimeFormula: _datetimeFormulaLegacy}
+
+	datetimeFormula := fmt.Sprintf(_datetimeFormulaLegacy, _effectiveDate)
+	sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s), %s, item_type, group_action_type, other_handle, COALESCE(service, ''), %s, date_edited > 0, date_retracted > 0, attributedBody, COALESCE(thread_originator_guid, ''), COALESCE(expressive_send_style_id, ''), COALESCE(balloon_bundle_id, ''), associated_message_type >= 2000 FROM message WHERE ROWID=192", datetimeFormula, _dateSource, _unkeptAudio))).
+		WillReturnError(errors.New("no such column: is_audio_message"))
+	schemaRows := sqlmock.NewRows([]string{"table", "column"})
+	for _, column := range []string{"ROWID", "is_from_me", "handle_id", "text", "date", "date_delivered", "date_read", "item_type", "group_action_type", "other_handle", "service"} {
+		schemaRows.AddRow("message", column)
+	}
+	sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).WillReturnRows(schemaRows)
+	sMock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf("SELECT is_from_me, handle_id, COALESCE(text, ''), DATETIME(%s), %s, item_type, group_action_type, other_handle, COALESCE(service, ''), (0 = 1 AND 0 = 1 AND 0 != 3), 0 > 0, 0 > 0, NULL, COALESCE(NULL, ''), COALESCE(NULL, ''), COALESCE(NULL, ''), 0 >= 2000 FROM message WHERE ROWID=192", datetimeFormula, _dateSource))).
+		WillReturnRows(sqlmock.NewRows([]string{"is_from_me", "handle_id", "text", "date", "date_source", "item_type", "group_action_type", "other_handle", "service", "unkept_audio", "edited", "unsent", "attributed_body", "reply_to", "effect", "balloon", "reaction"}).
+			AddRow(0, 10, "Want to play tennis?", "2013-03-01 15:34:05", 0, 0, 0, 0, "iMessage", false, false, false, nil, "", "", "", false))
+
+	msg, err := cdb.GetMessage(192, map[int]string{10: "Novak"}, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, "[2013-03-01 15:34:05] Novak: Want to play tennis?\n", msg.String())
+	assert.NilError(t, sMock.ExpectationsWereMet())
+}
+
+func TestReloadSchema(t *testing.T) {
+	db, sMock, err := sqlmock.New()
+	assert.NilError(t, err)
+	defer db.Close()
+	sMock.ExpectQuery(regexp.QuoteMeta(_schemaQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"table", "column"}).AddRow("message", "ROWID"))
+	cdb := &chatDB{DB: db}
+
+	loaded, err := cdb.reloadSchema()
+	assert.NilError(t, err)
+	assert.Assert(t, loaded.loaded)
+	// A query which failed while another loaded the schema does not load it
+	// again.
+	again, err := cdb.reloadSchema()
+	assert.NilError(t, err)
+	assert.Equal(t, loaded, again)
+	assert.NilError(t, sMock.ExpectationsWereMet())
+}
@@ -0,0 +1,72 @@
+-- The tables and columns of chat.db read by bagoup, as created by
+-- Mac OS 10.15 (Catalina).
+CREATE TABLE handle (
+	ROWID INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE,
+	id TEXT NOT NULL,
+	service TEXT NOT NULL,
+	UNIQUE (id, service)
+);
+CREATE TABLE chat (
+	ROWID INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid TEXT UNIQUE NOT NULL,
+	properties BLOB,
+	chat_identifier TEXT,
+	service_name TEXT,
+	is_archived INTEGER DEFAULT 0,
+	display_name TEXT
+);
+CREATE TABLE message (
+	ROWID INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid TEXT UNIQUE NOT NULL,
+	text TEXT,
+	handle_id INTEGER DEFAULT 0,
+	service TEXT,
+	account TEXT,
+	date INTEGER,
+	date_read INTEGER,
+	date_delivered INTEGER,
+	is_from_me INTEGER DEFAULT 0,
+	is_read INTEGER DEFAULT 0,
+	is_delivered INTEGER DEFAULT 0,
+	is_sent INTEGER DEFAULT 0,
+	error INTEGER DEFAULT 0,
+	item_type INTEGER DEFAULT 0,
+	other_handle INTEGER DEFAULT 0,
+	group_action_type INTEGER DEFAULT 0,
+	attributedBody BLOB,
+	is_audio_message INTEGER DEFAULT 0,
+	is_expirable INTEGER DEFAULT 0,
+	expire_state INTEGER DEFAULT 0,
+	associated_message_guid TEXT DEFAULT NULL,
+	associated_message_type INTEGER DEFAULT 0,
+	balloon_bundle_id TEXT,
+	payload_data BLOB,
+	expressive_send_style_id TEXT DEFAULT NULL,
+	ck_sync_state INTEGER DEFAULT 0
+);
+CREATE TABLE attachment (
+	ROWID INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid TEXT UNIQUE NOT NULL,
+	created_date INTEGER DEFAULT 0,
+	filename TEXT,
+	mime_type TEXT,
+	transfer_name TEXT,
+	total_bytes INTEGER DEFAULT 0,
+	is_sticker INT DEFAULT 0
+);
+CREATE TABLE chat_handle_join (
+	chat_id INTEGER REFERENCES chat (ROWID) ON DELETE CASCADE,
+	handle_id INTEGER REFERENCES handle (ROWID) ON DELETE CASCADE,
+	UNIQUE (chat_id, handle_id)
+);
+CREATE TABLE chat_message_join (
+	chat_id INTEGER REFERENCES chat (ROWID) ON DELETE CASCADE,
+	message_id INTEGER REFERENCES message (ROWID) ON DELETE CASCADE,
+	message_date INTEGER DEFAULT 0,
+	PRIMARY KEY (chat_id, message_id)
+);
+CREATE TABLE message_attachment_join (
+	message_id INTEGER REFERENCES message (ROWID) ON DELETE CASCADE,
+	attachment_id INTEGER REFERENCES attachment (ROWID) ON DELETE CASCADE,
+	UNIQUE (message_id, attachment_id)
+);
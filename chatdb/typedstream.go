@@ -0,0 +1,463 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// _typedStreamHeader starts typedstreams, with the version of the format
+	// and its signature.
+	_typedStreamHeader = "\x04\x0bstreamtyped"
+	// Tags of the typedstream format. Integers which do not fit in a signed
+	// byte follow _tsInt16 or _tsInt32, and floating-point numbers follow
+	// _tsFloat. New strings, objects, and classes follow _tsNew, and groups
+	// of values of an object end with _tsEnd.
+	_tsInt16 = 0x81
+	_tsInt32 = 0x82
+	_tsFloat = 0x83
+	_tsNew   = 0x84
+	_tsNil   = 0x85
+	_tsEnd   = 0x86
+	// _tsRefBase is the value, as a signed integer, of the tag referring to
+	// the first shared string or object. Later ones count up from it.
+	_tsRefBase = -110
+	// _maxTypedStreamDepth limits the nesting of objects, so that a malformed
+	// typedstream cannot recurse forever.
+	_maxTypedStreamDepth = 32
+)
+
+type (
+	// archivedObject is an object decoded from a typedstream, with the name
+	// of its class and the values which it encoded, in order. Strings are
+	// decoded as string values rather than archived objects.
+	archivedObject struct {
+		class  string
+		values []interface{}
+	}
+
+	// archivedClass is a class decoded from a typedstream, which later
+	// objects of the class refer to.
+	archivedClass string
+
+	// typedStream is the typedstream format of NSArchiver, in which e.g. the
+	// attributed bodies of messages are archived. Strings, i.e. type
+	// encodings and class names, and objects are shared: after their first
+	// appearance, they are referred to by their index.
+	typedStream struct {
+		data    []byte
+		pos     int
+		strings []string
+		objects []interface{}
+	}
+)
+
+// decodeTypedStream decodes the root object of a typedstream into nil, int64,
+// float64, []byte, string, and *archivedObject values.
+func decodeTypedStream(data []byte) (interface{}, error) {
+	if !strings.HasPrefix(string(data), _typedStreamHeader) {
+		return nil, errors.New("not a typedstream")
+	}
+	s := &typedStream{data: data, pos: len(_typedStreamHeader)}
+	// The header ends with the version of the system which archived it.
+	if _, err := s.readInt(); err != nil {
+		return nil, err
+	}
+	typ, err := s.readSharedString()
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.readValues(typ, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, errors.Errorf("invalid root type %q", typ)
+	}
+	return values[0], nil
+}
+
+func (s *typedStream) next() (byte, error) {
+	if s.pos >= len(s.data) {
+		return 0, errors.New("typedstream ends early")
+	}
+	s.pos++
+	return s.data[s.pos-1], nil
+}
+
+func (s *typedStream) peek() (byte, error) {
+	if s.pos >= len(s.data) {
+		return 0, errors.New("typedstream ends early")
+	}
+	return s.data[s.pos], nil
+}
+
+func (s *typedStream) readBytes(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(s.data)-s.pos) {
+		return nil, errors.Errorf("length %d exceeds typedstream", n)
+	}
+	b := s.data[s.pos : s.pos+int(n)]
+	s.pos += int(n)
+	return b, nil
+}
+
+func (s *typedStream) readInt() (int64, error) {
+	tag, err := s.next()
+	if err != nil {
+		return 0, err
+	}
+	return s.readTaggedInt(tag)
+}
+
+// readTaggedInt reads an integer starting with the given tag: either the
+// integer itself, as a signed byte, or a tag for a longer integer.
+func (s *typedStream) readTaggedInt(tag byte) (int64, error) {
+	switch tag {
+	case _tsInt16:
+		b, err := s.readBytes(2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(binary.LittleEndian.Uint16(b))), nil
+	case _tsInt32:
+		b, err := s.readBytes(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	}
+	return int64(int8(tag)), nil
+}
+
+// readRef reads the index of a shared string or object referred to by a tag
+// which was already read.
+func (s *typedStream) readRef(tag byte, count int) (int, error) {
+	ref, err := s.readTaggedInt(tag)
+	if err != nil {
+		return 0, err
+	}
+	i := ref - _tsRefBase
+	if i < 0 || i >= int64(count) {
+		return 0, errors.Errorf("invalid typedstream reference %d", ref)
+	}
+	return int(i), nil
+}
+
+// readSharedString reads a type encoding or class name, which is either new
+// or refers to an earlier one.
+func (s *typedStream) readSharedString() (string, error) {
+	tag, err := s.next()
+	if err != nil {
+		return "", err
+	}
+	switch tag {
+	case _tsNil:
+		return "", nil
+	case _tsNew:
+		n, err := s.readInt()
+		if err != nil {
+			return "", err
+		}
+		b, err := s.readBytes(n)
+		if err != nil {
+			return "", err
+		}
+		s.strings = append(s.strings, string(b))
+		return string(b), nil
+	}
+	i, err := s.readRef(tag, len(s.strings))
+	if err != nil {
+		return "", err
+	}
+	return s.strings[i], nil
+}
+
+// readCString reads a C string, which is a shared string, possibly marked as
+// new itself.
+func (s *typedStream) readCString() (string, error) {
+	tag, err := s.peek()
+	if err != nil {
+		return "", err
+	}
+	if tag == _tsNew && s.pos+1 < len(s.data) && s.data[s.pos+1] >= _tsInt16 {
+		s.pos++
+	}
+	return s.readSharedString()
+}
+
+// readClass reads the name of the class of an object, and the names of its
+// superclasses.
+func (s *typedStream) readClass(depth int) (string, error) {
+	if depth > _maxTypedStreamDepth {
+		return "", errors.New("typedstream nested too deeply")
+	}
+	tag, err := s.next()
+	if err != nil {
+		return "", err
+	}
+	switch tag {
+	case _tsNil:
+		return "", nil
+	case _tsNew:
+		name, err := s.readSharedString()
+		if err != nil {
+			return "", err
+		}
+		if _, err := s.readInt(); err != nil {
+			return "", err
+		}
+		s.objects = append(s.objects, archivedClass(name))
+		if _, err := s.readClass(depth + 1); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	i, err := s.readRef(tag, len(s.objects))
+	if err != nil {
+		return "", err
+	}
+	class, ok := s.objects[i].(archivedClass)
+	if !ok {
+		return "", errors.Errorf("typedstream reference %d is not a class", i)
+	}
+	return string(class), nil
+}
+
+// readObject reads an object, which is either new or refers to an earlier
+// one. New objects are followed by groups of values, each with its type
+// encoding, up to an end tag.
+func (s *typedStream) readObject(depth int) (interface{}, error) {
+	if depth > _maxTypedStreamDepth {
+		return nil, errors.New("typedstream nested too deeply")
+	}
+	tag, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case _tsNil:
+		return nil, nil
+	case _tsNew:
+		i := len(s.objects)
+		s.objects = append(s.objects, nil)
+		class, err := s.readClass(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		obj := &archivedObject{class: class}
+		s.objects[i] = obj
+		for {
+			tag, err := s.peek()
+			if err != nil {
+				return nil, err
+			}
+			if tag == _tsEnd {
+				s.pos++
+				break
+			}
+			typ, err := s.readSharedString()
+			if err != nil {
+				return nil, err
+			}
+			values, err := s.readValues(typ, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj.values = append(obj.values, values...)
+		}
+		if text, ok := obj.string(); ok {
+			s.objects[i] = text
+			return text, nil
+		}
+		return obj, nil
+	}
+	i, err := s.readRef(tag, len(s.objects))
+	if err != nil {
+		return nil, err
+	}
+	return s.objects[i], nil
+}
+
+// readValues reads the values of the given type encoding. Structures are
+// flattened into their fields, and arrays of chars are read as []byte.
+func (s *typedStream) readValues(typ string, depth int) ([]interface{}, error) {
+	var values []interface{}
+	for i := 0; i < len(typ); i++ {
+		switch c := typ[i]; c {
+		case '@':
+			v, err := s.readObject(depth)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case '+':
+			n, err := s.readInt()
+			if err != nil {
+				return nil, err
+			}
+			b, err := s.readBytes(n)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(b))
+		case '*', '%', ':', '#':
+			v, err := s.readCString()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case 'c', 'C', 's', 'S', 'i', 'I', 'l', 'L', 'q', 'Q', 'B':
+			v, err := s.readInt()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case 'f', 'd':
+			v, err := s.readFloat(c == 'd')
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		case '[':
+			end := closingIndex(typ, i)
+			if end < 0 {
+				return nil, errors.Errorf("invalid type encoding %q", typ)
+			}
+			digits := strings.IndexFunc(typ[i+1:end], func(r rune) bool { return r < '0' || r > '9' })
+			if digits <= 0 {
+				return nil, errors.Errorf("invalid type encoding %q", typ)
+			}
+			n, _ := strconv.Atoi(typ[i+1 : i+1+digits])
+			elem := typ[i+1+digits : end]
+			if elem == "c" || elem == "C" {
+				b, err := s.readBytes(int64(n))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, append([]byte{}, b...))
+			} else {
+				for j := 0; j < n; j++ {
+					v, err := s.readValues(elem, depth+1)
+					if err != nil {
+						return nil, err
+					}
+					values = append(values, v...)
+				}
+			}
+			i = end
+		case '{':
+			end := closingIndex(typ, i)
+			if end < 0 {
+				return nil, errors.Errorf("invalid type encoding %q", typ)
+			}
+			fields := typ[i+1 : end]
+			if j := strings.IndexByte(fields, '='); j >= 0 {
+				fields = fields[j+1:]
+			}
+			v, err := s.readValues(fields, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v...)
+			i = end
+		case 'r', 'n', 'N', 'o', 'O', 'R', 'V':
+			// Type qualifiers, e.g. const, do not change how values are read.
+		default:
+			return nil, errors.Errorf("unsupported type encoding %q", typ)
+		}
+	}
+	return values, nil
+}
+
+// closingIndex returns the index of the bracket closing the array or
+// structure type encoding at the given index, or -1 if it is not closed.
+func closingIndex(typ string, start int) int {
+	depth := 0
+	for i := start; i < len(typ); i++ {
+		switch typ[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (s *typedStream) readFloat(double bool) (float64, error) {
+	tag, err := s.next()
+	if err != nil {
+		return 0, err
+	}
+	if tag != _tsFloat {
+		n, err := s.readTaggedInt(tag)
+		return float64(n), err
+	}
+	if !double {
+		b, err := s.readBytes(4)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	}
+	b, err := s.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// string returns the text of an NSString.
+func (o *archivedObject) string() (string, bool) {
+	switch o.class {
+	case "NSString", "NSMutableString":
+		if len(o.values) == 1 {
+			text, ok := o.values[0].(string)
+			return text, ok
+		}
+	}
+	return "", false
+}
+
+// dictionary returns the entries of an NSDictionary with string keys.
+func (o *archivedObject) dictionary() (map[string]interface{}, bool) {
+	if o.class != "NSDictionary" && o.class != "NSMutableDictionary" || len(o.values) == 0 {
+		return nil, false
+	}
+	n, ok := o.values[0].(int64)
+	if !ok || n < 0 || int64(len(o.values)) != 1+2*n {
+		return nil, false
+	}
+	dict := make(map[string]interface{}, n)
+	for i := 1; i < len(o.values); i += 2 {
+		key, ok := o.values[i].(string)
+		if !ok {
+			return nil, false
+		}
+		dict[key] = o.values[i+1]
+	}
+	return dict, true
+}
+
+// number returns the value of an NSNumber.
+func (o *archivedObject) number() (float64, bool) {
+	if o.class != "NSNumber" || len(o.values) == 0 {
+		return 0, false
+	}
+	switch v := o.values[len(o.values)-1].(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// _testMessageBody is the attributed body of a message with the text "Hello",
+// as archived by Messages.
+const _testMessageBody = "\x04\x0bstreamtyped\x81\xe8\x03\x84\x01@\x84\x84\x84\x12NSAttributedString\x00\x84\x84\x08NSObject\x00\x85" +
+	"\x92\x84\x84\x84\x08NSString\x01\x94\x84\x01+\x05Hello\x86" +
+	"\x84\x02iI\x01\x05" +
+	"\x92\x84\x84\x84\x0cNSDictionary\x00\x94\x84\x01i\x01" +
+	"\x92\x84\x96\x96\x1d__kIMMessagePartAttributeName\x86" +
+	"\x92\x84\x84\x84\x08NSNumber\x00\x84\x84\x07NSValue\x00\x94\x84\x01*\x84\x99\x99\x00\x86" +
+	"\x86\x86"
+
+// testTypedStream archives objects in the typedstream format for tests,
+// sharing strings and classes as NSArchiver does.
+type testTypedStream struct {
+	bytes.Buffer
+	strings map[string]int
+	classes map[string]int
+	objects int
+}
+
+func newTestTypedStream() *testTypedStream {
+	s := &testTypedStream{strings: map[string]int{}, classes: map[string]int{}}
+	s.WriteString("\x04\x0bstreamtyped\x81\xe8\x03")
+	return s
+}
+
+func (s *testTypedStream) int(n int) {
+	if n >= 0 && n < 0x80 {
+		s.WriteByte(byte(int8(n)))
+		return
+	}
+	s.WriteByte(_tsInt16)
+	s.WriteByte(byte(n))
+	s.WriteByte(byte(n >> 8))
+}
+
+func (s *testTypedStream) ref(i int) {
+	s.WriteByte(byte(int8(i + _tsRefBase)))
+}
+
+func (s *testTypedStream) sharedString(str string) {
+	if i, ok := s.strings[str]; ok {
+		s.ref(i)
+		return
+	}
+	s.strings[str] = len(s.strings)
+	s.WriteByte(_tsNew)
+	s.int(len(str))
+	s.WriteString(str)
+}
+
+// class archives a class with the names of its superclasses, ending with
+// NSObject.
+func (s *testTypedStream) class(names ...string) {
+	for _, name := range append(names, "NSObject") {
+		if i, ok := s.classes[name]; ok {
+			s.ref(i)
+			return
+		}
+		s.WriteByte(_tsNew)
+		s.sharedString(name)
+		s.int(0)
+		s.classes[name] = s.objects
+		s.objects++
+	}
+	s.WriteByte(_tsNil)
+}
+
+func (s *testTypedStream) beginObject(classes ...string) {
+	s.WriteByte(_tsNew)
+	s.objects++
+	s.class(classes...)
+}
+
+func (s *testTypedStream) endObject() {
+	s.WriteByte(_tsEnd)
+}
+
+// object archives a string, an integer as an NSNumber, or a map of strings
+// as an NSDictionary.
+func (s *testTypedStream) object(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		s.beginObject("NSString")
+		s.sharedString("+")
+		s.int(len(v))
+		s.WriteString(v)
+	case int:
+		s.beginObject("NSNumber", "NSValue")
+		s.sharedString("*")
+		s.WriteByte(_tsNew)
+		s.sharedString("q")
+		s.sharedString("q")
+		s.int(v)
+	case map[string]interface{}:
+		s.beginObject("NSDictionary")
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		s.sharedString("i")
+		s.int(len(keys))
+		for _, key := range keys {
+			s.sharedString("@")
+			s.object(key)
+			s.sharedString("@")
+			s.object(v[key])
+		}
+	}
+	s.endObject()
+}
+
+// testRun is a run of characters of an attributed string in tests, with the
+// index of its attributes. The attributes are archived with the first run
+// which has the index.
+type testRun struct {
+	length int
+	index  int
+	attrs  map[string]interface{}
+}
+
+// testAttributedBody archives the given text and runs like the attributedBody
+// column of the message table.
+func testAttributedBody(text string, runs ...testRun) []byte {
+	s := newTestTypedStream()
+	s.sharedString("@")
+	s.beginObject("NSMutableAttributedString", "NSAttributedString")
+	s.sharedString("@")
+	s.object(text)
+	seen := map[int]bool{}
+	for _, run := range runs {
+		s.sharedString("iI")
+		s.int(run.index)
+		s.int(run.length)
+		if !seen[run.index] {
+			seen[run.index] = true
+			s.sharedString("@")
+			s.object(run.attrs)
+		}
+	}
+	s.endObject()
+	return s.Bytes()
+}
+
+// describeArchived describes a value decoded from a typedstream, e.g.
+// `NSNumber{"q", 1}`.
+func describeArchived(v interface{}) string {
+	obj, ok := v.(*archivedObject)
+	if !ok {
+		return fmt.Sprintf("%#v", v)
+	}
+	values := make([]string, len(obj.values))
+	for i, value := range obj.values {
+		values[i] = describeArchived(value)
+	}
+	return fmt.Sprintf("%s{%s}", obj.class, strings.Join(values, ", "))
+}
+
+func TestDecodeTypedStream(t *testing.T) {
+	values := newTestTypedStream()
+	values.sharedString("@")
+	values.beginObject("NSValue")
+	values.sharedString("{_NSRange=QQ}")
+	values.int(3)
+	values.int(300)
+	values.sharedString("[4c]")
+	values.WriteString("\x01\x02\x03\x04")
+	values.sharedString("d")
+	values.WriteString("\x83\x00\x00\x00\x00\x00\x00\xf8\x3f")
+	values.sharedString("f")
+	values.int(2)
+	values.sharedString("@")
+	values.WriteByte(_tsNil)
+	values.endObject()
+
+	tests := []struct {
+		msg     string
+		data    []byte
+		want    string
+		wantErr string
+	}{
+		{
+			msg:  "message",
+			data: []byte(_testMessageBody),
+			want: `NSAttributedString{"Hello", 1, 5, NSDictionary{1, "__kIMMessagePartAttributeName", NSNumber{"i", 0}}}`,
+		},
+		{
+			msg: "shared classes and strings",
+			data: testAttributedBody("Hi Novak",
+				testRun{length: 3, index: 1, attrs: map[string]interface{}{"part": 0}},
+				testRun{length: 5, index: 2, attrs: map[string]interface{}{"part": 0, "mention": "+14155555555"}},
+			),
+			want: `NSMutableAttributedString{"Hi Novak", 1, 3, NSDictionary{1, "part", NSNumber{"q", 0}}, 2, 5, NSDictionary{2, "mention", "+14155555555", "part", NSNumber{"q", 0}}}`,
+		},
+		{
+			msg:  "structures, arrays, and floating-point numbers",
+			data: values.Bytes(),
+			want: `NSValue{3, 300, []byte{0x1, 0x2, 0x3, 0x4}, 1.5, 2, <nil>}`,
+		},
+		{
+			msg:     "not a typedstream",
+			data:    []byte("bplist00"),
+			wantErr: "not a typedstream",
+		},
+		{
+			msg:     "truncated",
+			data:    []byte(_testMessageBody[:len(_testMessageBody)-1]),
+			wantErr: "typedstream ends early",
+		},
+		{
+			msg:     "truncated string",
+			data:    []byte(_testMessageBody[:60]),
+			wantErr: "length 8 exceeds typedstream",
+		},
+		{
+			msg:     "invalid length",
+			data:    []byte(strings.Replace(_testMessageBody, "\x05Hello", "\x7fHello", 1)),
+			wantErr: "length 127 exceeds typedstream",
+		},
+		{
+			msg:     "invalid reference",
+			data:    []byte(strings.Replace(_testMessageBody, "\x08NSString\x01\x94", "\x08NSString\x01\xa0", 1)),
+			wantErr: "invalid typedstream reference -96",
+		},
+		{
+			msg:     "unsupported type",
+			data:    []byte(strings.Replace(_testMessageBody, "\x84\x02iI", "\x84\x02i^", 1)),
+			wantErr: `unsupported type encoding "i^"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			v, err := decodeTypedStream(tt.data)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, describeArchived(v))
+		})
+	}
+}
+
+func TestDecodeTypedStreamNesting(t *testing.T) {
+	s := newTestTypedStream()
+	s.sharedString("@")
+	for i := 0; i <= _maxTypedStreamDepth; i++ {
+		s.beginObject("NSArray")
+		s.sharedString("@")
+	}
+	_, err := decodeTypedStream(s.Bytes())
+	assert.ErrorContains(t, err, "typedstream nested too deeply")
+}
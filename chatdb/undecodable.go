@@ -0,0 +1,27 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"strings"
+
+	"github.com/tagatac/bagoup/logging"
+)
+
+// _undecodableContent marks the place of content of a message which could not
+// be decoded.
+const _undecodableContent = "[undecodable content]"
+
+// markUndecodable marks a message as undecodable because the given part of it
+// is malformed, and notes the undecodable content after whatever of its text
+// was recovered.
+func (m *Message) markUndecodable(part string, err error) {
+	logging.Warnf("decode %s of message ID %d: %s", part, m.ID, err)
+	m.Undecodable = true
+	if text := strings.TrimSpace(m.Text); text != "" {
+		m.Text = text + " " + _undecodableContent
+		return
+	}
+	m.Text = _undecodableContent
+}
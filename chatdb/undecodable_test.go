@@ -0,0 +1,38 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package chatdb
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestMarkUndecodable(t *testing.T) {
+	tests := []struct {
+		msg  string
+		text string
+		want string
+	}{
+		{
+			msg:  "recovered text",
+			text: "Want to play ",
+			want: "Want to play [undecodable content]",
+		},
+		{
+			msg:  "no text",
+			want: "[undecodable content]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			m := Message{ID: 42, Text: tt.text}
+			m.markUndecodable("attributed body", errors.New("this is a decode error"))
+			assert.Equal(t, tt.want, m.Text)
+			assert.Assert(t, m.Undecodable)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package collation compares names in the alphabetical order of a language,
+// e.g. to list chats by contact name, rather than in the order of their bytes,
+// which sorts lowercase and accented letters after all uppercase ASCII
+// letters.
+package collation
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// _otherInitial groups names which do not start with a letter.
+const _otherInitial = "#"
+
+// Collator compares names in the alphabetical order of a language. It is not
+// safe for concurrent use.
+type Collator struct {
+	strict *collate.Collator
+	// loose ignores case and accents, for grouping names by initial.
+	loose *collate.Collator
+}
+
+// New returns a Collator for the language with the given BCP 47 tag, e.g.
+// "en" or "sv".
+func New(locale string) (*Collator, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, fmt.Errorf("unknown language %q - FIX: use a language tag, e.g. 'en' or 'sv'", locale)
+	}
+	return &Collator{
+		strict: collate.New(tag),
+		loose:  collate.New(tag, collate.Loose),
+	}, nil
+}
+
+// Compare returns -1, 0, or 1 if the first name sorts before, with, or after
+// the second name.
+func (c *Collator) Compare(a, b string) int {
+	return c.strict.CompareString(a, b)
+}
+
+// Initial returns the letter under which the given name is listed in an
+// index. Accented letters are listed under their base letters, e.g. "Émile"
+// under "E", unless they are letters of their own in the language, e.g. "Åsa"
+// in Swedish. Names which do not start with a letter are listed under "#".
+func (c *Collator) Initial(name string) string {
+	r, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsLetter(r) {
+		return _otherInitial
+	}
+	initial := string(unicode.ToUpper(r))
+	for l := 'A'; l <= 'Z'; l++ {
+		if c.loose.CompareString(initial, string(l)) == 0 {
+			return string(l)
+		}
+	}
+	return initial
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package collation
+
+import (
+	"sort"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New("en")
+	assert.NilError(t, err)
+	_, err = New("not a language")
+	assert.Error(t, err, `unknown language "not a language" - FIX: use a language tag, e.g. 'en' or 'sv'`)
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		msg    string
+		locale string
+		names  []string
+		want   []string
+	}{
+		{
+			msg:    "English",
+			locale: "en",
+			names:  []string{"Zoë", "émile", "Ana", "Øyvind", "bruno", "Åsa"},
+			want:   []string{"Ana", "Åsa", "bruno", "émile", "Øyvind", "Zoë"},
+		},
+		{
+			msg:    "Swedish",
+			locale: "sv",
+			names:  []string{"Zoë", "Örjan", "Ana", "Åsa", "Ärla"},
+			want:   []string{"Ana", "Zoë", "Åsa", "Ärla", "Örjan"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			c, err := New(tt.locale)
+			assert.NilError(t, err)
+			names := append([]string{}, tt.names...)
+			sort.SliceStable(names, func(i, j int) bool { return c.Compare(names[i], names[j]) < 0 })
+			assert.DeepEqual(t, tt.want, names)
+		})
+	}
+}
+
+func TestInitial(t *testing.T) {
+	tests := []struct {
+		locale string
+		name   string
+		want   string
+	}{
+		{locale: "en", name: "novak", want: "N"},
+		{locale: "en", name: "Émile", want: "E"},
+		{locale: "en", name: "Åsa", want: "A"},
+		{locale: "sv", name: "Åsa", want: "Å"},
+		{locale: "en", name: "Дмитрий", want: "Д"},
+		{locale: "en", name: "+14155555555", want: "#"},
+		{locale: "en", name: "", want: "#"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale+" "+tt.name, func(t *testing.T) {
+			c, err := New(tt.locale)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, c.Initial(tt.name))
+		})
+	}
+}
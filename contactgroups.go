@@ -0,0 +1,85 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// contactGroups sorts one-to-one chats by the groups of their contacts, i.e.
+// the CATEGORIES of their vCards, e.g. Family or Work, to select the chats of
+// the groups given with --contact-group and to lay out the chat folders with
+// the Group field of --dir-template.
+type contactGroups struct {
+	contacts chatdb.ContactResolver
+	// selected are the groups given with --contact-group, in lower case.
+	selected map[string]bool
+}
+
+// newContactGroups returns the groups of the contacts, or nil if no contacts
+// were given.
+func newContactGroups(opts options, contacts chatdb.ContactResolver) (*contactGroups, error) {
+	if contacts == nil {
+		if len(opts.ContactGroups) > 0 {
+			return nil, errors.New("--contact-group selects chats by the groups of contacts - FIX: pass the contacts with --contacts-path")
+		}
+		return nil, nil
+	}
+	g := &contactGroups{contacts: contacts}
+	if len(opts.ContactGroups) > 0 {
+		g.selected = make(map[string]bool, len(opts.ContactGroups))
+		for _, group := range opts.ContactGroups {
+			g.selected[strings.ToLower(strings.TrimSpace(group))] = true
+		}
+	}
+	return g, nil
+}
+
+// groups returns the groups of the contact of the other participant of the
+// given chat, if it is a one-to-one chat, in the order of the vCard.
+func (g *contactGroups) groups(chat chatdb.Chat) []string {
+	if g == nil {
+		return nil
+	}
+	handle := directHandle(chat)
+	if handle == "" {
+		return nil
+	}
+	card := g.contacts.Contact(handle)
+	if card == nil {
+		return nil
+	}
+	var groups []string
+	for _, category := range card.Categories() {
+		if category = strings.TrimSpace(category); category != "" {
+			groups = append(groups, category)
+		}
+	}
+	return groups
+}
+
+// keep checks if the given chat is in one of the groups selected with
+// --contact-group, if any were.
+func (g *contactGroups) keep(chat chatdb.Chat) bool {
+	if g == nil || g.selected == nil {
+		return true
+	}
+	return g.group(chat) != ""
+}
+
+// group returns the group in which to lay out the folder of the given chat:
+// the first of the groups of its contact which was selected with
+// --contact-group, or the first of them if none were selected, or an empty
+// string if its contact is in none of them.
+func (g *contactGroups) group(chat chatdb.Chat) string {
+	for _, group := range g.groups(chat) {
+		if g.selected == nil || g.selected[strings.ToLower(group)] {
+			return group
+		}
+	}
+	return ""
+}
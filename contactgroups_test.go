@@ -0,0 +1,96 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestContactGroups(t *testing.T) {
+	contacts := chatdb.ContactMap{
+		"+14155555555": &vcard.Card{
+			"FN":         []*vcard.Field{{Value: "Rafael Nadal"}},
+			"CATEGORIES": []*vcard.Field{{Value: "Tennis, Family"}},
+		},
+		"+16505555555": &vcard.Card{"FN": []*vcard.Field{{Value: "Novak Djokovic"}}},
+	}
+
+	tests := []struct {
+		msg       string
+		opts      options
+		contacts  chatdb.ContactResolver
+		guid      string
+		wantGroup string
+		wantKeep  bool
+		wantErr   string
+	}{
+		{
+			msg:      "no contacts",
+			guid:     "iMessage;-;+14155555555",
+			wantKeep: true,
+		},
+		{
+			msg:       "first group",
+			contacts:  contacts,
+			guid:      "iMessage;-;+14155555555",
+			wantGroup: "Tennis",
+			wantKeep:  true,
+		},
+		{
+			msg:       "selected group",
+			opts:      options{ContactGroups: []string{"family"}},
+			contacts:  contacts,
+			guid:      "iMessage;-;+14155555555",
+			wantGroup: "Family",
+			wantKeep:  true,
+		},
+		{
+			msg:      "other group",
+			opts:     options{ContactGroups: []string{"Work"}},
+			contacts: contacts,
+			guid:     "iMessage;-;+14155555555",
+		},
+		{
+			msg:      "contact without groups",
+			contacts: contacts,
+			guid:     "iMessage;-;+16505555555",
+			wantKeep: true,
+		},
+		{
+			msg:      "handle without contact",
+			opts:     options{ContactGroups: []string{"Family"}},
+			contacts: contacts,
+			guid:     "iMessage;-;+12125555555",
+		},
+		{
+			msg:      "group chat",
+			opts:     options{ContactGroups: []string{"Family"}},
+			contacts: contacts,
+			guid:     "iMessage;+;chat123456",
+		},
+		{
+			msg:     "group without contacts",
+			opts:    options{ContactGroups: []string{"Family"}},
+			wantErr: "--contact-group selects chats by the groups of contacts - FIX: pass the contacts with --contacts-path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			g, err := newContactGroups(tt.opts, tt.contacts)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			chat := chatdb.Chat{GUID: tt.guid}
+			assert.Equal(t, tt.wantGroup, g.group(chat))
+			assert.Equal(t, tt.wantKeep, g.keep(chat))
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _copyRetryDelay is the delay before the first retry of a failed attachment
+// copy. Each further retry waits one more delay.
+const _copyRetryDelay = time.Second
+
+type (
+	// attachmentCopier copies attachments in the background with a pool of
+	// workers, optionally limiting the rate at which bytes are copied, e.g. for
+	// exports to network drives. Copies which fail for reasons other than the
+	// attachment not existing are retried.
+	attachmentCopier struct {
+		s              opsys.OS
+		clone          bool
+		bytesPerSecond float64
+		retries        int
+		retryDelay     time.Duration
+		now            func() time.Time
+		sleep          func(time.Duration)
+
+		jobs     chan copyJob
+		wg       sync.WaitGroup
+		waitOnce sync.Once
+		mu       sync.Mutex
+		pairMu   sync.Mutex
+		next     time.Time
+		err      error
+		// checksums are the checksums of the copies, by their paths, if
+		// they are recorded.
+		checksums map[string]opsys.Checksum
+		// reuse is set if copies already in the destination folders are
+		// used instead of copying the attachments again.
+		reuse bool
+		// batch holds the copies queued since the last call to whenCopied,
+		// and callbacks waits for the functions given to whenCopied.
+		batch     *copyBatch
+		callbacks sync.WaitGroup
+	}
+
+	copyJob struct {
+		src    string
+		dstDir string
+		size   int64
+		// video is the video of a Live Photo whose photo is src.
+		video string
+		batch *copyBatch
+	}
+
+	// copyBatch tracks a group of copies, e.g. the attachments of a chat.
+	copyBatch struct {
+		wg sync.WaitGroup
+		// failed is set, under the copier's mutex, if any of the copies
+		// failed.
+		failed bool
+	}
+)
+
+// newAttachmentCopier starts a copier with the given number of workers. A
+// megabytesPerSecond of 0 means that copying is not rate-limited. If clone is
+// true, attachments are cloned rather than copied where possible.
+func newAttachmentCopier(s opsys.OS, workers int, megabytesPerSecond float64, retries int, clone bool) *attachmentCopier {
+	if workers < 1 {
+		workers = 1
+	}
+	c := &attachmentCopier{
+		s:              s,
+		clone:          clone,
+		bytesPerSecond: megabytesPerSecond * 1000000,
+		retries:        retries,
+		retryDelay:     _copyRetryDelay,
+		now:            time.Now,
+		sleep:          time.Sleep,
+		jobs:           make(chan copyJob),
+	}
+	c.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go c.work()
+	}
+	return c
+}
+
+// recordChecksums makes the copier record the checksums of the copies, computed
+// while they are copied. Clones are not read, so their checksums are not
+// recorded. It must be called before any attachment is queued.
+func (c *attachmentCopier) recordChecksums() {
+	c.checksums = map[string]opsys.Checksum{}
+}
+
+// reuseCopies makes the copier use copies of attachments which are already in
+// the destination folders, e.g. when resuming an interrupted export, rather
+// than copying them again under other names. It must be called before any
+// attachment is queued.
+func (c *attachmentCopier) reuseCopies() {
+	c.reuse = true
+}
+
+// add queues the attachment at the given path, of the given size in bytes,
+// for copying into the given directory.
+func (c *attachmentCopier) add(src, dstDir string, size int64) {
+	c.queue(copyJob{src: src, dstDir: dstDir, size: size})
+}
+
+// addLivePhoto queues the photo and the video of a Live Photo, of the given
+// combined size in bytes, for copying together into the given directory.
+func (c *attachmentCopier) addLivePhoto(photo, video, dstDir string, size int64) {
+	c.queue(copyJob{src: photo, dstDir: dstDir, size: size, video: video})
+}
+
+func (c *attachmentCopier) queue(job copyJob) {
+	if c.batch == nil {
+		c.batch = &copyBatch{}
+	}
+	job.batch = c.batch
+	job.batch.wg.Add(1)
+	c.jobs <- job
+}
+
+// whenCopied calls fn in the background once the attachments queued since the
+// last call have been copied, e.g. to record that a chat was completely
+// exported, unless any of them could not be copied. An error returned by fn is
+// returned by wait.
+func (c *attachmentCopier) whenCopied(fn func() error) {
+	b := c.batch
+	c.batch = nil
+	c.callbacks.Add(1)
+	go func() {
+		defer c.callbacks.Done()
+		if b != nil {
+			b.wg.Wait()
+			c.mu.Lock()
+			failed := b.failed
+			c.mu.Unlock()
+			if failed {
+				return
+			}
+		}
+		if err := fn(); err != nil {
+			c.fail(err)
+		}
+	}()
+}
+
+// wait waits for all queued attachments to be copied, and for the functions
+// given to whenCopied, returning the first error which could not be retried
+// away. It may be called more than once.
+func (c *attachmentCopier) wait() error {
+	c.waitOnce.Do(func() {
+		close(c.jobs)
+		c.wg.Wait()
+		c.callbacks.Wait()
+	})
+	return c.err
+}
+
+// fail records the given error, unless an error was already recorded.
+func (c *attachmentCopier) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *attachmentCopier) work() {
+	defer c.wg.Done()
+	for job := range c.jobs {
+		c.throttle(job.size)
+		if err := c.copy(job); err != nil {
+			c.fail(err)
+			c.mu.Lock()
+			job.batch.failed = true
+			c.mu.Unlock()
+		}
+		job.batch.wg.Done()
+	}
+}
+
+func (c *attachmentCopier) copy(job copyJob) error {
+	if job.video == "" {
+		_, err := c.copyFile(job.src, job.dstDir)
+		return err
+	}
+	// Live Photos are copied one at a time, so that the halves of another Live
+	// Photo with the same name cannot take the name of one of the halves.
+	c.pairMu.Lock()
+	defer c.pairMu.Unlock()
+	photoDst, err := c.copyFile(job.src, job.dstDir)
+	if err != nil {
+		return err
+	}
+	videoDst, err := c.copyFile(job.video, job.dstDir)
+	if err != nil {
+		return err
+	}
+	if photoDst != "" && videoDst != "" && !strings.EqualFold(trimExt(photoDst), trimExt(videoDst)) {
+		logging.Warnf("the photo and the video of Live Photo %q were copied to %q and %q", job.src, photoDst, videoDst)
+	}
+	return nil
+}
+
+// copyFile copies the file at the given path into the given directory,
+// returning the path of the copy, or an empty path if the file does not exist.
+func (c *attachmentCopier) copyFile(src, dstDir string) (string, error) {
+	if c.reuse {
+		dst, sum, err := c.s.ExistingCopy(src, dstDir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "find copy of attachment %q in %q", src, dstDir)
+		}
+		if dst != "" {
+			c.recordChecksum(dst, sum)
+			return dst, nil
+		}
+	}
+	var dst string
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			c.sleep(time.Duration(attempt) * c.retryDelay)
+		}
+		var sum opsys.Checksum
+		switch {
+		case c.clone:
+			dst, err = c.s.CloneFile(src, dstDir)
+		case c.checksums != nil:
+			dst, sum, err = c.s.CopyFileChecksum(src, dstDir)
+		default:
+			dst, err = c.s.CopyFile(src, dstDir)
+		}
+		if os.IsNotExist(err) {
+			logging.Warnf("attachment %q does not exist locally", src)
+			return "", nil
+		}
+		if err == nil {
+			c.recordChecksum(dst, sum)
+			return dst, nil
+		}
+	}
+	return "", errors.Wrapf(err, "copy attachment %q to %q", src, dstDir)
+}
+
+// recordChecksum records the given checksum of the copy at the given path, if
+// checksums are recorded and it was computed.
+func (c *attachmentCopier) recordChecksum(dst string, sum opsys.Checksum) {
+	if c.checksums == nil || sum.SHA256 == "" {
+		return
+	}
+	c.mu.Lock()
+	c.checksums[dst] = sum
+	c.mu.Unlock()
+}
+
+func trimExt(p string) string {
+	return strings.TrimSuffix(p, path.Ext(p))
+}
+
+// throttle waits until copying the given number of bytes keeps all of the
+// workers together within the rate limit.
+func (c *attachmentCopier) throttle(size int64) {
+	if c.bytesPerSecond <= 0 || size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	now := c.now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	wait := c.next.Sub(now)
+	c.next = c.next.Add(time.Duration(float64(size) / c.bytesPerSecond * float64(time.Second)))
+	c.mu.Unlock()
+	if wait > 0 {
+		c.sleep(wait)
+	}
+}
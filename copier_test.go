@@ -0,0 +1,246 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestAttachmentCopier(t *testing.T) {
+	tests := []struct {
+		msg       string
+		clone     bool
+		setupMock func(*mock_opsys.MockOS)
+		wantDelay time.Duration
+		wantErr   string
+	}{
+		{
+			msg: "success",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo.jpeg", nil)
+			},
+		},
+		{
+			msg:   "clone",
+			clone: true,
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().CloneFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo.jpeg", nil)
+			},
+		},
+		{
+			msg: "missing attachment",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("", os.ErrNotExist)
+			},
+		},
+		{
+			msg: "transient error",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("", errors.New("this is a network error")),
+					osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo.jpeg", nil),
+				)
+			},
+			wantDelay: time.Second,
+		},
+		{
+			msg: "persistent error",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("", errors.New("this is a network error")).Times(3)
+			},
+			wantDelay: 3 * time.Second,
+			wantErr:   `copy attachment "/attachments/photo.jpeg" to "backup/Novak/attachments": this is a network error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			tt.setupMock(osMock)
+
+			c := newAttachmentCopier(osMock, 2, 0, 2, tt.clone)
+			var delay time.Duration
+			c.sleep = func(d time.Duration) { delay += d }
+			c.add("/attachments/photo.jpeg", "backup/Novak/attachments", 1024)
+			err := c.wait()
+			assert.Equal(t, tt.wantDelay, delay)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.NilError(t, c.wait())
+		})
+	}
+}
+
+func TestAttachmentCopierReuseCopies(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	osMock := mock_opsys.NewMockOS(ctrl)
+	sum := opsys.Checksum{Bytes: 1024, SHA256: "photosum"}
+	osMock.EXPECT().ExistingCopy("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo-1.jpeg", sum, nil)
+	osMock.EXPECT().ExistingCopy("/attachments/new.jpeg", "backup/Novak/attachments").Return("", opsys.Checksum{}, nil)
+	osMock.EXPECT().CopyFileChecksum("/attachments/new.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/new.jpeg", sum, nil)
+	osMock.EXPECT().ExistingCopy("/attachments/broken.jpeg", "backup/Novak/attachments").Return("", opsys.Checksum{}, errors.New("this is a disk error"))
+
+	c := newAttachmentCopier(osMock, 1, 0, 0, false)
+	c.recordChecksums()
+	c.reuseCopies()
+	c.add("/attachments/photo.jpeg", "backup/Novak/attachments", 1024)
+	c.add("/attachments/new.jpeg", "backup/Novak/attachments", 1024)
+	c.add("/attachments/broken.jpeg", "backup/Novak/attachments", 1024)
+	assert.Error(t, c.wait(), `find copy of attachment "/attachments/broken.jpeg" in "backup/Novak/attachments": this is a disk error`)
+	assert.DeepEqual(t, map[string]opsys.Checksum{
+		"backup/Novak/attachments/photo-1.jpeg": sum,
+		"backup/Novak/attachments/new.jpeg":     sum,
+	}, c.checksums)
+}
+
+func TestAttachmentCopierWhenCopied(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	osMock := mock_opsys.NewMockOS(ctrl)
+	osMock.EXPECT().CopyFile("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo.jpeg", nil)
+	osMock.EXPECT().CopyFile("/attachments/broken.jpeg", "backup/Rafa/attachments").Return("", errors.New("this is a disk error"))
+
+	c := newAttachmentCopier(osMock, 2, 0, 0, false)
+	var mu sync.Mutex
+	var copied []string
+	done := func(chat string) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			copied = append(copied, chat)
+			return nil
+		}
+	}
+	c.add("/attachments/photo.jpeg", "backup/Novak/attachments", 1024)
+	c.whenCopied(done("Novak"))
+	c.add("/attachments/broken.jpeg", "backup/Rafa/attachments", 1024)
+	c.whenCopied(done("Rafa"))
+	c.whenCopied(func() error { return errors.New("this is a manifest error") })
+	err := c.wait()
+	assert.Assert(t, err != nil, "errors not returned")
+	assert.DeepEqual(t, []string{"Novak"}, copied)
+}
+
+func TestAttachmentCopierChecksums(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	osMock := mock_opsys.NewMockOS(ctrl)
+	sum := opsys.Checksum{Bytes: 1024, SHA256: "photosum"}
+	osMock.EXPECT().CopyFileChecksum("/attachments/photo.jpeg", "backup/Novak/attachments").Return("backup/Novak/attachments/photo.jpeg", sum, nil)
+	osMock.EXPECT().CopyFileChecksum("/attachments/missing.jpeg", "backup/Novak/attachments").Return("", opsys.Checksum{}, os.ErrNotExist)
+
+	c := newAttachmentCopier(osMock, 2, 0, 2, false)
+	c.recordChecksums()
+	c.add("/attachments/photo.jpeg", "backup/Novak/attachments", 1024)
+	c.add("/attachments/missing.jpeg", "backup/Novak/attachments", 1024)
+	assert.NilError(t, c.wait())
+	assert.DeepEqual(t, map[string]opsys.Checksum{"backup/Novak/attachments/photo.jpeg": sum}, c.checksums)
+}
+
+func TestAttachmentCopierLivePhoto(t *testing.T) {
+	tests := []struct {
+		msg       string
+		setupMock func(*mock_opsys.MockOS)
+		wantErr   string
+	}{
+		{
+			msg: "success",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.HEIC", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234-1.HEIC", nil),
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.MOV", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234-1.MOV", nil),
+				)
+			},
+		},
+		{
+			msg: "different names",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.HEIC", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234.HEIC", nil),
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.MOV", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234-1.MOV", nil),
+				)
+			},
+		},
+		{
+			msg: "missing photo",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.HEIC", "backup/Novak/attachments").Return("", os.ErrNotExist),
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.MOV", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234.MOV", nil),
+				)
+			},
+		},
+		{
+			msg: "photo error",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().CopyFile("/attachments/IMG_1234.HEIC", "backup/Novak/attachments").Return("", errors.New("this is a disk error"))
+			},
+			wantErr: `copy attachment "/attachments/IMG_1234.HEIC" to "backup/Novak/attachments": this is a disk error`,
+		},
+		{
+			msg: "video error",
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				gomock.InOrder(
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.HEIC", "backup/Novak/attachments").Return("backup/Novak/attachments/IMG_1234.HEIC", nil),
+					osMock.EXPECT().CopyFile("/attachments/IMG_1234.MOV", "backup/Novak/attachments").Return("", errors.New("this is a disk error")),
+				)
+			},
+			wantErr: `copy attachment "/attachments/IMG_1234.MOV" to "backup/Novak/attachments": this is a disk error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			tt.setupMock(osMock)
+
+			c := newAttachmentCopier(osMock, 2, 0, 0, false)
+			c.addLivePhoto("/attachments/IMG_1234.HEIC", "/attachments/IMG_1234.MOV", "backup/Novak/attachments", 2048)
+			err := c.wait()
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestAttachmentCopierThrottle(t *testing.T) {
+	start := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+	now := start
+	var waits []time.Duration
+	c := &attachmentCopier{
+		bytesPerSecond: 1000000,
+		now:            func() time.Time { return now },
+		sleep:          func(d time.Duration) { waits = append(waits, d) },
+	}
+
+	c.throttle(500000)
+	c.throttle(1000000)
+	now = start.Add(time.Second)
+	c.throttle(250000)
+	now = start.Add(5 * time.Second)
+	c.throttle(1000000)
+	c.throttle(0)
+	c.throttle(1)
+	assert.DeepEqual(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, time.Second}, waits)
+}
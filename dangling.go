@@ -0,0 +1,77 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+)
+
+// The stubs of messages which are joined to their chats but missing from
+// chat.db, with --dangling-joins=stub, are labeled with these.
+const (
+	_missingSender  = "Unknown"
+	_missingMessage = "[missing message]"
+)
+
+// danglingJoins counts the rows of the join tables of chat.db which point at
+// missing messages, attachments, and handles, for the run summary.
+type danglingJoins struct {
+	Messages    int `json:"messages"`
+	Attachments int `json:"attachments"`
+	Handles     int `json:"handles"`
+}
+
+// checkDanglingJoins looks for rows of the join tables of chat.db which point
+// at missing rows, e.g. in databases restored from backups, warning about them
+// and recording them in the run summary. It returns the missing messages of
+// each chat, for --dangling-joins=stub.
+func checkDanglingJoins(cdb chatdb.ChatDB, opts options, summary *runSummary) map[int][]chatdb.MissingMessage {
+	joins, err := cdb.GetDanglingJoins()
+	if err != nil {
+		logging.Warnf("check chat.db for rows pointing at missing messages, attachments, or participants: %s", err)
+		return nil
+	}
+	if joins.Count() == 0 {
+		return joins.Messages
+	}
+	summary.DanglingJoins = &danglingJoins{Attachments: joins.Attachments, Handles: joins.Handles}
+	for _, missing := range joins.Messages {
+		summary.DanglingJoins.Messages += len(missing)
+	}
+	fix := "restore a complete copy of chat.db"
+	if opts.DanglingJoins != "stub" {
+		fix += fmt.Sprintf(", or mark the missing messages in their chats with %q using --dangling-joins=stub", _missingMessage)
+	}
+	logging.Warnf("chat.db refers to %d messages, %d attachments, and %d participants which are missing from it, e.g. because it was restored from a backup, so they are not exported - FIX: %s", summary.DanglingJoins.Messages, joins.Attachments, joins.Handles, fix)
+	return joins.Messages
+}
+
+// stubMissingMessages merges stubs of the given missing messages of a chat
+// into its messages by date, for --dangling-joins=stub. The stubs of missing
+// messages without dates go at the end of the chat.
+func stubMissingMessages(msgs []chatdb.Message, missing []chatdb.MissingMessage) []chatdb.Message {
+	if len(missing) == 0 {
+		return msgs
+	}
+	stubbed := make([]chatdb.Message, 0, len(msgs)+len(missing))
+	var undated []chatdb.Message
+	i := 0
+	for _, m := range missing {
+		stub := chatdb.Message{ID: m.ID, Date: m.Date, Handle: _missingSender, Text: _missingMessage}
+		if m.Date.IsZero() {
+			stub.DateSource = chatdb.DateUnknown
+			undated = append(undated, stub)
+			continue
+		}
+		for ; i < len(msgs) && !msgs[i].Date.After(m.Date); i++ {
+			stubbed = append(stubbed, msgs[i])
+		}
+		stubbed = append(stubbed, stub)
+	}
+	stubbed = append(stubbed, msgs[i:]...)
+	return append(stubbed, undated...)
+}
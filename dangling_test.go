@@ -0,0 +1,113 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckDanglingJoins(t *testing.T) {
+	missing := map[int][]chatdb.MissingMessage{
+		1: {{ID: 100}, {ID: 101}},
+		2: {{ID: 200}},
+	}
+
+	tests := []struct {
+		msg         string
+		setupMock   func(*mock_chatdb.MockChatDB)
+		wantMissing map[int][]chatdb.MissingMessage
+		wantSummary *danglingJoins
+	}{
+		{
+			msg: "none",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetDanglingJoins().Return(chatdb.DanglingJoins{Messages: map[int][]chatdb.MissingMessage{}}, nil)
+			},
+			wantMissing: map[int][]chatdb.MissingMessage{},
+		},
+		{
+			msg: "dangling",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetDanglingJoins().Return(chatdb.DanglingJoins{Messages: missing, Attachments: 4, Handles: 5}, nil)
+			},
+			wantMissing: missing,
+			wantSummary: &danglingJoins{Messages: 3, Attachments: 4, Handles: 5},
+		},
+		{
+			msg: "DB error",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetDanglingJoins().Return(chatdb.DanglingJoins{}, errors.New("this is a DB error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			tt.setupMock(dbMock)
+
+			var summary runSummary
+			assert.DeepEqual(t, tt.wantMissing, checkDanglingJoins(dbMock, options{}, &summary))
+			assert.DeepEqual(t, tt.wantSummary, summary.DanglingJoins)
+		})
+	}
+}
+
+func TestStubMissingMessages(t *testing.T) {
+	msgs := []chatdb.Message{
+		testMessage(100, "message%d"),
+		{ID: 102, Date: _testDate.Add(time.Hour), Text: "message102"},
+	}
+	stub := func(id int, date time.Time) chatdb.Message {
+		return chatdb.Message{ID: id, Date: date, Handle: _missingSender, Text: _missingMessage}
+	}
+	undated := stub(103, time.Time{})
+	undated.DateSource = chatdb.DateUnknown
+
+	tests := []struct {
+		msg     string
+		missing []chatdb.MissingMessage
+		want    []chatdb.Message
+	}{
+		{
+			msg:  "none missing",
+			want: msgs,
+		},
+		{
+			msg: "missing messages",
+			missing: []chatdb.MissingMessage{
+				{ID: 103},
+				{ID: 99, Date: _testDate.Add(-time.Minute)},
+				{ID: 101, Date: _testDate},
+			},
+			want: []chatdb.Message{
+				stub(99, _testDate.Add(-time.Minute)),
+				msgs[0],
+				stub(101, _testDate),
+				msgs[1],
+				undated,
+			},
+		},
+		{
+			msg:     "after the last message",
+			missing: []chatdb.MissingMessage{{ID: 104, Date: _testDate.Add(2 * time.Hour)}},
+			want:    []chatdb.Message{msgs[0], msgs[1], stub(104, _testDate.Add(2*time.Hour))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, stubMissingMessages(msgs, tt.missing))
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// dedupServiceFallbacks removes the copies of messages which were resent over
+// another service, e.g. an iMessage which failed and fell back to SMS. A
+// message is considered a copy if an earlier message from the same sender has
+// the same text, a different service, and a date at most window earlier.
+// Senders are compared by their canonical handles, since a sender has a
+// separate handle for each service. Messages are expected to be ordered by
+// date.
+func dedupServiceFallbacks(msgs []chatdb.Message, window time.Duration, canonicalHandle func(int) int) []chatdb.Message {
+	deduped := make([]chatdb.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if !isFallbackCopy(msg, deduped, window, canonicalHandle) {
+			deduped = append(deduped, msg)
+		}
+	}
+	return deduped
+}
+
+func isFallbackCopy(msg chatdb.Message, earlier []chatdb.Message, window time.Duration, canonicalHandle func(int) int) bool {
+	text := normalizeText(msg.Text)
+	if msg.GroupAction != chatdb.NoGroupAction || text == "" {
+		return false
+	}
+	for i := len(earlier) - 1; i >= 0; i-- {
+		prev := earlier[i]
+		if msg.Date.Sub(prev.Date) > window {
+			return false
+		}
+		if prev.FromMe == msg.FromMe && canonicalHandle(prev.HandleID) == canonicalHandle(msg.HandleID) &&
+			prev.Service != msg.Service && normalizeText(prev.Text) == text {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
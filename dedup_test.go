@@ -0,0 +1,68 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestDedupServiceFallbacks(t *testing.T) {
+	at := func(seconds int) time.Time {
+		return _testDate.Add(time.Duration(seconds) * time.Second)
+	}
+	// Handle 12 is the SMS handle of the sender with iMessage handle 10.
+	canonicalHandle := func(handleID int) int {
+		if handleID == 12 {
+			return 10
+		}
+		return handleID
+	}
+	msgs := []chatdb.Message{
+		{ID: 1, Date: at(0), HandleID: 10, Service: "iMessage", Text: "Want to play tennis?"},
+		{ID: 2, Date: at(20), HandleID: 12, Service: "SMS", Text: "Want to play  tennis? "},
+		{ID: 3, Date: at(30), FromMe: true, Service: "iMessage", Text: "Sure"},
+		{ID: 4, Date: at(40), FromMe: true, Service: "iMessage", Text: "Sure"},
+		{ID: 5, Date: at(50), HandleID: 11, Service: "SMS", Text: "Sure"},
+		{ID: 6, Date: at(105), FromMe: true, Service: "SMS", Text: "Sure"},
+		{ID: 7, Date: at(110), HandleID: 10, Service: "iMessage", Text: "\ufffc"},
+		{ID: 8, Date: at(111), HandleID: 10, Service: "SMS", Text: ""},
+		{ID: 9, Date: at(112), HandleID: 10, Service: "SMS", Text: ""},
+	}
+
+	tests := []struct {
+		msg     string
+		window  time.Duration
+		wantIDs []int
+	}{
+		{
+			msg:     "one minute",
+			window:  time.Minute,
+			wantIDs: []int{1, 3, 4, 5, 6, 7, 8, 9},
+		},
+		{
+			msg:     "two minutes",
+			window:  2 * time.Minute,
+			wantIDs: []int{1, 3, 4, 5, 7, 8, 9},
+		},
+		{
+			msg:     "ten seconds",
+			window:  10 * time.Second,
+			wantIDs: []int{1, 2, 3, 4, 5, 6, 7, 8, 9},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var ids []int
+			for _, msg := range dedupServiceFallbacks(msgs, tt.window, canonicalHandle) {
+				ids = append(ids, msg.ID)
+			}
+			assert.DeepEqual(t, tt.wantIDs, ids)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// chatModTimes are the dates of the last exported messages in the folders of
+// the chats, to which --deterministic sets the modification times of the files
+// in the folders, so that files which did not change since the last run look
+// unchanged to backup tools, e.g. restic.
+type chatModTimes map[string]time.Time
+
+// add records the date of the last of the given messages, with a valid date,
+// for the given chat folder. Chats sharing a folder leave it with the latest
+// date.
+func (t chatModTimes) add(dir string, msgs []chatdb.Message) {
+	if dir == "" {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.DateSource != chatdb.DateUnknown && msg.Date.After(t[dir]) {
+			t[dir] = msg.Date
+		}
+	}
+}
+
+// apply sets the modification times of the files in the chat folders, once
+// they are completely written.
+func (t chatModTimes) apply(s opsys.OS) error {
+	for dir, date := range t {
+		err := afero.Walk(s, dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			return errors.Wrapf(s.Chtimes(p, date, date), "set modification time of file %q", p)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "normalize modification times in folder %q", dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestChatModTimes(t *testing.T) {
+	march1 := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+	march2 := march1.Add(24 * time.Hour)
+	fs := afero.NewMemMapFs()
+	for _, p := range []string{"backup/Novak/testguid.txt", "backup/Novak/attachments/photo.jpeg", "backup/Novak/testguid2.txt", "backup/Rafa/testguid3.txt", "backup/run-summary.json"} {
+		assert.NilError(t, afero.WriteFile(fs, p, nil, 0644))
+	}
+	s := opsys.NewOS(fs, nil, nil)
+
+	modTimes := chatModTimes{}
+	modTimes.add("backup/Novak", []chatdb.Message{{Date: march1}, {Date: march2, DateSource: chatdb.DateUnknown}})
+	modTimes.add("backup/Novak", []chatdb.Message{{Date: march2, DateSource: chatdb.DateRead}})
+	modTimes.add("backup/Rafa", []chatdb.Message{{Date: march1}})
+	modTimes.add("", []chatdb.Message{{Date: march1}})
+	modTimes.add("backup/Nole", nil)
+	assert.DeepEqual(t, chatModTimes{"backup/Novak": march2, "backup/Rafa": march1}, modTimes)
+
+	assert.NilError(t, modTimes.apply(s))
+	for p, want := range map[string]time.Time{
+		"backup/Novak/testguid.txt":           march2,
+		"backup/Novak/attachments/photo.jpeg": march2,
+		"backup/Novak/testguid2.txt":          march2,
+		"backup/Rafa/testguid3.txt":           march1,
+	} {
+		info, err := fs.Stat(p)
+		assert.NilError(t, err)
+		assert.Assert(t, info.ModTime().Equal(want), "%s modified at %s, want %s", p, info.ModTime(), want)
+	}
+	info, err := fs.Stat("backup/run-summary.json")
+	assert.NilError(t, err)
+	assert.Assert(t, info.ModTime().After(march2), "run summary modification time changed")
+
+	err = chatModTimes{"backup/Nole": march1}.apply(s)
+	assert.ErrorContains(t, err, `normalize modification times in folder "backup/Nole"`)
+	err = chatModTimes{"backup/Rafa": march1}.apply(chtimesErrOS{OS: s})
+	assert.ErrorContains(t, err, `set modification time of file "backup/Rafa/testguid3.txt": this is a file system error`)
+}
+
+// chtimesErrOS is an OS which fails to set modification times.
+type chtimesErrOS struct {
+	opsys.OS
+}
+
+func (chtimesErrOS) Chtimes(string, time.Time, time.Time) error {
+	return errors.New("this is a file system error")
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// dirTemplate lays out the chat folders within the export folder with the
+// --dir-template template, e.g. "{{.ContactName}}/{{.Service}}/{{.Year}}" or
+// "{{.Group}}/{{.ContactName}}", for archival systems which expect a
+// particular hierarchy.
+type dirTemplate struct {
+	tmpl *template.Template
+}
+
+// dirTemplateData are the fields of a chat available to the --dir-template
+// template. Slashes in them are replaced, so that each field names a single
+// folder.
+type dirTemplateData struct {
+	// ContactName is the display name of the chat, e.g. the name of the
+	// contact of a one-to-one chat.
+	ContactName string
+	// Handle is the phone number or email address of the other participant
+	// of a one-to-one chat, or empty for group chats.
+	Handle string
+	// Service is the service of the chat, e.g. "iMessage" or "SMS", and
+	// Identifier identifies the chat within it, e.g. the handle of a
+	// one-to-one chat or "chat123456" for a group chat, so that folders can
+	// be named after the GUID without the service, e.g. for chats which
+	// moved from SMS to iMessage.
+	Service    string
+	Identifier string
+	// Year is the year of the last message of the chat, or empty if it has
+	// no messages.
+	Year string
+	// GUID is the GUID of the chat.
+	GUID string
+	// Group is the group of the contact of a one-to-one chat, i.e. a
+	// CATEGORIES value of its vCard, e.g. "Family", or empty.
+	Group string
+}
+
+// newDirTemplate parses the given --dir-template template, or returns nil if
+// it is empty.
+func newDirTemplate(text string) (*dirTemplate, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("dir").Parse(text)
+	if err == nil {
+		// Unknown fields are only found when the template is executed, so
+		// it is tried before any chat is exported.
+		err = tmpl.Execute(ioutil.Discard, dirTemplateData{})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse --dir-template template %q - FIX: use the fields ContactName, Handle, Service, Identifier, Year, GUID, and Group, e.g. '{{.ContactName}}/{{.Year}}'", text)
+	}
+	return &dirTemplate{tmpl: tmpl}, nil
+}
+
+// folders returns the names of the nested folders, within the export folder,
+// in which to export the given chat, in the given contact group, from the
+// outermost to the chat's own.
+func (d *dirTemplate) folders(chat chatdb.Chat, group string) ([]string, error) {
+	service, identifier, _ := chatdb.ParseGUID(chat.GUID)
+	data := dirTemplateData{
+		ContactName: chat.DisplayName,
+		Handle:      directHandle(chat),
+		Service:     service,
+		Identifier:  identifier,
+		GUID:        chat.GUID,
+		Group:       group,
+	}
+	if !chat.LastMessageDate.IsZero() {
+		data.Year = strconv.Itoa(chat.LastMessageDate.Year())
+	}
+	for _, field := range []*string{&data.ContactName, &data.Handle, &data.Service, &data.Identifier, &data.GUID, &data.Group} {
+		*field = strings.ReplaceAll(*field, "/", "-")
+	}
+	var b bytes.Buffer
+	if err := d.tmpl.Execute(&b, data); err != nil {
+		return nil, errors.Wrapf(err, "execute --dir-template template for chat %q", chat.GUID)
+	}
+	var folders []string
+	for _, folder := range strings.Split(b.String(), "/") {
+		folder = strings.TrimSpace(folder)
+		switch folder {
+		case "", ".":
+			continue
+		case "..":
+			return nil, errors.Errorf("--dir-template template gives folder %q for chat %q, outside the export folder - FIX: remove '..' from the template", b.String(), chat.GUID)
+		}
+		folders = append(folders, folder)
+	}
+	if len(folders) == 0 {
+		return nil, errors.Errorf("--dir-template template gives no folder for chat %q - FIX: include a field which is never empty, e.g. '{{.ContactName}}'", chat.GUID)
+	}
+	return folders, nil
+}
+
+// chatFolder returns the folder within the export folder in which to export
+// the given chat, in the given contact group, within the given folder, e.g.
+// for chats from unknown senders, and the name of the chat's own folder,
+// adapted to the volume of the export folder. Without a template, the folder
+// is named after the chat.
+func chatFolder(d *dirTemplate, f *chatFolders, dir string, chat chatdb.Chat, group string) (string, string, error) {
+	if d == nil {
+		return dir, f.name(dir, chat.DisplayName), nil
+	}
+	folders, err := d.folders(chat, group)
+	if err != nil {
+		return "", "", err
+	}
+	for _, folder := range folders[:len(folders)-1] {
+		dir = path.Join(dir, f.name(dir, folder))
+	}
+	return dir, f.name(dir, folders[len(folders)-1]), nil
+}
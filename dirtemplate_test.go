@@ -0,0 +1,157 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewDirTemplate(t *testing.T) {
+	tests := []struct {
+		msg     string
+		text    string
+		wantNil bool
+		wantErr string
+	}{
+		{
+			msg:     "no template",
+			wantNil: true,
+		},
+		{
+			msg:  "template",
+			text: "{{.ContactName}}/{{.Service}}/{{.Year}}",
+		},
+		{
+			msg:     "bad syntax",
+			text:    "{{.ContactName",
+			wantErr: `parse --dir-template template "{{.ContactName" - FIX: use the fields ContactName, Handle, Service, Identifier, Year, GUID, and Group`,
+		},
+		{
+			msg:     "unknown field",
+			text:    "{{.Contact}}",
+			wantErr: `parse --dir-template template "{{.Contact}}"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d, err := newDirTemplate(tt.text)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantNil, d == nil)
+		})
+	}
+}
+
+func TestChatFolder(t *testing.T) {
+	direct := chatdb.Chat{
+		GUID:            "iMessage;-;+14155555555",
+		DisplayName:     "Novak Djokovic",
+		LastMessageDate: time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local),
+	}
+	group := chatdb.Chat{GUID: "SMS;+;chat123456", DisplayName: "AC/DC fans"}
+
+	tests := []struct {
+		msg        string
+		template   string
+		dir        string
+		group      string
+		chats      []chatdb.Chat
+		wantDirs   []string
+		wantFolder []string
+		wantErr    string
+	}{
+		{
+			msg:        "no template",
+			dir:        "unknown-senders",
+			chats:      []chatdb.Chat{direct},
+			wantDirs:   []string{"unknown-senders"},
+			wantFolder: []string{"Novak Djokovic"},
+		},
+		{
+			msg:        "nested folders",
+			template:   "{{.ContactName}}/{{.Service}}/{{.Year}}",
+			chats:      []chatdb.Chat{direct},
+			wantDirs:   []string{"Novak Djokovic/iMessage"},
+			wantFolder: []string{"2020"},
+		},
+		{
+			msg:        "within unknown senders",
+			template:   "{{.Handle}}",
+			dir:        "unknown-senders",
+			chats:      []chatdb.Chat{direct},
+			wantDirs:   []string{"unknown-senders"},
+			wantFolder: []string{"+14155555555"},
+		},
+		{
+			msg:        "contact group",
+			template:   "{{.Group}}/{{.ContactName}}",
+			group:      "Friends/Tennis",
+			chats:      []chatdb.Chat{direct},
+			wantDirs:   []string{"Friends-Tennis"},
+			wantFolder: []string{"Novak Djokovic"},
+		},
+		{
+			msg:        "identifier without service",
+			template:   "{{.Identifier}}",
+			chats:      []chatdb.Chat{direct, {GUID: "sms;-;+14155555555", DisplayName: "Novak Djokovic"}, group},
+			wantDirs:   []string{"", "", ""},
+			wantFolder: []string{"+14155555555", "+14155555555", "chat123456"},
+		},
+		{
+			msg:        "empty and slashed fields",
+			template:   "{{.Service}}/{{.Handle}}/{{.Year}}/{{.ContactName}}",
+			chats:      []chatdb.Chat{group},
+			wantDirs:   []string{"SMS"},
+			wantFolder: []string{"AC-DC fans"},
+		},
+		{
+			msg:        "folders adapted to the volume",
+			template:   "{{.ContactName}}/{{.Year}}",
+			chats:      []chatdb.Chat{direct, {GUID: "SMS;-;+14155555555", DisplayName: "novak djokovic"}},
+			wantDirs:   []string{"Novak Djokovic", ""},
+			wantFolder: []string{"2020", "novak djokovic (2)"},
+		},
+		{
+			msg:      "no folder",
+			template: "{{.Year}}",
+			chats:    []chatdb.Chat{group},
+			wantErr:  `--dir-template template gives no folder for chat "SMS;+;chat123456" - FIX: include a field which is never empty`,
+		},
+		{
+			msg:      "outside the export folder",
+			template: "../{{.ContactName}}",
+			chats:    []chatdb.Chat{direct},
+			wantErr:  `--dir-template template gives folder "../Novak Djokovic" for chat "iMessage;-;+14155555555", outside the export folder - FIX: remove '..' from the template`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			d, err := newDirTemplate(tt.template)
+			assert.NilError(t, err)
+			f := &chatFolders{rules: opsys.FilenameRules{CaseInsensitive: true, MaxNameBytes: 255}, owners: map[string]string{}}
+			var dirs, folders []string
+			for _, chat := range tt.chats {
+				dir, folder, err := chatFolder(d, f, tt.dir, chat, tt.group)
+				if tt.wantErr != "" {
+					assert.ErrorContains(t, err, tt.wantErr)
+					return
+				}
+				assert.NilError(t, err)
+				dirs, folders = append(dirs, dir), append(folders, folder)
+			}
+			assert.DeepEqual(t, tt.wantDirs, dirs)
+			assert.DeepEqual(t, tt.wantFolder, folders)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// The credentials for the --smtp-server are read from the environment, so that
+// they are kept out of the options recorded in run summaries and out of the
+// process list.
+const (
+	_smtpUsernameEnv = "BAGOUP_SMTP_USERNAME"
+	_smtpPasswordEnv = "BAGOUP_SMTP_PASSWORD"
+)
+
+// sendMailFunc sends an email through an SMTP server, like smtp.SendMail.
+type sendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// checkEmailOptions checks that the options give everything needed to send the
+// --email-summary, before the export runs.
+func checkEmailOptions(opts options) error {
+	if opts.EmailSummary == "" {
+		return nil
+	}
+	if opts.SMTPServer == "" {
+		return errors.New("--email-summary needs an SMTP server to send the summary through - FIX: pass --smtp-server, e.g. --smtp-server smtp.example.com:587")
+	}
+	if _, _, err := net.SplitHostPort(opts.SMTPServer); err != nil {
+		return errors.Wrapf(err, "parse --smtp-server %q - FIX: give the host and port of the SMTP server, e.g. smtp.example.com:587", opts.SMTPServer)
+	}
+	return nil
+}
+
+// emailSummary emails the outcome of an export run to the --email-summary
+// address, with the run summary attached, and the attachment report if any
+// attachments are missing or corrupt.
+func emailSummary(s opsys.OS, send sendMailFunc, opts options, n runNotification, now time.Time) error {
+	username, password := os.Getenv(_smtpUsernameEnv), os.Getenv(_smtpPasswordEnv)
+	from := opts.EmailSummary
+	if strings.Contains(username, "@") {
+		// Most SMTP servers only send emails from the authenticated user.
+		from = username
+	}
+	msg, err := summaryEmail(s, opts, n, from, now)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(opts.SMTPServer)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return errors.Wrapf(send(opts.SMTPServer, auth, from, []string{opts.EmailSummary}, msg), "send email through SMTP server %q", opts.SMTPServer)
+}
+
+// summaryEmail formats the email of the outcome of an export run as a MIME
+// message, with the summary line of the run as its text.
+func summaryEmail(s opsys.OS, opts options, n runNotification, from string, now time.Time) ([]byte, error) {
+	subject := fmt.Sprintf("bagoup export %s: %d messages from %d chats", n.Status, n.Summary.Messages, n.Summary.Chats)
+	text := n.Summary.line() + "\n"
+	if n.Error != "" {
+		text = fmt.Sprintf("Export failed: %s\n\n%s", n.Error, text)
+	}
+	summaryJSON := &bytes.Buffer{}
+	if err := writeJSON(n.Summary)(summaryJSON); err != nil {
+		return nil, errors.Wrap(err, "encode run summary")
+	}
+	attachments := map[string][]byte{_runSummaryFilename: summaryJSON.Bytes()}
+	if n.Summary.AttachmentProblems > 0 {
+		reportPath := path.Join(opts.ExportPath, _attachmentReportFilename)
+		report, err := afero.ReadFile(s, reportPath)
+		switch {
+		case os.IsNotExist(err):
+			// The email is still worth sending, e.g. if the export failed
+			// before writing the report.
+			logging.Warnf("attachment report %q is missing, so it is not attached to the summary email", reportPath)
+		case err != nil:
+			return nil, errors.Wrapf(err, "read file %q", reportPath)
+		default:
+			attachments[_attachmentReportFilename] = report
+		}
+	}
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", opts.EmailSummary)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, errors.Wrap(err, "write email text")
+	}
+	part.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+	for _, filename := range []string{_runSummaryFilename, _attachmentReportFilename} {
+		content, ok := attachments[filename]
+		if !ok {
+			continue
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.TypeByExtension(path.Ext(filename))},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "attach file %q", filename)
+		}
+		enc := base64.StdEncoding.EncodeToString(content)
+		for len(enc) > 76 {
+			fmt.Fprintf(part, "%s\r\n", enc[:76])
+			enc = enc[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", enc)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "finish email")
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckEmailOptions(t *testing.T) {
+	tests := []struct {
+		msg     string
+		opts    options
+		wantErr string
+	}{
+		{
+			msg:  "no email",
+			opts: options{},
+		},
+		{
+			msg:  "email",
+			opts: options{EmailSummary: "david@example.com", SMTPServer: "smtp.example.com:587"},
+		},
+		{
+			msg:     "no SMTP server",
+			opts:    options{EmailSummary: "david@example.com"},
+			wantErr: "--email-summary needs an SMTP server to send the summary through - FIX: pass --smtp-server, e.g. --smtp-server smtp.example.com:587",
+		},
+		{
+			msg:     "no port",
+			opts:    options{EmailSummary: "david@example.com", SMTPServer: "smtp.example.com"},
+			wantErr: `parse --smtp-server "smtp.example.com" - FIX: give the host and port of the SMTP server, e.g. smtp.example.com:587`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			err := checkEmailOptions(tt.opts)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestEmailSummary(t *testing.T) {
+	start := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+	summary := runSummary{Start: start, End: start.Add(time.Minute), Chats: 2, Messages: 5, Errors: []string{}}
+	opts := options{ExportPath: "backup", EmailSummary: "david@example.com", SMTPServer: "smtp.example.com:587"}
+
+	tests := []struct {
+		msg             string
+		n               runNotification
+		username        string
+		sendErr         error
+		wantFrom        string
+		wantAuth        bool
+		wantSubject     string
+		wantText        string
+		wantAttachments []string
+		wantErr         string
+	}{
+		{
+			msg:             "succeeded",
+			n:               runNotification{Status: "succeeded", Summary: summary},
+			wantFrom:        "david@example.com",
+			wantSubject:     "bagoup export succeeded: 5 messages from 2 chats",
+			wantText:        "5 messages in 2 chats exported",
+			wantAttachments: []string{"run-summary.json"},
+		},
+		{
+			msg:             "authenticated",
+			n:               runNotification{Status: "succeeded", Summary: summary},
+			username:        "bagoup@example.com",
+			wantFrom:        "bagoup@example.com",
+			wantAuth:        true,
+			wantSubject:     "bagoup export succeeded: 5 messages from 2 chats",
+			wantText:        "5 messages in 2 chats exported",
+			wantAttachments: []string{"run-summary.json"},
+		},
+		{
+			msg: "failed with missing attachments",
+			n: runNotification{
+				Status:  "failed",
+				Error:   "export chats: this is a DB error",
+				Summary: runSummary{Start: start, End: start.Add(time.Minute), AttachmentProblems: 1, Errors: []string{"export chats: this is a DB error"}},
+			},
+			username:        "bagoup",
+			wantFrom:        "david@example.com",
+			wantAuth:        true,
+			wantSubject:     "bagoup export failed: 0 messages from 0 chats",
+			wantText:        "Export failed: export chats: this is a DB error",
+			wantAttachments: []string{"run-summary.json", "attachment-report.csv"},
+		},
+		{
+			msg:     "send error",
+			n:       runNotification{Status: "succeeded", Summary: summary},
+			sendErr: errors.New("this is an SMTP error"),
+			wantErr: `send email through SMTP server "smtp.example.com:587": this is an SMTP error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			os.Setenv(_smtpUsernameEnv, tt.username)
+			os.Setenv(_smtpPasswordEnv, "hunter2")
+			defer os.Unsetenv(_smtpUsernameEnv)
+			defer os.Unsetenv(_smtpPasswordEnv)
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, "backup/attachment-report.csv", []byte("chat,date,path,problem\n"), 0644))
+			s := opsys.NewOS(fs, nil, nil)
+			var gotFrom string
+			var gotAuth smtp.Auth
+			var gotMsg []byte
+			send := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+				assert.Equal(t, "smtp.example.com:587", addr)
+				assert.DeepEqual(t, []string{"david@example.com"}, to)
+				gotFrom, gotAuth, gotMsg = from, a, msg
+				return tt.sendErr
+			}
+
+			err := emailSummary(s, send, opts, tt.n, start.Add(time.Minute))
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantFrom, gotFrom)
+			assert.Equal(t, tt.wantAuth, gotAuth != nil)
+			email, err := mail.ReadMessage(strings.NewReader(string(gotMsg)))
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantFrom, email.Header.Get("From"))
+			assert.Equal(t, "david@example.com", email.Header.Get("To"))
+			subject, err := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject"))
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantSubject, subject)
+			mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+			assert.NilError(t, err)
+			assert.Equal(t, "multipart/mixed", mediaType)
+			mr := multipart.NewReader(email.Body, params["boundary"])
+			text, err := mr.NextPart()
+			assert.NilError(t, err)
+			body, err := ioutil.ReadAll(text)
+			assert.NilError(t, err)
+			assert.Assert(t, strings.Contains(string(body), tt.wantText), string(body))
+			var gotAttachments []string
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				assert.NilError(t, err)
+				assert.Equal(t, "base64", part.Header.Get("Content-Transfer-Encoding"))
+				gotAttachments = append(gotAttachments, part.FileName())
+			}
+			assert.DeepEqual(t, tt.wantAttachments, gotAttachments)
+		})
+	}
+}
+
+func TestEmailSummaryMissingReport(t *testing.T) {
+	s := opsys.NewOS(afero.NewMemMapFs(), nil, nil)
+	n := runNotification{Status: "failed", Error: "export chats: this is a DB error", Summary: runSummary{AttachmentProblems: 1}}
+	var sent bool
+	send := func(string, smtp.Auth, string, []string, []byte) error {
+		sent = true
+		return nil
+	}
+	err := emailSummary(s, send, options{ExportPath: "backup", EmailSummary: "david@example.com", SMTPServer: "smtp.example.com:587"}, n, time.Now())
+	assert.NilError(t, err)
+	assert.Assert(t, sent, "email not sent")
+
+	msg, err := summaryEmail(s, options{ExportPath: "backup", EmailSummary: "david@example.com"}, n, "bagoup@example.com", time.Now())
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(msg), "attachment-report.csv"), "missing attachment report attached")
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package exporter provides an interface Exporter for writing chats in an
+// export format, and a registry of the available formats. The text format is
+// the reference implementation.
+//
+// To add a format, implement Exporter and register a Factory for it with
+// Register from an init function, e.g. in a file added to this package or in
+// a package imported by bagoup.
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+type (
+	// Exporter writes chats in an export format. Chats are exported one at a
+	// time: for each chat, Begin is called once, then WriteMessage is called
+	// for each of its messages in order, and then Finish is called. Messages
+	// are passed with their attachments already exported, and exporters must
+	// not modify them.
+	Exporter interface {
+		// Begin starts exporting the given chat, creating the folder for the
+		// chat if needed.
+		Begin(chat Chat) (Output, error)
+		// WriteMessage exports a message of the current chat.
+		WriteMessage(msg chatdb.Message) error
+		// Finish finishes exporting the current chat, e.g. by closing its file.
+		Finish() error
+	}
+
+	// Finalizer is implemented by exporters which write files for the whole
+	// export, e.g. an index of the chats, after all chats have been exported.
+	Finalizer interface {
+		FinishExport() error
+	}
+
+	// Factory returns an Exporter which exports into the given export folder.
+	Factory func(s opsys.OS, exportPath string) Exporter
+
+	// Chat is a chat to be exported.
+	Chat struct {
+		chatdb.Chat
+		// Members are the names of the participants of the chat, including the
+		// owner of the database.
+		Members []string
+		// Participants are the participants of a group chat before and after
+		// each of its messages, keyed by message ID. It is nil for chats which
+		// are not group chats.
+		Participants map[int]Participants
+		// Dir is the folder within the export folder in which to export the
+		// chat, e.g. for chats from unknown senders, or empty to export it
+		// directly in the export folder. Formats which lay out the export
+		// folder as another application expects, e.g. slack, ignore it.
+		Dir string
+		// Folder is the name of the folder of the chat within Dir, adapted to
+		// the volume of the export folder, or empty to name it after the
+		// display name of the chat.
+		Folder string
+	}
+
+	// Participants lists the participants of a group chat just before and
+	// just after a message.
+	Participants struct {
+		Before string
+		After  string
+	}
+
+	// Output is where a chat is exported.
+	Output struct {
+		// Dir is the folder of the chat, into which its attachments and
+		// statistics are also written.
+		Dir string
+		// Path is the file or folder containing the exported chat.
+		Path string
+	}
+)
+
+// _partialSuffix is added to the names of files until they are completely
+// written.
+const _partialSuffix = ".partial"
+
+var (
+	_registryMu sync.RWMutex
+	_registry   = map[string]Factory{}
+)
+
+// Register makes an export format available under the given name. It panics
+// if the name is already registered.
+func Register(format string, factory Factory) {
+	_registryMu.Lock()
+	defer _registryMu.Unlock()
+	if _, ok := _registry[format]; ok {
+		panic(fmt.Sprintf("exporter: format %q registered twice", format))
+	}
+	_registry[format] = factory
+}
+
+// New returns an Exporter for the given registered format.
+func New(format string, s opsys.OS, exportPath string) (Exporter, error) {
+	_registryMu.RLock()
+	factory, ok := _registry[format]
+	_registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %q - FIX: use one of %v", format, Formats())
+	}
+	return factory(s, exportPath), nil
+}
+
+// Formats returns the names of the registered export formats, sorted.
+func Formats() []string {
+	_registryMu.RLock()
+	defer _registryMu.RUnlock()
+	formats := make([]string, 0, len(_registry))
+	for format := range _registry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// chatDir returns the path of the folder of the given chat in the given export
+// folder.
+func chatDir(exportPath string, chat Chat) string {
+	folder := chat.Folder
+	if folder == "" {
+		folder = chat.DisplayName
+	}
+	return path.Join(exportPath, chat.Dir, folder)
+}
+
+// WriteFile writes the file at the given path with the given write function.
+// The file is written under a partial name and renamed into place once it is
+// complete, so that an interrupted export does not leave truncated files.
+func WriteFile(s opsys.OS, filePath string, write func(io.Writer) error) error {
+	partialPath := filePath + _partialSuffix
+	f, err := s.Create(partialPath)
+	if err != nil {
+		return errors.Wrapf(err, "create file %q", partialPath)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close file %q", partialPath)
+	}
+	return errors.Wrapf(s.Rename(partialPath, filePath), "rename file %q", partialPath)
+}
+
+// finishFile closes the given partial file and renames it to the given path.
+func finishFile(s opsys.OS, f afero.File, filePath string) error {
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close file %q", f.Name())
+	}
+	return errors.Wrapf(s.Rename(f.Name(), filePath), "rename file %q", f.Name())
+}
+
+func writeJSON(v interface{}) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
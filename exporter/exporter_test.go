@@ -0,0 +1,93 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+type nopExporter struct{}
+
+func (nopExporter) Begin(Chat) (Output, error)        { return Output{}, nil }
+func (nopExporter) WriteMessage(chatdb.Message) error { return nil }
+func (nopExporter) Finish() error                     { return nil }
+
+func TestRegistry(t *testing.T) {
+	assert.DeepEqual(t, []string{"matrix", "mbox", "slack", "txt"}, Formats())
+
+	Register("nop", func(opsys.OS, string) Exporter { return nopExporter{} })
+	defer func() {
+		_registryMu.Lock()
+		delete(_registry, "nop")
+		_registryMu.Unlock()
+	}()
+	assert.DeepEqual(t, []string{"matrix", "mbox", "nop", "slack", "txt"}, Formats())
+	exp, err := New("nop", opsys.NewOS(afero.NewMemMapFs(), nil, nil), "backup")
+	assert.NilError(t, err)
+	assert.Equal(t, nopExporter{}, exp)
+
+	assert.Assert(t, func() (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		Register("nop", func(opsys.OS, string) Exporter { return nopExporter{} })
+		return false
+	}(), "registering a format twice did not panic")
+
+	_, err = New("pdf", nil, "backup")
+	assert.Error(t, err, `unknown export format "pdf" - FIX: use one of [matrix mbox nop slack txt]`)
+}
+
+func TestWriteFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	assert.NilError(t, WriteFile(s, "backup/report.txt", func(w io.Writer) error {
+		_, err := io.WriteString(w, "report\n")
+		return err
+	}))
+	b, err := afero.ReadFile(fs, "backup/report.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, "report\n", string(b))
+
+	err = WriteFile(s, "backup/failed.txt", func(io.Writer) error { return errors.New("this is a write error") })
+	assert.Error(t, err, "this is a write error")
+	exist, err := afero.Exists(fs, "backup/failed.txt")
+	assert.NilError(t, err)
+	assert.Assert(t, !exist, "failed file renamed into place")
+}
+
+func TestChatDir(t *testing.T) {
+	tests := []struct {
+		msg  string
+		chat Chat
+		want string
+	}{
+		{
+			msg:  "display name",
+			chat: Chat{Chat: chatdb.Chat{DisplayName: "Novak"}},
+			want: "backup/Novak",
+		},
+		{
+			msg:  "folder",
+			chat: Chat{Chat: chatdb.Chat{DisplayName: "novak"}, Folder: "novak (2)"},
+			want: "backup/novak (2)",
+		},
+		{
+			msg:  "sender folder",
+			chat: Chat{Chat: chatdb.Chat{DisplayName: "72975"}, Dir: "unknown-senders"},
+			want: "backup/unknown-senders/72975",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, chatDir("backup", tt.chat))
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+func init() {
+	Register("matrix", newMatrixExporter)
+}
+
+const _matrixServerName = "bagoup.invalid"
+
+var _matrixLocalpartInvalid = regexp.MustCompile(`[^a-z0-9._=/-]+`)
+
+type (
+	// matrixExporter writes each chat to a JSON file of Matrix events, named
+	// after the GUID of the chat, in a folder named after its display name.
+	matrixExporter struct {
+		s          opsys.OS
+		exportPath string
+		room       *matrixRoom
+		roomPath   string
+	}
+
+	// matrixRoom is a chat as a Matrix room with its timeline of message
+	// events, as accepted by Matrix import tools.
+	matrixRoom struct {
+		RoomID string        `json:"room_id"`
+		Name   string        `json:"name"`
+		Events []matrixEvent `json:"events"`
+	}
+
+	matrixEvent struct {
+		Type           string        `json:"type"`
+		EventID        string        `json:"event_id"`
+		Sender         string        `json:"sender"`
+		OriginServerTS int64         `json:"origin_server_ts"`
+		Content        matrixContent `json:"content"`
+	}
+
+	// matrixContent is the content of a message event, with the text of the
+	// message as stored in the Messages database, the kind of the message,
+	// and the payment sent with Apple Pay in the message, if any, under
+	// custom keys.
+	// Messages which mention participants or have styled text also have an
+	// HTML body, in which the mentions link to the participants.
+	matrixContent struct {
+		MsgType       string             `json:"msgtype"`
+		Body          string             `json:"body"`
+		RawBody       string             `json:"net.bagoup.raw_body"`
+		Kind          chatdb.MessageKind `json:"net.bagoup.kind"`
+		Format        string             `json:"format,omitempty"`
+		FormattedBody string             `json:"formatted_body,omitempty"`
+		Mentions      *matrixMentions    `json:"m.mentions,omitempty"`
+		RelatesTo     *matrixRelation    `json:"m.relates_to,omitempty"`
+		Payment       *chatdb.Payment    `json:"net.bagoup.payment,omitempty"`
+	}
+
+	matrixMentions struct {
+		UserIDs []string `json:"user_ids"`
+	}
+
+	matrixRelation struct {
+		InReplyTo matrixEventRef `json:"m.in_reply_to"`
+	}
+
+	matrixEventRef struct {
+		EventID string `json:"event_id"`
+	}
+)
+
+func newMatrixExporter(s opsys.OS, exportPath string) Exporter {
+	return &matrixExporter{s: s, exportPath: exportPath}
+}
+
+func (e *matrixExporter) Begin(chat Chat) (Output, error) {
+	dirPath := chatDir(e.exportPath, chat)
+	if err := e.s.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return Output{}, errors.Wrapf(err, "create directory %q", dirPath)
+	}
+	e.room = newMatrixRoom(chat.Chat)
+	e.roomPath = path.Join(dirPath, fmt.Sprintf("%s.json", chat.GUID))
+	return Output{Dir: dirPath, Path: e.roomPath}, nil
+}
+
+func (e *matrixExporter) WriteMessage(msg chatdb.Message) error {
+	e.room.add(msg)
+	return nil
+}
+
+func (e *matrixExporter) Finish() error {
+	return errors.Wrapf(WriteFile(e.s, e.roomPath, writeJSON(e.room)), "write Matrix events for chat %q", e.room.Name)
+}
+
+func newMatrixRoom(chat chatdb.Chat) *matrixRoom {
+	return &matrixRoom{
+		RoomID: fmt.Sprintf("!chat%d:%s", chat.ID, _matrixServerName),
+		Name:   chat.DisplayName,
+		Events: []matrixEvent{},
+	}
+}
+
+// add adds a message to the room as an m.room.message event. Group actions,
+// e.g. participants being added, are added as notices.
+func (r *matrixRoom) add(msg chatdb.Message) {
+	msgType := "m.text"
+	if msg.GroupAction != chatdb.NoGroupAction {
+		msgType = "m.notice"
+	}
+	content := matrixContent{MsgType: msgType, Body: msg.Text, RawBody: msg.RawText, Kind: msg.Kind(), Payment: msg.Payment}
+	if len(msg.Mentions) > 0 || len(msg.Styles) > 0 {
+		content.addFormatting(msg)
+	}
+	if msg.ReplyTo != nil {
+		content.RelatesTo = &matrixRelation{InReplyTo: matrixEventRef{EventID: matrixEventID(msg.ReplyTo.ID)}}
+	}
+	r.Events = append(r.Events, matrixEvent{
+		Type:           "m.room.message",
+		EventID:        matrixEventID(msg.ID),
+		Sender:         matrixUserID(msg.Handle),
+		OriginServerTS: msg.Date.UnixNano() / 1000000,
+		Content:        content,
+	})
+}
+
+// addFormatting adds an HTML body to the content, in which the mentions of
+// participants in the message link to them, as Matrix clients show mentions,
+// and its styled text is marked up.
+func (c *matrixContent) addFormatting(msg chatdb.Message) {
+	var body strings.Builder
+	var userIDs []string
+	seen := map[string]bool{}
+	formatted := false
+	for _, segment := range splitText(msg) {
+		text := strings.ReplaceAll(html.EscapeString(segment.text), "\n", "<br>")
+		switch {
+		case segment.mention != nil:
+			userID := matrixUserID(segment.mention.Handle)
+			fmt.Fprintf(&body, `<a href="https://matrix.to/#/%s">%s</a>`, userID, text)
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		case segment.style != nil:
+			open, closing := htmlStyleTags(*segment.style)
+			body.WriteString(open + text + closing)
+		default:
+			body.WriteString(text)
+			continue
+		}
+		formatted = true
+	}
+	if !formatted {
+		return
+	}
+	c.Format = "org.matrix.custom.html"
+	c.FormattedBody = body.String()
+	if len(userIDs) > 0 {
+		c.Mentions = &matrixMentions{UserIDs: userIDs}
+	}
+}
+
+// htmlStyleTags returns the HTML tags which open and close text with the
+// given style.
+func htmlStyleTags(style chatdb.TextStyle) (string, string) {
+	var open, closing string
+	for _, tag := range []struct {
+		name string
+		set  bool
+	}{
+		{"strong", style.Bold},
+		{"em", style.Italic},
+		{"u", style.Underline},
+		{"del", style.Strikethrough},
+	} {
+		if tag.set {
+			open += "<" + tag.name + ">"
+			closing = "</" + tag.name + ">" + closing
+		}
+	}
+	return open, closing
+}
+
+// matrixEventID returns a made-up Matrix event ID for the message with the
+// given ID.
+func matrixEventID(messageID int) string {
+	return fmt.Sprintf("$message%d:%s", messageID, _matrixServerName)
+}
+
+// matrixUserID returns a made-up Matrix user ID for the given handle, with
+// the characters which are not allowed in user IDs replaced.
+func matrixUserID(handle string) string {
+	localpart := strings.Trim(_matrixLocalpartInvalid.ReplaceAllString(strings.ToLower(handle), "_"), "_")
+	if localpart == "" {
+		localpart = "unknown"
+	}
+	return fmt.Sprintf("@%s:%s", localpart, _matrixServerName)
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestMatrixRoomAdd(t *testing.T) {
+	date := time.Date(2020, 3, 1, 15, 34, 5, 123456789, time.Local)
+	room := newMatrixRoom(chatdb.Chat{ID: 7, DisplayName: "Novak"})
+	room.add(chatdb.Message{ID: 1, Date: date, Handle: "Novak", Text: "hi Audio message (expired, not kept)", RawText: "hi \ufffc"})
+	room.add(chatdb.Message{ID: 2, Date: date, Handle: "Me", Text: "added Jelena to the conversation", GroupAction: chatdb.ParticipantAdded})
+	room.add(chatdb.Message{ID: 3, Date: date, Handle: "Novak", Text: "Welcome", ReplyTo: &chatdb.Reply{ID: 2, Handle: "Me", Text: "added Jelena to the conversation"}})
+	room.add(chatdb.Message{ID: 4, Date: date, Handle: "Novak", Text: "Received $25.00 via Apple Pay", Payment: &chatdb.Payment{Amount: "25.00", Currency: "USD"}})
+	room.add(chatdb.Message{ID: 5, Date: date, Handle: "Novak", Text: "Jelena & Marian,\ntennis?", Mentions: []chatdb.Mention{{Text: "Jelena", Handle: "Jelena"}, {Text: "Marian", Handle: "+14155555555"}}})
+	room.add(chatdb.Message{ID: 6, Date: date, Handle: "Novak", Text: "See you <at> noon", Styles: []chatdb.TextStyle{{Text: "noon", Bold: true, Underline: true}}})
+
+	assert.DeepEqual(t, &matrixRoom{
+		RoomID: "!chat7:bagoup.invalid",
+		Name:   "Novak",
+		Events: []matrixEvent{
+			{
+				Type:           "m.room.message",
+				EventID:        "$message1:bagoup.invalid",
+				Sender:         "@novak:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content:        matrixContent{MsgType: "m.text", Body: "hi Audio message (expired, not kept)", RawBody: "hi \ufffc", Kind: chatdb.TextMessage},
+			},
+			{
+				Type:           "m.room.message",
+				EventID:        "$message2:bagoup.invalid",
+				Sender:         "@me:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content:        matrixContent{MsgType: "m.notice", Body: "added Jelena to the conversation", Kind: chatdb.SystemMessage},
+			},
+			{
+				Type:           "m.room.message",
+				EventID:        "$message3:bagoup.invalid",
+				Sender:         "@novak:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content: matrixContent{
+					MsgType:   "m.text",
+					Body:      "Welcome",
+					Kind:      chatdb.TextMessage,
+					RelatesTo: &matrixRelation{InReplyTo: matrixEventRef{EventID: "$message2:bagoup.invalid"}},
+				},
+			},
+			{
+				Type:           "m.room.message",
+				EventID:        "$message4:bagoup.invalid",
+				Sender:         "@novak:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content: matrixContent{
+					MsgType: "m.text",
+					Body:    "Received $25.00 via Apple Pay",
+					Kind:    chatdb.TextMessage,
+					Payment: &chatdb.Payment{Amount: "25.00", Currency: "USD"},
+				},
+			},
+			{
+				Type:           "m.room.message",
+				EventID:        "$message5:bagoup.invalid",
+				Sender:         "@novak:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content: matrixContent{
+					MsgType:       "m.text",
+					Body:          "Jelena & Marian,\ntennis?",
+					Kind:          chatdb.TextMessage,
+					Format:        "org.matrix.custom.html",
+					FormattedBody: `<a href="https://matrix.to/#/@jelena:bagoup.invalid">Jelena</a> &amp; <a href="https://matrix.to/#/@14155555555:bagoup.invalid">Marian</a>,<br>tennis?`,
+					Mentions:      &matrixMentions{UserIDs: []string{"@jelena:bagoup.invalid", "@14155555555:bagoup.invalid"}},
+				},
+			},
+			{
+				Type:           "m.room.message",
+				EventID:        "$message6:bagoup.invalid",
+				Sender:         "@novak:bagoup.invalid",
+				OriginServerTS: date.Unix()*1000 + 123,
+				Content: matrixContent{
+					MsgType:       "m.text",
+					Body:          "See you <at> noon",
+					Kind:          chatdb.TextMessage,
+					Format:        "org.matrix.custom.html",
+					FormattedBody: "See you &lt;at&gt; <strong><u>noon</u></strong>",
+				},
+			},
+		},
+	}, room)
+}
+
+func TestMatrixUserID(t *testing.T) {
+	tests := []struct {
+		handle string
+		want   string
+	}{
+		{handle: "Novak", want: "@novak:bagoup.invalid"},
+		{handle: "+1 (415) 555-5555", want: "@1_415_555-5555:bagoup.invalid"},
+		{handle: "novak@example.com", want: "@novak_example.com:bagoup.invalid"},
+		{handle: "", want: "@unknown:bagoup.invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.handle, func(t *testing.T) {
+			assert.Equal(t, tt.want, matrixUserID(tt.handle))
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+func init() {
+	Register("mbox", newMboxExporter)
+}
+
+// _mboxDomain is the domain of the made-up addresses of senders whose handles
+// are not email addresses.
+const _mboxDomain = "bagoup.invalid"
+
+var (
+	_mboxFromLine     = regexp.MustCompile(`(?m)^(>*From )`)
+	_addressLocalPart = regexp.MustCompile(`[^A-Za-z0-9+._-]`)
+)
+
+// mboxExporter writes each chat to an mbox file with an email for each
+// message, for importing into mail clients and archival tools.
+type mboxExporter struct {
+	s          opsys.OS
+	exportPath string
+	chat       Chat
+	chatPath   string
+	file       afero.File
+}
+
+func newMboxExporter(s opsys.OS, exportPath string) Exporter {
+	return &mboxExporter{s: s, exportPath: exportPath}
+}
+
+func (e *mboxExporter) Begin(chat Chat) (Output, error) {
+	file, out, err := createChatFile(e.s, e.exportPath, chat, "mbox")
+	if err != nil {
+		return Output{}, err
+	}
+	e.chat, e.chatPath, e.file = chat, out.Path, file
+	return out, nil
+}
+
+func (e *mboxExporter) WriteMessage(msg chatdb.Message) error {
+	if _, err := e.file.WriteString(mboxMessage(msg, e.chat.Chat)); err != nil {
+		return errors.Wrapf(err, "write message %q to file %q", msg, e.file.Name())
+	}
+	return nil
+}
+
+func (e *mboxExporter) Finish() error {
+	return finishFile(e.s, e.file, e.chatPath)
+}
+
+// mboxMessage formats the given message as an email in mboxrd format, with
+// the chat's display name as its subject.
+func mboxMessage(msg chatdb.Message, chat chatdb.Chat) string {
+	from := mboxAddress(msg.Handle)
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s %s\n", from.Address, msg.Date.Format(time.ANSIC))
+	fmt.Fprintf(&b, "From: %s\n", from)
+	fmt.Fprintf(&b, "Date: %s\n", msg.Date.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", chat.DisplayName))
+	fmt.Fprintf(&b, "Message-ID: <message-%d@%s>\n", msg.ID, _mboxDomain)
+	if msg.ReplyTo != nil {
+		fmt.Fprintf(&b, "In-Reply-To: <message-%d@%s>\n", msg.ReplyTo.ID, _mboxDomain)
+	}
+	switch msg.DateSource {
+	case chatdb.DateDelivered:
+		b.WriteString("X-Date-Source: delivered\n")
+	case chatdb.DateRead:
+		b.WriteString("X-Date-Source: read\n")
+	case chatdb.DateUnknown:
+		b.WriteString("X-Date-Source: unknown\n")
+	}
+	fmt.Fprintf(&b, "X-Message-Kind: %s\n", msg.Kind())
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	body := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(msg.Text)
+	b.WriteString(_mboxFromLine.ReplaceAllString(body, ">$1"))
+	b.WriteString("\n\n")
+	return b.String()
+}
+
+// mboxAddress returns an address for the given handle, using the handle
+// itself if it is an email address.
+func mboxAddress(handle string) *mail.Address {
+	if addr, err := mail.ParseAddress(handle); err == nil && addr.Address == handle {
+		return addr
+	}
+	localPart := _addressLocalPart.ReplaceAllString(handle, "")
+	if localPart == "" {
+		localPart = "unknown"
+	}
+	return &mail.Address{Name: handle, Address: fmt.Sprintf("%s@%s", localPart, _mboxDomain)}
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestMboxMessage(t *testing.T) {
+	date := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.FixedZone("PST", -8*60*60))
+	chat := chatdb.Chat{GUID: "iMessage;-;+14155555555", DisplayName: "Novak Đoković"}
+
+	tests := []struct {
+		msg  string
+		in   chatdb.Message
+		want string
+	}{
+		{
+			msg: "phone number handle",
+			in:  chatdb.Message{ID: 42, Date: date, Handle: "+14155555555", Text: "Want to play tennis?\nFrom 5pm\n>From 6pm"},
+			want: `From +14155555555@bagoup.invalid Sun Mar  1 15:34:05 2020
+From: "+14155555555" <+14155555555@bagoup.invalid>
+Date: Sun, 01 Mar 2020 15:34:05 -0800
+Subject: =?utf-8?q?Novak_=C4=90okovi=C4=87?=
+Message-ID: <message-42@bagoup.invalid>
+X-Message-Kind: text
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+Want to play tennis?
+>From 5pm
+>>From 6pm
+
+`,
+		},
+		{
+			msg: "email handle with fallback date replying to a message",
+			in:  chatdb.Message{ID: 43, Date: date, DateSource: chatdb.DateDelivered, Handle: "novak@example.com", Text: "Sure", ReplyTo: &chatdb.Reply{ID: 42, Handle: "Me", Text: "Want to play tennis?"}},
+			want: `From novak@example.com Sun Mar  1 15:34:05 2020
+From: <novak@example.com>
+Date: Sun, 01 Mar 2020 15:34:05 -0800
+Subject: =?utf-8?q?Novak_=C4=90okovi=C4=87?=
+Message-ID: <message-43@bagoup.invalid>
+In-Reply-To: <message-42@bagoup.invalid>
+X-Date-Source: delivered
+X-Message-Kind: text
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+Sure
+
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, mboxMessage(tt.in, chat))
+		})
+	}
+}
+
+func TestMboxAddress(t *testing.T) {
+	tests := []struct {
+		handle string
+		want   string
+	}{
+		{handle: "novak@example.com", want: "<novak@example.com>"},
+		{handle: "Jelena Djokovic", want: `"Jelena Djokovic" <JelenaDjokovic@bagoup.invalid>`},
+		{handle: "王小明", want: "=?utf-8?q?=E7=8E=8B=E5=B0=8F=E6=98=8E?= <unknown@bagoup.invalid>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.handle, func(t *testing.T) {
+			assert.Equal(t, tt.want, mboxAddress(tt.handle).String())
+		})
+	}
+}
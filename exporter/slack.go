@@ -0,0 +1,236 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+func init() {
+	Register("slack", newSlackExporter)
+}
+
+const _slackDayLayout = "2006-01-02"
+
+var _slackChannelInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+type (
+	// slackExporter collects chats into the layout of a Slack workspace export:
+	// channels.json and users.json in the export folder, and a folder for each
+	// channel with one JSON file of messages per day.
+	slackExporter struct {
+		s          opsys.OS
+		exportPath string
+		current    *slackChannel
+		channels   []*slackChannel
+		users      []slackUser
+		userIDs    map[string]string
+		names      map[string]bool
+	}
+
+	slackChannel struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		Created    int64    `json:"created"`
+		Members    []string `json:"members"`
+		IsArchived bool     `json:"is_archived"`
+		messages   map[string][]slackMessage
+	}
+
+	slackUser struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		RealName string `json:"real_name"`
+	}
+
+	// slackMessage is a message in the Slack format, with the text of the
+	// message as stored in the Messages database, the kind of the message,
+	// and the payment sent with Apple Pay in the message, if any, which Slack
+	// does not have.
+	slackMessage struct {
+		Type    string             `json:"type"`
+		User    string             `json:"user"`
+		Text    string             `json:"text"`
+		RawText string             `json:"raw_text"`
+		Kind    chatdb.MessageKind `json:"kind"`
+		TS      string             `json:"ts"`
+		Payment *chatdb.Payment    `json:"payment,omitempty"`
+	}
+)
+
+func newSlackExporter(s opsys.OS, exportPath string) Exporter {
+	return &slackExporter{
+		s:          s,
+		exportPath: exportPath,
+		userIDs:    map[string]string{},
+		names:      map[string]bool{},
+	}
+}
+
+func (e *slackExporter) Begin(chat Chat) (Output, error) {
+	e.current = e.addChannel(chat.Chat, chat.Members)
+	dirPath := e.channelPath(e.current)
+	if err := e.s.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return Output{}, errors.Wrapf(err, "create directory %q", dirPath)
+	}
+	return Output{Dir: dirPath, Path: dirPath}, nil
+}
+
+func (e *slackExporter) WriteMessage(msg chatdb.Message) error {
+	e.add(e.current, msg)
+	return nil
+}
+
+// Finish writes the messages of the current channel, one file per day.
+func (e *slackExporter) Finish() error {
+	channel := e.current
+	days := make([]string, 0, len(channel.messages))
+	for day := range channel.messages {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		dayPath := path.Join(e.channelPath(channel), day+".json")
+		if err := WriteFile(e.s, dayPath, writeJSON(channel.messages[day])); err != nil {
+			return errors.Wrapf(err, "write messages for channel %q", channel.Name)
+		}
+	}
+	return nil
+}
+
+// FinishExport writes channels.json and users.json into the export folder.
+func (e *slackExporter) FinishExport() error {
+	channels := make([]slackChannel, 0, len(e.channels))
+	for _, channel := range e.channels {
+		if len(channel.messages) > 0 {
+			channels = append(channels, *channel)
+		}
+	}
+	channelsPath := path.Join(e.exportPath, "channels.json")
+	if err := WriteFile(e.s, channelsPath, writeJSON(channels)); err != nil {
+		return errors.Wrap(err, "write channels")
+	}
+	users := e.users
+	if users == nil {
+		users = []slackUser{}
+	}
+	usersPath := path.Join(e.exportPath, "users.json")
+	return errors.Wrap(WriteFile(e.s, usersPath, writeJSON(users)), "write users")
+}
+
+func (e *slackExporter) channelPath(channel *slackChannel) string {
+	return path.Join(e.exportPath, channel.Name)
+}
+
+// addChannel adds a channel for the given chat, named after its display name
+// and made unique with a numeric suffix.
+func (e *slackExporter) addChannel(chat chatdb.Chat, members []string) *slackChannel {
+	base := strings.Trim(_slackChannelInvalid.ReplaceAllString(strings.ToLower(chat.DisplayName), "-"), "-")
+	if base == "" {
+		base = "chat"
+	}
+	name := base
+	for i := 2; e.names[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	e.names[name] = true
+	channel := &slackChannel{
+		ID:         fmt.Sprintf("C%04d", len(e.channels)+1),
+		Name:       name,
+		Members:    []string{},
+		IsArchived: chat.Archived,
+		messages:   map[string][]slackMessage{},
+	}
+	for _, member := range members {
+		if member == "" {
+			continue
+		}
+		channel.Members = append(channel.Members, e.userID(member))
+	}
+	e.channels = append(e.channels, channel)
+	return channel
+}
+
+// add adds a message to the channel, filed under the day on which it was
+// sent.
+func (e *slackExporter) add(channel *slackChannel, msg chatdb.Message) {
+	if channel.Created == 0 || msg.Date.Unix() < channel.Created {
+		channel.Created = msg.Date.Unix()
+	}
+	day := msg.Date.Format(_slackDayLayout)
+	channel.messages[day] = append(channel.messages[day], slackMessage{
+		Type:    "message",
+		User:    e.userID(msg.Handle),
+		Text:    e.text(msg),
+		RawText: msg.RawText,
+		Kind:    msg.Kind(),
+		TS:      fmt.Sprintf("%d.%06d", msg.Date.Unix(), msg.Date.Nanosecond()/1000),
+		Payment: msg.Payment,
+	})
+}
+
+// text returns the text of a message, with mentions of participants in the
+// Slack format, e.g. "<@U0002>", and styled text marked up in Slack's
+// formatting, e.g. "*bold*". Slack has no underlined text.
+func (e *slackExporter) text(msg chatdb.Message) string {
+	if len(msg.Mentions) == 0 && len(msg.Styles) == 0 {
+		return msg.Text
+	}
+	var text strings.Builder
+	for _, segment := range splitText(msg) {
+		switch {
+		case segment.mention != nil:
+			fmt.Fprintf(&text, "<@%s>", e.userID(segment.mention.Handle))
+		case segment.style != nil:
+			text.WriteString(slackStyle(segment.text, *segment.style))
+		default:
+			text.WriteString(segment.text)
+		}
+	}
+	return text.String()
+}
+
+// slackStyle marks up the given text with the given style in Slack's
+// formatting. The markers must touch the text, so surrounding spaces are kept
+// outside of them.
+func slackStyle(text string, style chatdb.TextStyle) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	marked := trimmed
+	for _, marker := range []struct {
+		marker string
+		set    bool
+	}{
+		{"~", style.Strikethrough},
+		{"_", style.Italic},
+		{"*", style.Bold},
+	} {
+		if marker.set {
+			marked = marker.marker + marked + marker.marker
+		}
+	}
+	i := strings.Index(text, trimmed)
+	return text[:i] + marked + text[i+len(trimmed):]
+}
+
+func (e *slackExporter) userID(name string) string {
+	if id, ok := e.userIDs[name]; ok {
+		return id
+	}
+	id := fmt.Sprintf("U%04d", len(e.users)+1)
+	e.userIDs[name] = id
+	e.users = append(e.users, slackUser{ID: id, Name: name, RealName: name})
+	return id
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestSlackAddChannel(t *testing.T) {
+	tests := []struct {
+		msg         string
+		displayName string
+		wantName    string
+	}{
+		{
+			msg:         "name with spaces and capitals",
+			displayName: "Novak Djokovic",
+			wantName:    "novak-djokovic",
+		},
+		{
+			msg:         "phone number",
+			displayName: "+1 (415) 555-5555",
+			wantName:    "1-415-555-5555",
+		},
+		{
+			msg:         "no usable characters",
+			displayName: "ノバク",
+			wantName:    "chat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			e := newSlackExporter(nil, "backup").(*slackExporter)
+			channel := e.addChannel(chatdb.Chat{DisplayName: tt.displayName}, []string{"Me", "", "Novak"})
+			assert.Equal(t, tt.wantName, channel.Name)
+			assert.Equal(t, "C0001", channel.ID)
+			assert.DeepEqual(t, []string{"U0001", "U0002"}, channel.Members)
+
+			channel = e.addChannel(chatdb.Chat{DisplayName: tt.displayName}, nil)
+			assert.Equal(t, tt.wantName+"-2", channel.Name)
+			assert.Equal(t, "C0002", channel.ID)
+		})
+	}
+}
+
+func TestSlackWrite(t *testing.T) {
+	day1 := time.Date(2020, 3, 1, 23, 59, 0, 1500, time.Local)
+	day2 := time.Date(2020, 3, 2, 0, 1, 0, 0, time.Local)
+	fs := afero.NewMemMapFs()
+	e := newSlackExporter(opsys.NewOS(fs, nil, nil), "backup")
+	out, err := e.Begin(Chat{Chat: chatdb.Chat{DisplayName: "Novak", Archived: true}, Members: []string{"Me", "Novak"}})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Output{Dir: "backup/novak", Path: "backup/novak"}, out)
+	assert.NilError(t, e.WriteMessage(chatdb.Message{Date: day2, Handle: "Novak", Text: "good morning", RawText: "good morning"}))
+	assert.NilError(t, e.WriteMessage(chatdb.Message{Date: day1, Handle: "Me", Text: "good night", RawText: "good night"}))
+	assert.NilError(t, e.Finish())
+	_, err = e.Begin(Chat{Chat: chatdb.Chat{DisplayName: "Empty"}})
+	assert.NilError(t, err)
+	assert.NilError(t, e.Finish())
+	assert.NilError(t, e.(Finalizer).FinishExport())
+
+	wantFiles := map[string]string{
+		"backup/novak/2020-03-01.json": fmt.Sprintf(`[
+  {
+    "type": "message",
+    "user": "U0001",
+    "text": "good night",
+    "raw_text": "good night",
+    "kind": "text",
+    "ts": "%d.000001"
+  }
+]
+`, day1.Unix()),
+		"backup/novak/2020-03-02.json": fmt.Sprintf(`[
+  {
+    "type": "message",
+    "user": "U0002",
+    "text": "good morning",
+    "raw_text": "good morning",
+    "kind": "text",
+    "ts": "%d.000000"
+  }
+]
+`, day2.Unix()),
+		"backup/channels.json": fmt.Sprintf(`[
+  {
+    "id": "C0001",
+    "name": "novak",
+    "created": %d,
+    "members": [
+      "U0001",
+      "U0002"
+    ],
+    "is_archived": true
+  }
+]
+`, day1.Unix()),
+		"backup/users.json": `[
+  {
+    "id": "U0001",
+    "name": "Me",
+    "real_name": "Me"
+  },
+  {
+    "id": "U0002",
+    "name": "Novak",
+    "real_name": "Novak"
+  }
+]
+`,
+	}
+	for filename, expected := range wantFiles {
+		actual, err := afero.ReadFile(fs, filename)
+		assert.NilError(t, err)
+		assert.Equal(t, expected, string(actual))
+	}
+}
+
+func TestSlackWriteError(t *testing.T) {
+	e := newSlackExporter(opsys.NewOS(afero.NewReadOnlyFs(afero.NewMemMapFs()), nil, nil), "backup").(*slackExporter)
+	_, err := e.Begin(Chat{Chat: chatdb.Chat{DisplayName: "Novak"}})
+	assert.ErrorContains(t, err, `create directory "backup/novak"`)
+	assert.NilError(t, e.WriteMessage(chatdb.Message{Date: time.Date(2020, 3, 1, 0, 0, 0, 0, time.Local), Handle: "Novak"}))
+	assert.ErrorContains(t, e.Finish(), `write messages for channel "novak": create file "backup/novak/2020-03-01.json.partial"`)
+	assert.ErrorContains(t, e.FinishExport(), `write channels: create file "backup/channels.json.partial"`)
+}
+
+func TestSlackText(t *testing.T) {
+	e := newSlackExporter(nil, "backup").(*slackExporter)
+	e.addChannel(chatdb.Chat{DisplayName: "Tennis"}, []string{"Me", "Novak"})
+	assert.Equal(t, "good morning", e.text(chatdb.Message{Text: "good morning"}))
+	assert.Equal(t, "<@U0002> & <@U0003>, tennis?", e.text(chatdb.Message{
+		Text:     "Novak & Jelena, tennis?",
+		Mentions: []chatdb.Mention{{Text: "Novak", Handle: "Novak"}, {Text: "Jelena", Handle: "Jelena"}},
+	}))
+	assert.Equal(t, "See you *_noon_* ~today~ ", e.text(chatdb.Message{
+		Text:   "See you noon today ",
+		Styles: []chatdb.TextStyle{{Text: "noon", Bold: true, Italic: true, Underline: true}, {Text: " today ", Strikethrough: true}},
+	}))
+	assert.Equal(t, 3, len(e.users))
+}
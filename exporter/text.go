@@ -0,0 +1,67 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// textSegment is a part of the text of a message, which mentions a
+// participant if mention is set, or is styled if style is set.
+type textSegment struct {
+	text    string
+	mention *chatdb.Mention
+	style   *chatdb.TextStyle
+}
+
+// splitText splits the text of a message at its mentions of participants and
+// its styled text. Each mention is looked for after the previous one, as is
+// each styled text, and those which are not in the text, e.g. because the text
+// was replaced, are left out. Mentions take precedence over styled text which
+// overlaps them.
+func splitText(msg chatdb.Message) []textSegment {
+	type span struct {
+		start, end int
+		segment    textSegment
+	}
+	var spans []span
+	find := func(text string, from int, segment textSegment) int {
+		i := strings.Index(msg.Text[from:], text)
+		if text == "" || i < 0 {
+			return from
+		}
+		segment.text = text
+		spans = append(spans, span{start: from + i, end: from + i + len(text), segment: segment})
+		return from + i + len(text)
+	}
+	from := 0
+	for i := range msg.Mentions {
+		from = find(msg.Mentions[i].Text, from, textSegment{mention: &msg.Mentions[i]})
+	}
+	from = 0
+	for i := range msg.Styles {
+		from = find(msg.Styles[i].Text, from, textSegment{style: &msg.Styles[i]})
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var segments []textSegment
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			continue
+		}
+		if s.start > pos {
+			segments = append(segments, textSegment{text: msg.Text[pos:s.start]})
+		}
+		segments = append(segments, s.segment)
+		pos = s.end
+	}
+	if pos < len(msg.Text) {
+		segments = append(segments, textSegment{text: msg.Text[pos:]})
+	}
+	return segments
+}
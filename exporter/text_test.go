@@ -0,0 +1,81 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		msg      string
+		text     string
+		mentions []chatdb.Mention
+		styles   []chatdb.TextStyle
+		want     []string
+	}{
+		{
+			msg:  "no mentions",
+			text: "Want to play tennis?",
+			want: []string{"Want to play tennis?"},
+		},
+		{
+			msg:      "mentions",
+			text:     "Novak, Jelena, tennis?",
+			mentions: []chatdb.Mention{{Text: "Novak", Handle: "Novak"}, {Text: "Jelena", Handle: "jelena@example.com"}},
+			want:     []string{"@Novak(Novak)", ", ", "@Jelena(jelena@example.com)", ", tennis?"},
+		},
+		{
+			msg:      "repeated name",
+			text:     "Novak said Novak",
+			mentions: []chatdb.Mention{{Text: "Novak", Handle: "Novak"}, {Text: "Novak", Handle: "Novak"}},
+			want:     []string{"@Novak(Novak)", " said ", "@Novak(Novak)"},
+		},
+		{
+			msg:      "mention not in text",
+			text:     "Tennis?",
+			mentions: []chatdb.Mention{{Text: "Novak", Handle: "Novak"}},
+			want:     []string{"Tennis?"},
+		},
+		{
+			msg:      "empty mention",
+			text:     "Tennis?",
+			mentions: []chatdb.Mention{{Handle: "Novak"}},
+			want:     []string{"Tennis?"},
+		},
+		{
+			msg:    "styles",
+			text:   "Tennis today? Sure",
+			styles: []chatdb.TextStyle{{Text: "today", Bold: true}, {Text: "Sure", Italic: true}},
+			want:   []string{"Tennis ", "<today>", "? ", "<Sure>"},
+		},
+		{
+			msg:      "mentions and styles",
+			text:     "Novak, tennis today?",
+			mentions: []chatdb.Mention{{Text: "Novak", Handle: "Novak"}},
+			styles:   []chatdb.TextStyle{{Text: "Novak, tennis", Bold: true}, {Text: "today", Italic: true}},
+			want:     []string{"@Novak(Novak)", ", tennis ", "<today>", "?"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var got []string
+			for _, segment := range splitText(chatdb.Message{Text: tt.text, Mentions: tt.mentions, Styles: tt.styles}) {
+				switch {
+				case segment.mention != nil:
+					got = append(got, "@"+segment.text+"("+segment.mention.Handle+")")
+				case segment.style != nil:
+					got = append(got, "<"+segment.text+">")
+				default:
+					got = append(got, segment.text)
+				}
+			}
+			assert.DeepEqual(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+func init() {
+	Register("txt", newTxtExporter)
+}
+
+// txtExporter is the reference Exporter. It writes each chat to a text file
+// with a line for each message, named after the GUID of the chat, in a folder
+// named after its display name. The participants of group chats are listed
+// whenever they change.
+type txtExporter struct {
+	s                opsys.OS
+	exportPath       string
+	chat             Chat
+	chatPath         string
+	file             afero.File
+	lastParticipants string
+	// stream, if set, is written instead of a file for each chat.
+	stream     io.Writer
+	streamName string
+}
+
+func newTxtExporter(s opsys.OS, exportPath string) Exporter {
+	return &txtExporter{s: s, exportPath: exportPath}
+}
+
+// NewTxtStream returns an Exporter which writes chats in the txt format to the
+// given writer, e.g. standard output, described in errors by the given name,
+// instead of into files in an export folder. It creates no folders, and
+// returns an empty Output for each chat.
+func NewTxtStream(w io.Writer, name string) Exporter {
+	return &txtExporter{stream: w, streamName: name}
+}
+
+func (e *txtExporter) Begin(chat Chat) (Output, error) {
+	if e.stream != nil {
+		e.chat, e.lastParticipants = chat, ""
+		return Output{}, nil
+	}
+	file, out, err := createChatFile(e.s, e.exportPath, chat, "txt")
+	if err != nil {
+		return Output{}, err
+	}
+	e.chat, e.chatPath, e.file, e.lastParticipants = chat, out.Path, file, ""
+	return out, nil
+}
+
+// out returns the writer of the current chat, and its name for errors.
+func (e *txtExporter) out() (io.Writer, string) {
+	if e.stream != nil {
+		return e.stream, e.streamName
+	}
+	return e.file, fmt.Sprintf("file %q", e.file.Name())
+}
+
+func (e *txtExporter) WriteMessage(msg chatdb.Message) error {
+	p, inTimeline := e.chat.Participants[msg.ID]
+	if inTimeline && p.Before != e.lastParticipants {
+		if err := e.writeParticipants(p.Before); err != nil {
+			return err
+		}
+	}
+	w, name := e.out()
+	if _, err := io.WriteString(w, msg.String()); err != nil {
+		return errors.Wrapf(err, "write message %q to %s", msg, name)
+	}
+	if inTimeline && p.After != e.lastParticipants {
+		return e.writeParticipants(p.After)
+	}
+	return nil
+}
+
+func (e *txtExporter) writeParticipants(participants string) error {
+	marker := fmt.Sprintf("--- Participants at this point: %s ---\n", participants)
+	w, name := e.out()
+	if _, err := io.WriteString(w, marker); err != nil {
+		return errors.Wrapf(err, "write participants to %s", name)
+	}
+	e.lastParticipants = participants
+	return nil
+}
+
+func (e *txtExporter) Finish() error {
+	if e.stream != nil {
+		return nil
+	}
+	return finishFile(e.s, e.file, e.chatPath)
+}
+
+// createChatFile creates the folder for the given chat, named after its folder
+// name or display name, within the chat's folder in the export folder, if any,
+// and creates a partial file for the chat in the folder, to be renamed with
+// finishFile to the returned output path, named after the chat's GUID with the
+// given extension.
+func createChatFile(s opsys.OS, exportPath string, chat Chat, ext string) (afero.File, Output, error) {
+	dirPath := chatDir(exportPath, chat)
+	if err := s.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, Output{}, errors.Wrapf(err, "create directory %q", dirPath)
+	}
+	chatPath := path.Join(dirPath, fmt.Sprintf("%s.%s", chat.GUID, ext))
+	file, err := s.Create(chatPath + _partialSuffix)
+	if err != nil {
+		return nil, Output{}, errors.Wrapf(err, "create file %q", chatPath+_partialSuffix)
+	}
+	return file, Output{Dir: dirPath, Path: chatPath}, nil
+}
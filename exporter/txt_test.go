@@ -0,0 +1,128 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestTxtExporter(t *testing.T) {
+	date := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)
+	msgs := []chatdb.Message{
+		{ID: 1, Date: date, Handle: "Novak", Text: "Want to play tennis?"},
+		{ID: 2, Date: date, Handle: "Novak", Text: "added Marian to the conversation", GroupAction: chatdb.ParticipantAdded},
+		{ID: 3, Date: date, Handle: "Marian", Text: "Sure"},
+	}
+
+	tests := []struct {
+		msg          string
+		participants map[int]Participants
+		want         string
+	}{
+		{
+			msg: "one-on-one chat",
+			want: `[2020-03-01 15:34:05] Novak: Want to play tennis?
+[2020-03-01 15:34:05] Novak: added Marian to the conversation
+[2020-03-01 15:34:05] Marian: Sure
+`,
+		},
+		{
+			msg: "group chat",
+			participants: map[int]Participants{
+				1: {Before: "Jelena, Novak", After: "Jelena, Novak"},
+				2: {Before: "Jelena, Novak", After: "Jelena, Marian, Novak"},
+				3: {Before: "Jelena, Marian, Novak", After: "Jelena, Marian, Novak"},
+			},
+			want: `--- Participants at this point: Jelena, Novak ---
+[2020-03-01 15:34:05] Novak: Want to play tennis?
+[2020-03-01 15:34:05] Novak: added Marian to the conversation
+--- Participants at this point: Jelena, Marian, Novak ---
+[2020-03-01 15:34:05] Marian: Sure
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			e, err := New("txt", opsys.NewOS(fs, nil, nil), "backup")
+			assert.NilError(t, err)
+			chat := Chat{
+				Chat:         chatdb.Chat{GUID: "testguid", DisplayName: "Novak"},
+				Participants: tt.participants,
+			}
+			out, err := e.Begin(chat)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, Output{Dir: "backup/Novak", Path: "backup/Novak/testguid.txt"}, out)
+			for _, msg := range msgs {
+				assert.NilError(t, e.WriteMessage(msg))
+			}
+			exist, err := afero.Exists(fs, "backup/Novak/testguid.txt")
+			assert.NilError(t, err)
+			assert.Assert(t, !exist, "chat file written before finishing")
+			assert.NilError(t, e.Finish())
+			actual, err := afero.ReadFile(fs, "backup/Novak/testguid.txt")
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, string(actual))
+			exist, err = afero.Exists(fs, "backup/Novak/testguid.txt.partial")
+			assert.NilError(t, err)
+			assert.Assert(t, !exist, "partial chat file left behind")
+		})
+	}
+}
+
+func TestTxtExporterDir(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	e, err := New("txt", opsys.NewOS(fs, nil, nil), "backup")
+	assert.NilError(t, err)
+	out, err := e.Begin(Chat{Chat: chatdb.Chat{GUID: "testguid", DisplayName: "72975"}, Dir: "unknown-senders"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Output{Dir: "backup/unknown-senders/72975", Path: "backup/unknown-senders/72975/testguid.txt"}, out)
+	assert.NilError(t, e.Finish())
+	exist, err := afero.Exists(fs, "backup/unknown-senders/72975/testguid.txt")
+	assert.NilError(t, err)
+	assert.Assert(t, exist)
+}
+
+func TestTxtExporterError(t *testing.T) {
+	e := newTxtExporter(opsys.NewOS(afero.NewReadOnlyFs(afero.NewMemMapFs()), nil, nil), "backup")
+	_, err := e.Begin(Chat{Chat: chatdb.Chat{GUID: "testguid", DisplayName: "Novak"}})
+	assert.ErrorContains(t, err, `create directory "backup/Novak"`)
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("this is a write error")
+}
+
+func TestTxtStream(t *testing.T) {
+	date := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)
+	var b bytes.Buffer
+	e := NewTxtStream(&b, "standard output")
+	out, err := e.Begin(Chat{
+		Chat:         chatdb.Chat{GUID: "testguid", DisplayName: "Doubles"},
+		Participants: map[int]Participants{1: {Before: "Jelena, Novak", After: "Jelena, Novak"}},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, Output{}, out)
+	assert.NilError(t, e.WriteMessage(chatdb.Message{ID: 1, Date: date, Handle: "Novak", Text: "Doubles on Saturday?"}))
+	assert.NilError(t, e.Finish())
+	assert.Equal(t, "--- Participants at this point: Jelena, Novak ---\n[2020-03-01 15:34:05] Novak: Doubles on Saturday?\n", b.String())
+
+	e = NewTxtStream(failingWriter{}, "standard output")
+	_, err = e.Begin(Chat{Chat: chatdb.Chat{GUID: "testguid", DisplayName: "Novak"}})
+	assert.NilError(t, err)
+	err = e.WriteMessage(chatdb.Message{ID: 1, Date: date, Handle: "Novak", Text: "Tennis?"})
+	assert.ErrorContains(t, err, "to standard output: this is a write error")
+}
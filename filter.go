@@ -0,0 +1,151 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// _selfSender selects the messages sent by the owner of the database with
+// --sender, whatever their messages are labeled with.
+const _selfSender = "me"
+
+// messageFilter selects the messages to export by regular expression, along
+// with the messages surrounding each match, by sender, and by kind.
+type messageFilter struct {
+	match   *regexp.Regexp
+	exclude *regexp.Regexp
+	before  int
+	after   int
+	// senders are the lowercased names of the senders whose messages are
+	// exported, and self is whether your own messages are, or both are
+	// empty to export the messages of all senders.
+	senders map[string]bool
+	self    bool
+	// kinds are the kinds of the messages which are exported, or nil to
+	// export messages of all kinds.
+	kinds map[chatdb.MessageKind]bool
+}
+
+func newMessageFilter(opts options) (messageFilter, error) {
+	f := messageFilter{before: opts.BeforeContext, after: opts.AfterContext}
+	if f.before < 0 {
+		return f, errors.Errorf("invalid --before-context %d - FIX: pass 0 or a positive number of messages", f.before)
+	}
+	if f.after < 0 {
+		return f, errors.Errorf("invalid --after-context %d - FIX: pass 0 or a positive number of messages", f.after)
+	}
+	for _, sender := range opts.Senders {
+		if strings.EqualFold(sender, _selfSender) {
+			f.self = true
+			continue
+		}
+		if f.senders == nil {
+			f.senders = map[string]bool{}
+		}
+		f.senders[strings.ToLower(sender)] = true
+	}
+	if len(opts.Kinds) > 0 || len(opts.ExcludeKinds) > 0 {
+		f.kinds = map[chatdb.MessageKind]bool{}
+		for _, kind := range chatdb.MessageKinds {
+			f.kinds[kind] = len(opts.Kinds) == 0
+		}
+		for _, kind := range opts.Kinds {
+			f.kinds[chatdb.MessageKind(kind)] = true
+		}
+		for _, kind := range opts.ExcludeKinds {
+			f.kinds[chatdb.MessageKind(kind)] = false
+		}
+		selected := false
+		for _, kind := range chatdb.MessageKinds {
+			selected = selected || f.kinds[kind]
+		}
+		if !selected {
+			return f, errors.New("--exclude-kind excludes every kind selected with --kind - FIX: exclude fewer kinds of messages")
+		}
+	}
+	var err error
+	if opts.Match != "" {
+		if f.match, err = regexp.Compile(opts.Match); err != nil {
+			return f, errors.Wrapf(err, "compile --match pattern %q", opts.Match)
+		}
+	}
+	if opts.Exclude != "" {
+		if f.exclude, err = regexp.Compile(opts.Exclude); err != nil {
+			return f, errors.Wrapf(err, "compile --exclude pattern %q", opts.Exclude)
+		}
+	}
+	return f, nil
+}
+
+func (f messageFilter) active() bool {
+	return f.match != nil || f.exclude != nil || f.bySender() || f.kinds != nil
+}
+
+// bySender checks if only the messages of some senders are exported.
+func (f messageFilter) bySender() bool {
+	return f.self || len(f.senders) > 0
+}
+
+// fromSender checks if the given message was sent by one of the senders whose
+// messages are exported.
+func (f messageFilter) fromSender(msg chatdb.Message) bool {
+	if !f.bySender() {
+		return true
+	}
+	if msg.FromMe && f.self {
+		return true
+	}
+	return f.senders[strings.ToLower(msg.Handle)]
+}
+
+// ofKind checks if the given message is of one of the kinds of messages which
+// are exported.
+func (f messageFilter) ofKind(msg chatdb.Message) bool {
+	return f.kinds == nil || f.kinds[msg.Kind()]
+}
+
+// apply returns the messages matched by the filter and the messages within the
+// context of each match, in their original order. Excluded messages, and
+// messages from other senders or of other kinds than those selected, are never
+// returned, even as context.
+func (f messageFilter) apply(msgs []chatdb.Message) []chatdb.Message {
+	keep := make([]bool, len(msgs))
+	for i, msg := range msgs {
+		if f.match != nil && !f.match.MatchString(msg.Text) {
+			continue
+		}
+		for j := i - f.before; j <= i+f.after; j++ {
+			if j >= 0 && j < len(msgs) {
+				keep[j] = true
+			}
+		}
+	}
+	var filtered []chatdb.Message
+	for i, msg := range msgs {
+		if keep[i] && (f.exclude == nil || !f.exclude.MatchString(msg.Text)) && f.fromSender(msg) && f.ofKind(msg) {
+			filtered = append(filtered, msg)
+		}
+	}
+	return filtered
+}
+
+// keepChatKind checks if a chat with the given participants, excluding the
+// owner of the database, is of the kind selected by --only-groups or
+// --only-direct, if either.
+func keepChatKind(opts options, participantIDs []int) bool {
+	group := len(participantIDs) > 1
+	return !(opts.OnlyGroups && !group || opts.OnlyDirect && group)
+}
+
+// filtered checks if the options select only some of the chats or only some
+// of the messages in them, so that an export with them is partial.
+func (opts options) filtered() bool {
+	return opts.Recent > 0 || opts.Handle != "" || opts.Chat != "" || len(opts.ContactGroups) > 0 || opts.OnlyGroups || opts.OnlyDirect || opts.MinMessages > 0 ||
+		opts.Match != "" || opts.Exclude != "" || len(opts.Senders) > 0 || len(opts.Kinds) > 0 || len(opts.ExcludeKinds) > 0
+}
@@ -0,0 +1,259 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewMessageFilter(t *testing.T) {
+	tests := []struct {
+		msg        string
+		opts       options
+		wantActive bool
+		wantErr    string
+	}{
+		{
+			msg: "no filter",
+		},
+		{
+			msg:        "match and exclude",
+			opts:       options{Match: "(?i)invoice", Exclude: "draft"},
+			wantActive: true,
+		},
+		{
+			msg:     "negative before context",
+			opts:    options{Match: "(?i)invoice", BeforeContext: -1},
+			wantErr: "invalid --before-context -1 - FIX: pass 0 or a positive number of messages",
+		},
+		{
+			msg:     "negative after context",
+			opts:    options{Match: "(?i)invoice", AfterContext: -2},
+			wantErr: "invalid --after-context -2 - FIX: pass 0 or a positive number of messages",
+		},
+		{
+			msg:        "senders",
+			opts:       options{Senders: []string{"me", "Novak"}},
+			wantActive: true,
+		},
+		{
+			msg:        "kinds",
+			opts:       options{Kinds: []string{"text", "emoji"}, ExcludeKinds: []string{"emoji"}},
+			wantActive: true,
+		},
+		{
+			msg:     "no kinds",
+			opts:    options{Kinds: []string{"reaction"}, ExcludeKinds: []string{"reaction"}},
+			wantErr: "--exclude-kind excludes every kind selected with --kind - FIX: exclude fewer kinds of messages",
+		},
+		{
+			msg:     "bad match pattern",
+			opts:    options{Match: "invoice("},
+			wantErr: "compile --match pattern \"invoice(\": error parsing regexp: missing closing ): `invoice(`",
+		},
+		{
+			msg:     "bad exclude pattern",
+			opts:    options{Exclude: "[draft"},
+			wantErr: "compile --exclude pattern \"[draft\": error parsing regexp: missing closing ]: `[draft`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			f, err := newMessageFilter(tt.opts)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantActive, f.active())
+		})
+	}
+}
+
+func TestMessageFilterApply(t *testing.T) {
+	var msgs []chatdb.Message
+	for i, text := range []string{
+		"Want to play tennis?",
+		"Sure, after I send this invoice",
+		"Draft invoice attached",
+		"👍",
+		"See you at 5",
+		"Invoice paid",
+	} {
+		// Novak and David, the owner of the database, take turns.
+		msg := chatdb.Message{ID: i, Text: text, Handle: "Novak"}
+		if i%2 == 1 {
+			msg.Handle, msg.FromMe = "David", true
+		}
+		msgs = append(msgs, msg)
+	}
+
+	tests := []struct {
+		msg     string
+		opts    options
+		wantIDs []int
+	}{
+		{
+			msg:     "match",
+			opts:    options{Match: "(?i)invoice"},
+			wantIDs: []int{1, 2, 5},
+		},
+		{
+			msg:     "exclude",
+			opts:    options{Exclude: "(?i)invoice"},
+			wantIDs: []int{0, 3, 4},
+		},
+		{
+			msg:     "match with context",
+			opts:    options{Match: "paid", BeforeContext: 2, AfterContext: 1},
+			wantIDs: []int{3, 4, 5},
+		},
+		{
+			msg:     "excluded context",
+			opts:    options{Match: "tennis", Exclude: "Draft", AfterContext: 3},
+			wantIDs: []int{0, 1, 3},
+		},
+		{
+			msg:     "sender",
+			opts:    options{Senders: []string{"novak"}},
+			wantIDs: []int{0, 2, 4},
+		},
+		{
+			msg:     "me",
+			opts:    options{Senders: []string{"Me"}},
+			wantIDs: []int{1, 3, 5},
+		},
+		{
+			msg:     "self label",
+			opts:    options{Senders: []string{"David"}},
+			wantIDs: []int{1, 3, 5},
+		},
+		{
+			msg:     "several senders",
+			opts:    options{Senders: []string{"me", "Novak"}},
+			wantIDs: []int{0, 1, 2, 3, 4, 5},
+		},
+		{
+			msg:     "sender with match and context",
+			opts:    options{Match: "(?i)invoice", AfterContext: 1, Senders: []string{"Novak"}},
+			wantIDs: []int{2},
+		},
+		{
+			msg:     "kind",
+			opts:    options{Kinds: []string{"emoji"}},
+			wantIDs: []int{3},
+		},
+		{
+			msg:     "excluded kind",
+			opts:    options{ExcludeKinds: []string{"emoji"}},
+			wantIDs: []int{0, 1, 2, 4, 5},
+		},
+		{
+			msg:     "excluded kind in context",
+			opts:    options{Match: "paid", BeforeContext: 2, ExcludeKinds: []string{"emoji"}},
+			wantIDs: []int{4, 5},
+		},
+		{
+			msg:  "unknown sender",
+			opts: options{Senders: []string{"Jelena"}},
+		},
+		{
+			msg:  "no matches",
+			opts: options{Match: "dinner"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			f, err := newMessageFilter(tt.opts)
+			assert.NilError(t, err)
+			var ids []int
+			for _, msg := range f.apply(msgs) {
+				ids = append(ids, msg.ID)
+			}
+			assert.DeepEqual(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestKeepChatKind(t *testing.T) {
+	tests := []struct {
+		msg            string
+		opts           options
+		participantIDs []int
+		want           bool
+	}{
+		{
+			msg:            "no kind selected",
+			participantIDs: []int{1, 2},
+			want:           true,
+		},
+		{
+			msg:            "group chat with --only-groups",
+			opts:           options{OnlyGroups: true},
+			participantIDs: []int{1, 2},
+			want:           true,
+		},
+		{
+			msg:            "direct chat with --only-groups",
+			opts:           options{OnlyGroups: true},
+			participantIDs: []int{1},
+			want:           false,
+		},
+		{
+			msg:            "direct chat with --only-direct",
+			opts:           options{OnlyDirect: true},
+			participantIDs: []int{1},
+			want:           true,
+		},
+		{
+			msg:            "chat without participants with --only-direct",
+			opts:           options{OnlyDirect: true},
+			participantIDs: []int{},
+			want:           true,
+		},
+		{
+			msg:            "group chat with --only-direct",
+			opts:           options{OnlyDirect: true},
+			participantIDs: []int{1, 2, 3},
+			want:           false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, keepChatKind(tt.opts, tt.participantIDs))
+		})
+	}
+}
+
+func TestOptionsFiltered(t *testing.T) {
+	tests := []struct {
+		msg  string
+		opts options
+		want bool
+	}{
+		{msg: "no filters", opts: options{Format: "txt", Resume: true}},
+		{msg: "recent", opts: options{Recent: 20}, want: true},
+		{msg: "handle", opts: options{Handle: "+14155555555"}, want: true},
+		{msg: "chat", opts: options{Chat: "Novak"}, want: true},
+		{msg: "only groups", opts: options{OnlyGroups: true}, want: true},
+		{msg: "min messages", opts: options{MinMessages: 2}, want: true},
+		{msg: "match", opts: options{Match: "(?i)invoice"}, want: true},
+		{msg: "exclude", opts: options{Exclude: "spam"}, want: true},
+		{msg: "sender", opts: options{Senders: []string{"me"}}, want: true},
+		{msg: "kind", opts: options{Kinds: []string{"text"}}, want: true},
+		{msg: "exclude kind", opts: options{ExcludeKinds: []string{"reaction"}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.opts.filtered())
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+	"golang.org/x/text/unicode/norm"
+)
+
+// chatFolders names the folders of the chats so that they can be created on
+// the volume of the export folder, e.g. an exFAT drive or an SMB share. Names
+// are normalized as the volume stores them and shortened to fit, and chats
+// whose names differ but would name the same folder, e.g. "Mom" and "mom" on a
+// case-insensitive volume, are exported into numbered folders. Chats with the
+// same display name still share a folder.
+type chatFolders struct {
+	rules opsys.FilenameRules
+	// owners are the normalized display names of the chats in each folder,
+	// keyed by the path of the folder as the volume compares names.
+	owners map[string]string
+}
+
+// newChatFolders finds out how the volume of the given export folder stores
+// file names. If it cannot, the folders are named after the chats.
+func newChatFolders(s opsys.OS, exportPath string) *chatFolders {
+	rules, err := s.FilenameRules(exportPath)
+	if err != nil {
+		logging.Warnf("check file names on the volume of %q: %s - naming chat folders after the chats", exportPath, err)
+	}
+	logging.Debugf("file names on the volume of %q: %+v", exportPath, rules)
+	return &chatFolders{rules: rules, owners: map[string]string{}}
+}
+
+// name returns the name of the folder, within the given folder, of the chat
+// with the given display name.
+func (f *chatFolders) name(dir, displayName string) string {
+	name := norm.NFC.String(displayName)
+	if f.rules.Decomposed {
+		name = norm.NFD.String(displayName)
+	}
+	for i := 1; ; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = fmt.Sprintf(" (%d)", i)
+		}
+		folder := shortenName(name, suffix, f.rules.MaxNameBytes)
+		key := path.Join(dir, folder)
+		if f.rules.CaseInsensitive {
+			key = strings.ToLower(key)
+		}
+		if owner, ok := f.owners[key]; !ok || owner == name {
+			f.owners[key] = name
+			return folder
+		}
+	}
+}
+
+// shortenName returns the given name with the given suffix, shortening the
+// name so that the result is at most the given number of bytes long, unless
+// it is zero.
+func shortenName(name, suffix string, maxBytes int) string {
+	if maxBytes == 0 || len(name)+len(suffix) <= maxBytes {
+		return name + suffix
+	}
+	n := maxBytes - len(suffix)
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	return name[:n] + suffix
+}
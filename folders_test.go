@@ -0,0 +1,89 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestChatFolders(t *testing.T) {
+	type chat struct{ dir, name string }
+	tests := []struct {
+		msg   string
+		rules opsys.FilenameRules
+		chats []chat
+		want  []string
+	}{
+		{
+			msg:   "case-sensitive volume",
+			rules: opsys.FilenameRules{MaxNameBytes: 255},
+			chats: []chat{{name: "Mom"}, {name: "mom"}, {name: "Mom"}},
+			want:  []string{"Mom", "mom", "Mom"},
+		},
+		{
+			msg:   "case-insensitive volume",
+			rules: opsys.FilenameRules{CaseInsensitive: true, MaxNameBytes: 255},
+			chats: []chat{{name: "Mom"}, {name: "mom"}, {name: "Mom"}, {name: "MOM"}, {name: "mom"}},
+			want:  []string{"Mom", "mom (2)", "Mom", "MOM (3)", "mom (2)"},
+		},
+		{
+			msg:   "different folders",
+			rules: opsys.FilenameRules{CaseInsensitive: true, MaxNameBytes: 255},
+			chats: []chat{{name: "Mom"}, {dir: "unknown-senders", name: "mom"}},
+			want:  []string{"Mom", "mom"},
+		},
+		{
+			msg:   "composed",
+			rules: opsys.FilenameRules{MaxNameBytes: 255},
+			chats: []chat{{name: "Renée"}, {name: "Renée"}},
+			want:  []string{"Renée", "Renée"},
+		},
+		{
+			msg:   "decomposed",
+			rules: opsys.FilenameRules{Decomposed: true, MaxNameBytes: 255},
+			chats: []chat{{name: "Renée"}},
+			want:  []string{"Renée"},
+		},
+		{
+			msg:   "long names",
+			rules: opsys.FilenameRules{MaxNameBytes: 12},
+			chats: []chat{{name: "Novak, Rafaela"}, {name: "Novak, Rafael"}, {name: "Novak"}},
+			want:  []string{"Novak, Rafae", "Novak, R (2)", "Novak"},
+		},
+		{
+			msg:   "long name with accents",
+			rules: opsys.FilenameRules{MaxNameBytes: 6},
+			chats: []chat{{name: "Renéé"}},
+			want:  []string{"René"},
+		},
+		{
+			msg:   "unknown volume",
+			chats: []chat{{name: strings.Repeat("Novak", 100)}},
+			want:  []string{strings.Repeat("Novak", 100)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			f := &chatFolders{rules: tt.rules, owners: map[string]string{}}
+			var got []string
+			for _, c := range tt.chats {
+				got = append(got, f.name(c.dir, c.name))
+			}
+			assert.DeepEqual(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewChatFolders(t *testing.T) {
+	f := newChatFolders(opsys.NewOS(afero.NewMemMapFs(), nil, nil), "backup")
+	assert.Equal(t, opsys.FilenameRules{MaxNameBytes: 255}, f.rules)
+	f = newChatFolders(opsys.NewOS(afero.NewReadOnlyFs(afero.NewMemMapFs()), nil, nil), "backup")
+	assert.Equal(t, opsys.FilenameRules{}, f.rules)
+}
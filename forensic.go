@@ -0,0 +1,169 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const _custodyManifestFilename = "chain-of-custody.json"
+
+// _databaseSuffixes are added to the path of the Messages database to name its
+// files, i.e. the database and its write-ahead log.
+var _databaseSuffixes = []string{"", "-wal"}
+
+type (
+	// forensicRecord is a message as stored in the database, with the chat
+	// which contains it.
+	forensicRecord struct {
+		ChatROWID int    `json:"chat_rowid"`
+		ChatGUID  string `json:"chat_guid"`
+		chatdb.RawMessage
+	}
+
+	// custodyManifest records who exported what from which database, for the
+	// chain of custody of a forensic export.
+	custodyManifest struct {
+		Tool      string             `json:"tool"`
+		Version   string             `json:"version"`
+		Operator  string             `json:"operator"`
+		Host      string             `json:"host"`
+		Start     time.Time          `json:"start"`
+		End       time.Time          `json:"end"`
+		Options   options            `json:"options"`
+		Databases []databaseChecksum `json:"databases"`
+		Files     []fileChecksum     `json:"files"`
+		// copied are the checksums of the attachments, by the paths of
+		// their copies, computed while they were copied, so that large
+		// attachments are not read again.
+		copied map[string]opsys.Checksum
+	}
+
+	// databaseChecksum records the checksums of a file of the Messages
+	// database before and after the export, which differ if Messages wrote
+	// to it in the meantime.
+	databaseChecksum struct {
+		Path   string `json:"path"`
+		Before string `json:"sha256_before"`
+		After  string `json:"sha256_after"`
+	}
+
+	// fileChecksum records the size and checksum of an exported file.
+	fileChecksum struct {
+		Path   string `json:"path"`
+		Bytes  int64  `json:"bytes"`
+		SHA256 string `json:"sha256"`
+	}
+)
+
+// checkForensicOptions checks that the options do not leave out or change any
+// messages of the exported chats, which forensic exports must not do.
+func checkForensicOptions(opts options) error {
+	if opts.Forensic && (opts.Match != "" || opts.Exclude != "" || opts.DedupWindow > 0 || len(opts.Senders) > 0 || len(opts.Kinds) > 0 || len(opts.ExcludeKinds) > 0) {
+		return errors.New("forensic exports include every message of the exported chats - FIX: remove the --match, --exclude, --dedup-window, --sender, --kind, and --exclude-kind options")
+	}
+	return nil
+}
+
+// writeRawMessages writes the given messages of a chat as stored in the
+// database into the given folder, as a JSON object per line, returning the
+// path of the file.
+func writeRawMessages(s opsys.OS, dirPath string, chat chatdb.Chat, msgs []chatdb.RawMessage) (string, error) {
+	rawPath := path.Join(dirPath, fmt.Sprintf("%s.raw.jsonl", chat.GUID))
+	err := exporter.WriteFile(s, rawPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, msg := range msgs {
+			if err := enc.Encode(forensicRecord{ChatROWID: chat.ID, ChatGUID: chat.GUID, RawMessage: msg}); err != nil {
+				return errors.Wrapf(err, "write message with ID %d", msg.ROWID)
+			}
+		}
+		return nil
+	})
+	return rawPath, err
+}
+
+// newCustodyManifest starts the chain-of-custody manifest of an export
+// starting at the given time, recording the checksums of the files of the
+// Messages database before the export.
+func newCustodyManifest(s opsys.OS, opts options, start time.Time) (*custodyManifest, error) {
+	dbPath, err := s.ExpandHome(opts.DBPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "expand DB path %q", opts.DBPath)
+	}
+	m := custodyManifest{Tool: "bagoup", Version: _version, Start: start, Options: opts}
+	if u, err := user.Current(); err == nil {
+		m.Operator = u.Username
+	}
+	m.Host, _ = s.Hostname()
+	for _, suffix := range _databaseSuffixes {
+		filePath := dbPath + suffix
+		if suffix != "" {
+			if exist, err := afero.Exists(s, filePath); err != nil {
+				return nil, errors.Wrapf(err, "check DB file %q", filePath)
+			} else if !exist {
+				continue
+			}
+		}
+		_, sum, err := checksumFile(s, filePath)
+		if err != nil {
+			return nil, err
+		}
+		m.Databases = append(m.Databases, databaseChecksum{Path: filePath, Before: sum})
+	}
+	return &m, nil
+}
+
+// finish records the checksums of the files of the Messages database after
+// the export, which ended at the given time, and of the exported files, and
+// writes the manifest into the export folder.
+func (m *custodyManifest) finish(s opsys.OS, exportPath string, end time.Time) error {
+	m.End = end
+	for i, db := range m.Databases {
+		_, sum, err := checksumFile(s, db.Path)
+		if err != nil {
+			return err
+		}
+		m.Databases[i].After = sum
+		if sum != db.Before {
+			logging.Warnf("DB file %q changed during the export - the exported chats may not match either checksum", db.Path)
+		}
+	}
+	manifestPath := path.Join(exportPath, _custodyManifestFilename)
+	m.Files = []fileChecksum{}
+	err := afero.Walk(s, exportPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filePath == manifestPath {
+			return err
+		}
+		n, sum := int64(0), ""
+		if copied, ok := m.copied[filePath]; ok {
+			n, sum = copied.Bytes, copied.SHA256
+		} else if n, sum, err = checksumFile(s, filePath); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(exportPath, filePath)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, fileChecksum{Path: filepath.ToSlash(rel), Bytes: n, SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "checksum files in export folder %q", exportPath)
+	}
+	return exporter.WriteFile(s, manifestPath, writeJSON(m))
+}
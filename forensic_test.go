@@ -0,0 +1,145 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckForensicOptions(t *testing.T) {
+	assert.NilError(t, checkForensicOptions(options{Forensic: true, Handle: "+14155555555"}))
+	assert.NilError(t, checkForensicOptions(options{Match: "invoice"}))
+	assert.Error(t, checkForensicOptions(options{Forensic: true, DedupWindow: 120}), "forensic exports include every message of the exported chats - FIX: remove the --match, --exclude, --dedup-window, --sender, --kind, and --exclude-kind options")
+	assert.Error(t, checkForensicOptions(options{Forensic: true, Senders: []string{"me"}}), "forensic exports include every message of the exported chats - FIX: remove the --match, --exclude, --dedup-window, --sender, --kind, and --exclude-kind options")
+	assert.Error(t, checkForensicOptions(options{Forensic: true, ExcludeKinds: []string{"reaction"}}), "forensic exports include every message of the exported chats - FIX: remove the --match, --exclude, --dedup-window, --sender, --kind, and --exclude-kind options")
+}
+
+func TestWriteRawMessages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	text := "message text"
+	msgs := []chatdb.RawMessage{
+		{ROWID: 100, GUID: "msgguid1", HandleID: 10, Text: &text, Service: "iMessage", Date: 591935191000000000},
+		{ROWID: 101, GUID: "msgguid2", IsFromMe: true, Service: "SMS", Date: 591935191},
+	}
+
+	rawPath, err := writeRawMessages(s, "backup/Novak", chatdb.Chat{ID: 1, GUID: "testguid"}, msgs)
+	assert.NilError(t, err)
+	assert.Equal(t, "backup/Novak/testguid.raw.jsonl", rawPath)
+	contents, err := afero.ReadFile(fs, rawPath)
+	assert.NilError(t, err)
+	assert.Equal(t, `{"chat_rowid":1,"chat_guid":"testguid","rowid":100,"guid":"msgguid1","handle_id":10,"text":"message text","service":"iMessage","account":"","date":591935191000000000,"date_read":0,"date_delivered":0,"is_from_me":false,"is_read":false,"is_delivered":false,"is_sent":false,"item_type":0,"group_action_type":0,"error":0}
+{"chat_rowid":1,"chat_guid":"testguid","rowid":101,"guid":"msgguid2","handle_id":0,"text":null,"service":"SMS","account":"","date":591935191,"date_read":0,"date_delivered":0,"is_from_me":true,"is_read":false,"is_delivered":false,"is_sent":false,"item_type":0,"group_action_type":0,"error":0}
+`, string(contents))
+}
+
+func TestCustodyManifest(t *testing.T) {
+	start := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	tests := []struct {
+		msg           string
+		setupFs       func(afero.Fs)
+		changeDB      bool
+		wantDatabases []databaseChecksum
+		wantErr       string
+	}{
+		{
+			msg: "database without write-ahead log",
+			wantDatabases: []databaseChecksum{
+				{
+					Path:   "test/chat.db",
+					Before: "a4188a305e9c314cd57216ac73c079e96ec166884d15a2b58218f35926db3c62",
+					After:  "a4188a305e9c314cd57216ac73c079e96ec166884d15a2b58218f35926db3c62",
+				},
+			},
+		},
+		{
+			msg: "database changed during export",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "test/chat.db-wal", []byte("wal"), 0600)
+			},
+			changeDB: true,
+			wantDatabases: []databaseChecksum{
+				{
+					Path:   "test/chat.db",
+					Before: "a4188a305e9c314cd57216ac73c079e96ec166884d15a2b58218f35926db3c62",
+					After:  "a4188a305e9c314cd57216ac73c079e96ec166884d15a2b58218f35926db3c62",
+				},
+				{
+					Path:   "test/chat.db-wal",
+					Before: "27a75a1c9d8f31b0bc4ca4889e25fe6413f00d78d8578a36e4cce1c38c452e45",
+					After:  "c3552e097973d10e6aeb07435f9f2e733326067108dafaa50c67fc4d45d33460",
+				},
+			},
+		},
+		{
+			msg: "missing database",
+			setupFs: func(fs afero.Fs) {
+				fs.Remove("test/chat.db")
+			},
+			wantErr: `open file "test/chat.db"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, "test/chat.db", []byte("chat.db"), 0600))
+			assert.NilError(t, afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("line1\n"), 0600))
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+			s := opsys.NewOS(fs, nil, nil)
+			opts := options{DBPath: "test/chat.db", ExportPath: "backup", Forensic: true}
+
+			m, err := newCustodyManifest(s, opts, start)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			if tt.changeDB {
+				assert.NilError(t, afero.WriteFile(fs, "test/chat.db-wal", []byte("wal2"), 0600))
+			}
+			assert.NilError(t, m.finish(s, "backup", start.Add(time.Minute)))
+
+			contents, err := afero.ReadFile(fs, "backup/chain-of-custody.json")
+			assert.NilError(t, err)
+			var got custodyManifest
+			assert.NilError(t, json.Unmarshal(contents, &got))
+			assert.Equal(t, "bagoup", got.Tool)
+			assert.Equal(t, _version, got.Version)
+			assert.Equal(t, start, got.Start.UTC())
+			assert.Equal(t, start.Add(time.Minute), got.End.UTC())
+			assert.DeepEqual(t, tt.wantDatabases, got.Databases)
+			assert.DeepEqual(t, []fileChecksum{
+				{Path: "Novak/testguid.txt", Bytes: 6, SHA256: "cd205f1f8b8ab1bf7da554fd3460b5d377c587eb7fa4f394c3f403af3a787a1b"},
+			}, got.Files)
+		})
+	}
+}
+
+func TestCustodyManifestCopied(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NilError(t, afero.WriteFile(fs, "test/chat.db", []byte("chat.db"), 0600))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/attachments/video.mov", []byte("mov data"), 0600))
+	s := opsys.NewOS(fs, nil, nil)
+	m, err := newCustodyManifest(s, options{DBPath: "test/chat.db", ExportPath: "backup", Forensic: true}, time.Now())
+	assert.NilError(t, err)
+	// The checksum recorded while copying is used without reading the copy.
+	m.copied = map[string]opsys.Checksum{"backup/Novak/attachments/video.mov": {Bytes: 1 << 30, SHA256: "copiedsum"}}
+	assert.NilError(t, m.finish(s, "backup", time.Now()))
+
+	contents, err := afero.ReadFile(fs, "backup/chain-of-custody.json")
+	assert.NilError(t, err)
+	var got custodyManifest
+	assert.NilError(t, json.Unmarshal(contents, &got))
+	assert.DeepEqual(t, []fileChecksum{{Path: "Novak/attachments/video.mov", Bytes: 1 << 30, SHA256: "copiedsum"}}, got.Files)
+}
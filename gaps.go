@@ -0,0 +1,63 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/stats"
+)
+
+// _gapReportFilename is the name of the report of gaps without messages in
+// active chats, written into the export folder.
+const _gapReportFilename = "gap-report.csv"
+
+// _gapActiveMessages is the number of messages which a chat must have within
+// the minimum gap length both before and after a gap for the gap to be
+// reported.
+const _gapActiveMessages = 10
+
+// _gapDateLayout formats the dates of the messages around gaps, as in txt
+// exports.
+const _gapDateLayout = "2006-01-02 15:04:05"
+
+// findChatGaps returns a report record for each gap of at least the given
+// number of days without messages in the given chat.
+func findChatGaps(chat chatdb.Chat, msgs []chatdb.Message, gapDays int) [][]string {
+	dates := make([]time.Time, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.DateSource != chatdb.DateUnknown {
+			dates = append(dates, msg.Date)
+		}
+	}
+	var records [][]string
+	for _, gap := range stats.FindGaps(dates, time.Duration(gapDays)*24*time.Hour, _gapActiveMessages) {
+		records = append(records, []string{
+			chat.DisplayName,
+			gap.Start.Format(_gapDateLayout),
+			gap.End.Format(_gapDateLayout),
+			fmt.Sprint(gap.Days()),
+			fmt.Sprint(gap.Before),
+			fmt.Sprint(gap.After),
+		})
+	}
+	return records
+}
+
+// writeGapReport writes the given gaps to a CSV file in the export folder,
+// returning the path of the file.
+func writeGapReport(s opsys.OS, exportPath string, gaps [][]string) (string, error) {
+	reportPath := path.Join(exportPath, _gapReportFilename)
+	records := append([][]string{{"chat", "last_message_before", "first_message_after", "days", "messages_before", "messages_after"}}, gaps...)
+	return reportPath, exporter.WriteFile(s, reportPath, func(w io.Writer) error {
+		return csv.NewWriter(w).WriteAll(records)
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestFindChatGaps(t *testing.T) {
+	jan := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	jun := time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC)
+	var msgs []chatdb.Message
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs,
+			chatdb.Message{Date: jan.AddDate(0, 0, i)},
+			chatdb.Message{Date: jun.AddDate(0, 0, i)},
+		)
+	}
+	chat := chatdb.Chat{DisplayName: "Novak"}
+
+	assert.DeepEqual(t, [][]string{
+		{"Novak", "2020-01-10 12:00:00", "2020-06-01 12:00:00", "143", "10", "10"},
+	}, findChatGaps(chat, msgs, 30))
+	assert.Equal(t, 0, len(findChatGaps(chat, msgs, 180)))
+
+	// Messages without dates do not close gaps.
+	msgs = append(msgs, chatdb.Message{DateSource: chatdb.DateUnknown})
+	assert.Equal(t, 1, len(findChatGaps(chat, msgs, 30)))
+}
+
+func TestWriteGapReport(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	reportPath, err := writeGapReport(s, "backup", [][]string{
+		{"Novak", "2020-01-10 12:00:00", "2020-06-01 12:00:00", "143", "10", "10"},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, "backup/gap-report.csv", reportPath)
+	contents, err := afero.ReadFile(fs, reportPath)
+	assert.NilError(t, err)
+	assert.Equal(t, "chat,last_message_before,first_message_after,days,messages_before,messages_after\nNovak,2020-01-10 12:00:00,2020-06-01 12:00:00,143,10,10\n", string(contents))
+
+	_, err = writeGapReport(opsys.NewOS(afero.NewReadOnlyFs(fs), nil, nil), "backup", nil)
+	assert.ErrorContains(t, err, `create file "backup/gap-report.csv.partial"`)
+}
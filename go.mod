@@ -1,6 +1,6 @@
 module github.com/tagatac/bagoup
 
-go 1.14
+go 1.16
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.4.1
@@ -11,7 +11,7 @@ require (
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/afero v1.2.2
-	golang.org/x/text v0.3.2 // indirect
+	golang.org/x/text v0.3.2
 	golang.org/x/tools v0.0.0-20200329025819-fd4102a86c65 // indirect
 	gotest.tools/v3 v3.0.2
 )
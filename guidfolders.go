@@ -0,0 +1,32 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"path"
+
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _guidFolderTemplate names the chat folders after the GUIDs of the chats with
+// --guid-folders, so that they keep their names when contacts are renamed.
+const _guidFolderTemplate = "{{.GUID}}"
+
+// _chatIndexFilename is the name of the index of the chat folders named after
+// GUIDs, written into the export folder.
+const _chatIndexFilename = "chat-index.csv"
+
+// writeChatIndex writes the given folders, GUIDs, and display names of the
+// chats to a CSV file in the export folder, so that the folders named after
+// GUIDs can be told apart, returning the path of the file.
+func writeChatIndex(s opsys.OS, exportPath string, chats [][]string) (string, error) {
+	indexPath := path.Join(exportPath, _chatIndexFilename)
+	records := append([][]string{{"folder", "guid", "name"}}, chats...)
+	return indexPath, exporter.WriteFile(s, indexPath, func(w io.Writer) error {
+		return csv.NewWriter(w).WriteAll(records)
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteChatIndex(t *testing.T) {
+	tests := []struct {
+		msg     string
+		roFs    bool
+		want    string
+		wantErr string
+	}{
+		{
+			msg:  "success",
+			want: "folder,guid,name\nunknown-senders/SMS;-;12345,SMS;-;12345,12345\niMessage;+;chat123456,iMessage;+;chat123456,\"Novak, Rafa\"\n",
+		},
+		{
+			msg:     "read-only filesystem",
+			roFs:    true,
+			wantErr: "backup/chat-index.csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.roFs {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+			s := opsys.NewOS(fs, nil, nil)
+			indexPath, err := writeChatIndex(s, "backup", [][]string{
+				{"unknown-senders/SMS;-;12345", "SMS;-;12345", "12345"},
+				{"iMessage;+;chat123456", "iMessage;+;chat123456", "Novak, Rafa"},
+			})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, "backup/chat-index.csv", indexPath)
+			b, err := afero.ReadFile(fs, indexPath)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, string(b))
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _hashChainSuffix is added to the name of an exported chat file to name the
+// file recording its hash chain.
+const _hashChainSuffix = ".sha256chain"
+
+type verifyOptions struct{}
+
+// hashChain returns the hash chain over the lines of the given reader, in hex.
+// The hash of each line is the SHA-256 hash of the hash of the previous line
+// followed by the line, including its line ending, so that changing, adding,
+// removing, or reordering any line changes the hashes of all the lines after
+// it.
+func hashChain(r io.Reader) ([]string, error) {
+	var chain []string
+	var prev []byte
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			h := sha256.New()
+			h.Write(prev)
+			h.Write(line)
+			prev = h.Sum(nil)
+			chain = append(chain, hex.EncodeToString(prev))
+		}
+		if err == io.EOF {
+			return chain, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fileHashChain returns the hash chain over the lines of the file at the
+// given path.
+func fileHashChain(s opsys.OS, filePath string) ([]string, error) {
+	f, err := s.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", filePath)
+	}
+	defer f.Close()
+	chain, err := hashChain(f)
+	return chain, errors.Wrapf(err, "read file %q", filePath)
+}
+
+// writeHashChain records the hash chain of the exported chat file at the
+// given path next to it, with the hash of each line on a line of its own.
+// Chats exported to folders have no single file to chain, so they are skipped.
+func writeHashChain(s opsys.OS, chatPath string) error {
+	info, err := s.Stat(chatPath)
+	if err != nil {
+		return errors.Wrapf(err, "get file info of %q", chatPath)
+	}
+	if info.IsDir() {
+		logging.Warnf("chat %q is exported to a folder - skipping its hash chain", chatPath)
+		return nil
+	}
+	chain, err := fileHashChain(s, chatPath)
+	if err != nil {
+		return err
+	}
+	return exporter.WriteFile(s, chatPath+_hashChainSuffix, func(w io.Writer) error {
+		for _, hash := range chain {
+			if _, err := fmt.Fprintln(w, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// verifyHashChains checks the exported chat files in the given export folder
+// against their recorded hash chains, returning the number of chains checked
+// and a description of each mismatch.
+func verifyHashChains(s opsys.OS, exportPath string) (int, []string, error) {
+	checked := 0
+	var problems []string
+	err := afero.Walk(s, exportPath, func(chainPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(chainPath, _hashChainSuffix) {
+			return nil
+		}
+		checked++
+		chatPath := strings.TrimSuffix(chainPath, _hashChainSuffix)
+		want, err := afero.ReadFile(s, chainPath)
+		if err != nil {
+			return errors.Wrapf(err, "read file %q", chainPath)
+		}
+		if exist, err := s.FileExist(chatPath); err != nil {
+			return errors.Wrapf(err, "check chat file %q", chatPath)
+		} else if !exist {
+			problems = append(problems, fmt.Sprintf("chat file %q is missing", chatPath))
+			return nil
+		}
+		got, err := fileHashChain(s, chatPath)
+		if err != nil {
+			return err
+		}
+		if problem := compareHashChains(strings.Fields(string(want)), got); problem != "" {
+			problems = append(problems, fmt.Sprintf("chat file %q %s", chatPath, problem))
+		}
+		return nil
+	})
+	if err != nil {
+		return checked, nil, errors.Wrapf(err, "walk export folder %q", exportPath)
+	}
+	return checked, problems, nil
+}
+
+// compareHashChains describes the first difference between the recorded and
+// the recomputed hash chain of a file, or returns an empty string if they
+// match.
+func compareHashChains(want, got []string) string {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		if want[i] != got[i] {
+			return fmt.Sprintf("was modified at line %d", i+1)
+		}
+	}
+	switch {
+	case len(got) < len(want):
+		return fmt.Sprintf("has %d lines but %d were recorded", len(got), len(want))
+	case len(got) > len(want):
+		return fmt.Sprintf("has %d lines but only %d were recorded", len(got), len(want))
+	}
+	return ""
+}
+
+// verify checks the hash chains recorded in the export folder, failing if any
+// chat file does not match its chain.
+func verify(opts options, s opsys.OS) error {
+	checked, problems, err := verifyHashChains(s, opts.ExportPath)
+	if err != nil {
+		return err
+	}
+	if checked == 0 {
+		return fmt.Errorf("no hash chains found in export folder %q - FIX: export with the --hash-chain option", opts.ExportPath)
+	}
+	for _, problem := range problems {
+		logging.Warnf("%s", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d of %d chat files do not match their hash chains", len(problems), checked)
+	}
+	logging.Infof("%d chat files match their hash chains", checked)
+	return nil
+}
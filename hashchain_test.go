@@ -0,0 +1,130 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestHashChain(t *testing.T) {
+	chain, err := hashChain(strings.NewReader("first\nsecond\nthird"))
+	assert.NilError(t, err)
+	assert.Equal(t, 3, len(chain))
+
+	changed, err := hashChain(strings.NewReader("first\nSECOND\nthird"))
+	assert.NilError(t, err)
+	assert.Equal(t, chain[0], changed[0])
+	assert.Assert(t, chain[1] != changed[1])
+	assert.Assert(t, chain[2] != changed[2], "change not carried along the chain")
+
+	empty, err := hashChain(strings.NewReader(""))
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(empty))
+}
+
+func TestWriteHashChain(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, fs.Stat, nil)
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("line1\nline2\n"), 0600))
+	assert.NilError(t, fs.MkdirAll("backup/novak", 0700))
+
+	assert.NilError(t, writeHashChain(s, "backup/Novak/testguid.txt"))
+	chain, err := afero.ReadFile(fs, "backup/Novak/testguid.txt.sha256chain")
+	assert.NilError(t, err)
+	assert.Equal(t, 2, len(strings.Fields(string(chain))))
+
+	assert.NilError(t, writeHashChain(s, "backup/novak"))
+	exist, err := afero.Exists(fs, "backup/novak.sha256chain")
+	assert.NilError(t, err)
+	assert.Assert(t, !exist, "hash chain written for a folder")
+
+	assert.ErrorContains(t, writeHashChain(s, "backup/missing.txt"), `get file info of "backup/missing.txt"`)
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		msg     string
+		modify  func(afero.Fs)
+		wantErr string
+	}{
+		{
+			msg: "unchanged",
+		},
+		{
+			msg: "modified line",
+			modify: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("line1\nLINE2\nline3\n"), 0600)
+			},
+			wantErr: "1 of 2 chat files do not match their hash chains",
+		},
+		{
+			msg: "removed line",
+			modify: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("line1\nline2\n"), 0600)
+			},
+			wantErr: "1 of 2 chat files do not match their hash chains",
+		},
+		{
+			msg: "missing chat file",
+			modify: func(fs afero.Fs) {
+				fs.Remove("backup/Rafa/testguid2.txt")
+			},
+			wantErr: "1 of 2 chat files do not match their hash chains",
+		},
+		{
+			msg: "no hash chains",
+			modify: func(fs afero.Fs) {
+				fs.Remove("backup/Novak/testguid.txt.sha256chain")
+				fs.Remove("backup/Rafa/testguid2.txt.sha256chain")
+			},
+			wantErr: `no hash chains found in export folder "backup" - FIX: export with the --hash-chain option`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			s := opsys.NewOS(fs, fs.Stat, nil)
+			assert.NilError(t, afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("line1\nline2\nline3\n"), 0600))
+			assert.NilError(t, afero.WriteFile(fs, "backup/Rafa/testguid2.txt", []byte("line1\n"), 0600))
+			assert.NilError(t, writeHashChain(s, "backup/Novak/testguid.txt"))
+			assert.NilError(t, writeHashChain(s, "backup/Rafa/testguid2.txt"))
+			if tt.modify != nil {
+				tt.modify(fs)
+			}
+
+			err := verify(options{ExportPath: "backup"}, s)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestCompareHashChains(t *testing.T) {
+	tests := []struct {
+		msg         string
+		want        []string
+		got         []string
+		wantProblem string
+	}{
+		{msg: "match", want: []string{"a", "b"}, got: []string{"a", "b"}},
+		{msg: "modified", want: []string{"a", "b"}, got: []string{"a", "c"}, wantProblem: "was modified at line 2"},
+		{msg: "truncated", want: []string{"a", "b"}, got: []string{"a"}, wantProblem: "has 1 lines but 2 were recorded"},
+		{msg: "extended", want: []string{"a"}, got: []string{"a", "b"}, wantProblem: "has 2 lines but only 1 were recorded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.wantProblem, compareHashChains(tt.want, tt.got))
+		})
+	}
+}
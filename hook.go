@@ -0,0 +1,43 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// chatHookInfo describes an exported chat to the post-chat hook, both as JSON
+// on its standard input and as environment variables.
+type chatHookInfo struct {
+	GUID        string `json:"guid"`
+	DisplayName string `json:"display_name"`
+	Path        string `json:"path"`
+	Format      string `json:"format"`
+	Messages    int    `json:"messages"`
+	Pinned      bool   `json:"pinned"`
+	Archived    bool   `json:"archived"`
+}
+
+// runPostChatHook runs the given hook command for an exported chat.
+func runPostChatHook(s opsys.OS, command string, info chatHookInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "encode chat info")
+	}
+	env := []string{
+		fmt.Sprintf("BAGOUP_CHAT_GUID=%s", info.GUID),
+		fmt.Sprintf("BAGOUP_CHAT_NAME=%s", info.DisplayName),
+		fmt.Sprintf("BAGOUP_CHAT_PATH=%s", info.Path),
+		fmt.Sprintf("BAGOUP_FORMAT=%s", info.Format),
+		fmt.Sprintf("BAGOUP_MESSAGES=%d", info.Messages),
+		fmt.Sprintf("BAGOUP_CHAT_PINNED=%t", info.Pinned),
+		fmt.Sprintf("BAGOUP_CHAT_ARCHIVED=%t", info.Archived),
+	}
+	return s.RunHook(command, env, bytes.NewReader(b))
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestRunPostChatHook(t *testing.T) {
+	info := chatHookInfo{
+		GUID:        "iMessage;-;+3815555555",
+		DisplayName: "Novak Djokovic",
+		Path:        "backup/Novak Djokovic/iMessage;-;+3815555555.txt",
+		Format:      "txt",
+		Messages:    5,
+		Pinned:      true,
+	}
+	wantEnv := []string{
+		"BAGOUP_CHAT_GUID=iMessage;-;+3815555555",
+		"BAGOUP_CHAT_NAME=Novak Djokovic",
+		"BAGOUP_CHAT_PATH=backup/Novak Djokovic/iMessage;-;+3815555555.txt",
+		"BAGOUP_FORMAT=txt",
+		"BAGOUP_MESSAGES=5",
+		"BAGOUP_CHAT_PINNED=true",
+		"BAGOUP_CHAT_ARCHIVED=false",
+	}
+	wantStdin := `{"guid":"iMessage;-;+3815555555","display_name":"Novak Djokovic","path":"backup/Novak Djokovic/iMessage;-;+3815555555.txt","format":"txt","messages":5,"pinned":true,"archived":false}`
+
+	tests := []struct {
+		msg     string
+		hookErr error
+		wantErr string
+	}{
+		{
+			msg: "success",
+		},
+		{
+			msg:     "hook error",
+			hookErr: errors.New("this is a hook error"),
+			wantErr: "this is a hook error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			osMock.EXPECT().RunHook("./index.sh", wantEnv, gomock.Any()).DoAndReturn(
+				func(_ string, _ []string, stdin io.Reader) error {
+					b, err := ioutil.ReadAll(stdin)
+					assert.NilError(t, err)
+					assert.Equal(t, wantStdin, string(b))
+					return tt.hookErr
+				},
+			)
+
+			err := runPostChatHook(osMock, "./index.sh", info)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
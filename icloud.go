@@ -0,0 +1,175 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _iCloudSkipListFilename is the name of the list of attachments which could
+// not be downloaded from iCloud, written to the export folder. Later exports
+// into the same folder skip them without waiting for them again.
+const _iCloudSkipListFilename = "icloud-skipped.txt"
+
+const (
+	// _iCloudPollInterval is how often a download from iCloud is checked.
+	_iCloudPollInterval = time.Second
+	// _iCloudProgressInterval is how often the progress of a download from
+	// iCloud is logged.
+	_iCloudProgressInterval = 10 * time.Second
+)
+
+// iCloudDownloader downloads attachments which Optimize Mac Storage keeps only
+// in iCloud, so that they can be copied, waiting at most a timeout for each.
+// Attachments which cannot be downloaded are added to a skip list in the
+// export folder.
+type iCloudDownloader struct {
+	s          opsys.OS
+	timeout    time.Duration
+	now        func() time.Time
+	sleep      func(time.Duration)
+	skipPath   string
+	skipped    map[string]bool
+	downloaded int
+}
+
+// newICloudDownloader returns a downloader waiting at most the given number of
+// seconds for each attachment, with the skip list of the given export folder.
+func newICloudDownloader(s opsys.OS, exportPath string, timeoutSeconds int) (*iCloudDownloader, error) {
+	d := &iCloudDownloader{
+		s:        s,
+		timeout:  time.Duration(timeoutSeconds) * time.Second,
+		now:      time.Now,
+		sleep:    time.Sleep,
+		skipPath: path.Join(exportPath, _iCloudSkipListFilename),
+		skipped:  map[string]bool{},
+	}
+	if exist, err := afero.Exists(s, d.skipPath); err != nil {
+		return nil, errors.Wrapf(err, "check existence of iCloud skip list %q", d.skipPath)
+	} else if !exist {
+		return d, nil
+	}
+	b, err := afero.ReadFile(s, d.skipPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read iCloud skip list %q", d.skipPath)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			d.skipped[line] = true
+		}
+	}
+	logging.Infof("skipping %d attachments listed in %q which could not be downloaded from iCloud before - FIX: delete the list to try them again", len(d.skipped), d.skipPath)
+	return d, nil
+}
+
+// fetch downloads those of the given attachments which are only stored in
+// iCloud. It returns the attachments with those which could not be downloaded
+// left without a path, so that they are not copied.
+func (d *iCloudDownloader) fetch(attachments []chatdb.Attachment) ([]chatdb.Attachment, error) {
+	if d == nil {
+		return attachments, nil
+	}
+	var fetched []chatdb.Attachment
+	for i, att := range attachments {
+		if att.Filename == "" || leavesFolder(att.Filename) {
+			continue
+		}
+		attPath, err := d.s.ExpandHome(att.Filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expand attachment path %q", att.Filename)
+		}
+		ok := !d.skipped[attPath]
+		if ok {
+			if ok, err = d.download(attPath, att.TotalBytes); err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			continue
+		}
+		if fetched == nil {
+			// The attachments are shared with other lookups, so they are
+			// copied before they are changed.
+			fetched = append([]chatdb.Attachment(nil), attachments...)
+		}
+		fetched[i].Filename = ""
+	}
+	if fetched == nil {
+		return attachments, nil
+	}
+	return fetched, nil
+}
+
+// download downloads the attachment at the given path, of the given size in
+// bytes, if it is only stored in iCloud, and checks if it is stored locally.
+func (d *iCloudDownloader) download(attPath string, size int64) (bool, error) {
+	if exist, err := afero.Exists(d.s, attPath); err != nil {
+		return false, errors.Wrapf(err, "check existence of attachment %q", attPath)
+	} else if !exist {
+		return true, nil
+	}
+	dataless, err := d.s.Dataless(attPath)
+	if err != nil {
+		return false, errors.Wrapf(err, "check if attachment %q is in iCloud", attPath)
+	}
+	if !dataless {
+		return true, nil
+	}
+	logging.Infof("downloading attachment %q (%d bytes) from iCloud", attPath, size)
+	if err := d.s.RequestDownload(attPath); err != nil {
+		logging.Warnf("cannot download attachment %q from iCloud - skipping it: %s", attPath, err)
+		return false, d.skip(attPath)
+	}
+	start := d.now()
+	reported := start
+	for {
+		dataless, err := d.s.Dataless(attPath)
+		if err != nil {
+			return false, errors.Wrapf(err, "check if attachment %q is in iCloud", attPath)
+		}
+		if !dataless {
+			d.downloaded++
+			return true, nil
+		}
+		now := d.now()
+		if waited := now.Sub(start); waited >= d.timeout {
+			logging.Warnf("attachment %q did not download from iCloud within %s - skipping it", attPath, d.timeout)
+			return false, d.skip(attPath)
+		} else if now.Sub(reported) >= _iCloudProgressInterval {
+			logging.Infof("still downloading attachment %q from iCloud after %s", attPath, waited.Round(time.Second))
+			reported = now
+		}
+		d.sleep(_iCloudPollInterval)
+	}
+}
+
+// skip adds the attachment at the given path to the skip list.
+func (d *iCloudDownloader) skip(attPath string) error {
+	d.skipped[attPath] = true
+	paths := make([]string, 0, len(d.skipped))
+	for p := range d.skipped {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return exporter.WriteFile(d.s, d.skipPath, func(w io.Writer) error {
+		for _, p := range paths {
+			if _, err := fmt.Fprintln(w, p); err != nil {
+				return errors.Wrapf(err, "write file %q", d.skipPath)
+			}
+		}
+		return nil
+	})
+}
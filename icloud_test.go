@@ -0,0 +1,157 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+// iCloudOS is an OS whose dataless files download after they are checked the
+// given number of times following a download request.
+type iCloudOS struct {
+	opsys.OS
+	dataless   map[string]int
+	requested  map[string]bool
+	requestErr error
+}
+
+func (s *iCloudOS) Dataless(p string) (bool, error) {
+	checks, ok := s.dataless[p]
+	if !ok {
+		return false, nil
+	}
+	if s.requested[p] {
+		if checks == 0 {
+			delete(s.dataless, p)
+			return false, nil
+		}
+		s.dataless[p] = checks - 1
+	}
+	return true, nil
+}
+
+func (s *iCloudOS) RequestDownload(p string) error {
+	if s.requestErr != nil {
+		return s.requestErr
+	}
+	s.requested[p] = true
+	return nil
+}
+
+func TestNewICloudDownloader(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	d, err := newICloudDownloader(s, "backup", 300)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(d.skipped))
+	assert.Equal(t, 5*time.Minute, d.timeout)
+
+	assert.NilError(t, afero.WriteFile(fs, "backup/icloud-skipped.txt", []byte("/attachments/a.jpeg\n/attachments/b.jpeg\n"), 0644))
+	d, err = newICloudDownloader(s, "backup", 300)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string]bool{"/attachments/a.jpeg": true, "/attachments/b.jpeg": true}, d.skipped)
+}
+
+func TestICloudDownloaderFetch(t *testing.T) {
+	attachments := []chatdb.Attachment{
+		{ID: 1, Filename: "/attachments/jane.vcf"},
+		{ID: 2, Filename: "/attachments/IMG_0001.jpeg", TotalBytes: 2048},
+	}
+
+	tests := []struct {
+		msg            string
+		dataless       map[string]int
+		requestErr     error
+		skipList       string
+		wantFilenames  []string
+		wantDownloaded int
+		wantSleep      time.Duration
+		wantSkipList   string
+	}{
+		{
+			msg:           "stored locally",
+			wantFilenames: []string{"/attachments/jane.vcf", "/attachments/IMG_0001.jpeg"},
+		},
+		{
+			msg:            "downloaded",
+			dataless:       map[string]int{"/attachments/IMG_0001.jpeg": 12},
+			wantFilenames:  []string{"/attachments/jane.vcf", "/attachments/IMG_0001.jpeg"},
+			wantDownloaded: 1,
+			wantSleep:      12 * time.Second,
+		},
+		{
+			msg:           "timeout",
+			dataless:      map[string]int{"/attachments/IMG_0001.jpeg": 100},
+			wantFilenames: []string{"/attachments/jane.vcf", ""},
+			wantSleep:     30 * time.Second,
+			wantSkipList:  "/attachments/IMG_0001.jpeg\n",
+		},
+		{
+			msg:           "download error",
+			dataless:      map[string]int{"/attachments/IMG_0001.jpeg": 0},
+			requestErr:    errors.New("this is a brctl error"),
+			skipList:      "/attachments/a.jpeg\n",
+			wantFilenames: []string{"/attachments/jane.vcf", ""},
+			wantSkipList:  "/attachments/IMG_0001.jpeg\n/attachments/a.jpeg\n",
+		},
+		{
+			msg:           "skipped before",
+			dataless:      map[string]int{"/attachments/IMG_0001.jpeg": 0},
+			skipList:      "/attachments/IMG_0001.jpeg\n",
+			wantFilenames: []string{"/attachments/jane.vcf", ""},
+			wantSkipList:  "/attachments/IMG_0001.jpeg\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/attachments/jane.vcf", []byte("BEGIN:VCARD\nEND:VCARD\n"), 0644)
+			afero.WriteFile(fs, "/attachments/IMG_0001.jpeg", nil, 0644)
+			if tt.skipList != "" {
+				afero.WriteFile(fs, "backup/icloud-skipped.txt", []byte(tt.skipList), 0644)
+			}
+			s := &iCloudOS{OS: opsys.NewOS(fs, nil, nil), dataless: tt.dataless, requested: map[string]bool{}, requestErr: tt.requestErr}
+			d, err := newICloudDownloader(s, "backup", 30)
+			assert.NilError(t, err)
+			now := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+			var slept time.Duration
+			d.now = func() time.Time { return now.Add(slept) }
+			d.sleep = func(d time.Duration) { slept += d }
+
+			fetched, err := d.fetch(attachments)
+			assert.NilError(t, err)
+			filenames := make([]string, len(fetched))
+			for i, att := range fetched {
+				filenames[i] = att.Filename
+			}
+			assert.DeepEqual(t, tt.wantFilenames, filenames)
+			assert.Equal(t, "/attachments/IMG_0001.jpeg", attachments[1].Filename)
+			assert.Equal(t, tt.wantDownloaded, d.downloaded)
+			assert.Equal(t, tt.wantSleep, slept)
+			skipList, err := afero.ReadFile(fs, "backup/icloud-skipped.txt")
+			if tt.wantSkipList == "" {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantSkipList, string(skipList))
+		})
+	}
+}
+
+func TestICloudDownloaderFetchNil(t *testing.T) {
+	var d *iCloudDownloader
+	attachments := []chatdb.Attachment{{ID: 1, Filename: "/attachments/jane.vcf"}}
+	fetched, err := d.fetch(attachments)
+	assert.NilError(t, err)
+	assert.Equal(t, &attachments[0], &fetched[0])
+}
@@ -0,0 +1,69 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+)
+
+// checkIndexOptions checks that --index-copy is only used on a copy of
+// chat.db, whose checksum is not recorded by a forensic export.
+func checkIndexOptions(opts options) error {
+	if !opts.IndexCopy {
+		return nil
+	}
+	if opts.DBPath == _defaultDBPath {
+		return errors.New("--index-copy adds indexes to the Messages database, which must be left untouched - FIX: copy chat.db, e.g. with 'cp ~/Library/Messages/chat.db* ~/Desktop', and pass the copy with --db-path")
+	}
+	if opts.Forensic {
+		return errors.New("--index-copy changes the copy of chat.db whose checksum forensic exports record - FIX: remove --index-copy")
+	}
+	return nil
+}
+
+// addIndexes adds temporary indexes to the copy of chat.db for --index-copy,
+// logging how much they speed up estimating the sizes of the chats, which
+// joins each message to its chat and attachments as exports do. It returns
+// a function dropping the indexes again after the export.
+func addIndexes(opts options, cdb chatdb.ChatDB) (func(), error) {
+	if !opts.IndexCopy {
+		return func() {}, nil
+	}
+	before, beforeErr := timeChatSizes(cdb)
+	names, err := cdb.AddIndexes()
+	drop := func() {
+		if err := cdb.DropIndexes(names); err != nil {
+			logging.Warnf("drop temporary indexes %v - FIX: drop them from the copy of chat.db with: sqlite3 %s 'DROP INDEX <name>': %s", names, opts.DBPath, err)
+		}
+	}
+	if err != nil {
+		drop()
+		return nil, errors.Wrap(err, "add temporary indexes")
+	}
+	if len(names) == 0 {
+		logging.Infof("%q already has indexes on all of the columns which exports look up", opts.DBPath)
+		return drop, nil
+	}
+	after, afterErr := timeChatSizes(cdb)
+	if beforeErr != nil || afterErr != nil {
+		logging.Infof("added temporary indexes %v to %q", names, opts.DBPath)
+		return drop, nil
+	}
+	logging.Infof("added temporary indexes %v to %q, which sped up estimating the sizes of the chats from %s to %s", names, opts.DBPath, before.Round(time.Millisecond), after.Round(time.Millisecond))
+	return drop, nil
+}
+
+// timeChatSizes returns how long it takes to get the sizes of the chats.
+func timeChatSizes(cdb chatdb.ChatDB) (time.Duration, error) {
+	start := time.Now()
+	if _, err := cdb.GetChatSizes(); err != nil {
+		logging.Debugf("time estimating the sizes of the chats: %s", err)
+		return 0, err
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckIndexOptions(t *testing.T) {
+	tests := []struct {
+		msg     string
+		opts    options
+		wantErr string
+	}{
+		{
+			msg:  "no index copy",
+			opts: options{DBPath: _defaultDBPath},
+		},
+		{
+			msg:  "copy",
+			opts: options{DBPath: "chat.db", IndexCopy: true},
+		},
+		{
+			msg:     "Messages database",
+			opts:    options{DBPath: _defaultDBPath, IndexCopy: true},
+			wantErr: "--index-copy adds indexes to the Messages database, which must be left untouched - FIX: copy chat.db, e.g. with 'cp ~/Library/Messages/chat.db* ~/Desktop', and pass the copy with --db-path",
+		},
+		{
+			msg:     "forensic",
+			opts:    options{DBPath: "chat.db", IndexCopy: true, Forensic: true},
+			wantErr: "--index-copy changes the copy of chat.db whose checksum forensic exports record - FIX: remove --index-copy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			err := checkIndexOptions(tt.opts)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestAddIndexes(t *testing.T) {
+	names := []string{"bagoup_chat_message_join_chat_id"}
+
+	tests := []struct {
+		msg       string
+		indexCopy bool
+		setupMock func(*mock_chatdb.MockChatDB)
+		wantErr   string
+	}{
+		{
+			msg:       "no index copy",
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+		},
+		{
+			msg:       "indexes added",
+			indexCopy: true,
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetChatSizes(),
+					dbMock.EXPECT().AddIndexes().Return(names, nil),
+					dbMock.EXPECT().GetChatSizes(),
+					dbMock.EXPECT().DropIndexes(names),
+				)
+			},
+		},
+		{
+			msg:       "already indexed",
+			indexCopy: true,
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes()
+				dbMock.EXPECT().AddIndexes()
+				dbMock.EXPECT().DropIndexes(nil)
+			},
+		},
+		{
+			msg:       "timing error",
+			indexCopy: true,
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(nil, errors.New("this is a DB error")).Times(2)
+				dbMock.EXPECT().AddIndexes().Return(names, nil)
+				dbMock.EXPECT().DropIndexes(names)
+			},
+		},
+		{
+			msg:       "drop error",
+			indexCopy: true,
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Times(2)
+				dbMock.EXPECT().AddIndexes().Return(names, nil)
+				dbMock.EXPECT().DropIndexes(names).Return(errors.New("this is a DB error"))
+			},
+		},
+		{
+			msg:       "add error",
+			indexCopy: true,
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes()
+				dbMock.EXPECT().AddIndexes().Return(names, errors.New("this is a DB error"))
+				dbMock.EXPECT().DropIndexes(names)
+			},
+			wantErr: "add temporary indexes: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			tt.setupMock(dbMock)
+
+			drop, err := addIndexes(options{DBPath: "chat.db", IndexCopy: tt.indexCopy}, dbMock)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			drop()
+		})
+	}
+}
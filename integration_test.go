@@ -0,0 +1,238 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+// _updateGolden rewrites the golden exports with the output of the
+// integration tests, e.g. after a deliberate change to an export format.
+var _updateGolden = flag.Bool("update-golden", false, "rewrite the golden exports in testdata/golden")
+
+// _syntheticSchema creates the tables of chat.db read by bagoup.
+const _syntheticSchema = "chatdb/testdata/schema/15.sql"
+
+// _goldenSkipped are the files of an export which differ between runs.
+var _goldenSkipped = map[string]bool{_runSummaryFilename: true}
+
+// syntheticMessage is a message of the synthetic database, sent the given
+// time after the start of the synthetic chats.
+type syntheticMessage struct {
+	guid    string
+	handle  int
+	fromMe  bool
+	after   time.Duration
+	text    string
+	replyTo string
+	edited  bool
+	unsent  bool
+	effect  string
+}
+
+// syntheticChat is a chat of the synthetic database with its participants,
+// as indexes into _syntheticHandles, and messages.
+type syntheticChat struct {
+	guid         string
+	identifier   string
+	displayName  string
+	participants []int
+	messages     []syntheticMessage
+}
+
+var (
+	// _syntheticStart is when the first synthetic message was sent.
+	_syntheticStart = time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+
+	_syntheticHandles = []string{"+14155555555", "jelena@example.com", "+381111111111"}
+
+	_syntheticChats = []syntheticChat{
+		{
+			guid:         "iMessage;-;+14155555555",
+			identifier:   "+14155555555",
+			participants: []int{1},
+			messages: []syntheticMessage{
+				{guid: "msg-1", handle: 1, text: "Want to play tennis?"},
+				{guid: "msg-2", fromMe: true, after: time.Minute, text: "Sure, what time?"},
+				{guid: "msg-3", handle: 1, after: 2 * time.Minute, text: "4pm at the club", replyTo: "msg-2"},
+				{guid: "msg-4", fromMe: true, after: 3 * time.Minute, text: "See you there 🎾", edited: true},
+				{guid: "msg-5", handle: 1, after: 24 * time.Hour, text: "Good game!", effect: "com.apple.MobileSMS.expressivesend.impact"},
+			},
+		},
+		{
+			guid:         "iMessage;+;chat123456",
+			identifier:   "chat123456",
+			displayName:  "Doubles",
+			participants: []int{1, 2, 3},
+			messages: []syntheticMessage{
+				{guid: "msg-6", fromMe: true, after: time.Hour, text: "Doubles on Saturday?"},
+				{guid: "msg-7", handle: 2, after: time.Hour + time.Minute, text: "I'm in"},
+				{guid: "msg-8", handle: 3, after: time.Hour + 2*time.Minute, text: "Me too\nBringing balls"},
+				{guid: "msg-9", fromMe: true, after: time.Hour + 3*time.Minute, unsent: true},
+			},
+		},
+	}
+)
+
+// newSyntheticDB creates a Messages database at the given path with the
+// tables of the latest Mac OS, filled with _syntheticChats.
+func newSyntheticDB(t *testing.T, dbPath string) {
+	schema, err := ioutil.ReadFile(_syntheticSchema)
+	assert.NilError(t, err)
+	db, err := sql.Open("sqlite3", dbPath)
+	assert.NilError(t, err)
+	defer db.Close()
+	_, err = db.Exec(string(schema))
+	assert.NilError(t, err)
+
+	for i, handle := range _syntheticHandles {
+		_, err := db.Exec("INSERT INTO handle (ROWID, id, service) VALUES (?, ?, 'iMessage')", i+1, handle)
+		assert.NilError(t, err)
+	}
+	messageID := 0
+	for i, chat := range _syntheticChats {
+		chatID := i + 1
+		_, err := db.Exec("INSERT INTO chat (ROWID, guid, chat_identifier, service_name, display_name) VALUES (?, ?, ?, 'iMessage', ?)", chatID, chat.guid, chat.identifier, chat.displayName)
+		assert.NilError(t, err)
+		for _, handle := range chat.participants {
+			_, err := db.Exec("INSERT INTO chat_handle_join (chat_id, handle_id) VALUES (?, ?)", chatID, handle)
+			assert.NilError(t, err)
+		}
+		for _, msg := range chat.messages {
+			messageID++
+			// Dates are stored in nanoseconds since the start of 2001.
+			date := _syntheticStart.Add(msg.after).Sub(time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)).Nanoseconds()
+			var text, replyTo, effect interface{}
+			if msg.text != "" {
+				text = msg.text
+			}
+			if msg.replyTo != "" {
+				replyTo = msg.replyTo
+			}
+			if msg.effect != "" {
+				effect = msg.effect
+			}
+			var edited, retracted int64
+			if msg.edited {
+				edited = date + time.Minute.Nanoseconds()
+			}
+			if msg.unsent {
+				retracted = date + time.Minute.Nanoseconds()
+			}
+			_, err := db.Exec(
+				"INSERT INTO message (ROWID, guid, text, handle_id, service, date, is_from_me, thread_originator_guid, expressive_send_style_id, date_edited, date_retracted) VALUES (?, ?, ?, ?, 'iMessage', ?, ?, ?, ?, ?, ?)",
+				messageID, msg.guid, text, msg.handle, date, msg.fromMe, replyTo, effect, edited, retracted,
+			)
+			assert.NilError(t, err)
+			_, err = db.Exec("INSERT INTO chat_message_join (chat_id, message_id, message_date) VALUES (?, ?, ?)", chatID, messageID, date)
+			assert.NilError(t, err)
+		}
+	}
+}
+
+// TestIntegration exports the synthetic database in each format, with the
+// default options, and compares the exports with the golden exports in
+// testdata/golden. Run it with make integration, or with -update-golden to
+// rewrite the golden exports after a deliberate change to a format.
+func TestIntegration(t *testing.T) {
+	if os.Getenv("TZ") != "UTC" {
+		t.Fatal("the golden exports are in UTC - FIX: run the integration tests with TZ=UTC, e.g. with make integration")
+	}
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "chat.db")
+	newSyntheticDB(t, dbPath)
+
+	for _, format := range []string{"txt", "mbox", "slack", "matrix"} {
+		t.Run(format, func(t *testing.T) {
+			exportPath := filepath.Join(dir, format)
+			var opts options
+			_, err := flags.ParseArgs(&opts, []string{"--db-path", dbPath, "--export-path", exportPath, "--format", format})
+			assert.NilError(t, err)
+
+			db, err := sql.Open("sqlite3", dataSourceName(dbPath, false, opts.BusyTimeout))
+			assert.NilError(t, err)
+			defer db.Close()
+			s := opsys.NewOS(afero.NewOsFs(), os.Stat, exec.Command)
+			cdb := chatdb.NewChatDB(db, opts.SelfHandle, chatdb.NameFormat{}, chatdb.PoolOptions{Workers: opts.DBWorkers, ConnsPerWorker: opts.DBConnsPerWorker})
+			_, err = bagoup(opts, s, cdb)
+			assert.NilError(t, err)
+
+			compareGolden(t, exportPath, filepath.Join("testdata", "golden", format))
+		})
+	}
+}
+
+// compareGolden compares the files of the export at the given path with the
+// golden export at the given path, or rewrites the golden export with
+// -update-golden.
+func compareGolden(t *testing.T, exportPath, goldenPath string) {
+	exported := readExport(t, exportPath)
+	if *_updateGolden {
+		assert.NilError(t, os.RemoveAll(goldenPath))
+		for name, contents := range exported {
+			p := filepath.Join(goldenPath, name)
+			assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0755))
+			assert.NilError(t, ioutil.WriteFile(p, []byte(contents), 0644))
+		}
+		return
+	}
+	golden := readExport(t, goldenPath)
+	for name, want := range golden {
+		got, ok := exported[name]
+		if !ok {
+			t.Errorf("missing exported file %q", name)
+			continue
+		}
+		assert.Check(t, got == want, "exported file %q differs from the golden file - FIX: if the change is deliberate, run the integration tests with -update-golden\n--- want\n%s\n--- got\n%s", name, want, got)
+	}
+	for name := range exported {
+		if _, ok := golden[name]; !ok {
+			t.Errorf("unexpected exported file %q - FIX: if it is deliberate, run the integration tests with -update-golden", name)
+		}
+	}
+}
+
+// readExport reads the files of the export at the given path, by their paths
+// relative to it, except for the files which differ between runs.
+func readExport(t *testing.T, exportPath string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(exportPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(exportPath, p)
+		if err != nil {
+			return err
+		}
+		if _goldenSkipped[filepath.ToSlash(name)] {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = strings.ReplaceAll(string(b), exportPath, "EXPORT_PATH")
+		return nil
+	})
+	assert.NilError(t, err, "read export %q", exportPath)
+	return files
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _linksReportName is the name, without extension, of the report of the links
+// shared in the exported chats, written into the export folder in each format
+// given with --links-report.
+const _linksReportName = "links"
+
+// _linkPattern matches the web links in the text of a message.
+var _linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// _linkTrailing are the characters which end sentences or enclose links,
+// rather than belong to the links they follow, e.g. "(https://example.com)."
+const _linkTrailing = ".,;:!?)]}'"
+
+// sharedLink is a link shared in a message, with the chat in which and the
+// participant by whom it was shared.
+type sharedLink struct {
+	Date   string
+	Chat   string
+	Sender string
+	URL    string
+}
+
+// findLinks returns the links shared in the given messages of the given chat,
+// in the order in which they were shared.
+func findLinks(chat chatdb.Chat, msgs []chatdb.Message) []sharedLink {
+	var links []sharedLink
+	for _, msg := range msgs {
+		date := msg.Date.Format(_gapDateLayout)
+		if msg.DateSource == chatdb.DateUnknown {
+			date = ""
+		}
+		for _, url := range _linkPattern.FindAllString(msg.Text, -1) {
+			links = append(links, sharedLink{
+				Date:   date,
+				Chat:   chat.DisplayName,
+				Sender: msg.Handle,
+				URL:    trimLink(url),
+			})
+		}
+	}
+	return links
+}
+
+// trimLink removes the punctuation following the given link. Closing
+// parentheses are kept if they close parentheses in the link, e.g. in
+// "https://en.wikipedia.org/wiki/Rally_(tennis)".
+func trimLink(url string) string {
+	for url != "" && strings.ContainsRune(_linkTrailing, rune(url[len(url)-1])) {
+		if url[len(url)-1] == ')' && strings.Count(url, "(") >= strings.Count(url, ")") {
+			break
+		}
+		url = url[:len(url)-1]
+	}
+	return url
+}
+
+// writeLinksReport writes the given links, sorted by date, into the export
+// folder in each of the given formats, csv or html with the given template,
+// returning the paths of the files.
+func writeLinksReport(s opsys.OS, exportPath string, links []sharedLink, formats []string, tmpl *template.Template) ([]string, error) {
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Date < links[j].Date })
+	var reportPaths []string
+	for _, format := range formats {
+		reportPath := path.Join(exportPath, _linksReportName+"."+format)
+		write := func(w io.Writer) error {
+			records := [][]string{{"date", "chat", "sender", "url"}}
+			for _, link := range links {
+				records = append(records, []string{link.Date, link.Chat, link.Sender, link.URL})
+			}
+			return csv.NewWriter(w).WriteAll(records)
+		}
+		if format == "html" {
+			write = func(w io.Writer) error { return tmpl.Execute(w, links) }
+		}
+		if err := exporter.WriteFile(s, reportPath, write); err != nil {
+			return reportPaths, err
+		}
+		reportPaths = append(reportPaths, reportPath)
+	}
+	return reportPaths, nil
+}
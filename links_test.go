@@ -0,0 +1,57 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/assets"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestFindLinks(t *testing.T) {
+	date := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)
+	chat := chatdb.Chat{DisplayName: "Novak"}
+	msgs := []chatdb.Message{
+		{Date: date, Handle: "Novak", Text: "Tickets: https://www.rolandgarros.com/en-us/tickets."},
+		{Date: date, Handle: "Me", Text: "No links here"},
+		{Date: date, Handle: "Me", Text: "See (https://en.wikipedia.org/wiki/Rally_(tennis)) and HTTP://example.com/a?b=c, ok?"},
+		{DateSource: chatdb.DateUnknown, Handle: "Novak", Text: "https://example.com/\"quoted\""},
+	}
+
+	assert.DeepEqual(t, []sharedLink{
+		{Date: "2020-03-01 15:34:05", Chat: "Novak", Sender: "Novak", URL: "https://www.rolandgarros.com/en-us/tickets"},
+		{Date: "2020-03-01 15:34:05", Chat: "Novak", Sender: "Me", URL: "https://en.wikipedia.org/wiki/Rally_(tennis)"},
+		{Date: "2020-03-01 15:34:05", Chat: "Novak", Sender: "Me", URL: "HTTP://example.com/a?b=c"},
+		{Chat: "Novak", Sender: "Novak", URL: "https://example.com/"},
+	}, findLinks(chat, msgs))
+}
+
+func TestWriteLinksReport(t *testing.T) {
+	links := []sharedLink{
+		{Date: "2020-03-02 09:00:00", Chat: "Doubles", Sender: "Jelena", URL: "https://example.com/?a=1&b=2"},
+		{Date: "2020-03-01 15:34:05", Chat: "Novak", Sender: "Novak", URL: "https://www.rolandgarros.com"},
+	}
+	tmpl, err := assets.New(nil, "").HTMLTemplate("links.html")
+	assert.NilError(t, err)
+
+	fs := afero.NewMemMapFs()
+	reportPaths, err := writeLinksReport(opsys.NewOS(fs, nil, nil), "backup", links, []string{"csv", "html"}, tmpl)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"backup/links.csv", "backup/links.html"}, reportPaths)
+	contents, err := afero.ReadFile(fs, "backup/links.csv")
+	assert.NilError(t, err)
+	assert.Equal(t, "date,chat,sender,url\n2020-03-01 15:34:05,Novak,Novak,https://www.rolandgarros.com\n2020-03-02 09:00:00,Doubles,Jelena,https://example.com/?a=1&b=2\n", string(contents))
+	contents, err = afero.ReadFile(fs, "backup/links.html")
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(contents), `<tr><td>2020-03-02 09:00:00</td><td>Doubles</td><td>Jelena</td><td><a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a></td></tr>`), string(contents))
+
+	_, err = writeLinksReport(opsys.NewOS(afero.NewReadOnlyFs(fs), nil, nil), "backup", links, []string{"csv"}, nil)
+	assert.ErrorContains(t, err, `create file "backup/links.csv.partial"`)
+}
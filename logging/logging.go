@@ -0,0 +1,162 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package logging provides leveled logging, either as text for people to read
+// or as JSON lines for log aggregators, e.g. when bagoup is run by launchd or
+// in CI. The package-level functions log with a default logger, which writes
+// text to standard error until it is replaced with SetDefault.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Logger logs messages at levels of severity, dropping the messages below
+	// its minimum level.
+	Logger interface {
+		// Debugf logs details which help to debug a run after the fact.
+		Debugf(format string, args ...interface{})
+		// Infof logs the progress and outcome of a run.
+		Infof(format string, args ...interface{})
+		// Warnf logs problems which do not stop the run.
+		Warnf(format string, args ...interface{})
+		// Errorf logs problems which stop the run.
+		Errorf(format string, args ...interface{})
+	}
+
+	// Level is the severity of a log message.
+	Level int
+
+	// Format is the format in which messages are logged.
+	Format string
+
+	logger struct {
+		mu     sync.Mutex
+		w      io.Writer
+		format Format
+		level  Level
+		now    func() time.Time
+	}
+
+	// jsonEntry is a message logged in the JSON format.
+	jsonEntry struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+	}
+)
+
+const (
+	// Debug is the level of details which help to debug a run.
+	Debug Level = iota
+	// Info is the level of the progress and outcome of a run.
+	Info
+	// Warn is the level of problems which do not stop a run.
+	Warn
+	// Error is the level of problems which stop a run.
+	Error
+)
+
+const (
+	// Text logs a line per message, e.g.
+	// "2020/03/01 15:34:05 WARN: attachment "a.jpeg" does not exist locally".
+	Text Format = "text"
+	// JSON logs a JSON object per line, e.g.
+	// {"time":"2020-03-01T15:34:05Z","level":"warn","msg":"..."}.
+	JSON Format = "json"
+)
+
+const _textTimeLayout = "2006/01/02 15:04:05"
+
+var _levelNames = []string{"debug", "info", "warn", "error"}
+
+var (
+	_defaultMu sync.RWMutex
+	_default   Logger = New(os.Stderr, Text, Info)
+)
+
+// String returns the name of the level, e.g. "warn".
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return _levelNames[l]
+}
+
+// ParseLevel returns the level with the given name, ignoring case.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range _levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q - FIX: use one of %v", name, _levelNames)
+}
+
+// New returns a Logger which writes the messages at or above the given level
+// to the given writer in the given format. Messages are written whole, so the
+// Logger can be used concurrently.
+func New(w io.Writer, format Format, level Level) Logger {
+	return newLogger(w, format, level, time.Now)
+}
+
+func newLogger(w io.Writer, format Format, level Level, now func() time.Time) *logger {
+	return &logger{w: w, format: format, level: level, now: now}
+}
+
+func (l *logger) Debugf(format string, args ...interface{}) { l.logf(Debug, format, args...) }
+func (l *logger) Infof(format string, args ...interface{})  { l.logf(Info, format, args...) }
+func (l *logger) Warnf(format string, args ...interface{})  { l.logf(Warn, format, args...) }
+func (l *logger) Errorf(format string, args ...interface{}) { l.logf(Error, format, args...) }
+
+func (l *logger) logf(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	t := l.now()
+	var line []byte
+	if l.format == JSON {
+		// Encoding a struct of strings cannot fail.
+		line, _ = json.Marshal(jsonEntry{Time: t.Format(time.RFC3339), Level: level.String(), Msg: msg})
+	} else {
+		line = []byte(fmt.Sprintf("%s %s: %s", t.Format(_textTimeLayout), strings.ToUpper(level.String()), msg))
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// SetDefault replaces the logger used by the package-level functions.
+func SetDefault(l Logger) {
+	_defaultMu.Lock()
+	defer _defaultMu.Unlock()
+	_default = l
+}
+
+// Default returns the logger used by the package-level functions.
+func Default() Logger {
+	_defaultMu.RLock()
+	defer _defaultMu.RUnlock()
+	return _default
+}
+
+// Debugf logs details with the default logger.
+func Debugf(format string, args ...interface{}) { Default().Debugf(format, args...) }
+
+// Infof logs progress with the default logger.
+func Infof(format string, args ...interface{}) { Default().Infof(format, args...) }
+
+// Warnf logs a problem which does not stop the run with the default logger.
+func Warnf(format string, args ...interface{}) { Default().Warnf(format, args...) }
+
+// Errorf logs a problem which stops the run with the default logger.
+func Errorf(format string, args ...interface{}) { Default().Errorf(format, args...) }
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package logging
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		msg    string
+		format Format
+		level  Level
+		want   string
+	}{
+		{
+			msg:    "text",
+			format: Text,
+			level:  Info,
+			want: `2020/03/01 15:34:05 INFO: exported 5 messages
+2020/03/01 15:34:05 WARN: attachment "a.jpeg" does not exist locally
+2020/03/01 15:34:05 ERROR: export chats: this is a DB error
+`,
+		},
+		{
+			msg:    "text with debug messages",
+			format: Text,
+			level:  Debug,
+			want: `2020/03/01 15:34:05 DEBUG: found 2 chats
+2020/03/01 15:34:05 INFO: exported 5 messages
+2020/03/01 15:34:05 WARN: attachment "a.jpeg" does not exist locally
+2020/03/01 15:34:05 ERROR: export chats: this is a DB error
+`,
+		},
+		{
+			msg:    "JSON errors only",
+			format: JSON,
+			level:  Error,
+			want: `{"time":"2020-03-01T15:34:05Z","level":"error","msg":"export chats: this is a DB error"}
+`,
+		},
+		{
+			msg:    "JSON",
+			format: JSON,
+			level:  Warn,
+			want: `{"time":"2020-03-01T15:34:05Z","level":"warn","msg":"attachment \"a.jpeg\" does not exist locally"}
+{"time":"2020-03-01T15:34:05Z","level":"error","msg":"export chats: this is a DB error"}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := newLogger(&buf, tt.format, tt.level, func() time.Time {
+				return time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+			})
+			l.Debugf("found %d chats", 2)
+			l.Infof("exported %d messages", 5)
+			l.Warnf("attachment %q does not exist locally", "a.jpeg")
+			l.Errorf("export chats: %s", "this is a DB error")
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NilError(t, err)
+	assert.Equal(t, Warn, level)
+	assert.Equal(t, "warn", level.String())
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err, `unknown log level "verbose" - FIX: use one of [debug info warn error]`)
+	assert.Equal(t, "Level(7)", Level(7).String())
+}
+
+func TestDefault(t *testing.T) {
+	defer SetDefault(Default())
+	var buf bytes.Buffer
+	SetDefault(New(&buf, JSON, Debug))
+	Debugf("a")
+	Infof("b")
+	Warnf("c")
+	Errorf("d")
+	assert.Equal(t, 4, bytes.Count(buf.Bytes(), []byte("\n")))
+}
@@ -19,10 +19,16 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"html/template"
+	"io"
+	"net/http"
+	"net/smtp"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/emersion/go-vcard"
@@ -30,131 +36,862 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/assets"
 	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/collation"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
 	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/photos"
+	"github.com/tagatac/bagoup/stats"
 )
 
 const _readmeURL = "https://github.com/tagatac/bagoup/blob/master/README.md#chatdb-access"
 const _defaultDBPath = "~/Library/Messages/chat.db"
 
+// _version is the version of bagoup, set when building a release with
+// -ldflags "-X main._version=...".
+var _version = "dev"
+
+// Exit codes of bagoup besides 0, which means that everything was exported,
+// for wrapper scripts and cron jobs.
+const (
+	// _exitPartial means that the export finished, but skipped items which
+	// could not be exported, e.g. missing attachments, or stopped early after
+	// --max-duration.
+	_exitPartial = 1
+	// _exitFatal means that bagoup failed, e.g. because the export stopped.
+	_exitFatal = 2
+)
+
+// _webhookClient posts notifications to the --notify-webhook URL.
+var _webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// _sendMail sends the --email-summary through the --smtp-server.
+var _sendMail sendMailFunc = smtp.SendMail
+
 type options struct {
-	DBPath       string  `short:"i" long:"db-path" description:"Path to the Messages chat database file" default:"~/Library/Messages/chat.db"`
-	ExportPath   string  `short:"o" long:"export-path" description:"Path to which the Messages will be exported" default:"backup"`
-	MacOSVersion *string `short:"m" long:"mac-os-version" description:"Version of Mac OS, e.g. '10.15', from which the Messages chat database file was copied (not needed if bagoup is running on the same Mac)"`
-	ContactsPath *string `short:"c" long:"contacts-path" description:"Path to the contacts vCard file"`
-	SelfHandle   string  `short:"s" long:"self-handle" description:"Prefix to use for for messages sent by you" default:"Me"`
+	DBPath           string   `short:"i" long:"db-path" description:"Path to the Messages chat database file" default:"~/Library/Messages/chat.db"`
+	ExportPath       string   `short:"o" long:"export-path" description:"Path to which the Messages will be exported" default:"backup"`
+	Format           string   `short:"f" long:"format" description:"Format of the exported chat files: txt, mbox (an email for each message), slack (a Slack workspace export), or matrix (Matrix room events)" default:"txt"`
+	UserHome         string   `long:"user-home" description:"Home folder of another user of this Mac, e.g. '/Users/jelena', whose chats to export with their consent, when running bagoup with sudo; paths starting with ~ are in this folder"`
+	FileMode         string   `long:"file-mode" description:"Permissions of the exported files, in octal" default:"0600"`
+	DirMode          string   `long:"dir-mode" description:"Permissions of the export folders, in octal" default:"0700"`
+	Owner            string   `long:"owner" description:"User, and optionally group, to own the exported files and folders, e.g. 'david:staff', when running bagoup with sudo"`
+	MacOSVersion     *string  `short:"m" long:"mac-os-version" description:"Version of Mac OS, e.g. '10.15', from which the Messages chat database file was copied (detected from the database if omitted)"`
+	ContactsPath     *string  `short:"c" long:"contacts-path" description:"Path to the contacts vCard file"`
+	NamesPath        *string  `long:"names-path" description:"Path to a CSV file of handles and the names to label them with, which take precedence over the contacts file"`
+	AliasesPath      string   `long:"aliases-path" description:"Path to a CSV file of handles which people used before, e.g. old phone numbers, and the handles they use now, e.g. '+14155550000,+14155555555', so that the chats with their old handles are exported with their current chats"`
+	SelfHandle       string   `short:"s" long:"self-handle" description:"Prefix to use for for messages sent by you" default:"Me"`
+	SelfHandles      []string `long:"self-handle-for" description:"Prefix to use for messages sent by you in group or direct chats, in an export format, or in both, e.g. 'group:David' or 'slack.direct:Me'; '@vcard' uses the name from --self-vcard (may be repeated)"`
+	SelfVCard        string   `long:"self-vcard" description:"Path to a vCard file with your own contact card, whose name is used for the prefix '@vcard'"`
+	CopyAttachments  bool     `short:"a" long:"copy-attachments" description:"Copy attachments to an attachments folder next to the chat which included them"`
+	CloneAttachments bool     `long:"clone-attachments" description:"Clone attachments instead of copying them, which is near-instant and takes no extra space when exporting to the same APFS volume; implies --copy-attachments"`
+	CopyWorkers      int      `long:"copy-workers" description:"Number of attachments to copy at the same time" default:"4"`
+	CopyRateLimit    float64  `long:"copy-rate-limit" description:"Maximum rate at which to copy attachments, in megabytes per second, e.g. for exports to network drives (default: unlimited)"`
+	CopyRetries      int      `long:"copy-retries" description:"Number of times to retry copying an attachment which fails to copy" default:"2"`
+	PhotosLibrary    string   `long:"photos-library" description:"Path to a Photos library, e.g. '~/Pictures/Photos Library.photoslibrary', in which to look for attachments which are missing from Messages, e.g. photos saved to Photos before they expired"`
+	ICloudDownload   bool     `long:"icloud-download" description:"Download attachments which Optimize Mac Storage keeps only in iCloud before exporting them; those which cannot be downloaded are listed in icloud-skipped.txt in the export folder and skipped by later exports"`
+	ICloudTimeout    int      `long:"icloud-timeout" description:"Number of seconds to wait for each attachment to download from iCloud with --icloud-download before skipping it" default:"300"`
+	OCR              bool     `long:"ocr" description:"Recognize text in image attachments, e.g. screenshots, with tesseract, and add it to the exported messages, e.g. 'Text in image: ...', so that it can be searched"`
+	VolumeSize       float64  `long:"volume-size" description:"Split the export into volume folders, e.g. volume-1, of at most about the given number of gigabytes each, e.g. 4 for FAT32 drives or 4.7 for DVDs, keeping each chat with its attachments in one volume"`
+	SkipSpaceCheck   bool     `long:"skip-space-check" description:"Export even if the estimated size of the export exceeds the free space at the export path"`
+	NameOrder        string   `long:"name-order" description:"Order of the parts of contacts' full names; auto puts the family name first for contacts with phonetic names, as is common for CJK contacts" choice:"given-first" choice:"family-first" choice:"auto" default:"given-first"`
+	Honorifics       bool     `long:"honorifics" description:"Include honorific prefixes and suffixes, e.g. 'Dr.' and 'Jr.', in contacts' full names"`
+	GapDays          int      `long:"gap-days" description:"Report gaps of at least the given number of days without messages in chats which were active before and after them, which may mean that messages were lost, e.g. when moving to a new Mac; 0 disables the report" default:"30"`
+	DanglingJoins    string   `long:"dangling-joins" description:"How to export the messages which chats refer to but which are missing from chat.db, e.g. in databases restored from backups: skip them, or stub them out as '[missing message]' at their dates" choice:"skip" choice:"stub" default:"skip"`
+	Collate          string   `long:"collate" description:"Export and list chats by name in the alphabetical order of the given language, e.g. 'en' or 'sv', instead of in the order of the Messages database; the viewer also groups them by initial"`
+	Heatmap          string   `long:"heatmap" description:"Generate a heatmap of messages per day in each chat folder, in the given image format" choice:"svg" choice:"png"`
+	BusyTimeout      int      `long:"busy-timeout" description:"Number of seconds to wait for the Messages database while it is locked, e.g. by Messages, before retrying" default:"5"`
+	DBWorkers        int      `long:"db-workers" description:"Number of queries to run on the Messages database at the same time, e.g. for viewer requests" default:"4"`
+	DBConnsPerWorker int      `long:"db-conns-per-worker" description:"Number of connections to the Messages database which each query may hold open" default:"1"`
+	DBMaxConns       int      `long:"db-max-conns" description:"Maximum number of open connections to the Messages database (default: --db-workers times --db-conns-per-worker)"`
+	IndexCopy        bool     `long:"index-copy" description:"Add temporary indexes to the copy of the Messages database given with --db-path before exporting, which speeds up large exports, log the speedup, and drop them afterwards"`
+	MinMessages      int      `long:"min-messages" description:"Skip chats with fewer than the given number of messages, e.g. one-message spam threads; they are listed in the run summary"`
+	UnknownSenders   bool     `long:"unknown-senders" description:"Export one-to-one chats with short codes, or with phone numbers and email addresses which are not in the contacts or names file, into an unknown-senders folder in the export folder"`
+	UnknownPattern   string   `long:"unknown-senders-pattern" description:"Regular expression matching the handles to treat as unknown senders with --unknown-senders even if they are in the contacts, e.g. '^[0-9]{3,6}$' for short codes" default:"^[0-9]{3,6}$"`
+	OnlyGroups       bool     `long:"only-groups" description:"Only export group chats, i.e. chats with more than one other participant"`
+	OnlyDirect       bool     `long:"only-direct" description:"Only export one-to-one chats, i.e. chats with at most one other participant"`
+	ContactGroups    []string `long:"contact-group" description:"Only export one-to-one chats with contacts in the given group, i.e. with the given CATEGORIES in their vCards, e.g. 'Family' (may be repeated)"`
+	DedupWindow      int      `long:"dedup-window" description:"Drop copies of messages resent over another service, e.g. iMessages which fell back to SMS, sent within the given number of seconds of the original"`
+	Stdout           bool     `long:"stdout" description:"Write the chat selected with --handle to standard output in the txt format instead of exporting into the export folder, e.g. to pipe it into less, grep, or pbcopy"`
+	GUIDFolders      bool     `long:"guid-folders" description:"Name the chat folders after the GUIDs of the chats, which do not change when contacts are renamed, e.g. for incremental sync tools, and list the names of the chats in chat-index.csv in the export folder"`
+	DirTemplate      string   `long:"dir-template" description:"Template of the folders within the export folder into which to export each chat, e.g. '{{.ContactName}}/{{.Service}}/{{.Year}}', with the fields ContactName, Handle, Service, Identifier (the GUID without the service), Year (of the last message), GUID, and Group (of the contact) (default: a folder named after the chat)"`
+	Recent           int      `long:"recent" description:"Only export the given number of chats with the most recent messages, e.g. for quick periodic backups"`
+	Handle           string   `long:"handle" description:"Only export chats with the given phone number or email address as stored in the Messages database, e.g. '+14155555555'"`
+	Chat             string   `long:"chat" description:"Only export chats with the given name, ignoring case, e.g. a contact's full name or the name of a group chat, as their folders are named"`
+	Match            string   `long:"match" description:"Only export messages matching the given regular expression, e.g. '(?i)invoice'"`
+	Exclude          string   `long:"exclude" description:"Do not export messages matching the given regular expression"`
+	BeforeContext    int      `short:"B" long:"before-context" description:"Number of messages to export before each message matched by --match"`
+	AfterContext     int      `short:"A" long:"after-context" description:"Number of messages to export after each message matched by --match"`
+	Senders          []string `long:"sender" description:"Only export messages sent by the participant with the given name or handle, as labeled in the export, or by you with 'me', e.g. to compile one person's contributions to group chats (may be repeated)"`
+	Kinds            []string `long:"kind" description:"Only export messages of the given kind: text, emoji (only emoji), attachment (only attachments), reaction (tapbacks), or system (e.g. participants added to group chats) (may be repeated)" choice:"text" choice:"emoji" choice:"attachment" choice:"reaction" choice:"system"`
+	ExcludeKinds     []string `long:"exclude-kind" description:"Do not export messages of the given kind, e.g. reaction (may be repeated)" choice:"text" choice:"emoji" choice:"attachment" choice:"reaction" choice:"system"`
+	LinksReport      []string `long:"links-report" description:"Collect the links shared in the exported chats, with their dates, chats, and senders, into a links report in the export folder, in the given format (may be repeated)" choice:"csv" choice:"html"`
+	WordStats        []string `long:"word-stats" description:"Write word and emoji statistics for each participant in each chat folder, in the given format (may be repeated)" choice:"json" choice:"csv" choice:"html"`
+	AssetsDir        string   `long:"assets-dir" description:"Directory of templates and stylesheets, e.g. stats.html and style.css, which override the built-in ones"`
+	Deterministic    bool     `long:"deterministic" description:"Make repeated exports of the same messages byte-identical, so that they can be diffed and stored efficiently in backup tools: attachments are copied one at a time and numbered in a stable order, and the exported files take the dates of the last messages of their chats as their modification times"`
+	Resume           bool     `long:"resume" description:"Resume an interrupted export in the existing export folder, skipping chats which were completely exported"`
+	MaxDuration      int      `long:"max-duration" description:"Stop exporting chats once the given number of minutes have passed, after finishing the chat being exported, e.g. for scheduled backups on battery; the export can be finished with --resume"`
+	OriginHints      bool     `long:"origin-hints" description:"Note how messages were sent where the database records it, e.g. '(sent with Digital Touch)' or '(sent with Slam effect)', in txt exports"`
+	Forensic         bool     `long:"forensic" description:"Export for legal or forensic use: also write every stored field of each message, with its text unchanged, record hash chains as with --hash-chain, and write a chain-of-custody manifest with checksums of chat.db and of the exported files"`
+	HashChain        bool     `long:"hash-chain" description:"Record a hash chain over the lines of each exported chat file, in which the hash of each line includes the hash of the previous line, so that the export can be checked for changes with the verify command"`
+	Spotlight        bool     `long:"spotlight" description:"Label exported chat files with their participants and dates as Spotlight metadata, so that Spotlight can find chats by contact name"`
+	Notify           bool     `long:"notify" description:"Show a Notification Center alert when the export finishes or fails"`
+	NotifyWebhook    string   `long:"notify-webhook" description:"URL to which to POST a JSON summary of the export when it finishes or fails" json:"-"`
+	EmailSummary     string   `long:"email-summary" description:"Email address to which to send the summary of the export, with the run summary and the attachment report attached, when it finishes or fails" json:"-"`
+	SMTPServer       string   `long:"smtp-server" description:"Host and port of the SMTP server through which to send the --email-summary, e.g. 'smtp.example.com:587'; the username and password are read from the BAGOUP_SMTP_USERNAME and BAGOUP_SMTP_PASSWORD environment variables" json:"-"`
+	LogFormat        string   `long:"log-format" description:"Format of the log messages written to standard error; json writes a JSON object per line for log aggregators" choice:"text" choice:"json" default:"text"`
+	Quiet            bool     `short:"q" long:"quiet" description:"Only log errors, and print a single summary line to standard output when the export finishes, e.g. for cron jobs"`
+	LogLevel         string   `long:"log-level" description:"Minimum severity of the log messages to write" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+	PostChatHook     string   `long:"post-chat-hook" description:"Shell command to run after each chat is exported, with information about the chat as JSON on its standard input and in BAGOUP_* environment variables"`
 }
 
 func main() {
 	var opts options
-	_, err := flags.Parse(&opts)
+	var serveOpts serveOptions
+	var verifyOpts verifyOptions
+	var pruneOpts pruneOptions
+	var shortcutOpts shortcutOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.AddCommand("serve", "Browse chats in a web browser", "Serve a viewer for the chats in the export folder, or in the chat database with --from-db, at a local address.", &serveOpts)
+	logFatalOnErr(errors.Wrap(err, "add serve command"))
+	_, err = parser.AddCommand("verify", "Check an export for changes", "Check the chat files in the export folder against the hash chains recorded with --hash-chain.", &verifyOpts)
+	logFatalOnErr(errors.Wrap(err, "add verify command"))
+	_, err = parser.AddCommand("prune", "Remove old exports", "Remove the export folders of scheduled backups in a folder which are beyond the retention given by --keep and --max-age.", &pruneOpts)
+	logFatalOnErr(errors.Wrap(err, "add prune command"))
+	_, err = parser.AddCommand("shortcut", "Export a chat from Shortcuts or AppleScript", "Export the chats with the given name into a new export folder in the given folder, and write the outcome to standard output as JSON, e.g. for the Run Shell Script action of Shortcuts.", &shortcutOpts)
+	logFatalOnErr(errors.Wrap(err, "add shortcut command"))
+	_, err = parser.Parse()
 	if err != nil && err.(*flags.Error).Type == flags.ErrHelp {
 		os.Exit(0)
 	}
 	logFatalOnErr(errors.Wrap(err, "parse flags"))
+	serving := parser.Active != nil && parser.Active.Name == "serve"
+	logFatalOnErr(setupLogging(opts))
 
-	s := opsys.NewOS(afero.NewOsFs(), os.Stat, exec.Command)
-	db, err := sql.Open("sqlite3", opts.DBPath)
-	logFatalOnErr(errors.Wrapf(err, "open DB file %q", opts.DBPath))
+	perms, err := getPermissions(opts)
+	logFatalOnErr(err)
+	s := opsys.NewOSWithHome(opsys.NewPermissionFs(afero.NewOsFs(), perms, os.Chown), os.Stat, exec.Command, opts.UserHome)
+	if opts.UserHome != "" {
+		logFatalOnErr(checkUserHome(s, opts.UserHome))
+	}
+	if parser.Active != nil && parser.Active.Name == "verify" {
+		logFatalOnErr(verify(opts, s))
+		return
+	}
+	if parser.Active != nil && parser.Active.Name == "prune" {
+		logFatalOnErr(prune(pruneOpts, s, time.Now()))
+		return
+	}
+	dbPath, err := s.ExpandHome(opts.DBPath)
+	logFatalOnErr(errors.Wrapf(err, "expand DB path %q", opts.DBPath))
+	logging.Debugf("opening DB file %q", dbPath)
+	db, err := sql.Open("sqlite3", dataSourceName(dbPath, serving, opts.BusyTimeout))
+	logFatalOnErr(errors.Wrapf(err, "open DB file %q", dbPath))
 	defer db.Close()
-	cdb := chatdb.NewChatDB(db, opts.SelfHandle)
+	cdb := chatdb.NewChatDB(db, opts.SelfHandle, chatdb.NameFormat{
+		Order:      chatdb.NameOrder(opts.NameOrder),
+		Honorifics: opts.Honorifics,
+	}, chatdb.PoolOptions{
+		Workers:        opts.DBWorkers,
+		ConnsPerWorker: opts.DBConnsPerWorker,
+		MaxOpenConns:   opts.DBMaxConns,
+	})
+
+	if serving {
+		logFatalOnErr(withRemediation(serve(opts, serveOpts, s, cdb)))
+		return
+	}
+	if parser.Active != nil && parser.Active.Name == "shortcut" {
+		logFatalOnErr(runShortcut(opts, shortcutOpts, s, cdb, time.Now(), _stdout))
+		return
+	}
+	summary, err := bagoup(opts, s, cdb)
+	logFatalOnErr(withRemediation(err))
+	if opts.Quiet && !opts.Stdout {
+		fmt.Println(summary.line())
+	}
+	if summary.partial() {
+		os.Exit(_exitPartial)
+	}
+}
 
-	logFatalOnErr(bagoup(opts, s, cdb))
+// dataSourceName returns the data source name for opening the database at the
+// given path, waiting up to the given number of seconds for it while it is
+// locked, e.g. by Messages.
+func dataSourceName(dbPath string, readOnly bool, busyTimeout int) string {
+	timeout := busyTimeout * 1000
+	if readOnly {
+		// The viewer only reads the database, so it is opened read-only to
+		// leave a live database untouched.
+		return fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", dbPath, timeout)
+	}
+	return fmt.Sprintf("%s?_busy_timeout=%d", dbPath, timeout)
+}
+
+// setupLogging replaces the default logger with one writing to standard error
+// in the format and at the level given by the options.
+func setupLogging(opts options) error {
+	level, err := logLevel(opts)
+	if err != nil {
+		return err
+	}
+	logging.SetDefault(logging.New(os.Stderr, logging.Format(opts.LogFormat), level))
+	return nil
+}
+
+// logLevel returns the minimum level of the log messages to write. --quiet
+// only logs errors, whatever the level.
+func logLevel(opts options) (logging.Level, error) {
+	if opts.Quiet {
+		return logging.Error, nil
+	}
+	level, err := logging.ParseLevel(opts.LogLevel)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse log level")
+	}
+	return level, nil
 }
 
 func logFatalOnErr(err error) {
 	if err != nil {
-		log.Fatalf("ERROR: %s", err)
+		logging.Errorf("%s", err)
+		os.Exit(_exitFatal)
 	}
 }
 
-func bagoup(opts options, s opsys.OS, cdb chatdb.ChatDB) error {
+// bagoup exports the chats, or writes the chat selected for --stdout, and
+// returns the summary of the run.
+func bagoup(opts options, s opsys.OS, cdb chatdb.ChatDB) (runSummary, error) {
+	summary := runSummary{Start: time.Now(), Options: opts}
+	if opts.Stdout {
+		return summary, streamChat(opts, s, cdb, _stdout)
+	}
+	if err := checkEmailOptions(opts); err != nil {
+		return summary, err
+	}
+	err := runExport(opts, s, cdb, &summary)
+	if opts.Notify || opts.NotifyWebhook != "" || opts.EmailSummary != "" {
+		notifyRun(s, _webhookClient, _sendMail, opts, summary, err)
+	}
+	return summary, err
+}
+
+// runExport exports the chats, recording the outcome in the given summary.
+func runExport(opts options, s opsys.OS, cdb chatdb.ChatDB, summary *runSummary) error {
 	if opts.DBPath == _defaultDBPath {
-		if f, err := s.Open(opts.DBPath); err != nil {
-			return errors.Wrapf(err, "test DB file %q - FIX: %s", opts.DBPath, _readmeURL)
+		dbPath, err := s.ExpandHome(opts.DBPath)
+		if err != nil {
+			return errors.Wrapf(err, "expand DB path %q", opts.DBPath)
+		}
+		if f, err := s.Open(dbPath); err != nil {
+			return errors.Wrapf(err, "test DB file %q - FIX: %s", dbPath, _readmeURL)
 		} else {
 			f.Close()
 		}
+		if running, err := s.ProcessRunning("Messages"); err != nil {
+			logging.Debugf("check if Messages is running: %s", err)
+		} else if running {
+			logging.Warnf("Messages is running and may lock chat.db or add messages to it during the export - FIX: quit Messages for a consistent export")
+		}
+	}
+
+	if err := checkForensicOptions(opts); err != nil {
+		return err
+	}
+	if err := checkIndexOptions(opts); err != nil {
+		return err
 	}
 
-	if exist, err := s.FileExist(opts.ExportPath); exist {
-		return fmt.Errorf("export folder %q already exists - FIX: move it or specify a different export path with the --export-path option", opts.ExportPath)
+	if exist, err := s.FileExist(opts.ExportPath); exist && !opts.Resume {
+		return fmt.Errorf("export folder %q already exists - FIX: move it, specify a different export path with the --export-path option, or resume an interrupted export with the --resume option", opts.ExportPath)
 	} else if err != nil {
 		return errors.Wrapf(err, "check export path %q", opts.ExportPath)
 	}
 
-	var macOSVersion *semver.Version
-	var err error
-	if opts.MacOSVersion != nil {
-		macOSVersion, err = semver.NewVersion(*opts.MacOSVersion)
-		if err != nil {
-			return errors.Wrapf(err, "parse Mac OS version %q", *opts.MacOSVersion)
+	macOSVersion, err := getMacOSVersion(opts, s)
+	if err != nil {
+		return err
+	}
+	contacts, err := getContacts(opts, s)
+	if err != nil {
+		return err
+	}
+
+	handleMap, err := cdb.GetHandleMap(contacts)
+	if err != nil {
+		return errors.Wrap(err, "get handle map")
+	}
+	synced, err := cdb.GetSyncedMessageCount()
+	if err != nil {
+		return errors.Wrap(err, "check Messages in iCloud")
+	}
+	if summary.ICloudSync = synced > 0; summary.ICloudSync {
+		logging.Warnf("Messages in iCloud is enabled, so chat.db may not include the full history of your chats, e.g. with Optimize Mac Storage - FIX: in Messages, open Settings > iMessage, click Sync Now, and wait for the whole history to download before exporting")
+	}
+	missing := checkDanglingJoins(cdb, opts, summary)
+
+	dropIndexes, err := addIndexes(opts, cdb)
+	if err != nil {
+		return err
+	}
+	defer dropIndexes()
+
+	var custody *custodyManifest
+	if opts.Forensic {
+		if custody, err = newCustodyManifest(s, opts, summary.Start); err != nil {
+			return errors.Wrap(err, "begin chain-of-custody manifest")
+		}
+	}
+
+	count, exportErr := exportChats(s, cdb, opts, macOSVersion, contacts, handleMap, missing, summary, custody)
+	summary.End = time.Now()
+	summary.Messages = count
+	if exportErr != nil {
+		summary.Errors = append(summary.Errors, exportErr.Error())
+	}
+	if err := writeRunSummary(s, opts.ExportPath, *summary); err != nil {
+		if exportErr == nil {
+			return errors.Wrap(err, "write run summary")
+		}
+		logging.Warnf("write run summary: %s", err)
+	}
+	if exportErr != nil {
+		return errors.Wrap(exportErr, "export chats")
+	}
+	if n := len(summary.UndecodableMessages); n > 0 {
+		logging.Warnf("%d messages could not be fully decoded and are marked %q - their IDs are listed in %q: %v", n, "[undecodable content]", _runSummaryFilename, summary.UndecodableMessages)
+	}
+	if custody != nil {
+		if err := custody.finish(s, opts.ExportPath, summary.End); err != nil {
+			return errors.Wrap(err, "write chain-of-custody manifest")
 		}
-	} else if macOSVersion, err = s.GetMacOSVersion(); err != nil {
-		return errors.Wrap(err, "get Mac OS version - FIX: specify the Mac OS version from which chat.db was copied with the --mac-os-version option")
 	}
+	logging.Infof("%d messages successfully exported to folder %q", count, opts.ExportPath)
+	return nil
+}
+
+// getMacOSVersion returns the version of Mac OS from which the Messages
+// database was copied, or nil if the date format should be detected from the
+// database contents.
+func getMacOSVersion(opts options, s opsys.OS) (*semver.Version, error) {
+	if opts.MacOSVersion != nil {
+		macOSVersion, err := semver.NewVersion(*opts.MacOSVersion)
+		return macOSVersion, errors.Wrapf(err, "parse Mac OS version %q", *opts.MacOSVersion)
+	}
+	if opts.DBPath != _defaultDBPath {
+		return nil, nil
+	}
+	// A copied database may come from another Mac, so the local version is
+	// only consulted for the database in its default location. Otherwise, the
+	// date encoding is detected from the database contents.
+	macOSVersion, err := s.GetMacOSVersion()
+	if err != nil {
+		logging.Warnf("get Mac OS version - detecting the date format from chat.db instead: %s", err)
+	} else {
+		logging.Debugf("running on Mac OS %s", macOSVersion)
+	}
+	return macOSVersion, nil
+}
 
+// getContacts returns a resolver of the contacts from the contacts file, with
+// the names from the names file taking precedence, or nil if neither is given.
+// The resolver looks up each handle only once.
+func getContacts(opts options, s opsys.OS) (chatdb.ContactResolver, error) {
 	var contactMap map[string]*vcard.Card
+	var err error
 	if opts.ContactsPath != nil {
 		contactMap, err = s.GetContactMap(*opts.ContactsPath)
 		if err != nil {
-			return errors.Wrapf(err, "get contacts from vcard file %q", *opts.ContactsPath)
+			return nil, errors.Wrapf(err, "get contacts from vcard file %q", *opts.ContactsPath)
 		}
 	}
-
-	handleMap, err := cdb.GetHandleMap(contactMap)
-	if err != nil {
-		return errors.Wrap(err, "get handle map")
+	if opts.NamesPath != nil {
+		nameMap, err := s.GetNameMap(*opts.NamesPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get names from file %q", *opts.NamesPath)
+		}
+		contactMap = addNameOverrides(contactMap, nameMap)
 	}
-
-	count, err := exportChats(s, cdb, opts.ExportPath, macOSVersion, contactMap, handleMap)
-	if err != nil {
-		return errors.Wrap(err, "export chats")
+	var contacts chatdb.ContactResolver
+	if contactMap != nil {
+		contacts = chatdb.NewContactCache(chatdb.ContactMap(contactMap))
 	}
-	fmt.Printf("%d messages successfully exported to folder %q\n", count, opts.ExportPath)
-	return nil
+	if opts.AliasesPath != "" {
+		// The aliases file has the same layout as the names file, with
+		// the current handles in place of the names.
+		aliases, err := s.GetNameMap(opts.AliasesPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get aliases from file %q", opts.AliasesPath)
+		}
+		contacts = chatdb.NewAliasResolver(contacts, aliases)
+	}
+	return contacts, nil
 }
 
 func exportChats(
 	s opsys.OS,
 	cdb chatdb.ChatDB,
-	exportPath string,
+	opts options,
 	macOSVersion *semver.Version,
-	contactMap map[string]*vcard.Card,
+	contacts chatdb.ContactResolver,
 	handleMap map[int]string,
+	missing map[int][]chatdb.MissingMessage,
+	summary *runSummary,
+	custody *custodyManifest,
 ) (int, error) {
 	count := 0
-	chats, err := cdb.GetChats(contactMap)
+	exp, err := exporter.New(opts.Format, s, opts.ExportPath)
 	if err != nil {
+		return count, err
+	}
+	filter, err := newMessageFilter(opts)
+	if err != nil {
+		return count, err
+	}
+	labels, err := newSelfLabels(opts, s)
+	if err != nil {
+		return count, err
+	}
+	classifier, err := newSenderClassifier(opts, contacts)
+	if err != nil {
+		return count, err
+	}
+	groups, err := newContactGroups(opts, contacts)
+	if err != nil {
+		return count, err
+	}
+	volumes, err := newVolumePlanner(cdb, opts)
+	if err != nil {
+		return count, err
+	}
+	if opts.OnlyGroups && opts.OnlyDirect {
+		return count, errors.New("--only-groups and --only-direct together exclude every chat - FIX: use at most one of them")
+	}
+	dirTemplate := opts.DirTemplate
+	if opts.GUIDFolders {
+		if dirTemplate != "" {
+			return count, errors.New("--guid-folders and --dir-template both name the chat folders - FIX: use at most one of them, e.g. --dir-template '{{.ContactName}}/{{.GUID}}'")
+		}
+		dirTemplate = _guidFolderTemplate
+	}
+	layout, err := newDirTemplate(dirTemplate)
+	if err != nil {
+		return count, err
+	}
+	var collator *collation.Collator
+	if opts.Collate != "" {
+		if collator, err = collation.New(opts.Collate); err != nil {
+			return count, errors.Wrap(err, "parse collation language")
+		}
+	}
+	_, finalizes := exp.(exporter.Finalizer)
+	if finalizes && opts.MaxDuration > 0 {
+		return count, fmt.Errorf("the %s format cannot resume an export, so --max-duration cannot stop it early - FIX: use the txt or mbox format, or remove --max-duration", opts.Format)
+	}
+	var deadline time.Time
+	if opts.MaxDuration > 0 {
+		deadline = summary.Start.Add(time.Duration(opts.MaxDuration) * time.Minute)
+	}
+	manifest := newResumeManifest(opts.ExportPath)
+	if opts.Resume {
+		if finalizes {
+			logging.Warnf("the %s format cannot skip exported chats - exporting all chats again", opts.Format)
+		} else if manifest, err = loadResumeManifest(s, opts.ExportPath); err != nil {
+			return count, errors.Wrap(err, "load resume manifest")
+		}
+	}
+	var statsTemplate *template.Template
+	for _, format := range opts.WordStats {
+		if format == "html" {
+			statsTemplate, err = assets.New(s, opts.AssetsDir).HTMLTemplate("stats.html")
+			if err != nil {
+				return count, errors.Wrap(err, "load word statistics template")
+			}
+		}
+	}
+	var linksTemplate *template.Template
+	for _, format := range opts.LinksReport {
+		if format == "html" {
+			linksTemplate, err = assets.New(s, opts.AssetsDir).HTMLTemplate("links.html")
+			if err != nil {
+				return count, errors.Wrap(err, "load links report template")
+			}
+		}
+	}
+	var chats []chatdb.Chat
+	if opts.Handle != "" {
+		chats, err = cdb.GetChatsForHandle(opts.Handle, contacts)
+		if err != nil {
+			return count, errors.Wrapf(err, "get chats for handle %q", opts.Handle)
+		}
+	} else if chats, err = cdb.GetChats(contacts); err != nil {
 		return count, errors.Wrap(err, "get chats")
 	}
+	if opts.Chat != "" {
+		if chats, err = chatsNamed(chats, opts.Chat); err != nil {
+			return count, err
+		}
+	}
+	logging.Debugf("found %d chats", len(chats))
+	if collator != nil {
+		sort.SliceStable(chats, func(i, j int) bool { return collator.Compare(chats[i].DisplayName, chats[j].DisplayName) < 0 })
+	}
+	recent, err := recentChats(chats, opts.Recent)
+	if err != nil {
+		return count, err
+	}
+	selected := chats
+	if recent != nil {
+		selected = make([]chatdb.Chat, 0, len(recent))
+		for _, chat := range chats {
+			if recent[chat.ID] {
+				selected = append(selected, chat)
+			}
+		}
+	}
+	if err := checkFreeSpace(s, cdb, opts, selected, manifest); err != nil {
+		return count, err
+	}
+	attachments, err := cdb.GetAttachmentPaths()
+	if err != nil {
+		return count, errors.Wrap(err, "get attachment paths")
+	}
+	var library photos.Library
+	if opts.PhotosLibrary != "" {
+		var closeLibrary func() error
+		if library, closeLibrary, err = _openPhotosLibrary(s, opts.PhotosLibrary, opts.BusyTimeout); err != nil {
+			return count, errors.Wrap(err, "open Photos library")
+		}
+		defer closeLibrary()
+	}
+	var downloader *iCloudDownloader
+	if opts.ICloudDownload {
+		if downloader, err = newICloudDownloader(s, opts.ExportPath, opts.ICloudTimeout); err != nil {
+			return count, err
+		}
+	}
+	var copier *attachmentCopier
+	if opts.CopyAttachments || opts.CloneAttachments {
+		workers := opts.CopyWorkers
+		if opts.Deterministic {
+			// Attachments with the same name are numbered in the order in
+			// which they are copied, so they are copied in export order.
+			workers = 1
+		}
+		copier = newAttachmentCopier(s, workers, opts.CopyRateLimit, opts.CopyRetries, opts.CloneAttachments)
+		defer copier.wait()
+		if custody != nil {
+			copier.recordChecksums()
+		}
+		if opts.Resume {
+			copier.reuseCopies()
+		}
+	}
+	folders := newChatFolders(s, opts.ExportPath)
+	var attRefs []attachmentRef
+	modTimes := chatModTimes{}
+	var index [][]string
+	var gaps [][]string
+	var links []sharedLink
 	for _, chat := range chats {
-		chatDirPath := path.Join(exportPath, chat.DisplayName)
-		if err := s.MkdirAll(chatDirPath, os.ModePerm); err != nil {
-			return count, errors.Wrapf(err, "create directory %q", chatDirPath)
+		// Folders are named before chats are skipped, so that each chat
+		// has the same folder however many chats are exported.
+		dir, folder, err := chatFolder(layout, folders, classifier.dir(chat), chat, groups.group(chat))
+		if err != nil {
+			return count, err
+		}
+		if opts.GUIDFolders {
+			index = append(index, []string{path.Join(dir, folder), chat.GUID, chat.DisplayName})
+		}
+		if recent != nil && !recent[chat.ID] {
+			summary.SkippedChats++
+			continue
+		}
+		if !groups.keep(chat) {
+			summary.SkippedChats++
+			continue
 		}
-		chatPath := path.Join(chatDirPath, fmt.Sprintf("%s.txt", chat.GUID))
-		chatFile, err := s.OpenFile(chatPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if done, err := manifest.done(s, chat.GUID); err != nil {
+			return count, errors.Wrapf(err, "check export of chat %q", chat.GUID)
+		} else if done {
+			summary.ResumedChats++
+			continue
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			summary.RemainingChats++
+			continue
+		}
+		participantIDs, err := cdb.GetParticipants(chat.ID)
 		if err != nil {
-			return count, errors.Wrapf(err, "open/create file %s", chatPath)
+			return count, errors.Wrapf(err, "get participants for chat ID %d", chat.ID)
+		}
+		if !keepChatKind(opts, participantIDs) {
+			summary.SkippedChats++
+			continue
 		}
-		defer chatFile.Close()
-
 		messageIDs, err := cdb.GetMessageIDs(chat.ID)
 		if err != nil {
 			return count, errors.Wrapf(err, "get message IDs for chat ID %d", chat.ID)
 		}
+		if len(messageIDs) < opts.MinMessages {
+			summary.SkippedChats++
+			summary.SmallChats = append(summary.SmallChats, smallChat{GUID: chat.GUID, Name: chat.DisplayName, Messages: len(messageIDs)})
+			continue
+		}
+		selfLabel := labels.label(len(participantIDs) > 1)
+		chatHandleMap := handleMap
+		if len(participantIDs) > 1 {
+			chatHandleMap = cdb.DisambiguateHandles(handleMap, participantIDs)
+		}
+		msgs := make([]chatdb.Message, 0, len(messageIDs))
+		var raws []chatdb.RawMessage
 		for _, messageID := range messageIDs {
-			msg, err := cdb.GetMessage(messageID, handleMap, macOSVersion)
+			msg, err := cdb.GetMessage(messageID, chatHandleMap, macOSVersion)
 			if err != nil {
 				return count, errors.Wrapf(err, "get message with ID %d", messageID)
 			}
-			if _, err := chatFile.WriteString(msg); err != nil {
-				return count, errors.Wrapf(err, "write message %q to file %q", msg, chatFile.Name())
+			if !opts.OriginHints {
+				msg.Hints = nil
+			}
+			if msg.Undecodable {
+				summary.UndecodableMessages = append(summary.UndecodableMessages, msg.ID)
+			}
+			msgs = append(msgs, relabel(msg, selfLabel))
+			if opts.Forensic {
+				raw, err := cdb.GetRawMessage(messageID)
+				if err != nil {
+					return count, errors.Wrapf(err, "get raw message with ID %d", messageID)
+				}
+				raws = append(raws, raw)
+			}
+		}
+		if opts.DanglingJoins == "stub" {
+			msgs = stubMissingMessages(msgs, missing[chat.ID])
+		}
+		timeline := getParticipantTimeline(msgs, participantIDs, chatHandleMap)
+		if opts.GapDays > 0 {
+			gaps = append(gaps, findChatGaps(chat, msgs, opts.GapDays)...)
+		}
+		if len(opts.LinksReport) > 0 {
+			links = append(links, findLinks(chat, msgs)...)
+		}
+		if opts.DedupWindow > 0 {
+			msgs = dedupServiceFallbacks(msgs, time.Duration(opts.DedupWindow)*time.Second, cdb.CanonicalHandle)
+		}
+		if filter.active() {
+			msgs = filter.apply(msgs)
+			if len(msgs) == 0 {
+				summary.SkippedChats++
+				continue
+			}
+		}
+
+		if volumes != nil {
+			dir = volumes.dir(chat, dir, folder)
+			if opts.GUIDFolders {
+				index[len(index)-1][0] = path.Join(dir, folder)
+			}
+		}
+		members := []string{selfLabel}
+		for _, id := range participantIDs {
+			members = append(members, chatHandleMap[id])
+		}
+		logging.Debugf("exporting %d messages of chat %q", len(msgs), chat.GUID)
+		out, err := exp.Begin(exporter.Chat{Chat: chat, Members: members, Participants: timeline, Dir: dir, Folder: folder})
+		if err != nil {
+			return count, errors.Wrapf(err, "begin exporting chat %q", chat.GUID)
+		}
+
+		heatmap := stats.NewHeatmap()
+		wordStats := stats.NewWordStats()
+		for _, msg := range msgs {
+			if msg.DateSource != chatdb.DateUnknown {
+				heatmap.Add(msg.Date)
+			}
+			wordStats.Add(msg.Handle, string(msg.Kind()), msg.Text)
+			msgAttachments := attachments[msg.ID]
+			expired, err := isExpiredAudio(s, msg, msgAttachments)
+			if err != nil {
+				return count, errors.Wrapf(err, "check expiration of message with ID %d", msg.ID)
+			}
+			if expired {
+				msg.Text = insertSummaries(msg.Text, []string{_expiredAudioSummary})
+				msgAttachments = nil
+			}
+			msgAttachments, found, err := findInPhotos(s, library, msgAttachments)
+			if err != nil {
+				return count, errors.Wrapf(err, "find attachments of message with ID %d in Photos", msg.ID)
+			}
+			summary.AttachmentsFromPhotos += found
+			for _, att := range msgAttachments {
+				attRefs = append(attRefs, attachmentRef{chat: chat.DisplayName, messageID: msg.ID, att: att})
+			}
+			msgAttachments, err = downloader.fetch(msgAttachments)
+			if err != nil {
+				return count, errors.Wrapf(err, "download attachments of message with ID %d from iCloud", msg.ID)
+			}
+			msg.Text, err = exportAttachments(s, msg.Text, msgAttachments, out.Dir, copier, opts.OCR)
+			if err != nil {
+				return count, errors.Wrapf(err, "export attachments for message with ID %d", msg.ID)
+			}
+			if err := exp.WriteMessage(msg); err != nil {
+				return count, errors.Wrapf(err, "export message with ID %d", msg.ID)
 			}
 			count++
 		}
-		chatFile.Close()
+		if err := exp.Finish(); err != nil {
+			return count, errors.Wrapf(err, "finish exporting chat %q", chat.GUID)
+		}
+		summary.Chats++
+		modTimes.add(out.Dir, msgs)
+		if (opts.HashChain || opts.Forensic) && out.Path != "" {
+			if err := writeHashChain(s, out.Path); err != nil {
+				return count, errors.Wrapf(err, "write hash chain for chat %q", chat.GUID)
+			}
+		}
+		if opts.Forensic {
+			rawPath, err := writeRawMessages(s, out.Dir, chat, raws)
+			if err != nil {
+				return count, errors.Wrapf(err, "write raw messages of chat %q", chat.GUID)
+			}
+			if err := writeHashChain(s, rawPath); err != nil {
+				return count, errors.Wrapf(err, "write hash chain for chat %q", chat.GUID)
+			}
+		}
+		if opts.Spotlight && out.Path != "" {
+			metadata := spotlightMetadata(chat, members[1:], msgs, selfLabel)
+			if err := s.SetSpotlightMetadata(out.Path, metadata); err != nil {
+				logging.Warnf("set Spotlight metadata of chat %q: %s", chat.GUID, err)
+			}
+		}
+		if opts.PostChatHook != "" {
+			info := chatHookInfo{
+				GUID:        chat.GUID,
+				DisplayName: chat.DisplayName,
+				Path:        out.Path,
+				Format:      opts.Format,
+				Messages:    len(msgs),
+				Pinned:      chat.Pinned,
+				Archived:    chat.Archived,
+			}
+			if err := runPostChatHook(s, opts.PostChatHook, info); err != nil {
+				return count, errors.Wrapf(err, "run post-chat hook for chat %q", chat.GUID)
+			}
+		}
+		if len(msgs) > 0 {
+			if err := writeChatStats(s, opts, chat, out.Dir, heatmap, wordStats, statsTemplate); err != nil {
+				return count, err
+			}
+		}
+		if copier != nil {
+			// The chat is not done until its attachments are copied.
+			guid, chatPath := chat.GUID, out.Path
+			copier.whenCopied(func() error {
+				return errors.Wrapf(manifest.record(s, guid, chatPath), "record export of chat %q", guid)
+			})
+		} else if err := manifest.record(s, chat.GUID, out.Path); err != nil {
+			return count, errors.Wrapf(err, "record export of chat %q", chat.GUID)
+		}
+	}
+	if summary.RemainingChats > 0 {
+		logging.Warnf("stopped after --max-duration of %d minutes with %d chats left to export - FIX: run bagoup again with --resume to export them", opts.MaxDuration, summary.RemainingChats)
+	}
+	if f, ok := exp.(exporter.Finalizer); ok {
+		if err := f.FinishExport(); err != nil {
+			return count, errors.Wrap(err, "finish export")
+		}
+	}
+	if copier != nil {
+		if err := copier.wait(); err != nil {
+			return count, errors.Wrap(err, "copy attachments")
+		}
+		if custody != nil {
+			custody.copied = copier.checksums
+		}
+	}
+	if opts.Deterministic {
+		if err := modTimes.apply(s); err != nil {
+			return count, err
+		}
+	}
+	summary.Attachments = len(attRefs)
+	if downloader != nil {
+		summary.AttachmentsFromICloud = downloader.downloaded
+	}
+	problems, err := verifyAttachments(s, attRefs)
+	if err != nil {
+		return count, errors.Wrap(err, "verify attachments")
+	}
+	summary.AttachmentProblems = len(problems)
+	if len(problems) > 0 {
+		reportPath, err := writeAttachmentReport(s, opts.ExportPath, problems)
+		if err != nil {
+			return count, errors.Wrap(err, "write attachment report")
+		}
+		logging.Warnf("%d attachments are missing or corrupt - see %q", len(problems), reportPath)
+	}
+	if opts.GUIDFolders {
+		indexPath, err := writeChatIndex(s, opts.ExportPath, index)
+		if err != nil {
+			return count, errors.Wrap(err, "write chat index")
+		}
+		logging.Infof("the chats in the folders named after their GUIDs are listed in %q", indexPath)
+	}
+	if len(gaps) > 0 {
+		reportPath, err := writeGapReport(s, opts.ExportPath, gaps)
+		if err != nil {
+			return count, errors.Wrap(err, "write gap report")
+		}
+		logging.Warnf("%d long gaps without messages in active chats may be lost messages - see %q", len(gaps), reportPath)
+	}
+	if len(opts.LinksReport) > 0 {
+		reportPaths, err := writeLinksReport(s, opts.ExportPath, links, opts.LinksReport, linksTemplate)
+		if err != nil {
+			return count, errors.Wrap(err, "write links report")
+		}
+		logging.Infof("%d shared links collected in %s", len(links), strings.Join(reportPaths, ", "))
 	}
 	return count, nil
 }
+
+// writeChatStats writes the heatmap and word statistics of a chat into the
+// given folder, in the formats given by the options.
+func writeChatStats(
+	s opsys.OS,
+	opts options,
+	chat chatdb.Chat,
+	dirPath string,
+	heatmap *stats.Heatmap,
+	wordStats *stats.WordStats,
+	statsTemplate *template.Template,
+) error {
+	// Chats whose messages all have unknown dates have no heatmap.
+	if opts.Heatmap != "" && !heatmap.Empty() {
+		heatmapPath := path.Join(dirPath, fmt.Sprintf("%s-heatmap.%s", chat.GUID, opts.Heatmap))
+		write := heatmap.WriteSVG
+		if opts.Heatmap == "png" {
+			write = heatmap.WritePNG
+		}
+		if err := exporter.WriteFile(s, heatmapPath, write); err != nil {
+			return errors.Wrapf(err, "write heatmap for chat %q", chat.GUID)
+		}
+	}
+	report := wordStats.Report()
+	for _, format := range opts.WordStats {
+		statsPath := path.Join(dirPath, fmt.Sprintf("%s-stats.%s", chat.GUID, format))
+		write := func(w io.Writer) error { return stats.WriteJSON(w, report) }
+		switch format {
+		case "csv":
+			write = func(w io.Writer) error { return stats.WriteCSV(w, report) }
+		case "html":
+			write = func(w io.Writer) error { return stats.WriteHTML(w, statsTemplate, chat.DisplayName, report) }
+		}
+		if err := exporter.WriteFile(s, statsPath, write); err != nil {
+			return errors.Wrapf(err, "write word statistics for chat %q", chat.GUID)
+		}
+	}
+	return nil
+}
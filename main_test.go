@@ -4,29 +4,54 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/golang/mock/gomock"
 	"github.com/spf13/afero"
 	"github.com/tagatac/bagoup/chatdb"
 	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/logging"
 	"github.com/tagatac/bagoup/opsys"
 	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"github.com/tagatac/bagoup/stats"
 	"gotest.tools/v3/assert"
 )
 
+var _testDate = time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)
+
+func testMessage(id int, text string) chatdb.Message {
+	return chatdb.Message{
+		ID:      id,
+		Date:    _testDate,
+		Handle:  "Novak",
+		Text:    fmt.Sprintf(text, id),
+		RawText: fmt.Sprintf(text, id),
+	}
+}
+
+func summaryFile(t *testing.T) afero.File {
+	f, err := afero.NewMemMapFs().Create("run-summary.json")
+	assert.NilError(t, err)
+	return f
+}
+
 func TestBagoup(t *testing.T) {
 	defaultOpts := options{
 		DBPath:     "~/Library/Messages/chat.db",
 		ExportPath: "backup",
+		Format:     "txt",
 		SelfHandle: "Me",
 	}
 	tenDotTwelve := "10.12"
 	tenDotTenDotTenDotTen := "10.10.10.10"
 	contactsPath := "contacts.vcf"
+	namesPath := "names.csv"
 
 	tests := []struct {
 		msg        string
@@ -39,11 +64,20 @@ func TestBagoup(t *testing.T) {
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
 					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
 					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
 				)
 			},
 		},
@@ -51,39 +85,135 @@ func TestBagoup(t *testing.T) {
 			msg:  "default options running on Mac OS",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
-				osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(nil, errors.New("this is a permissions error"))
+				osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil)
+				osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(nil, errors.New("this is a permissions error"))
+			},
+			wantErr: `test DB file "/Users/david/Library/Messages/chat.db" - FIX: https://github.com/tagatac/bagoup/blob/master/README.md#chatdb-access: this is a permissions error`,
+		},
+		{
+			msg:  "Messages running",
+			opts: defaultOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(true, nil),
+					osMock.EXPECT().FileExist("backup").Return(true, nil),
+				)
 			},
-			wantErr: `test DB file "~/Library/Messages/chat.db" - FIX: https://github.com/tagatac/bagoup/blob/master/README.md#chatdb-access: this is a permissions error`,
+			wantErr: `export folder "backup" already exists`,
+		},
+		{
+			msg: "email summary without SMTP server",
+			opts: options{
+				DBPath:       "~/Library/Messages/chat.db",
+				ExportPath:   "backup",
+				Format:       "txt",
+				SelfHandle:   "Me",
+				EmailSummary: "david@example.com",
+			},
+			setupMocks: func(*mock_opsys.MockOS, *mock_chatdb.MockChatDB) {},
+			wantErr:    "--email-summary needs an SMTP server to send the summary through - FIX: pass --smtp-server",
+		},
+		{
+			msg:  "process check error",
+			opts: defaultOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, errors.New("this is an exec error")),
+					osMock.EXPECT().FileExist("backup").Return(true, nil),
+				)
+			},
+			wantErr: `export folder "backup" already exists`,
 		},
 		{
 			msg:  "default options running on Windows",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(nil, errors.New("this is an exec error")),
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
+					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
+				)
+			},
+		},
+		{
+			msg: "copied chat.db without version",
+			opts: options{
+				DBPath:     "chat.db",
+				ExportPath: "backup",
+				Format:     "txt",
+				SelfHandle: "Me",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
+					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
 				)
 			},
-			wantErr: "get Mac OS version - FIX: specify the Mac OS version from which chat.db was copied with the --mac-os-version option: this is an exec error",
 		},
 		{
 			msg:  "export path exists",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(true, nil),
 				)
 			},
-			wantErr: `export folder "backup" already exists - FIX: move it or specify a different export path with the --export-path option`,
+			wantErr: `export folder "backup" already exists - FIX: move it, specify a different export path with the --export-path option, or resume an interrupted export with the --resume option`,
+		},
+		{
+			msg: "export path exists with notification",
+			opts: options{
+				DBPath:     "~/Library/Messages/chat.db",
+				ExportPath: "backup",
+				Format:     "txt",
+				SelfHandle: "Me",
+				Notify:     true,
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(true, nil),
+					osMock.EXPECT().Notify("bagoup", gomock.Any()).Return(nil),
+				)
+			},
+			wantErr: `export folder "backup" already exists`,
 		},
 		{
 			msg:  "error checking export path",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, errors.New("this is a stat error")),
 				)
 			},
@@ -94,15 +224,25 @@ func TestBagoup(t *testing.T) {
 			opts: options{
 				DBPath:       "~/Library/Messages/chat.db",
 				ExportPath:   "backup",
+				Format:       "txt",
 				MacOSVersion: &tenDotTwelve,
 				SelfHandle:   "Me",
 			},
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
 					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
 				)
 			},
 		},
@@ -111,12 +251,15 @@ func TestBagoup(t *testing.T) {
 			opts: options{
 				DBPath:       "~/Library/Messages/chat.db",
 				ExportPath:   "backup",
+				Format:       "txt",
 				MacOSVersion: &tenDotTenDotTenDotTen,
 				SelfHandle:   "Me",
 			},
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 				)
 			},
@@ -127,17 +270,27 @@ func TestBagoup(t *testing.T) {
 			opts: options{
 				DBPath:       "~/Library/Messages/chat.db",
 				ExportPath:   "backup",
+				Format:       "txt",
 				ContactsPath: &contactsPath,
 				SelfHandle:   "Me",
 			},
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
 					osMock.EXPECT().GetContactMap("contacts.vcf").Return(nil, nil),
 					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
 					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
 				)
 			},
 		},
@@ -146,12 +299,15 @@ func TestBagoup(t *testing.T) {
 			opts: options{
 				DBPath:       "~/Library/Messages/chat.db",
 				ExportPath:   "backup",
+				Format:       "txt",
 				ContactsPath: &contactsPath,
 				SelfHandle:   "Me",
 			},
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
 					osMock.EXPECT().GetContactMap("contacts.vcf").Return(nil, errors.New("this is an os error")),
@@ -159,12 +315,114 @@ func TestBagoup(t *testing.T) {
 			},
 			wantErr: `get contacts from vcard file "contacts.vcf": this is an os error`,
 		},
+		{
+			msg: "names file specified",
+			opts: options{
+				DBPath:     "~/Library/Messages/chat.db",
+				ExportPath: "backup",
+				Format:     "txt",
+				NamesPath:  &namesPath,
+				SelfHandle: "Me",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					osMock.EXPECT().GetNameMap("names.csv").Return(map[string]string{"+14155555555": "Rafa"}, nil),
+					dbMock.EXPECT().GetHandleMap(gomock.Not(gomock.Nil())).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
+					dbMock.EXPECT().GetChats(gomock.Not(gomock.Nil())).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
+				)
+			},
+		},
+		{
+			msg: "error getting name map",
+			opts: options{
+				DBPath:     "~/Library/Messages/chat.db",
+				ExportPath: "backup",
+				Format:     "txt",
+				NamesPath:  &namesPath,
+				SelfHandle: "Me",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					osMock.EXPECT().GetNameMap("names.csv").Return(nil, errors.New("this is an os error")),
+				)
+			},
+			wantErr: `get names from file "names.csv": this is an os error`,
+		},
+		{
+			msg: "aliases file specified",
+			opts: options{
+				DBPath:      "~/Library/Messages/chat.db",
+				ExportPath:  "backup",
+				Format:      "txt",
+				AliasesPath: "aliases.csv",
+				SelfHandle:  "Me",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					osMock.EXPECT().GetNameMap("aliases.csv").Return(map[string]string{"+14155550000": "+14155555555"}, nil),
+					dbMock.EXPECT().GetHandleMap(gomock.Not(gomock.Nil())).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
+					dbMock.EXPECT().GetChats(gomock.Not(gomock.Nil())).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
+				)
+			},
+		},
+		{
+			msg: "error getting aliases",
+			opts: options{
+				DBPath:      "~/Library/Messages/chat.db",
+				ExportPath:  "backup",
+				Format:      "txt",
+				AliasesPath: "aliases.csv",
+				SelfHandle:  "Me",
+			},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					osMock.EXPECT().GetNameMap("aliases.csv").Return(nil, errors.New("this is an os error")),
+				)
+			},
+			wantErr: `get aliases from file "aliases.csv": this is an os error`,
+		},
 		{
 			msg:  "error getting handle map",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
 					dbMock.EXPECT().GetHandleMap(nil).Return(nil, errors.New("this is a DB error")),
@@ -172,16 +430,61 @@ func TestBagoup(t *testing.T) {
 			},
 			wantErr: "get handle map: this is a DB error",
 		},
+		{
+			msg:  "error checking Messages in iCloud",
+			opts: defaultOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, errors.New("this is a DB error")),
+				)
+			},
+			wantErr: "check Messages in iCloud: this is a DB error",
+		},
+		{
+			msg:  "Messages in iCloud",
+			opts: defaultOpts,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
+					osMock.EXPECT().FileExist("backup").Return(false, nil),
+					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(3, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
+					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+					osMock.EXPECT().FilenameRules("backup").Return(opsys.FilenameRules{MaxNameBytes: 255}, nil),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
+				)
+			},
+		},
 		{
 			msg:  "export chats error",
 			opts: defaultOpts,
 			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
 				gomock.InOrder(
-					osMock.EXPECT().Open("~/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ExpandHome("~/Library/Messages/chat.db").Return("/Users/david/Library/Messages/chat.db", nil),
+					osMock.EXPECT().Open("/Users/david/Library/Messages/chat.db").Return(&os.File{}, nil),
+					osMock.EXPECT().ProcessRunning("Messages").Return(false, nil),
 					osMock.EXPECT().FileExist("backup").Return(false, nil),
 					osMock.EXPECT().GetMacOSVersion().Return(semver.MustParse("10.15"), nil),
 					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil),
+					dbMock.EXPECT().GetDanglingJoins(),
 					dbMock.EXPECT().GetChats(nil).Return(nil, errors.New("this is a DB error")),
+					osMock.EXPECT().MkdirAll("backup", os.ModePerm).Return(nil),
+					osMock.EXPECT().Create("backup/run-summary.json.partial").Return(summaryFile(t), nil),
+					osMock.EXPECT().Rename("backup/run-summary.json.partial", "backup/run-summary.json").Return(nil),
 				)
 			},
 			wantErr: "export chats: get chats: this is a DB error",
@@ -196,7 +499,7 @@ func TestBagoup(t *testing.T) {
 			dbMock := mock_chatdb.NewMockChatDB(ctrl)
 			tt.setupMocks(osMock, dbMock)
 
-			err := bagoup(tt.opts, osMock, dbMock)
+			_, err := bagoup(tt.opts, osMock, dbMock)
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
 				return
@@ -207,12 +510,45 @@ func TestBagoup(t *testing.T) {
 }
 
 func TestExportChats(t *testing.T) {
+	heatmap := stats.NewHeatmap()
+	heatmap.Add(_testDate)
+	var heatmapSVG bytes.Buffer
+	assert.NilError(t, heatmap.WriteSVG(&heatmapSVG))
+
 	tests := []struct {
 		msg       string
 		setupMock func(*mock_chatdb.MockChatDB)
 		roFs      bool
+		format    string
+		copyAtts  bool
+		heatmap   string
+		wordStats []string
+		match     string
+		assetsDir string
+		handle    string
+		resume    bool
+		hints     bool
+		hashChain bool
+		forensic  bool
+		collate   string
+		minMsgs   int
+		recent    int
+		dirTmpl   string
+		determ    bool
+		guidDirs  bool
+		groups    bool
+		direct    bool
+		maxDur    int
+		ctGroups  []string
+		missing   map[int][]chatdb.MissingMessage
+		dangling  string
+		volSize   float64
+		elapsed   time.Duration
+		setupFs   func(afero.Fs)
 		wantFiles map[string]string
 		wantCount int
+		wantChats int
+		wantLeft  int
 		wantErr   string
 	}{
 		{
@@ -235,22 +571,815 @@ func TestExportChats(t *testing.T) {
 						DisplayName: "testdisplayname2",
 					},
 				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
 				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100, 200}, nil)
-				dbMock.EXPECT().GetMessage(100, nil, nil).Return("message100\n", nil)
-				dbMock.EXPECT().GetMessage(200, nil, nil).Return("message200\n", nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(testMessage(200, "message%d"), nil)
 				dbMock.EXPECT().GetMessageIDs(2).Return([]int{300, 400}, nil)
-				dbMock.EXPECT().GetMessage(300, nil, nil).Return("message300\n", nil)
-				dbMock.EXPECT().GetMessage(400, nil, nil).Return("message400\n", nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(testMessage(300, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(400, nil, nil).Return(testMessage(400, "message%d"), nil)
 				dbMock.EXPECT().GetMessageIDs(3).Return([]int{500, 600}, nil)
-				dbMock.EXPECT().GetMessage(500, nil, nil).Return("message500\n", nil)
-				dbMock.EXPECT().GetMessage(600, nil, nil).Return("message600\n", nil)
+				dbMock.EXPECT().GetParticipants(3).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(500, nil, nil).Return(testMessage(500, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(600, nil, nil).Return(testMessage(600, "message%d"), nil)
 			},
 			wantFiles: map[string]string{
-				"backup/testdisplayname/testguid.txt":   "message100\nmessage200\n",
-				"backup/testdisplayname/testguid2.txt":  "message300\nmessage400\n",
-				"backup/testdisplayname2/testguid3.txt": "message500\nmessage600\n",
+				"backup/testdisplayname/testguid.txt":   "[2020-03-01 15:34:05] Novak: message100\n[2020-03-01 15:34:05] Novak: message200\n",
+				"backup/testdisplayname/testguid2.txt":  "[2020-03-01 15:34:05] Novak: message300\n[2020-03-01 15:34:05] Novak: message400\n",
+				"backup/testdisplayname2/testguid3.txt": "[2020-03-01 15:34:05] Novak: message500\n[2020-03-01 15:34:05] Novak: message600\n",
 			},
 			wantCount: 6,
+			wantChats: 3,
+		},
+		{
+			msg: "resume",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+					{
+						ID:          2,
+						GUID:        "testguid2",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{300, 400}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(testMessage(300, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(400, nil, nil).Return(testMessage(400, "message%d"), nil)
+			},
+			resume: true,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/testdisplayname/testguid.txt", []byte("[2020-03-01 15:34:05] Novak: message100\n[2020-03-01 15:34:05] Novak: message200\n"), 0644)
+				afero.WriteFile(fs, "backup/testdisplayname/testguid2.txt.partial", []byte("[2020-03-01 15:34:05] Novak: mess"), 0644)
+				afero.WriteFile(fs, "backup/.bagoup-resume.json", []byte(`{"chats": {"testguid": {"path": "backup/testdisplayname/testguid.txt", "size": 80, "sha256": "e84efb10c72e9ec70a75a32b9abe893996984a1dc9151e9149283b212e19700a"}}}`), 0644)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt":  "[2020-03-01 15:34:05] Novak: message100\n[2020-03-01 15:34:05] Novak: message200\n",
+				"backup/testdisplayname/testguid2.txt": "[2020-03-01 15:34:05] Novak: message300\n[2020-03-01 15:34:05] Novak: message400\n",
+			},
+			wantCount: 2,
+			wantChats: 1,
+		},
+		{
+			msg: "attachments",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(map[int][]chatdb.Attachment{
+					100: {
+						{ID: 1, Filename: "/attachments/photo.jpeg", MIMEType: "image/jpeg"},
+						{ID: 2, Filename: "/attachments/jane.vcf", MIMEType: "text/vcard"},
+					},
+				}, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d \ufffc\ufffc"), nil)
+			},
+			copyAtts: true,
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+				afero.WriteFile(fs, "/attachments/jane.vcf", []byte("BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n"), 0644)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt":           "[2020-03-01 15:34:05] Novak: message100 \ufffcShared contact: Jane Doe, +14155555555\n",
+				"backup/testdisplayname/attachments/photo.jpeg": "jpeg data",
+				"backup/testdisplayname/attachments/jane.vcf":   "BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "missing attachment",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(map[int][]chatdb.Attachment{
+					100: {{ID: 1, Filename: "/attachments/photo.jpeg", MIMEType: "image/jpeg", TotalBytes: 9}},
+				}, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d \ufffc"), nil)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100 \ufffc\n",
+				"backup/attachment-report.csv":        "chat,message_id,path,problem,expected_bytes,actual_bytes\ntestdisplayname,100,/attachments/photo.jpeg,missing,9,0\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "statistics",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+					{
+						ID:          2,
+						GUID:        "testguid2",
+						DisplayName: "testdisplayname2",
+					},
+					{
+						ID:          3,
+						GUID:        "testguid3",
+						DisplayName: "testdisplayname3",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				undated := testMessage(300, "message%d")
+				undated.DateSource = chatdb.DateUnknown
+				dbMock.EXPECT().GetMessageIDs(3).Return([]int{300}, nil)
+				dbMock.EXPECT().GetParticipants(3).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(undated, nil)
+			},
+			heatmap:   "svg",
+			wordStats: []string{"csv"},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt":         "[2020-03-01 15:34:05] Novak: message100\n",
+				"backup/testdisplayname/testguid-heatmap.svg": heatmapSVG.String(),
+				"backup/testdisplayname2/testguid2.txt":       "",
+				"backup/testdisplayname3/testguid3.txt":       "[date unknown] Novak: message300\n",
+			},
+			wantCount: 2,
+			wantChats: 3,
+		},
+		{
+			msg: "mbox",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			format: "mbox",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.mbox": fmt.Sprintf(`From Novak@bagoup.invalid %s
+From: "Novak" <Novak@bagoup.invalid>
+Date: %s
+Subject: testdisplayname
+Message-ID: <message-100@bagoup.invalid>
+X-Message-Kind: text
+MIME-Version: 1.0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 8bit
+
+message100
+
+`, _testDate.Format(time.ANSIC), _testDate.Format(time.RFC1123Z)),
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "slack",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "Test Display Name",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			format: "slack",
+			wantFiles: map[string]string{
+				"backup/test-display-name/2020-03-01.json": fmt.Sprintf(`[
+  {
+    "type": "message",
+    "user": "U0001",
+    "text": "message100",
+    "raw_text": "message100",
+    "kind": "text",
+    "ts": "%d.000000"
+  }
+]
+`, _testDate.Unix()),
+				"backup/channels.json": fmt.Sprintf(`[
+  {
+    "id": "C0001",
+    "name": "test-display-name",
+    "created": %d,
+    "members": [],
+    "is_archived": false
+  }
+]
+`, _testDate.Unix()),
+				"backup/users.json": `[
+  {
+    "id": "U0001",
+    "name": "Novak",
+    "real_name": "Novak"
+  }
+]
+`,
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "matrix",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			format: "matrix",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.json": fmt.Sprintf(`{
+  "room_id": "!chat1:bagoup.invalid",
+  "name": "testdisplayname",
+  "events": [
+    {
+      "type": "m.room.message",
+      "event_id": "$message100:bagoup.invalid",
+      "sender": "@novak:bagoup.invalid",
+      "origin_server_ts": %d,
+      "content": {
+        "msgtype": "m.text",
+        "body": "message100",
+        "net.bagoup.raw_body": "message100",
+        "net.bagoup.kind": "text"
+      }
+    }
+  ]
+}
+`, _testDate.Unix()*1000),
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "expired audio message",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(map[int][]chatdb.Attachment{
+					100: {{Filename: "/attachments/expired.caf", TotalBytes: 1024}},
+				}, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				msg := testMessage(100, "")
+				msg.Text = "\ufffc"
+				msg.UnkeptAudio = true
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(msg, nil)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: Audio message (expired, not kept)\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "origin hints",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				msg := testMessage(100, "message%d")
+				msg.Hints = []string{"sent with Digital Touch"}
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(msg, nil)
+			},
+			hints: true,
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100 (sent with Digital Touch)\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "origin hints off",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				msg := testMessage(100, "message%d")
+				msg.Hints = []string{"sent with Digital Touch"}
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(msg, nil)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "hash chain",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			hashChain: true,
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt":             "[2020-03-01 15:34:05] Novak: message100\n",
+				"backup/testdisplayname/testguid.txt.sha256chain": "a172f0b99660e81afa7686615cc546ad1a2f3a7fe13171a4eee1277e0ca87a0e\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "forensic",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetRawMessage(100).Return(chatdb.RawMessage{ROWID: 100, GUID: "msgguid", Service: "iMessage", Date: 604707245000000000}, nil)
+			},
+			forensic: true,
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt":       "[2020-03-01 15:34:05] Novak: message100\n",
+				"backup/testdisplayname/testguid.raw.jsonl": `{"chat_rowid":1,"chat_guid":"testguid","rowid":100,"guid":"msgguid","handle_id":0,"text":null,"service":"iMessage","account":"","date":604707245000000000,"date_read":0,"date_delivered":0,"is_from_me":false,"is_read":false,"is_delivered":false,"is_sent":false,"item_type":0,"group_action_type":0,"error":0}` + "\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "GetRawMessage error",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"}}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetRawMessage(100).Return(chatdb.RawMessage{}, errors.New("this is a DB error"))
+			},
+			forensic: true,
+			wantErr:  "get raw message with ID 100: this is a DB error",
+		},
+		{
+			msg: "collated",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "Zoë"},
+					{ID: 2, GUID: "testguid2", DisplayName: "émile"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				gomock.InOrder(
+					dbMock.EXPECT().GetMessageIDs(2).Return(nil, nil),
+					dbMock.EXPECT().GetMessageIDs(1).Return(nil, nil),
+				)
+				dbMock.EXPECT().GetParticipants(gomock.Any()).Return(nil, nil).Times(2)
+			},
+			collate:   "en",
+			wantChats: 2,
+		},
+		{
+			msg:       "bad collation language",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			collate:   "not a language",
+			wantErr:   `parse collation language: unknown language "not a language"`,
+		},
+		{
+			msg: "only groups",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+					{ID: 2, GUID: "testguid2", DisplayName: "testdisplayname2"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return([]int{10}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return([]int{10, 11}, nil)
+				dbMock.EXPECT().DisambiguateHandles(nil, []int{10, 11}).Return(nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return(nil, nil)
+			},
+			groups:    true,
+			wantChats: 1,
+		},
+		{
+			msg: "min messages",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+					{ID: 2, GUID: "testguid2", DisplayName: "testdisplayname2"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{200, 300}, nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(testMessage(200, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(testMessage(300, "message%d"), nil)
+			},
+			minMsgs:   2,
+			wantCount: 2,
+			wantChats: 1,
+		},
+		{
+			msg: "recent",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname", MessageCount: 1, LastMessageDate: time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)},
+					{ID: 2, GUID: "testguid2", DisplayName: "testdisplayname2", MessageCount: 2, LastMessageDate: time.Date(2020, time.March, 2, 15, 34, 5, 0, time.Local)},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{200, 300}, nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(testMessage(200, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(testMessage(300, "message%d"), nil)
+			},
+			recent:    1,
+			wantCount: 2,
+			wantChats: 1,
+		},
+		{
+			msg: "stub missing messages",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			missing: map[int][]chatdb.MissingMessage{
+				1: {{ID: 99, Date: _testDate.Add(-time.Minute)}, {ID: 101}},
+				2: {{ID: 200, Date: _testDate}},
+			},
+			dangling: "stub",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:33:05] Unknown: [missing message]\n[2020-03-01 15:34:05] Novak: message100\n[date unknown] Unknown: [missing message]\n",
+			},
+			wantCount: 3,
+			wantChats: 1,
+		},
+		{
+			msg: "skip missing messages",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			missing: map[int][]chatdb.MissingMessage{
+				1: {{ID: 99, Date: _testDate.Add(-time.Minute)}},
+			},
+			dangling: "skip",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "dir template",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "iMessage;-;+14155555555", DisplayName: "testdisplayname", MessageCount: 1, LastMessageDate: time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			dirTmpl: "{{.ContactName}}/{{.Service}}/{{.Year}}",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/iMessage/2020/iMessage;-;+14155555555.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "deterministic",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"}}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			determ: true,
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "max duration",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"}}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			maxDur:  30,
+			elapsed: 29 * time.Minute,
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "max duration passed",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+					{ID: 2, GUID: "testguid2", DisplayName: "testdisplayname2"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+			},
+			maxDur:   30,
+			elapsed:  31 * time.Minute,
+			wantLeft: 2,
+		},
+		{
+			msg:       "max duration without resume",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			format:    "slack",
+			maxDur:    30,
+			wantErr:   "the slack format cannot resume an export, so --max-duration cannot stop it early - FIX: use the txt or mbox format, or remove --max-duration",
+		},
+		{
+			msg: "guid folders",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "iMessage;-;+14155555555", DisplayName: "testdisplayname"},
+					{ID: 2, GUID: "iMessage;+;chat123456", DisplayName: "testdisplayname"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{200}, nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(testMessage(200, "message%d"), nil)
+			},
+			guidDirs: true,
+			wantFiles: map[string]string{
+				"backup/iMessage;-;+14155555555/iMessage;-;+14155555555.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+				"backup/iMessage;+;chat123456/iMessage;+;chat123456.txt":     "[2020-03-01 15:34:05] Novak: message200\n",
+				"backup/chat-index.csv": "folder,guid,name\niMessage;-;+14155555555,iMessage;-;+14155555555,testdisplayname\niMessage;+;chat123456,iMessage;+;chat123456,testdisplayname\n",
+			},
+			wantCount: 2,
+			wantChats: 2,
+		},
+		{
+			msg: "volumes",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(map[int]chatdb.ChatSize{
+					1: {Messages: 1, TextBytes: 600000000},
+					2: {Messages: 1, TextBytes: 600000000},
+					3: {Messages: 1, TextBytes: 300000000},
+				}, nil)
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{ID: 1, GUID: "testguid", DisplayName: "testdisplayname"},
+					{ID: 2, GUID: "testguid2", DisplayName: "testdisplayname2"},
+					{ID: 3, GUID: "testguid3", DisplayName: "testdisplayname3"},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				for _, id := range []int{1, 2, 3} {
+					dbMock.EXPECT().GetParticipants(id).Return(nil, nil)
+					dbMock.EXPECT().GetMessageIDs(id).Return([]int{id * 100}, nil)
+					dbMock.EXPECT().GetMessage(id*100, nil, nil).Return(testMessage(id*100, "message%d"), nil)
+				}
+			},
+			guidDirs: true,
+			volSize:  1,
+			wantFiles: map[string]string{
+				"backup/volume-1/testguid/testguid.txt":   "[2020-03-01 15:34:05] Novak: message100\n",
+				"backup/volume-2/testguid2/testguid2.txt": "[2020-03-01 15:34:05] Novak: message200\n",
+				"backup/volume-2/testguid3/testguid3.txt": "[2020-03-01 15:34:05] Novak: message300\n",
+				"backup/chat-index.csv":                   "folder,guid,name\nvolume-1/testguid,testguid,testdisplayname\nvolume-2/testguid2,testguid2,testdisplayname2\nvolume-2/testguid3,testguid3,testdisplayname3\n",
+			},
+			wantCount: 3,
+			wantChats: 3,
+		},
+		{
+			msg:       "guid folders and dir template",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			guidDirs:  true,
+			dirTmpl:   "{{.ContactName}}",
+			wantErr:   "--guid-folders and --dir-template both name the chat folders - FIX: use at most one of them",
+		},
+		{
+			msg:       "bad dir template",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			dirTmpl:   "{{.Contact}}",
+			wantErr:   `parse --dir-template template "{{.Contact}}"`,
+		},
+		{
+			msg:       "only groups and only direct",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			groups:    true,
+			direct:    true,
+			wantErr:   "--only-groups and --only-direct together exclude every chat",
+		},
+		{
+			msg:       "contact group without contacts",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			ctGroups:  []string{"Family"},
+			wantErr:   "--contact-group selects chats by the groups of contacts - FIX: pass the contacts with --contacts-path",
+		},
+		{
+			msg: "match",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+					{
+						ID:          2,
+						GUID:        "testguid2",
+						DisplayName: "testdisplayname2",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100, 200}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(testMessage(200, "message%d"), nil)
+				dbMock.EXPECT().GetMessageIDs(2).Return([]int{300}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(300, nil, nil).Return(testMessage(300, "message%d"), nil)
+			},
+			match: "message2",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message200\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg:       "unknown format",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			format:    "pdf",
+			wantErr:   `unknown export format "pdf"`,
+		},
+		{
+			msg:       "bad match pattern",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			match:     "message(",
+			wantErr:   "compile --match pattern \"message(\"",
+		},
+		{
+			msg: "group chat",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100, 200, 300}, nil)
+				chatHandleMap := map[int]string{10: "Alex Smith", 11: "Alex Jones"}
+				dbMock.EXPECT().GetMessage(100, chatHandleMap, nil).Return(testMessage(100, "message%d"), nil)
+				added := testMessage(200, "")
+				added.Text = "added 12 to the conversation"
+				added.GroupAction = chatdb.ParticipantAdded
+				added.OtherHandleID = 12
+				dbMock.EXPECT().GetMessage(200, chatHandleMap, nil).Return(added, nil)
+				dbMock.EXPECT().GetMessage(300, chatHandleMap, nil).Return(testMessage(300, "message%d"), nil)
+				dbMock.EXPECT().GetParticipants(1).Return([]int{10, 11, 12}, nil)
+				dbMock.EXPECT().DisambiguateHandles(nil, []int{10, 11, 12}).Return(chatHandleMap)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "--- Participants at this point: Alex Jones, Alex Smith ---\n" +
+					"[2020-03-01 15:34:05] Novak: message100\n" +
+					"[2020-03-01 15:34:05] Novak: added 12 to the conversation\n" +
+					"--- Participants at this point: 12, Alex Jones, Alex Smith ---\n" +
+					"[2020-03-01 15:34:05] Novak: message300\n",
+			},
+			wantCount: 3,
+			wantChats: 1,
+		},
+		{
+			msg: "GetParticipants error",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: "get participants for chat ID 1: this is a DB error",
+		},
+		{
+			msg: "HTML statistics with custom template",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			wordStats: []string{"html"},
+			assetsDir: "custom",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "custom/stats.html", []byte("{{.Title}}{{range .Report}}: {{.Participant}}{{end}}"), 0644)
+			},
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid-stats.html": "testdisplayname: Novak",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg:       "bad custom template",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {},
+			wordStats: []string{"html"},
+			assetsDir: "custom",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "custom/stats.html", []byte("{{.Title"), 0644)
+			},
+			wantErr: `load word statistics template: parse template "stats.html"`,
 		},
 		{
 			msg: "GetChats error",
@@ -259,6 +1388,44 @@ func TestExportChats(t *testing.T) {
 			},
 			wantErr: "get chats: this is a DB error",
 		},
+		{
+			msg: "chats for handle",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return([]chatdb.Chat{
+					{
+						ID:          1,
+						GUID:        "testguid",
+						DisplayName: "testdisplayname",
+					},
+				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+			},
+			handle: "+14155555555",
+			wantFiles: map[string]string{
+				"backup/testdisplayname/testguid.txt": "[2020-03-01 15:34:05] Novak: message100\n",
+			},
+			wantCount: 1,
+			wantChats: 1,
+		},
+		{
+			msg: "GetChatsForHandle error",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return(nil, errors.New("this is a DB error"))
+			},
+			handle:  "+14155555555",
+			wantErr: `get chats for handle "+14155555555": this is a DB error`,
+		},
+		{
+			msg: "GetAttachmentPaths error",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChats(nil).Return(nil, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: "get attachment paths: this is a DB error",
+		},
 		{
 			msg: "directory creation error",
 			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
@@ -269,6 +1436,9 @@ func TestExportChats(t *testing.T) {
 						DisplayName: "testdisplayname",
 					},
 				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
 			},
 			roFs:    true,
 			wantErr: "create directory \"backup/testdisplayname\": operation not permitted",
@@ -283,6 +1453,8 @@ func TestExportChats(t *testing.T) {
 						DisplayName: "testdisplayname",
 					},
 				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
 				dbMock.EXPECT().GetMessageIDs(1).Return(nil, errors.New("this is a DB error"))
 			},
 			wantErr: "get message IDs for chat ID 1: this is a DB error",
@@ -297,9 +1469,11 @@ func TestExportChats(t *testing.T) {
 						DisplayName: "testdisplayname",
 					},
 				}, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
 				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100, 200}, nil)
-				dbMock.EXPECT().GetMessage(100, nil, nil).Return("message100\n", nil)
-				dbMock.EXPECT().GetMessage(200, nil, nil).Return("", errors.New("this is a DB error"))
+				dbMock.EXPECT().GetMessage(100, nil, nil).Return(testMessage(100, "message%d"), nil)
+				dbMock.EXPECT().GetMessage(200, nil, nil).Return(chatdb.Message{}, errors.New("this is a DB error"))
 			},
 			wantErr: "get message with ID 200: this is a DB error",
 		},
@@ -312,12 +1486,47 @@ func TestExportChats(t *testing.T) {
 			dbMock := mock_chatdb.NewMockChatDB(ctrl)
 			tt.setupMock(dbMock)
 			fs := afero.NewMemMapFs()
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
 			if tt.roFs {
 				fs = afero.NewReadOnlyFs(fs)
 			}
 			s := opsys.NewOS(fs, nil, nil)
 
-			count, err := exportChats(s, dbMock, "backup", nil, nil, nil)
+			opts := options{
+				ExportPath:      "backup",
+				Format:          "txt",
+				CopyAttachments: tt.copyAtts,
+				Heatmap:         tt.heatmap,
+				WordStats:       tt.wordStats,
+				Match:           tt.match,
+				AssetsDir:       tt.assetsDir,
+				Handle:          tt.handle,
+				Resume:          tt.resume,
+				OriginHints:     tt.hints,
+				HashChain:       tt.hashChain,
+				Forensic:        tt.forensic,
+				Collate:         tt.collate,
+				MinMessages:     tt.minMsgs,
+				Recent:          tt.recent,
+				DirTemplate:     tt.dirTmpl,
+				Deterministic:   tt.determ,
+				GUIDFolders:     tt.guidDirs,
+				OnlyGroups:      tt.groups,
+				OnlyDirect:      tt.direct,
+				ContactGroups:   tt.ctGroups,
+				MaxDuration:     tt.maxDur,
+				DanglingJoins:   tt.dangling,
+				VolumeSize:      tt.volSize,
+				// The free space check is tested in TestCheckFreeSpace.
+				SkipSpaceCheck: true,
+			}
+			if tt.format != "" {
+				opts.Format = tt.format
+			}
+			summary := runSummary{Start: time.Now().Add(-tt.elapsed)}
+			count, err := exportChats(s, dbMock, opts, nil, nil, nil, tt.missing, &summary, nil)
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
 				return
@@ -328,7 +1537,55 @@ func TestExportChats(t *testing.T) {
 				assert.NilError(t, err)
 				assert.Equal(t, expected, string(actual))
 			}
+			if tt.heatmap != "" {
+				for _, p := range []string{"backup/testdisplayname2/testguid2-heatmap.svg", "backup/testdisplayname3/testguid3-heatmap.svg"} {
+					exist, err := afero.Exists(fs, p)
+					assert.NilError(t, err)
+					assert.Assert(t, !exist, "heatmap written for chat without dates: %s", p)
+				}
+			}
+			if tt.match != "" {
+				exist, err := afero.DirExists(fs, "backup/testdisplayname2")
+				assert.NilError(t, err)
+				assert.Assert(t, !exist, "folder created for chat without matches")
+			}
+			if tt.forensic {
+				for _, chainPath := range []string{"backup/testdisplayname/testguid.txt.sha256chain", "backup/testdisplayname/testguid.raw.jsonl.sha256chain"} {
+					exist, err := afero.Exists(fs, chainPath)
+					assert.NilError(t, err)
+					assert.Assert(t, exist, "missing hash chain %q", chainPath)
+				}
+			}
+			if tt.determ {
+				info, err := fs.Stat("backup/testdisplayname/testguid.txt")
+				assert.NilError(t, err)
+				assert.Assert(t, info.ModTime().Equal(_testDate), "chat file modified at %s", info.ModTime())
+			}
 			assert.Equal(t, tt.wantCount, count)
+			assert.Equal(t, tt.wantChats, summary.Chats)
+			assert.Equal(t, tt.wantLeft, summary.RemainingChats)
 		})
 	}
 }
+
+func TestSetupLogging(t *testing.T) {
+	defer logging.SetDefault(logging.Default())
+	assert.NilError(t, setupLogging(options{LogFormat: "json", LogLevel: "debug"}))
+	assert.Error(t, setupLogging(options{LogFormat: "text", LogLevel: "verbose"}), `parse log level: unknown log level "verbose" - FIX: use one of [debug info warn error]`)
+}
+
+func TestLogLevel(t *testing.T) {
+	level, err := logLevel(options{LogLevel: "debug"})
+	assert.NilError(t, err)
+	assert.Equal(t, logging.Debug, level)
+	level, err = logLevel(options{LogLevel: "debug", Quiet: true})
+	assert.NilError(t, err)
+	assert.Equal(t, logging.Error, level)
+	_, err = logLevel(options{LogLevel: "verbose"})
+	assert.Error(t, err, `parse log level: unknown log level "verbose" - FIX: use one of [debug info warn error]`)
+}
+
+func TestDataSourceName(t *testing.T) {
+	assert.Equal(t, "/test/chat.db?_busy_timeout=5000", dataSourceName("/test/chat.db", false, 5))
+	assert.Equal(t, "file:/test/chat.db?mode=ro&_busy_timeout=0", dataSourceName("/test/chat.db", true, 0))
+}
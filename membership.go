@@ -0,0 +1,70 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+)
+
+// getParticipantTimeline reconstructs the participants of a group chat at each
+// of its messages, keyed by message ID, by undoing the chat's group actions
+// starting from its current participants. It returns nil for chats which have
+// never had more than one other participant.
+func getParticipantTimeline(msgs []chatdb.Message, current []int, handleMap map[int]string) map[int]exporter.Participants {
+	members := make(map[int]bool, len(current))
+	for _, handleID := range current {
+		members[handleID] = true
+	}
+	isGroup := len(current) > 1
+	for _, msg := range msgs {
+		isGroup = isGroup || msg.GroupAction != chatdb.NoGroupAction
+	}
+	if !isGroup {
+		return nil
+	}
+
+	timeline := make(map[int]exporter.Participants, len(msgs))
+	after := formatParticipants(members, handleMap)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msg := msgs[i]
+		switch msg.GroupAction {
+		case chatdb.ParticipantAdded:
+			delete(members, msg.OtherHandleID)
+		case chatdb.ParticipantRemoved:
+			members[msg.OtherHandleID] = true
+		case chatdb.ParticipantLeft:
+			if !msg.FromMe {
+				members[msg.HandleID] = true
+			}
+		}
+		before := after
+		if msg.GroupAction != chatdb.NoGroupAction {
+			before = formatParticipants(members, handleMap)
+		}
+		timeline[msg.ID] = exporter.Participants{Before: before, After: after}
+		after = before
+	}
+	return timeline
+}
+
+func formatParticipants(members map[int]bool, handleMap map[int]string) string {
+	names := make([]string, 0, len(members))
+	for handleID := range members {
+		name, ok := handleMap[handleID]
+		if !ok {
+			name = strconv.Itoa(handleID)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "(none)"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetParticipantTimeline(t *testing.T) {
+	handleMap := map[int]string{
+		10: "Novak",
+		11: "Jelena",
+		12: "Marian",
+	}
+
+	tests := []struct {
+		msg     string
+		msgs    []chatdb.Message
+		current []int
+		want    map[int]exporter.Participants
+	}{
+		{
+			msg: "one-on-one chat",
+			msgs: []chatdb.Message{
+				{ID: 1, HandleID: 10, Text: "Want to play tennis?"},
+			},
+			current: []int{10},
+		},
+		{
+			msg: "unchanged group",
+			msgs: []chatdb.Message{
+				{ID: 1, HandleID: 10, Text: "Want to play tennis?"},
+			},
+			current: []int{10, 11},
+			want: map[int]exporter.Participants{
+				1: {Before: "Jelena, Novak", After: "Jelena, Novak"},
+			},
+		},
+		{
+			msg: "changing group",
+			msgs: []chatdb.Message{
+				{ID: 1, HandleID: 10, Text: "Want to play tennis?"},
+				{ID: 2, HandleID: 10, GroupAction: chatdb.ParticipantAdded, OtherHandleID: 12},
+				{ID: 3, HandleID: 12, Text: "Count me in"},
+				{ID: 4, HandleID: 11, GroupAction: chatdb.ParticipantLeft},
+				{ID: 5, FromMe: true, GroupAction: chatdb.ParticipantRemoved, OtherHandleID: 10},
+			},
+			current: []int{12},
+			want: map[int]exporter.Participants{
+				1: {Before: "Jelena, Novak", After: "Jelena, Novak"},
+				2: {Before: "Jelena, Novak", After: "Jelena, Marian, Novak"},
+				3: {Before: "Jelena, Marian, Novak", After: "Jelena, Marian, Novak"},
+				4: {Before: "Jelena, Marian, Novak", After: "Marian, Novak"},
+				5: {Before: "Marian, Novak", After: "Marian"},
+			},
+		},
+		{
+			msg: "everyone removed",
+			msgs: []chatdb.Message{
+				{ID: 1, FromMe: true, GroupAction: chatdb.ParticipantRemoved, OtherHandleID: 13},
+			},
+			want: map[int]exporter.Participants{
+				1: {Before: "13", After: "(none)"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, getParticipantTimeline(tt.msgs, tt.current, handleMap))
+		})
+	}
+}
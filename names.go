@@ -0,0 +1,22 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import "github.com/emersion/go-vcard"
+
+// addNameOverrides adds a contact card to the contact map for each handle in
+// the name map, replacing any card from the contacts file, so that the given
+// names label both the chats and the messages of those handles.
+func addNameOverrides(contactMap map[string]*vcard.Card, nameMap map[string]string) map[string]*vcard.Card {
+	if contactMap == nil {
+		contactMap = make(map[string]*vcard.Card, len(nameMap))
+	}
+	for handle, name := range nameMap {
+		card := vcard.Card{}
+		card.SetValue(vcard.FieldFormattedName, name)
+		card.AddName(&vcard.Name{GivenName: name})
+		contactMap[handle] = &card
+	}
+	return contactMap
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"gotest.tools/v3/assert"
+)
+
+func TestAddNameOverrides(t *testing.T) {
+	nadalCard := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Rafael Nadal"}},
+		"N":  []*vcard.Field{{Value: "Nadal;Rafael;;;"}},
+	}
+	djokovicCard := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "Novak Djokovic"}},
+		"N":  []*vcard.Field{{Value: "Djokovic;Novak;;;"}},
+	}
+
+	tests := []struct {
+		msg        string
+		contactMap map[string]*vcard.Card
+		nameMap    map[string]string
+		wantNames  map[string]string
+	}{
+		{
+			msg:       "no contacts file",
+			nameMap:   map[string]string{"+14155555555": "Rafa"},
+			wantNames: map[string]string{"+14155555555": "Rafa"},
+		},
+		{
+			msg: "override contact and add handle",
+			contactMap: map[string]*vcard.Card{
+				"+14155555555": nadalCard,
+				"+3815555555":  djokovicCard,
+			},
+			nameMap: map[string]string{
+				"+14155555555":     "Rafa",
+				"rafa@example.com": "Rafa",
+			},
+			wantNames: map[string]string{
+				"+14155555555":     "Rafa",
+				"rafa@example.com": "Rafa",
+				"+3815555555":      "Novak Djokovic",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			contactMap := addNameOverrides(tt.contactMap, tt.nameMap)
+			assert.Equal(t, len(tt.wantNames), len(contactMap))
+			for handle, wantName := range tt.wantNames {
+				card, ok := contactMap[handle]
+				assert.Assert(t, ok, "no card for handle %q", handle)
+				assert.Equal(t, wantName, card.PreferredValue(vcard.FieldFormattedName))
+				if _, ok := tt.nameMap[handle]; ok {
+					assert.Equal(t, wantName, card.Name().GivenName)
+				}
+			}
+		})
+	}
+}
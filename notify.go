@@ -0,0 +1,73 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const _notificationTitle = "bagoup"
+
+// runNotification is posted to the webhook when an export run finishes or
+// fails.
+type runNotification struct {
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Summary runSummary `json:"summary"`
+}
+
+// notifyRun reports the outcome of an export run in Notification Center, to
+// the webhook, and by email, as requested by the options. Failures to notify are logged
+// rather than failing the run.
+func notifyRun(s opsys.OS, client *http.Client, send sendMailFunc, opts options, summary runSummary, runErr error) {
+	n := runNotification{Status: "succeeded", Summary: summary}
+	message := fmt.Sprintf("Exported %d messages from %d chats to %q", summary.Messages, summary.Chats, opts.ExportPath)
+	if runErr != nil {
+		n.Status = "failed"
+		n.Error = runErr.Error()
+		message = "Export failed: " + n.Error
+	}
+	if n.Summary.Errors == nil {
+		n.Summary.Errors = []string{}
+	}
+	if opts.Notify {
+		if err := s.Notify(_notificationTitle, message); err != nil {
+			logging.Warnf("%s", err)
+		}
+	}
+	if opts.NotifyWebhook != "" {
+		if err := postWebhook(client, opts.NotifyWebhook, n); err != nil {
+			logging.Warnf("notify webhook: %s", err)
+		}
+	}
+	if opts.EmailSummary != "" {
+		if err := emailSummary(s, send, opts, n, time.Now()); err != nil {
+			logging.Warnf("email summary: %s", err)
+		}
+	}
+}
+
+func postWebhook(client *http.Client, url string, n runNotification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "encode notification")
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "post notification")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post notification: %s", resp.Status)
+	}
+	return nil
+}
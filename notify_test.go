@@ -0,0 +1,130 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestNotifyRun(t *testing.T) {
+	start := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+	summary := runSummary{Start: start, End: start.Add(time.Minute), Chats: 2, Messages: 5}
+
+	tests := []struct {
+		msg         string
+		notify      bool
+		webhook     bool
+		email       bool
+		runErr      error
+		setupMock   func(*mock_opsys.MockOS)
+		webhookCode int
+		wantStatus  string
+		wantError   string
+	}{
+		{
+			msg:       "email",
+			email:     true,
+			setupMock: func(*mock_opsys.MockOS) {},
+		},
+		{
+			msg:    "alert",
+			notify: true,
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().Notify("bagoup", `Exported 5 messages from 2 chats to "backup"`)
+			},
+		},
+		{
+			msg:    "alert error",
+			notify: true,
+			runErr: errors.New("export chats: this is a DB error"),
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().Notify("bagoup", "Export failed: export chats: this is a DB error").Return(errors.New("this is an osascript error"))
+			},
+		},
+		{
+			msg:         "webhook",
+			webhook:     true,
+			setupMock:   func(*mock_opsys.MockOS) {},
+			webhookCode: http.StatusOK,
+			wantStatus:  "succeeded",
+		},
+		{
+			msg:         "webhook failure",
+			webhook:     true,
+			runErr:      errors.New("export chats: this is a DB error"),
+			setupMock:   func(*mock_opsys.MockOS) {},
+			webhookCode: http.StatusInternalServerError,
+			wantStatus:  "failed",
+			wantError:   "export chats: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			tt.setupMock(osMock)
+			var gotBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				var err error
+				gotBody, err = ioutil.ReadAll(r.Body)
+				assert.NilError(t, err)
+				w.WriteHeader(tt.webhookCode)
+			}))
+			defer srv.Close()
+
+			opts := options{ExportPath: "backup", Notify: tt.notify}
+			if tt.webhook {
+				opts.NotifyWebhook = srv.URL
+			}
+			if tt.email {
+				opts.EmailSummary = "david@example.com"
+				opts.SMTPServer = "smtp.example.com:587"
+			}
+			var gotEmail []byte
+			send := func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+				assert.Equal(t, "smtp.example.com:587", addr)
+				assert.DeepEqual(t, []string{"david@example.com"}, to)
+				gotEmail = msg
+				return nil
+			}
+			notifyRun(osMock, srv.Client(), send, opts, summary, tt.runErr)
+			assert.Equal(t, tt.email, gotEmail != nil)
+			if !tt.webhook {
+				assert.Assert(t, gotBody == nil, "webhook posted")
+				return
+			}
+			var n runNotification
+			assert.NilError(t, json.Unmarshal(gotBody, &n))
+			assert.Equal(t, tt.wantStatus, n.Status)
+			assert.Equal(t, tt.wantError, n.Error)
+			assert.Equal(t, 5, n.Summary.Messages)
+			assert.Equal(t, 2, n.Summary.Chats)
+			assert.Assert(t, !strings.Contains(string(gotBody), srv.URL), "webhook URL in notification")
+		})
+	}
+}
+
+func TestPostWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	assert.Error(t, postWebhook(srv.Client(), srv.URL, runNotification{}), "post notification: 404 Not Found")
+}
@@ -9,6 +9,8 @@ import (
 	vcard "github.com/emersion/go-vcard"
 	gomock "github.com/golang/mock/gomock"
 	afero "github.com/spf13/afero"
+	opsys "github.com/tagatac/bagoup/opsys"
+	io "io"
 	os "os"
 	reflect "reflect"
 	time "time"
@@ -65,6 +67,52 @@ func (mr *MockOSMockRecorder) Chtimes(arg0, arg1, arg2 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Chtimes", reflect.TypeOf((*MockOS)(nil).Chtimes), arg0, arg1, arg2)
 }
 
+// CloneFile mocks base method
+func (m *MockOS) CloneFile(arg0, arg1 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CloneFile", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CloneFile indicates an expected call of CloneFile
+func (mr *MockOSMockRecorder) CloneFile(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloneFile", reflect.TypeOf((*MockOS)(nil).CloneFile), arg0, arg1)
+}
+
+// CopyFile mocks base method
+func (m *MockOS) CopyFile(arg0, arg1 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFile", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyFile indicates an expected call of CopyFile
+func (mr *MockOSMockRecorder) CopyFile(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFile", reflect.TypeOf((*MockOS)(nil).CopyFile), arg0, arg1)
+}
+
+// CopyFileChecksum mocks base method
+func (m *MockOS) CopyFileChecksum(arg0, arg1 string) (string, opsys.Checksum, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFileChecksum", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(opsys.Checksum)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CopyFileChecksum indicates an expected call of CopyFileChecksum
+func (mr *MockOSMockRecorder) CopyFileChecksum(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFileChecksum", reflect.TypeOf((*MockOS)(nil).CopyFileChecksum), arg0, arg1)
+}
+
 // Create mocks base method
 func (m *MockOS) Create(arg0 string) (afero.File, error) {
 	m.ctrl.T.Helper()
@@ -80,6 +128,52 @@ func (mr *MockOSMockRecorder) Create(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockOS)(nil).Create), arg0)
 }
 
+// Dataless mocks base method
+func (m *MockOS) Dataless(arg0 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Dataless", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Dataless indicates an expected call of Dataless
+func (mr *MockOSMockRecorder) Dataless(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dataless", reflect.TypeOf((*MockOS)(nil).Dataless), arg0)
+}
+
+// ExistingCopy mocks base method
+func (m *MockOS) ExistingCopy(arg0, arg1 string) (string, opsys.Checksum, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExistingCopy", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(opsys.Checksum)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ExistingCopy indicates an expected call of ExistingCopy
+func (mr *MockOSMockRecorder) ExistingCopy(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExistingCopy", reflect.TypeOf((*MockOS)(nil).ExistingCopy), arg0, arg1)
+}
+
+// ExpandHome mocks base method
+func (m *MockOS) ExpandHome(arg0 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpandHome", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpandHome indicates an expected call of ExpandHome
+func (mr *MockOSMockRecorder) ExpandHome(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpandHome", reflect.TypeOf((*MockOS)(nil).ExpandHome), arg0)
+}
+
 // FileExist mocks base method
 func (m *MockOS) FileExist(arg0 string) (bool, error) {
 	m.ctrl.T.Helper()
@@ -95,6 +189,36 @@ func (mr *MockOSMockRecorder) FileExist(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FileExist", reflect.TypeOf((*MockOS)(nil).FileExist), arg0)
 }
 
+// FilenameRules mocks base method
+func (m *MockOS) FilenameRules(arg0 string) (opsys.FilenameRules, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FilenameRules", arg0)
+	ret0, _ := ret[0].(opsys.FilenameRules)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FilenameRules indicates an expected call of FilenameRules
+func (mr *MockOSMockRecorder) FilenameRules(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FilenameRules", reflect.TypeOf((*MockOS)(nil).FilenameRules), arg0)
+}
+
+// FreeSpace mocks base method
+func (m *MockOS) FreeSpace(arg0 string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FreeSpace", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FreeSpace indicates an expected call of FreeSpace
+func (mr *MockOSMockRecorder) FreeSpace(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreeSpace", reflect.TypeOf((*MockOS)(nil).FreeSpace), arg0)
+}
+
 // GetContactMap mocks base method
 func (m *MockOS) GetContactMap(arg0 string) (map[string]*vcard.Card, error) {
 	m.ctrl.T.Helper()
@@ -125,6 +249,36 @@ func (mr *MockOSMockRecorder) GetMacOSVersion() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMacOSVersion", reflect.TypeOf((*MockOS)(nil).GetMacOSVersion))
 }
 
+// GetNameMap mocks base method
+func (m *MockOS) GetNameMap(arg0 string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetNameMap", arg0)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNameMap indicates an expected call of GetNameMap
+func (mr *MockOSMockRecorder) GetNameMap(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNameMap", reflect.TypeOf((*MockOS)(nil).GetNameMap), arg0)
+}
+
+// Hostname mocks base method
+func (m *MockOS) Hostname() (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Hostname")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Hostname indicates an expected call of Hostname
+func (mr *MockOSMockRecorder) Hostname() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Hostname", reflect.TypeOf((*MockOS)(nil).Hostname))
+}
+
 // Mkdir mocks base method
 func (m *MockOS) Mkdir(arg0 string, arg1 os.FileMode) error {
 	m.ctrl.T.Helper()
@@ -167,6 +321,20 @@ func (mr *MockOSMockRecorder) Name() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Name", reflect.TypeOf((*MockOS)(nil).Name))
 }
 
+// Notify mocks base method
+func (m *MockOS) Notify(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Notify", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Notify indicates an expected call of Notify
+func (mr *MockOSMockRecorder) Notify(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockOS)(nil).Notify), arg0, arg1)
+}
+
 // Open mocks base method
 func (m *MockOS) Open(arg0 string) (afero.File, error) {
 	m.ctrl.T.Helper()
@@ -197,6 +365,36 @@ func (mr *MockOSMockRecorder) OpenFile(arg0, arg1, arg2 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenFile", reflect.TypeOf((*MockOS)(nil).OpenFile), arg0, arg1, arg2)
 }
 
+// ProcessRunning mocks base method
+func (m *MockOS) ProcessRunning(arg0 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ProcessRunning", arg0)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ProcessRunning indicates an expected call of ProcessRunning
+func (mr *MockOSMockRecorder) ProcessRunning(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessRunning", reflect.TypeOf((*MockOS)(nil).ProcessRunning), arg0)
+}
+
+// RecognizeText mocks base method
+func (m *MockOS) RecognizeText(arg0 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecognizeText", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecognizeText indicates an expected call of RecognizeText
+func (mr *MockOSMockRecorder) RecognizeText(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecognizeText", reflect.TypeOf((*MockOS)(nil).RecognizeText), arg0)
+}
+
 // Remove mocks base method
 func (m *MockOS) Remove(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -239,6 +437,48 @@ func (mr *MockOSMockRecorder) Rename(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockOS)(nil).Rename), arg0, arg1)
 }
 
+// RequestDownload mocks base method
+func (m *MockOS) RequestDownload(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestDownload", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequestDownload indicates an expected call of RequestDownload
+func (mr *MockOSMockRecorder) RequestDownload(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestDownload", reflect.TypeOf((*MockOS)(nil).RequestDownload), arg0)
+}
+
+// RunHook mocks base method
+func (m *MockOS) RunHook(arg0 string, arg1 []string, arg2 io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunHook", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RunHook indicates an expected call of RunHook
+func (mr *MockOSMockRecorder) RunHook(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunHook", reflect.TypeOf((*MockOS)(nil).RunHook), arg0, arg1, arg2)
+}
+
+// SetSpotlightMetadata mocks base method
+func (m *MockOS) SetSpotlightMetadata(arg0 string, arg1 opsys.SpotlightMetadata) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSpotlightMetadata", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSpotlightMetadata indicates an expected call of SetSpotlightMetadata
+func (mr *MockOSMockRecorder) SetSpotlightMetadata(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSpotlightMetadata", reflect.TypeOf((*MockOS)(nil).SetSpotlightMetadata), arg0, arg1)
+}
+
 // Stat mocks base method
 func (m *MockOS) Stat(arg0 string) (os.FileInfo, error) {
 	m.ctrl.T.Helper()
@@ -6,10 +6,17 @@
 package opsys
 
 import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
 	"io"
-	"log"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -17,6 +24,8 @@ import (
 	"github.com/emersion/go-vcard"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/logging"
+	"golang.org/x/text/unicode/norm"
 )
 
 //go:generate mockgen -destination=mock_opsys/mock_opsys.go github.com/tagatac/bagoup/opsys OS
@@ -34,18 +43,126 @@ type (
 		// addresses specified in those cards, from the vcard file at the given
 		// path.
 		GetContactMap(path string) (map[string]*vcard.Card, error)
+		// GetNameMap gets a map of names indexed by phone numbers and email
+		// addresses, from the CSV file of handles and names at the given path.
+		GetNameMap(path string) (map[string]string, error)
+		// ExpandHome replaces a leading tilde in the given path with the home
+		// directory of the current user, or of the user given to
+		// NewOSWithHome.
+		ExpandHome(path string) (string, error)
+		// CopyFile copies the file at the given source path into the given
+		// destination directory, returning the path of the copy. If a file with
+		// the same name already exists in the destination directory, a numeric
+		// suffix is added to the name of the copy. It is safe to copy files
+		// into the same directory concurrently.
+		CopyFile(src, dstDir string) (string, error)
+		// CopyFileChecksum is like CopyFile, but also returns the checksum of
+		// the copy, computed while it is copied, so that the file is read only
+		// once however large it is.
+		CopyFileChecksum(src, dstDir string) (string, Checksum, error)
+		// CloneFile is like CopyFile, but on APFS volumes the copy is a clone
+		// which shares its storage with the source file until either is
+		// modified. If the file cannot be cloned, e.g. because the destination
+		// is on another volume, it is copied instead.
+		CloneFile(src, dstDir string) (string, error)
+		// ExistingCopy returns the path and checksum of a copy of the file at
+		// the given source path in the given destination directory, under a
+		// name which CopyFile would have given it, e.g. left by an interrupted
+		// export, or an empty path if there is none.
+		ExistingCopy(src, dstDir string) (string, Checksum, error)
+		// RunHook runs the given shell command with the given environment
+		// variables added to its environment and the given reader as its
+		// standard input. Its output is passed through to the standard error of
+		// bagoup, with the log, so that it cannot corrupt output written to
+		// standard output, e.g. with --stdout.
+		RunHook(command string, env []string, stdin io.Reader) error
+		// SetSpotlightMetadata labels the file at the given path with the
+		// given metadata as extended attributes, which Spotlight indexes so
+		// that the file can be found by e.g. the names of its authors. Empty
+		// fields are skipped.
+		SetSpotlightMetadata(path string, metadata SpotlightMetadata) error
+		// Notify shows an alert with the given title and message in
+		// Notification Center.
+		Notify(title, message string) error
+		// ProcessRunning checks if a process with the given name, e.g.
+		// "Messages", is running for any user.
+		ProcessRunning(name string) (bool, error)
+		// Hostname returns the name of the computer.
+		Hostname() (string, error)
+		// FreeSpace returns the number of bytes available to the current
+		// user on the volume of the given path, or of its closest existing
+		// parent folder if it does not exist yet.
+		FreeSpace(path string) (int64, error)
+		// FilenameRules finds out how the volume of the given folder, which
+		// is created if it does not exist yet, stores file names, by creating
+		// and removing files in the folder.
+		FilenameRules(dir string) (FilenameRules, error)
+		// Dataless checks if the file at the given path is dataless, i.e. its
+		// contents are only stored in iCloud, e.g. because Optimize Mac
+		// Storage offloaded it.
+		Dataless(path string) (bool, error)
+		// RequestDownload asks iCloud to download the contents of the
+		// dataless file at the given path. It returns before the download
+		// finishes.
+		RequestDownload(path string) error
+		// RecognizeText returns the text recognized in the image at the
+		// given path by tesseract, which must be installed.
+		RecognizeText(path string) (string, error)
+	}
+
+	// Checksum is the size and SHA-256 checksum of a file.
+	Checksum struct {
+		Bytes  int64
+		SHA256 string
+	}
+
+	// SpotlightMetadata describes a file for Spotlight.
+	SpotlightMetadata struct {
+		// Title is the title of the file, e.g. the name of a chat.
+		Title string
+		// Description summarizes the contents of the file.
+		Description string
+		// Authors are the names of the people who wrote the contents of the
+		// file.
+		Authors []string
+		// Keywords are other terms by which the file can be found, e.g.
+		// phone numbers and email addresses.
+		Keywords []string
+	}
+
+	// FilenameRules describes how a volume stores file names. External
+	// drives formatted with exFAT and SMB shares differ from the APFS volume
+	// of the Mac.
+	FilenameRules struct {
+		// CaseInsensitive is whether names which differ only in case, e.g.
+		// "Mom" and "mom", name the same file.
+		CaseInsensitive bool
+		// Decomposed is whether names are stored in Unicode normalization
+		// form D, with accents as separate characters, as on HFS+ volumes.
+		Decomposed bool
+		// MaxNameBytes is the length in bytes of the longest file name which
+		// can be created.
+		MaxNameBytes int
 	}
 
 	opSys struct {
 		afero.Fs
 		osStat      func(string) (os.FileInfo, error)
 		execCommand func(string, ...string) *exec.Cmd
+		home        string
 	}
 )
 
 // NewOS returns an OS from a given filesystem, os Stat, and exec Command.
 func NewOS(fs afero.Fs, osStat func(string) (os.FileInfo, error), execCommand func(string, ...string) *exec.Cmd) OS {
-	return opSys{Fs: fs, osStat: osStat, execCommand: execCommand}
+	return NewOSWithHome(fs, osStat, execCommand, "")
+}
+
+// NewOSWithHome is like NewOS, but paths starting with a tilde are in the
+// given home directory, e.g. of another user of the Mac, rather than that of
+// the current user. An empty home directory means that of the current user.
+func NewOSWithHome(fs afero.Fs, osStat func(string) (os.FileInfo, error), execCommand func(string, ...string) *exec.Cmd, home string) OS {
+	return opSys{Fs: fs, osStat: osStat, execCommand: execCommand, home: home}
 }
 
 func (s opSys) FileExist(path string) (bool, error) {
@@ -74,6 +191,10 @@ func (s opSys) GetMacOSVersion() (*semver.Version, error) {
 }
 
 func (s opSys) GetContactMap(contactsFilePath string) (map[string]*vcard.Card, error) {
+	contactsFilePath, err := s.ExpandHome(contactsFilePath)
+	if err != nil {
+		return nil, err
+	}
 	f, err := s.Fs.Open(contactsFilePath)
 	if err != nil {
 		return nil, err
@@ -97,7 +218,7 @@ func (s opSys) GetContactMap(contactsFilePath string) (map[string]*vcard.Card, e
 		phonesAndEmails := append(phones, card.Values(vcard.FieldEmail)...)
 		for _, phoneOrEmail := range phonesAndEmails {
 			if c, ok := contactMap[phoneOrEmail]; ok {
-				log.Printf("multiple contacts %q and %q share the same phone or email %q", c.PreferredValue(vcard.FieldFormattedName), card.PreferredValue(vcard.FieldFormattedName), phoneOrEmail)
+				logging.Warnf("multiple contacts %q and %q share the same phone or email %q", c.PreferredValue(vcard.FieldFormattedName), card.PreferredValue(vcard.FieldFormattedName), phoneOrEmail)
 			}
 			contactMap[phoneOrEmail] = &card
 		}
@@ -105,6 +226,477 @@ func (s opSys) GetContactMap(contactsFilePath string) (map[string]*vcard.Card, e
 	return contactMap, nil
 }
 
+func (s opSys) GetNameMap(namesFilePath string) (map[string]string, error) {
+	namesFilePath, err := s.ExpandHome(namesFilePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.Fs.Open(namesFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "read names CSV")
+	}
+	nameMap := make(map[string]string, len(records))
+	for _, record := range records {
+		handle := sanitizePhone(record[0])
+		if _, ok := nameMap[handle]; ok {
+			logging.Warnf("multiple names given for the same phone or email %q", handle)
+		}
+		nameMap[handle] = strings.TrimSpace(record[1])
+	}
+	return nameMap, nil
+}
+
+func (s opSys) ExpandHome(p string) (string, error) {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p, nil
+	}
+	home := s.home
+	if home == "" {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return "", errors.Wrap(err, "get home directory")
+		}
+	}
+	return path.Join(home, strings.TrimPrefix(p, "~")), nil
+}
+
+func (s opSys) CopyFile(src, dstDir string) (string, error) {
+	dst, _, err := s.copyFile(src, dstDir, nil)
+	return dst, err
+}
+
+func (s opSys) CopyFileChecksum(src, dstDir string) (string, Checksum, error) {
+	h := sha256.New()
+	dst, n, err := s.copyFile(src, dstDir, h)
+	if err != nil {
+		return "", Checksum{}, err
+	}
+	return dst, Checksum{Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// copyFile copies the file at the given source path into the given
+// destination directory, streaming it through the given hash, if any, as it is
+// copied. It returns the path of the copy and the number of bytes copied.
+func (s opSys) copyFile(src, dstDir string, h hash.Hash) (string, int64, error) {
+	name, err := copyName(src, dstDir)
+	if err != nil {
+		return "", 0, err
+	}
+	in, err := s.Fs.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+	if err := checkRegularFile(in, src); err != nil {
+		return "", 0, err
+	}
+	dst, out, err := s.createUnique(name)
+	if err != nil {
+		return "", 0, err
+	}
+	var w io.Writer = out
+	if h != nil {
+		w = io.MultiWriter(out, h)
+	}
+	n, err := io.Copy(w, in)
+	if err != nil {
+		out.Close()
+		return "", 0, errors.Wrapf(err, "copy %q to %q", src, dst)
+	}
+	return dst, n, out.Close()
+}
+
+func (s opSys) CloneFile(src, dstDir string) (string, error) {
+	name, err := copyName(src, dstDir)
+	if err != nil {
+		return "", err
+	}
+	// Sources which cannot be checked are left to cp, and to CopyFile if
+	// cp fails.
+	if info, err := s.Fs.Stat(src); err == nil && !info.Mode().IsRegular() {
+		return "", errors.Errorf("%q is not a regular file", src)
+	}
+	// The path of the clone is reserved first, so that a concurrent copy
+	// cannot take it, and the clone replaces the reserved file.
+	dst, out, err := s.createUnique(name)
+	if err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", errors.Wrapf(err, "close file %q", dst)
+	}
+	// The -c flag of the Mac OS cp command clones files with clonefile(2).
+	if err := s.execCommand("cp", "-c", src, dst).Run(); err == nil {
+		if e, ok := s.Fs.(enforcer); ok {
+			return dst, e.enforce(dst)
+		}
+		return dst, nil
+	}
+	if err := s.Fs.Remove(dst); err != nil {
+		return "", errors.Wrapf(err, "remove file %q", dst)
+	}
+	return s.CopyFile(src, dstDir)
+}
+
+// createUnique creates a new file at the given path, or with a numeric suffix
+// added to its name if a file with the name already exists, returning its path.
+func (s opSys) createUnique(name string) (string, afero.File, error) {
+	for {
+		// O_EXCL also keeps the file from following a symbolic link which
+		// was placed at its path.
+		dst, err := s.getUniquePath(name)
+		if err != nil {
+			return "", nil, err
+		}
+		f, err := s.Fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		// Another copy may have taken the path since it was checked.
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "create file %q", dst)
+		}
+		return dst, f, nil
+	}
+}
+
+func (s opSys) ExistingCopy(src, dstDir string) (string, Checksum, error) {
+	name, err := copyName(src, dstDir)
+	if err != nil {
+		return "", Checksum{}, err
+	}
+	info, err := s.Fs.Stat(src)
+	if err != nil {
+		return "", Checksum{}, err
+	}
+	var srcSum Checksum
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i, p := 1, name; ; i, p = i+1, fmt.Sprintf("%s-%d%s", base, i, ext) {
+		dstInfo, err := s.Fs.Stat(p)
+		if os.IsNotExist(err) {
+			return "", Checksum{}, nil
+		}
+		if err != nil {
+			return "", Checksum{}, errors.Wrapf(err, "check existence of file %q", p)
+		}
+		if !dstInfo.Mode().IsRegular() || dstInfo.Size() != info.Size() {
+			continue
+		}
+		if srcSum.SHA256 == "" {
+			if srcSum, err = s.checksum(src); err != nil {
+				return "", Checksum{}, err
+			}
+		}
+		sum, err := s.checksum(p)
+		if err != nil {
+			return "", Checksum{}, err
+		}
+		if sum == srcSum {
+			return p, sum, nil
+		}
+	}
+}
+
+// checksum returns the checksum of the file at the given path.
+func (s opSys) checksum(p string) (Checksum, error) {
+	f, err := s.Fs.Open(p)
+	if err != nil {
+		return Checksum{}, errors.Wrapf(err, "open file %q", p)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return Checksum{}, errors.Wrapf(err, "read file %q", p)
+	}
+	return Checksum{Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (s opSys) RunHook(command string, env []string, stdin io.Reader) error {
+	cmd := s.execCommand("sh", "-c", command)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "run %q", command)
+}
+
+func (s opSys) SetSpotlightMetadata(filePath string, metadata SpotlightMetadata) error {
+	attrs := []struct{ name, plist string }{
+		{"kMDItemTitle", plistString(metadata.Title)},
+		{"kMDItemDescription", plistString(metadata.Description)},
+		{"kMDItemAuthors", plistArray(metadata.Authors)},
+		{"kMDItemKeywords", plistArray(metadata.Keywords)},
+	}
+	for _, attr := range attrs {
+		if attr.plist == "" {
+			continue
+		}
+		name := "com.apple.metadata:" + attr.name
+		if o, err := s.execCommand("xattr", "-w", name, attr.plist, filePath).CombinedOutput(); err != nil {
+			return errors.Wrapf(err, "set attribute %q of file %q: %s", name, filePath, strings.TrimSpace(string(o)))
+		}
+	}
+	return nil
+}
+
+func (s opSys) Notify(title, message string) error {
+	script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+	if o, err := s.execCommand("osascript", "-e", script).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "show notification: %s", strings.TrimSpace(string(o)))
+	}
+	return nil
+}
+
+func (s opSys) ProcessRunning(name string) (bool, error) {
+	o, err := s.execCommand("pgrep", "-x", name).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	// pgrep exits with status 1 if no process matched.
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "check for process %q: %s", name, strings.TrimSpace(string(o)))
+}
+
+func (s opSys) Hostname() (string, error) {
+	name, err := os.Hostname()
+	return name, errors.Wrap(err, "get hostname")
+}
+
+func (s opSys) FreeSpace(p string) (int64, error) {
+	for {
+		exist, err := s.FileExist(p)
+		if err != nil {
+			return 0, err
+		}
+		if exist || p == path.Dir(p) {
+			break
+		}
+		p = path.Dir(p)
+	}
+	// -P prints one line per volume and -k sizes in kibibytes, on both Mac OS
+	// and Linux.
+	o, err := s.execCommand("df", "-Pk", p).Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "call df for %q", p)
+	}
+	lines := strings.Split(strings.TrimSpace(string(o)), "\n")
+	if len(lines) < 2 || len(strings.Fields(lines[len(lines)-1])) < 4 {
+		return 0, errors.Errorf("parse df output %q", string(o))
+	}
+	available := strings.Fields(lines[len(lines)-1])[3]
+	kib, err := strconv.ParseInt(available, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse available space %q", available)
+	}
+	return kib * 1024, nil
+}
+
+func (s opSys) Dataless(p string) (bool, error) {
+	// The flags of a file are printed comma-separated, or as "-" if it has
+	// none.
+	o, err := s.execCommand("stat", "-f", "%Sf", p).CombinedOutput()
+	if err != nil {
+		return false, errors.Wrapf(err, "get flags of file %q: %s", p, strings.TrimSpace(string(o)))
+	}
+	for _, flag := range strings.Split(strings.TrimSpace(string(o)), ",") {
+		if flag == "dataless" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s opSys) RequestDownload(p string) error {
+	if o, err := s.execCommand("brctl", "download", p).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "request download of file %q: %s", p, strings.TrimSpace(string(o)))
+	}
+	return nil
+}
+
+func (s opSys) RecognizeText(p string) (string, error) {
+	// tesseract writes the text to standard output when the output base is
+	// "stdout", and its progress to standard error.
+	o, err := s.execCommand("tesseract", p, "stdout").Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return "", errors.Wrapf(err, "recognize text in image %q: %s", p, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(o), errors.Wrapf(err, "recognize text in image %q", p)
+}
+
+// _probeName is the name of the files created by FilenameRules. It ends with
+// a precomposed accented letter, to find out if names are decomposed.
+const _probeName = ".bagoup-probe-\u00e9"
+
+// _maxNameBytes is the length in bytes of the longest file name on the
+// volumes of Mac OS and of the filesystems it mounts.
+const _maxNameBytes = 255
+
+func (s opSys) FilenameRules(dir string) (FilenameRules, error) {
+	if err := s.MkdirAll(dir, os.ModePerm); err != nil {
+		return FilenameRules{}, errors.Wrapf(err, "create directory %q", dir)
+	}
+	probe := path.Join(dir, _probeName)
+	if err := s.probe(probe); err != nil {
+		return FilenameRules{}, errors.Wrapf(err, "create file %q", probe)
+	}
+	var rules FilenameRules
+	if _, err := s.Fs.Stat(path.Join(dir, strings.ToUpper(_probeName))); err == nil {
+		rules.CaseInsensitive = true
+	}
+	infos, err := afero.ReadDir(s.Fs, dir)
+	if err != nil {
+		return FilenameRules{}, errors.Wrapf(err, "read directory %q", dir)
+	}
+	for _, info := range infos {
+		if name := info.Name(); name != _probeName && norm.NFC.String(name) == _probeName {
+			rules.Decomposed = true
+		}
+	}
+	if err := s.Fs.Remove(probe); err != nil {
+		return FilenameRules{}, errors.Wrapf(err, "remove file %q", probe)
+	}
+	// The longest name which can be created is found by bisection, since
+	// the limit depends on the filesystem, e.g. 143 bytes on eCryptfs.
+	short, long := len(_probeName), _maxNameBytes+1
+	for long-short > 1 {
+		n := (short + long) / 2
+		p := path.Join(dir, _probeName+strings.Repeat("x", n-len(_probeName)))
+		if s.probe(p) != nil {
+			long = n
+			continue
+		}
+		short = n
+		if err := s.Fs.Remove(p); err != nil {
+			return FilenameRules{}, errors.Wrapf(err, "remove file %q", p)
+		}
+	}
+	rules.MaxNameBytes = short
+	return rules, nil
+}
+
+// probe creates an empty file at the given path.
+func (s opSys) probe(p string) error {
+	f, err := s.Fs.Create(p)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// appleScriptString quotes the given string as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// _plistHeader and _plistFooter enclose the value of a property list, which
+// is the format of Spotlight metadata attributes.
+const (
+	_plistHeader = `<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd"><plist version="1.0">`
+	_plistFooter = `</plist>`
+)
+
+// plistString encodes the given string as a property list, or returns an
+// empty string if it is empty.
+func plistString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return _plistHeader + plistElement(s) + _plistFooter
+}
+
+// plistArray encodes the given strings as a property list array, or returns
+// an empty string if there are none.
+func plistArray(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(_plistHeader + "<array>")
+	for _, s := range ss {
+		b.WriteString(plistElement(s))
+	}
+	b.WriteString("</array>" + _plistFooter)
+	return b.String()
+}
+
+func plistElement(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return "<string>" + b.String() + "</string>"
+}
+
+// copyName returns the path of a copy of the file at the given source path in
+// the given destination directory, named after the source file with the
+// characters which are unsafe in filenames replaced. Names which would leave
+// the destination directory, e.g. "..", are refused.
+func copyName(src, dstDir string) (string, error) {
+	name := sanitizeFilename(path.Base(src))
+	dst := path.Join(dstDir, name)
+	if name == "" || path.Dir(dst) != path.Clean(dstDir) {
+		return "", errors.Errorf("refuse to copy %q outside of %q", src, dstDir)
+	}
+	return dst, nil
+}
+
+// sanitizeFilename replaces path separators, colons, which Finder shows as
+// slashes, and control characters in the given filename with underscores, and
+// removes its leading dots, so that it cannot name a parent directory or be
+// hidden.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.TrimLeft(name, ".")
+}
+
+// checkRegularFile checks that the given open file is a regular file, rather
+// than e.g. a directory or a device, following symbolic links.
+func checkRegularFile(f afero.File, name string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat file %q", name)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("%q is not a regular file", name)
+	}
+	return nil
+}
+
+// getUniquePath returns the given path if nothing exists there yet, and
+// otherwise the first path of the form "name-N.ext" which is available.
+func (s opSys) getUniquePath(p string) (string, error) {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		if _, err := s.Fs.Stat(p); os.IsNotExist(err) {
+			return p, nil
+		} else if err != nil {
+			return "", errors.Wrapf(err, "check existence of file %q", p)
+		}
+		p = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
 // Adapted from https://stackoverflow.com/a/44009184/5403337
 func sanitizePhone(dirty string) string {
 	return strings.Map(
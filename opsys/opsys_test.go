@@ -6,13 +6,18 @@ package opsys
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/Masterminds/semver"
 	"github.com/emersion/go-vcard"
 	"github.com/spf13/afero"
+	"golang.org/x/text/unicode/norm"
 	"gotest.tools/v3/assert"
 )
 
@@ -122,6 +127,499 @@ func TestRunExecCmd(t *testing.T) {
 	os.Exit(0)
 }
 
+func TestRunHook(t *testing.T) {
+	tests := []struct {
+		msg     string
+		hookErr string
+		wantErr string
+	}{
+		{
+			msg: "success",
+		},
+		{
+			msg:     "hook error",
+			hookErr: "beep boop beep\n",
+			wantErr: `run "./index.sh": exit status 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := NewOS(nil, nil, genFakeExecCommand("", tt.hookErr))
+			err := s.RunHook("./index.sh", []string{"BAGOUP_CHAT_GUID=testguid"}, strings.NewReader("{}"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestRunHookOutput(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	outR, outW, err := os.Pipe()
+	assert.NilError(t, err)
+	errR, errW, err := os.Pipe()
+	assert.NilError(t, err)
+	os.Stdout, os.Stderr = outW, errW
+
+	s := NewOS(nil, nil, genFakeExecCommand("indexed testguid\n", ""))
+	assert.NilError(t, s.RunHook("./index.sh", nil, strings.NewReader("{}")))
+	outW.Close()
+	errW.Close()
+	out, err := ioutil.ReadAll(outR)
+	assert.NilError(t, err)
+	assert.Equal(t, "", string(out))
+	logged, err := ioutil.ReadAll(errR)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(logged), "indexed testguid\n"), "hook output not logged: %q", logged)
+}
+
+func TestSetSpotlightMetadata(t *testing.T) {
+	tests := []struct {
+		msg       string
+		metadata  SpotlightMetadata
+		xattrErr  string
+		wantCalls [][]string
+		wantErr   string
+	}{
+		{
+			msg: "all fields",
+			metadata: SpotlightMetadata{
+				Title:       "Novak & Jelena",
+				Description: "Messages with Novak and Jelena",
+				Authors:     []string{"Novak", "Jelena"},
+				Keywords:    []string{"+3815555555555"},
+			},
+			wantCalls: [][]string{
+				{"xattr", "-w", "com.apple.metadata:kMDItemTitle", _plistHeader + "<string>Novak &amp; Jelena</string>" + _plistFooter, "backup/Novak/testguid.txt"},
+				{"xattr", "-w", "com.apple.metadata:kMDItemDescription", _plistHeader + "<string>Messages with Novak and Jelena</string>" + _plistFooter, "backup/Novak/testguid.txt"},
+				{"xattr", "-w", "com.apple.metadata:kMDItemAuthors", _plistHeader + "<array><string>Novak</string><string>Jelena</string></array>" + _plistFooter, "backup/Novak/testguid.txt"},
+				{"xattr", "-w", "com.apple.metadata:kMDItemKeywords", _plistHeader + "<array><string>+3815555555555</string></array>" + _plistFooter, "backup/Novak/testguid.txt"},
+			},
+		},
+		{
+			msg:      "empty fields",
+			metadata: SpotlightMetadata{Authors: []string{"Novak"}},
+			wantCalls: [][]string{
+				{"xattr", "-w", "com.apple.metadata:kMDItemAuthors", _plistHeader + "<array><string>Novak</string></array>" + _plistFooter, "backup/Novak/testguid.txt"},
+			},
+		},
+		{
+			msg:      "xattr error",
+			metadata: SpotlightMetadata{Title: "Novak"},
+			xattrErr: "xattr: [Errno 1] Operation not permitted\n",
+			wantCalls: [][]string{
+				{"xattr", "-w", "com.apple.metadata:kMDItemTitle", _plistHeader + "<string>Novak</string>" + _plistFooter, "backup/Novak/testguid.txt"},
+			},
+			wantErr: `set attribute "com.apple.metadata:kMDItemTitle" of file "backup/Novak/testguid.txt": xattr: [Errno 1] Operation not permitted: exit status 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand("", tt.xattrErr)
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			err := s.SetSpotlightMetadata("backup/Novak/testguid.txt", tt.metadata)
+			assert.DeepEqual(t, tt.wantCalls, calls)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		msg          string
+		osascriptErr string
+		wantErr      string
+	}{
+		{
+			msg: "success",
+		},
+		{
+			msg:          "osascript error",
+			osascriptErr: "execution error: Not authorized\n",
+			wantErr:      "show notification: execution error: Not authorized: exit status 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand("", tt.osascriptErr)
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			err := s.Notify("bagoup", `Export failed: open "backup\chat.db"`)
+			assert.DeepEqual(t, [][]string{{"osascript", "-e", `display notification "Export failed: open \"backup\\chat.db\"" with title "bagoup"`}}, calls)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestProcessRunning(t *testing.T) {
+	tests := []struct {
+		msg         string
+		pgrepErr    string
+		execCommand func(string, ...string) *exec.Cmd
+		wantRunning bool
+		wantErr     string
+	}{
+		{
+			msg:         "running",
+			wantRunning: true,
+		},
+		{
+			msg:      "not running",
+			pgrepErr: "\n",
+		},
+		{
+			msg: "pgrep error",
+			execCommand: func(string, ...string) *exec.Cmd {
+				return exec.Command("/nonexistent/pgrep")
+			},
+			wantErr: `check for process "Messages"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			execCommand := tt.execCommand
+			if execCommand == nil {
+				execCommand = genFakeExecCommand("", tt.pgrepErr)
+			}
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return execCommand(name, args...)
+			})
+			running, err := s.ProcessRunning("Messages")
+			assert.DeepEqual(t, [][]string{{"pgrep", "-x", "Messages"}}, calls)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantRunning, running)
+		})
+	}
+}
+
+func TestHostname(t *testing.T) {
+	want, err := os.Hostname()
+	assert.NilError(t, err)
+	name, err := NewOS(nil, nil, nil).Hostname()
+	assert.NilError(t, err)
+	assert.Equal(t, want, name)
+}
+
+func TestFreeSpace(t *testing.T) {
+	dfOutput := "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/disk3s5 971350180 485675090 485675090 50% /System/Volumes/Data\n"
+
+	tests := []struct {
+		msg      string
+		existing string
+		statErr  error
+		dfOutput string
+		dfErr    string
+		wantPath string
+		wantFree int64
+		wantErr  string
+	}{
+		{
+			msg:      "existing folder",
+			existing: "/Volumes/Backup/backup",
+			dfOutput: dfOutput,
+			wantPath: "/Volumes/Backup/backup",
+			wantFree: 485675090 * 1024,
+		},
+		{
+			msg:      "new folder",
+			existing: "/Volumes/Backup",
+			dfOutput: dfOutput,
+			wantPath: "/Volumes/Backup",
+			wantFree: 485675090 * 1024,
+		},
+		{
+			msg:     "stat error",
+			statErr: errors.New("this is a stat error"),
+			wantErr: `check existence of file "/Volumes/Backup/backup": this is a stat error`,
+		},
+		{
+			msg:      "df error",
+			existing: "/Volumes/Backup",
+			dfErr:    "df: /Volumes/Backup: No such file or directory\n",
+			wantPath: "/Volumes/Backup",
+			wantErr:  `call df for "/Volumes/Backup": exit status 1`,
+		},
+		{
+			msg:      "unexpected df output",
+			existing: "/Volumes/Backup",
+			dfOutput: "Filesystem\n",
+			wantPath: "/Volumes/Backup",
+			wantErr:  `parse df output "Filesystem\n"`,
+		},
+		{
+			msg:      "bad available space",
+			existing: "/Volumes/Backup",
+			dfOutput: "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/disk3s5 971350180 485675090 lots 50% /\n",
+			wantPath: "/Volumes/Backup",
+			wantErr:  `parse available space "lots"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			osStat := func(p string) (os.FileInfo, error) {
+				if tt.statErr != nil {
+					return nil, tt.statErr
+				}
+				if strings.HasPrefix(tt.existing, p) {
+					return nil, nil
+				}
+				return nil, os.ErrNotExist
+			}
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand(tt.dfOutput, tt.dfErr)
+			s := NewOS(nil, osStat, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			free, err := s.FreeSpace("/Volumes/Backup/backup")
+			if tt.wantPath != "" {
+				assert.DeepEqual(t, [][]string{{"df", "-Pk", tt.wantPath}}, calls)
+			}
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantFree, free)
+		})
+	}
+}
+
+func TestDataless(t *testing.T) {
+	tests := []struct {
+		msg          string
+		statOutput   string
+		statErr      string
+		wantDataless bool
+		wantErr      string
+	}{
+		{
+			msg:          "dataless",
+			statOutput:   "compressed,dataless\n",
+			wantDataless: true,
+		},
+		{
+			msg:        "local",
+			statOutput: "-\n",
+		},
+		{
+			msg:     "stat error",
+			statErr: "stat: IMG_0001.jpeg: stat: No such file or directory\n",
+			wantErr: `get flags of file "/attachments/IMG_0001.jpeg": stat: IMG_0001.jpeg: stat: No such file or directory: exit status 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand(tt.statOutput, tt.statErr)
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			dataless, err := s.Dataless("/attachments/IMG_0001.jpeg")
+			assert.DeepEqual(t, [][]string{{"stat", "-f", "%Sf", "/attachments/IMG_0001.jpeg"}}, calls)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantDataless, dataless)
+		})
+	}
+}
+
+func TestRequestDownload(t *testing.T) {
+	tests := []struct {
+		msg      string
+		brctlErr string
+		wantErr  string
+	}{
+		{
+			msg: "success",
+		},
+		{
+			msg:      "brctl error",
+			brctlErr: "brctl: not in iCloud\n",
+			wantErr:  `request download of file "/attachments/IMG_0001.jpeg": brctl: not in iCloud: exit status 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand("", tt.brctlErr)
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			err := s.RequestDownload("/attachments/IMG_0001.jpeg")
+			assert.DeepEqual(t, [][]string{{"brctl", "download", "/attachments/IMG_0001.jpeg"}}, calls)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestRecognizeText(t *testing.T) {
+	tests := []struct {
+		msg          string
+		tessOutput   string
+		tessErr      string
+		execCommand  func(string, ...string) *exec.Cmd
+		wantText     string
+		wantErr      string
+		wantNotFound bool
+	}{
+		{
+			msg:        "text",
+			tessOutput: "Dinner at 8\nZuni Cafe\n",
+			wantText:   "Dinner at 8\nZuni Cafe\n",
+		},
+		{
+			msg:     "tesseract error",
+			tessErr: "Error in pixReadStream: Unknown format: no pix returned\n",
+			wantErr: `recognize text in image "/attachments/screenshot.png": Error in pixReadStream: Unknown format: no pix returned: exit status 1`,
+		},
+		{
+			msg: "no tesseract",
+			execCommand: func(string, ...string) *exec.Cmd {
+				return exec.Command("bagoup-no-such-command")
+			},
+			wantErr:      `recognize text in image "/attachments/screenshot.png": exec: "bagoup-no-such-command": executable file not found in $PATH`,
+			wantNotFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			var calls [][]string
+			fakeExecCommand := genFakeExecCommand(tt.tessOutput, tt.tessErr)
+			if tt.execCommand != nil {
+				fakeExecCommand = tt.execCommand
+			}
+			s := NewOS(nil, nil, func(name string, args ...string) *exec.Cmd {
+				calls = append(calls, append([]string{name}, args...))
+				return fakeExecCommand(name, args...)
+			})
+			text, err := s.RecognizeText("/attachments/screenshot.png")
+			assert.DeepEqual(t, [][]string{{"tesseract", "/attachments/screenshot.png", "stdout"}}, calls)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantNotFound, errors.Is(err, exec.ErrNotFound))
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantText, text)
+		})
+	}
+}
+
+// foldingFs stores file names like an exFAT drive or an SMB share might: names
+// are folded to lower case and decomposed, and names longer than maxName bytes
+// cannot be created.
+type foldingFs struct {
+	afero.Fs
+	maxName int
+}
+
+func (fs foldingFs) fold(name string) string {
+	return strings.ToLower(norm.NFD.String(name))
+}
+
+func (fs foldingFs) Create(name string) (afero.File, error) {
+	if len(path.Base(name)) > fs.maxName {
+		return nil, syscall.ENAMETOOLONG
+	}
+	return fs.Fs.Create(fs.fold(name))
+}
+
+func (fs foldingFs) Stat(name string) (os.FileInfo, error) {
+	return fs.Fs.Stat(fs.fold(name))
+}
+
+func (fs foldingFs) Remove(name string) error {
+	return fs.Fs.Remove(fs.fold(name))
+}
+
+func TestFilenameRules(t *testing.T) {
+	tests := []struct {
+		msg       string
+		fs        afero.Fs
+		wantRules FilenameRules
+		wantErr   string
+	}{
+		{
+			msg:       "APFS",
+			fs:        afero.NewMemMapFs(),
+			wantRules: FilenameRules{MaxNameBytes: 255},
+		},
+		{
+			msg:       "exFAT",
+			fs:        foldingFs{Fs: afero.NewMemMapFs(), maxName: 255},
+			wantRules: FilenameRules{CaseInsensitive: true, Decomposed: true, MaxNameBytes: 255},
+		},
+		{
+			msg:       "short names",
+			fs:        foldingFs{Fs: afero.NewMemMapFs(), maxName: 143},
+			wantRules: FilenameRules{CaseInsensitive: true, Decomposed: true, MaxNameBytes: 143},
+		},
+		{
+			msg:     "read-only filesystem",
+			fs:      afero.NewReadOnlyFs(afero.NewMemMapFs()),
+			wantErr: `create directory "/backup"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := NewOS(tt.fs, nil, nil)
+			rules, err := s.FilenameRules("/backup")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantRules, rules)
+			infos, err := afero.ReadDir(tt.fs, "/backup")
+			assert.NilError(t, err)
+			assert.Equal(t, 0, len(infos), "probe files left behind")
+		})
+	}
+}
+
 func TestGetContactMap(t *testing.T) {
 	tagCard := &vcard.Card{
 		"VERSION": []*vcard.Field{
@@ -266,6 +764,376 @@ END:VCARD
 	}
 }
 
+func TestGetNameMap(t *testing.T) {
+	tests := []struct {
+		msg     string
+		setupFs func(afero.Fs)
+		wantMap map[string]string
+		wantErr string
+	}{
+		{
+			msg: "two names",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "names.csv", []byte(`# handle,name
++1 (415) 555-5555, Rafa Nadal
+info@novakdjokovic.com,"Djokovic, Novak"
+`), 0644)
+			},
+			wantMap: map[string]string{
+				"+14155555555":           "Rafa Nadal",
+				"info@novakdjokovic.com": "Djokovic, Novak",
+			},
+		},
+		{
+			msg: "same handle twice",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "names.csv", []byte("+14155555555,Rafa\n+14155555555,Rafa Nadal\n"), 0644)
+			},
+			wantMap: map[string]string{
+				"+14155555555": "Rafa Nadal",
+			},
+		},
+		{
+			msg:     "no names file",
+			wantErr: `open names.csv: file does not exist`,
+		},
+		{
+			msg: "missing name",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "names.csv", []byte("+14155555555\n"), 0644)
+			},
+			wantErr: "read names CSV",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+
+			s := NewOS(fs, nil, nil)
+			nameMap, err := s.GetNameMap("names.csv")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantMap, nameMap)
+		})
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", "/Users/david")
+
+	tests := []struct {
+		msg      string
+		home     string
+		path     string
+		wantPath string
+	}{
+		{
+			msg:      "tilde",
+			path:     "~/Library/Messages/Attachments/ab/11/photo.jpeg",
+			wantPath: "/Users/david/Library/Messages/Attachments/ab/11/photo.jpeg",
+		},
+		{
+			msg:      "another user's home",
+			home:     "/Users/jelena",
+			path:     "~/Library/Messages/Attachments/ab/11/photo.jpeg",
+			wantPath: "/Users/jelena/Library/Messages/Attachments/ab/11/photo.jpeg",
+		},
+		{
+			msg:      "absolute path",
+			path:     "/Library/Messages/Attachments/ab/11/photo.jpeg",
+			wantPath: "/Library/Messages/Attachments/ab/11/photo.jpeg",
+		},
+		{
+			msg:      "tilde in the middle",
+			path:     "Attachments/~/photo.jpeg",
+			wantPath: "Attachments/~/photo.jpeg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			s := NewOSWithHome(nil, nil, nil, tt.home)
+			p, err := s.ExpandHome(tt.path)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPath, p)
+		})
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	tests := []struct {
+		msg       string
+		src       string
+		setupFs   func(afero.Fs)
+		roFs      bool
+		wantPath  string
+		wantFiles map[string]string
+		wantErr   string
+	}{
+		{
+			msg: "success",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo.jpeg",
+			wantFiles: map[string]string{
+				"backup/attachments/photo.jpeg": "jpeg data",
+			},
+		},
+		{
+			msg: "name collision",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+				afero.WriteFile(fs, "backup/attachments/photo.jpeg", []byte("other jpeg data"), 0644)
+				afero.WriteFile(fs, "backup/attachments/photo-1.jpeg", []byte("more jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo-2.jpeg",
+			wantFiles: map[string]string{
+				"backup/attachments/photo.jpeg":   "other jpeg data",
+				"backup/attachments/photo-1.jpeg": "more jpeg data",
+				"backup/attachments/photo-2.jpeg": "jpeg data",
+			},
+		},
+		{
+			msg: "unsafe name",
+			src: "/attachments/.photo:1\x07.jpeg",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/.photo:1\x07.jpeg", []byte("jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo_1_.jpeg",
+			wantFiles: map[string]string{
+				"backup/attachments/photo_1_.jpeg": "jpeg data",
+			},
+		},
+		{
+			msg:     "parent directory",
+			src:     "/attachments/photo.jpeg/..",
+			wantErr: `refuse to copy "/attachments/photo.jpeg/.." outside of "backup/attachments"`,
+		},
+		{
+			msg: "not a regular file",
+			setupFs: func(fs afero.Fs) {
+				fs.MkdirAll("/attachments/photo.jpeg", 0755)
+			},
+			wantErr: `"/attachments/photo.jpeg" is not a regular file`,
+		},
+		{
+			msg:     "missing source file",
+			wantErr: "open /attachments/photo.jpeg: file does not exist",
+		},
+		{
+			msg: "read-only filesystem",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			},
+			roFs:    true,
+			wantErr: `create file "backup/attachments/photo.jpeg": operation not permitted`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+			if tt.roFs {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+
+			src := tt.src
+			if src == "" {
+				src = "/attachments/photo.jpeg"
+			}
+			s := NewOS(fs, nil, nil)
+			p, err := s.CopyFile(src, "backup/attachments")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPath, p)
+			for filename, expected := range tt.wantFiles {
+				actual, err := afero.ReadFile(fs, filename)
+				assert.NilError(t, err)
+				assert.Equal(t, expected, string(actual))
+			}
+		})
+	}
+}
+
+func TestCopyFileChecksum(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NilError(t, afero.WriteFile(fs, "/attachments/video.mov", []byte("mov data"), 0644))
+	s := NewOS(fs, nil, nil)
+
+	p, sum, err := s.CopyFileChecksum("/attachments/video.mov", "backup/attachments")
+	assert.NilError(t, err)
+	assert.Equal(t, "backup/attachments/video.mov", p)
+	assert.Equal(t, Checksum{Bytes: 8, SHA256: "f4a201b4fda1027d1f7aa57b1f22ca459ebe5942c5b2e7b408bcf168718d1fdf"}, sum)
+	actual, err := afero.ReadFile(fs, p)
+	assert.NilError(t, err)
+	assert.Equal(t, "mov data", string(actual))
+
+	_, _, err = s.CopyFileChecksum("/attachments/missing.mov", "backup/attachments")
+	assert.ErrorContains(t, err, "file does not exist")
+}
+
+func TestCloneFile(t *testing.T) {
+	tests := []struct {
+		msg       string
+		setupFs   func(afero.Fs)
+		cpErr     string
+		wantPath  string
+		wantFiles map[string]string
+		wantErr   string
+	}{
+		{
+			msg: "cloned",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/attachments/photo.jpeg", []byte("other jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo-1.jpeg",
+		},
+		{
+			msg: "fall back to copy",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			},
+			cpErr:    "cp: backup/attachments/photo.jpeg: Cross-device link\n",
+			wantPath: "backup/attachments/photo.jpeg",
+			wantFiles: map[string]string{
+				"backup/attachments/photo.jpeg": "jpeg data",
+			},
+		},
+		{
+			msg: "not a regular file",
+			setupFs: func(fs afero.Fs) {
+				fs.MkdirAll("/attachments/photo.jpeg", 0755)
+			},
+			wantErr: `"/attachments/photo.jpeg" is not a regular file`,
+		},
+		{
+			msg:     "missing source file",
+			cpErr:   "cp: /attachments/photo.jpeg: No such file or directory\n",
+			wantErr: "open /attachments/photo.jpeg: file does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+
+			s := NewOS(fs, nil, genFakeExecCommand("", tt.cpErr))
+			p, err := s.CloneFile("/attachments/photo.jpeg", "backup/attachments")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPath, p)
+			for filename, expected := range tt.wantFiles {
+				actual, err := afero.ReadFile(fs, filename)
+				assert.NilError(t, err)
+				assert.Equal(t, expected, string(actual))
+			}
+		})
+	}
+}
+
+func TestCloneFileReservesPath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := NewOS(fs, nil, genFakeExecCommand("", ""))
+	first, err := s.CloneFile("/attachments/photo.jpeg", "backup/attachments")
+	assert.NilError(t, err)
+	second, err := s.CloneFile("/other/photo.jpeg", "backup/attachments")
+	assert.NilError(t, err)
+	assert.Equal(t, "backup/attachments/photo.jpeg", first)
+	assert.Equal(t, "backup/attachments/photo-1.jpeg", second)
+}
+
+func TestExistingCopy(t *testing.T) {
+	tests := []struct {
+		msg      string
+		setupFs  func(afero.Fs)
+		wantPath string
+		wantErr  string
+	}{
+		{
+			msg: "no copy",
+		},
+		{
+			msg: "copy",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo.jpeg",
+		},
+		{
+			msg: "numbered copy",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/attachments/photo.jpeg", []byte("gif data!"), 0644)
+				afero.WriteFile(fs, "backup/attachments/photo-1.jpeg", []byte("other jpeg data"), 0644)
+				afero.WriteFile(fs, "backup/attachments/photo-2.jpeg", []byte("jpeg data"), 0644)
+			},
+			wantPath: "backup/attachments/photo-2.jpeg",
+		},
+		{
+			msg: "different files",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/attachments/photo.jpeg", []byte("gif data!"), 0644)
+			},
+		},
+		{
+			msg:     "missing source file",
+			setupFs: func(fs afero.Fs) { fs.Remove("/attachments/photo.jpeg") },
+			wantErr: "file does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/attachments/photo.jpeg", []byte("jpeg data"), 0644)
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+
+			s := NewOS(fs, nil, nil)
+			p, sum, err := s.ExistingCopy("/attachments/photo.jpeg", "backup/attachments")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPath, p)
+			if p != "" {
+				assert.Equal(t, int64(9), sum.Bytes)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "photo.jpeg", sanitizeFilename("photo.jpeg"))
+	assert.Equal(t, "Screen Shot 2020-03-01 at 15_34_05.png", sanitizeFilename("Screen Shot 2020-03-01 at 15:34:05.png"))
+	assert.Equal(t, "a_b_c", sanitizeFilename("a\\b\nc"))
+	assert.Equal(t, "bashrc", sanitizeFilename(".bashrc"))
+	assert.Equal(t, "", sanitizeFilename(".."))
+}
+
 func TestSanitizePhone(t *testing.T) {
 	tests := []struct {
 		msg   string
@@ -0,0 +1,118 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package opsys
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+type (
+	// Permissions are the modes and ownership of the files and folders
+	// created through a filesystem returned by NewPermissionFs.
+	Permissions struct {
+		FileMode os.FileMode
+		DirMode  os.FileMode
+		// UID and GID own the created files and folders. Either is left
+		// unchanged if it is -1.
+		UID, GID int
+	}
+
+	permissionFs struct {
+		afero.Fs
+		perms Permissions
+		chown func(string, int, int) error
+	}
+
+	// enforcer is implemented by filesystems which enforce the permissions
+	// of the files created through them, so that files created by other
+	// means, e.g. by the cp command, can be given the same permissions.
+	enforcer interface {
+		enforce(name string) error
+	}
+)
+
+// NewPermissionFs returns a filesystem which creates files and folders in the
+// given filesystem with the given permissions, regardless of the modes
+// requested and of the umask. Ownership is changed with the given chown
+// function, e.g. os.Chown.
+func NewPermissionFs(fs afero.Fs, perms Permissions, chown func(string, int, int) error) afero.Fs {
+	return permissionFs{Fs: fs, perms: perms, chown: chown}
+}
+
+func (p permissionFs) Create(name string) (afero.File, error) {
+	return p.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, p.perms.FileMode)
+}
+
+func (p permissionFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_CREATE == 0 {
+		return p.Fs.OpenFile(name, flag, perm)
+	}
+	f, err := p.Fs.OpenFile(name, flag, p.perms.FileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.apply(name, p.perms.FileMode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p permissionFs) Mkdir(name string, perm os.FileMode) error {
+	if err := p.Fs.Mkdir(name, p.perms.DirMode); err != nil {
+		return err
+	}
+	return p.apply(name, p.perms.DirMode)
+}
+
+func (p permissionFs) MkdirAll(dirPath string, perm os.FileMode) error {
+	// Only the folders which are created are given the permissions, leaving
+	// existing parents, e.g. the home folder, untouched.
+	var missing []string
+	for dir := filepath.Clean(dirPath); ; dir = filepath.Dir(dir) {
+		if _, err := p.Fs.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	if err := p.Fs.MkdirAll(dirPath, p.perms.DirMode); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := p.apply(missing[i], p.perms.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p permissionFs) enforce(name string) error {
+	info, err := p.Fs.Stat(name)
+	if err != nil {
+		return errors.Wrapf(err, "stat file %q", name)
+	}
+	if info.IsDir() {
+		return p.apply(name, p.perms.DirMode)
+	}
+	return p.apply(name, p.perms.FileMode)
+}
+
+func (p permissionFs) apply(name string, mode os.FileMode) error {
+	if err := p.Fs.Chmod(name, mode); err != nil {
+		return errors.Wrapf(err, "change mode of %q", name)
+	}
+	if p.perms.UID == -1 && p.perms.GID == -1 {
+		return nil
+	}
+	return errors.Wrapf(p.chown(name, p.perms.UID, p.perms.GID), "change owner of %q", name)
+}
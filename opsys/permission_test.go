@@ -0,0 +1,125 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package opsys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestPermissionFs(t *testing.T) {
+	tests := []struct {
+		msg       string
+		perms     Permissions
+		chownErr  error
+		create    func(afero.Fs) error
+		wantModes map[string]os.FileMode
+		wantOwned []string
+		wantErr   string
+	}{
+		{
+			msg:   "create file",
+			perms: Permissions{FileMode: 0600, DirMode: 0700, UID: -1, GID: -1},
+			create: func(fs afero.Fs) error {
+				f, err := fs.Create("backup/Novak/testguid.txt")
+				if err != nil {
+					return err
+				}
+				return f.Close()
+			},
+			wantModes: map[string]os.FileMode{"backup/Novak/testguid.txt": 0600},
+		},
+		{
+			msg:   "open file for writing",
+			perms: Permissions{FileMode: 0640, DirMode: 0750, UID: 501, GID: 20},
+			create: func(fs afero.Fs) error {
+				f, err := fs.OpenFile("backup/Novak/photo.jpeg", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+				if err != nil {
+					return err
+				}
+				return f.Close()
+			},
+			wantModes: map[string]os.FileMode{"backup/Novak/photo.jpeg": 0640},
+			wantOwned: []string{"backup/Novak/photo.jpeg"},
+		},
+		{
+			msg:   "make folders",
+			perms: Permissions{FileMode: 0600, DirMode: 0700, UID: 501, GID: -1},
+			create: func(fs afero.Fs) error {
+				return fs.MkdirAll("backup/Novak/attachments", os.ModePerm)
+			},
+			wantModes: map[string]os.FileMode{
+				"backup":                   0755,
+				"backup/Novak":             0700,
+				"backup/Novak/attachments": 0700,
+			},
+			wantOwned: []string{"backup/Novak", "backup/Novak/attachments"},
+		},
+		{
+			msg:   "make folder",
+			perms: Permissions{FileMode: 0600, DirMode: 0700, UID: -1, GID: -1},
+			create: func(fs afero.Fs) error {
+				return fs.Mkdir("backup/Jelena", os.ModePerm)
+			},
+			wantModes: map[string]os.FileMode{"backup/Jelena": 0700},
+		},
+		{
+			msg:      "chown error",
+			perms:    Permissions{FileMode: 0600, DirMode: 0700, UID: 501, GID: 20},
+			chownErr: errors.New("operation not permitted"),
+			create: func(fs afero.Fs) error {
+				_, err := fs.Create("backup/Novak/testguid.txt")
+				return err
+			},
+			wantErr: `change owner of "backup/Novak/testguid.txt": operation not permitted`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			base := afero.NewMemMapFs()
+			assert.NilError(t, base.MkdirAll("backup", 0755))
+			var owned []string
+			chown := func(name string, uid, gid int) error {
+				assert.Equal(t, tt.perms.UID, uid)
+				assert.Equal(t, tt.perms.GID, gid)
+				owned = append(owned, name)
+				return tt.chownErr
+			}
+			fs := NewPermissionFs(base, tt.perms, chown)
+			err := tt.create(fs)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			for name, wantMode := range tt.wantModes {
+				info, err := base.Stat(name)
+				assert.NilError(t, err)
+				assert.Equal(t, wantMode, info.Mode().Perm(), fmt.Sprintf("mode of %q", name))
+			}
+			assert.DeepEqual(t, tt.wantOwned, owned)
+		})
+	}
+}
+
+func TestPermissionFsEnforce(t *testing.T) {
+	base := afero.NewMemMapFs()
+	fs := NewPermissionFs(base, Permissions{FileMode: 0600, DirMode: 0700, UID: -1, GID: -1}, nil)
+	assert.NilError(t, afero.WriteFile(base, "backup/attachments/photo.jpeg", []byte("jpeg"), 0644))
+	assert.NilError(t, fs.(enforcer).enforce("backup/attachments/photo.jpeg"))
+	assert.NilError(t, fs.(enforcer).enforce("backup/attachments"))
+	info, err := base.Stat("backup/attachments/photo.jpeg")
+	assert.NilError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = base.Stat("backup/attachments")
+	assert.NilError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	assert.ErrorContains(t, fs.(enforcer).enforce("backup/attachments/missing.jpeg"), `stat file "backup/attachments/missing.jpeg"`)
+}
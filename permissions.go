@@ -0,0 +1,74 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// getPermissions returns the modes and ownership of the exported files and
+// folders given by the options.
+func getPermissions(opts options) (opsys.Permissions, error) {
+	perms := opsys.Permissions{UID: -1, GID: -1}
+	var err error
+	if perms.FileMode, err = parseMode(opts.FileMode); err != nil {
+		return perms, errors.Wrap(err, "parse file mode")
+	}
+	if perms.DirMode, err = parseMode(opts.DirMode); err != nil {
+		return perms, errors.Wrap(err, "parse folder mode")
+	}
+	if opts.Owner == "" {
+		return perms, nil
+	}
+	owner := strings.SplitN(opts.Owner, ":", 2)
+	if perms.UID, err = lookupID(owner[0], func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	}); err != nil {
+		return perms, errors.Wrapf(err, "look up user %q", owner[0])
+	}
+	if len(owner) == 1 {
+		return perms, nil
+	}
+	if perms.GID, err = lookupID(owner[1], func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	}); err != nil {
+		return perms, errors.Wrapf(err, "look up group %q", owner[1])
+	}
+	return perms, nil
+}
+
+// parseMode parses permission bits in octal, e.g. "0600".
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || os.FileMode(mode)&^os.ModePerm != 0 {
+		return 0, errors.Errorf("invalid mode %q - use octal permission bits, e.g. 0600", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// lookupID returns the given numeric ID, or the ID of the given name.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	id, err := lookup(nameOrID)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,81 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetPermissions(t *testing.T) {
+	tests := []struct {
+		msg       string
+		fileMode  string
+		dirMode   string
+		owner     string
+		wantPerms opsys.Permissions
+		wantErr   string
+	}{
+		{
+			msg:       "defaults",
+			fileMode:  "0600",
+			dirMode:   "0700",
+			wantPerms: opsys.Permissions{FileMode: 0600, DirMode: 0700, UID: -1, GID: -1},
+		},
+		{
+			msg:       "numeric owner and group",
+			fileMode:  "640",
+			dirMode:   "750",
+			owner:     "501:20",
+			wantPerms: opsys.Permissions{FileMode: 0640, DirMode: 0750, UID: 501, GID: 20},
+		},
+		{
+			msg:       "named owner",
+			fileMode:  "0600",
+			dirMode:   "0700",
+			owner:     "root",
+			wantPerms: opsys.Permissions{FileMode: 0600, DirMode: 0700, UID: 0, GID: -1},
+		},
+		{
+			msg:      "invalid file mode",
+			fileMode: "0800",
+			dirMode:  "0700",
+			wantErr:  `parse file mode: invalid mode "0800" - use octal permission bits, e.g. 0600`,
+		},
+		{
+			msg:      "invalid folder mode",
+			fileMode: "0600",
+			dirMode:  "01777",
+			wantErr:  `parse folder mode: invalid mode "01777"`,
+		},
+		{
+			msg:      "unknown user",
+			fileMode: "0600",
+			dirMode:  "0700",
+			owner:    "bagoup-nonexistent-user",
+			wantErr:  `look up user "bagoup-nonexistent-user"`,
+		},
+		{
+			msg:      "unknown group",
+			fileMode: "0600",
+			dirMode:  "0700",
+			owner:    "501:bagoup-nonexistent-group",
+			wantErr:  `look up group "bagoup-nonexistent-group"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			perms, err := getPermissions(options{FileMode: tt.fileMode, DirMode: tt.dirMode, Owner: tt.owner})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPerms, perms)
+		})
+	}
+}
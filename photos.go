@@ -0,0 +1,88 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"database/sql"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/photos"
+)
+
+// _openPhotosLibrary opens the Photos library at the given path read-only,
+// waiting up to the given number of seconds for its database while it is
+// locked, e.g. by Photos, and returns it with a function closing it.
+var _openPhotosLibrary = func(s opsys.OS, libraryPath string, busyTimeout int) (photos.Library, func() error, error) {
+	libraryPath, err := s.ExpandHome(libraryPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "expand Photos library path %q", libraryPath)
+	}
+	dbPath := path.Join(libraryPath, photos.DatabasePath)
+	if exist, err := afero.Exists(s, dbPath); err != nil {
+		return nil, nil, errors.Wrapf(err, "check Photos database %q", dbPath)
+	} else if !exist {
+		return nil, nil, errors.Errorf("no Photos database at %q - FIX: pass the path of a Photos library, e.g. '~/Pictures/Photos Library.photoslibrary', to --photos-library", dbPath)
+	}
+	db, err := sql.Open("sqlite3", dataSourceName(dbPath, true, busyTimeout))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "open Photos database %q", dbPath)
+	}
+	return photos.NewLibrary(db, libraryPath), db.Close, nil
+}
+
+// findInPhotos looks up the given attachments which no longer exist in the
+// given Photos library, e.g. photos saved to Photos before they expired from
+// Messages, by their names and sizes. It returns the attachments with those
+// found pointing to their originals in the library, and the number found.
+func findInPhotos(s opsys.OS, library photos.Library, attachments []chatdb.Attachment) ([]chatdb.Attachment, int, error) {
+	if library == nil {
+		return attachments, 0, nil
+	}
+	var found []chatdb.Attachment
+	n := 0
+	for i, att := range attachments {
+		if att.Filename == "" || leavesFolder(att.Filename) {
+			continue
+		}
+		attPath, err := s.ExpandHome(att.Filename)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "expand attachment path %q", att.Filename)
+		}
+		if exist, err := afero.Exists(s, attPath); err != nil {
+			return nil, 0, errors.Wrapf(err, "check existence of attachment %q", attPath)
+		} else if exist {
+			continue
+		}
+		original, err := library.FindOriginal(attachmentName(att), att.TotalBytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		if original == "" {
+			continue
+		}
+		if exist, err := afero.Exists(s, original); err != nil {
+			return nil, 0, errors.Wrapf(err, "check existence of Photos original %q", original)
+		} else if !exist {
+			logging.Warnf("attachment %q is in the Photos library as %q, but its original is not downloaded - FIX: turn on Download Originals to this Mac in the iCloud settings of Photos", attPath, original)
+			continue
+		}
+		logging.Debugf("found missing attachment %q in the Photos library as %q", attPath, original)
+		if found == nil {
+			// The attachments are shared with other lookups, so they are
+			// copied before they are changed.
+			found = append([]chatdb.Attachment(nil), attachments...)
+		}
+		found[i].Filename = original
+		n++
+	}
+	if found == nil {
+		return attachments, 0, nil
+	}
+	return found, n, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package photos provides an interface Library for finding media in a Photos
+// library, e.g. attachments which were saved to Photos before they were
+// deleted from Messages. The library's database is typically located at
+// "~/Pictures/Photos Library.photoslibrary/database/Photos.sqlite".
+package photos
+
+import (
+	"database/sql"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate mockgen -destination=mock_photos/mock_photos.go github.com/tagatac/bagoup/photos Library
+
+// DatabasePath is the path of the database of a Photos library, relative to
+// the library.
+const DatabasePath = "database/Photos.sqlite"
+
+// Library finds media in a Photos library.
+type Library interface {
+	// FindOriginal returns the path of the original file of the photo or
+	// video imported into the library from a file with the given name and
+	// size, or an empty path if there is none. A size of zero matches any
+	// size. Media in the Recently Deleted album are not found.
+	FindOriginal(name string, size int64) (string, error)
+}
+
+type library struct {
+	db          *sql.DB
+	libraryPath string
+	assetTable  string
+}
+
+// NewLibrary returns a Library for the Photos library at the given path, with
+// its database open as the given database.
+func NewLibrary(db *sql.DB, libraryPath string) Library {
+	return &library{db: db, libraryPath: libraryPath}
+}
+
+// getAssetTable returns the name of the table of the library's media, which
+// was ZGENERICASSET before Mac OS 11.
+func (l *library) getAssetTable() (string, error) {
+	if l.assetTable != "" {
+		return l.assetTable, nil
+	}
+	var name string
+	err := l.db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('ZASSET', 'ZGENERICASSET') ORDER BY name LIMIT 1").Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", errors.New("no table of media - not a Photos library database")
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "find table of media")
+	}
+	l.assetTable = name
+	return name, nil
+}
+
+func (l *library) FindOriginal(name string, size int64) (string, error) {
+	table, err := l.getAssetTable()
+	if err != nil {
+		return "", err
+	}
+	var dir, filename string
+	err = l.db.QueryRow(
+		"SELECT a.ZDIRECTORY, a.ZFILENAME FROM "+table+" a JOIN ZADDITIONALASSETATTRIBUTES aa ON aa.ZASSET = a.Z_PK WHERE aa.ZORIGINALFILENAME = ? AND (? = 0 OR aa.ZORIGINALFILESIZE = ?) AND COALESCE(a.ZTRASHEDSTATE, 0) = 0 ORDER BY a.Z_PK LIMIT 1",
+		name, size, size,
+	).Scan(&dir, &filename)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "find %q in Photos library", name)
+	}
+	return path.Join(l.libraryPath, "originals", dir, filename), nil
+}
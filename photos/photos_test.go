@@ -0,0 +1,112 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package photos
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/v3/assert"
+)
+
+func TestFindOriginal(t *testing.T) {
+	tests := []struct {
+		msg        string
+		setupMock  func(sqlmock.Sqlmock)
+		size       int64
+		wantPath   string
+		wantErr    string
+		findsAgain bool
+	}{
+		{
+			msg: "found",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ZASSET"))
+				sMock.ExpectQuery("SELECT a.ZDIRECTORY, a.ZFILENAME FROM ZASSET a").
+					WithArgs("IMG_0001.HEIC", 1024, 1024).
+					WillReturnRows(sqlmock.NewRows([]string{"ZDIRECTORY", "ZFILENAME"}).AddRow("A", "A1B2C3.heic"))
+				sMock.ExpectQuery("SELECT a.ZDIRECTORY, a.ZFILENAME FROM ZASSET a").
+					WithArgs("IMG_0001.HEIC", 1024, 1024).
+					WillReturnRows(sqlmock.NewRows([]string{"ZDIRECTORY", "ZFILENAME"}).AddRow("A", "A1B2C3.heic"))
+			},
+			size:       1024,
+			wantPath:   "Photos Library.photoslibrary/originals/A/A1B2C3.heic",
+			findsAgain: true,
+		},
+		{
+			msg: "Mac OS 10.15",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ZGENERICASSET"))
+				sMock.ExpectQuery("SELECT a.ZDIRECTORY, a.ZFILENAME FROM ZGENERICASSET a").
+					WithArgs("IMG_0001.HEIC", 0, 0).
+					WillReturnRows(sqlmock.NewRows([]string{"ZDIRECTORY", "ZFILENAME"}).AddRow("0", "A1B2C3.heic"))
+			},
+			wantPath: "Photos Library.photoslibrary/originals/0/A1B2C3.heic",
+		},
+		{
+			msg: "not found",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ZASSET"))
+				sMock.ExpectQuery("SELECT a.ZDIRECTORY, a.ZFILENAME FROM ZASSET a").
+					WillReturnRows(sqlmock.NewRows([]string{"ZDIRECTORY", "ZFILENAME"}))
+			},
+			size: 1024,
+		},
+		{
+			msg: "not a Photos library",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+			},
+			wantErr: "no table of media - not a Photos library database",
+		},
+		{
+			msg: "table error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: "find table of media: this is a DB error",
+		},
+		{
+			msg: "query error",
+			setupMock: func(sMock sqlmock.Sqlmock) {
+				sMock.ExpectQuery("SELECT name FROM sqlite_master").
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("ZASSET"))
+				sMock.ExpectQuery("SELECT a.ZDIRECTORY, a.ZFILENAME FROM ZASSET a").
+					WillReturnError(errors.New("this is a DB error"))
+			},
+			wantErr: `find "IMG_0001.HEIC" in Photos library: this is a DB error`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			db, sMock, err := sqlmock.New()
+			assert.NilError(t, err)
+			defer db.Close()
+			tt.setupMock(sMock)
+
+			l := NewLibrary(db, "Photos Library.photoslibrary")
+			p, err := l.FindOriginal("IMG_0001.HEIC", tt.size)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantPath, p)
+			if tt.findsAgain {
+				// The table of media is only looked up once.
+				p, err = l.FindOriginal("IMG_0001.HEIC", tt.size)
+				assert.NilError(t, err)
+				assert.Equal(t, tt.wantPath, p)
+			}
+			assert.NilError(t, sMock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/photos/mock_photos"
+	"gotest.tools/v3/assert"
+)
+
+func TestOpenPhotosLibrary(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	_, _, err := _openPhotosLibrary(s, "/Users/david/Pictures/Photos Library.photoslibrary", 5)
+	assert.Error(t, err, `no Photos database at "/Users/david/Pictures/Photos Library.photoslibrary/database/Photos.sqlite" - FIX: pass the path of a Photos library, e.g. '~/Pictures/Photos Library.photoslibrary', to --photos-library`)
+}
+
+func TestFindInPhotos(t *testing.T) {
+	attachments := []chatdb.Attachment{
+		{ID: 1, Filename: "/Library/Messages/Attachments/ab/01/IMG_0001.HEIC", TransferName: "IMG_0001.HEIC", TotalBytes: 1024},
+		{ID: 2, Filename: "/Library/Messages/Attachments/cd/02/IMG_0002.HEIC", TransferName: "IMG_0002.HEIC", TotalBytes: 2048},
+		{ID: 3, Filename: "/Library/Messages/Attachments/ef/03/IMG_0003.HEIC", TotalBytes: 4096},
+		{ID: 4, Filename: "/Library/Messages/Attachments/gh/04/IMG_0004.HEIC", TransferName: "IMG_0004.HEIC"},
+		{ID: 5},
+	}
+
+	tests := []struct {
+		msg       string
+		noLibrary bool
+		setupMock func(*mock_photos.MockLibrary)
+		wantPaths []string
+		wantFound int
+		wantErr   string
+	}{
+		{
+			msg:       "no library",
+			noLibrary: true,
+			wantPaths: []string{attachments[0].Filename, attachments[1].Filename, attachments[2].Filename, attachments[3].Filename, ""},
+		},
+		{
+			msg: "found",
+			setupMock: func(library *mock_photos.MockLibrary) {
+				library.EXPECT().FindOriginal("IMG_0002.HEIC", int64(2048)).Return("/Pictures/Photos Library.photoslibrary/originals/A/A1.heic", nil)
+				library.EXPECT().FindOriginal("IMG_0003.HEIC", int64(4096)).Return("", nil)
+				library.EXPECT().FindOriginal("IMG_0004.HEIC", int64(0)).Return("/Pictures/Photos Library.photoslibrary/originals/B/B1.heic", nil)
+			},
+			wantPaths: []string{
+				attachments[0].Filename,
+				"/Pictures/Photos Library.photoslibrary/originals/A/A1.heic",
+				attachments[2].Filename,
+				attachments[3].Filename,
+				"",
+			},
+			wantFound: 1,
+		},
+		{
+			msg: "library error",
+			setupMock: func(library *mock_photos.MockLibrary) {
+				library.EXPECT().FindOriginal("IMG_0002.HEIC", int64(2048)).Return("", errors.New("this is a DB error"))
+			},
+			wantErr: "this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			fs := afero.NewMemMapFs()
+			assert.NilError(t, afero.WriteFile(fs, attachments[0].Filename, nil, 0644))
+			// Only the original of the second attachment is downloaded from
+			// iCloud.
+			assert.NilError(t, afero.WriteFile(fs, "/Pictures/Photos Library.photoslibrary/originals/A/A1.heic", nil, 0644))
+			s := opsys.NewOS(fs, nil, nil)
+			library := mock_photos.NewMockLibrary(ctrl)
+			if tt.setupMock != nil {
+				tt.setupMock(library)
+			}
+
+			var got []chatdb.Attachment
+			var found int
+			var err error
+			if tt.noLibrary {
+				got, found, err = findInPhotos(s, nil, attachments)
+			} else {
+				got, found, err = findInPhotos(s, library, attachments)
+			}
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			var paths []string
+			for _, att := range got {
+				paths = append(paths, att.Filename)
+			}
+			assert.DeepEqual(t, tt.wantPaths, paths)
+			assert.Equal(t, tt.wantFound, found)
+			assert.Equal(t, "/Library/Messages/Attachments/cd/02/IMG_0002.HEIC", attachments[1].Filename, "attachments changed")
+		})
+	}
+}
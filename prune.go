@@ -0,0 +1,126 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+type pruneOptions struct {
+	Dir    string `long:"dir" description:"Folder containing the export folders of scheduled backups, e.g. 'backups' for exports into 'backups/2020-03-01'" required:"true"`
+	Keep   int    `long:"keep" description:"Number of the latest full exports to keep; older full exports are removed (default: keep all)"`
+	MaxAge int    `long:"max-age" description:"Remove partial exports, i.e. exports of selected chats or messages, e.g. with --recent or --match, and failed or interrupted exports, started more than the given number of days ago (default: keep all)"`
+	DryRun bool   `long:"dry-run" description:"Only log the export folders which would be removed"`
+}
+
+// pastExport is an export folder found by prune.
+type pastExport struct {
+	path  string
+	start time.Time
+	// full is set for exports which finished without errors and include
+	// every chat, without stopping after --max-duration.
+	full bool
+}
+
+// prune removes the export folders in the --dir folder which are beyond the
+// retention given by the options, so that scheduled backups do not fill the
+// disk.
+func prune(pruneOpts pruneOptions, s opsys.OS, now time.Time) error {
+	if pruneOpts.Keep <= 0 && pruneOpts.MaxAge <= 0 {
+		return errors.New("nothing to prune - FIX: pass --keep with the number of full exports to keep, and/or --max-age with the age in days of partial exports to remove")
+	}
+	exports, err := findExports(s, pruneOpts.Dir)
+	if err != nil {
+		return err
+	}
+	var remove []pastExport
+	full := 0
+	for _, export := range exports {
+		if export.full {
+			full++
+			if pruneOpts.Keep > 0 && full > pruneOpts.Keep {
+				remove = append(remove, export)
+			}
+		} else if pruneOpts.MaxAge > 0 && now.Sub(export.start) > time.Duration(pruneOpts.MaxAge)*24*time.Hour {
+			remove = append(remove, export)
+		}
+	}
+	for _, export := range remove {
+		if pruneOpts.DryRun {
+			logging.Infof("would remove export folder %q from %s", export.path, export.start.Format(time.RFC3339))
+			continue
+		}
+		logging.Infof("removing export folder %q from %s", export.path, export.start.Format(time.RFC3339))
+		if err := s.RemoveAll(export.path); err != nil {
+			return errors.Wrapf(err, "remove export folder %q", export.path)
+		}
+	}
+	logging.Infof("%d of %d export folders in %q pruned", len(remove), len(exports), pruneOpts.Dir)
+	return nil
+}
+
+// findExports returns the export folders in the given folder, newest first.
+// Export folders are recognized by their run summaries, or by their resume
+// manifests for exports which were interrupted before writing a run summary;
+// other folders are left alone.
+func findExports(s opsys.OS, dir string) ([]pastExport, error) {
+	infos, err := afero.ReadDir(s, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read directory %q", dir)
+	}
+	var exports []pastExport
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		exportPath := path.Join(dir, info.Name())
+		export, ok, err := readPastExport(s, exportPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			exports = append(exports, export)
+		}
+	}
+	sort.SliceStable(exports, func(i, j int) bool { return exports[i].start.After(exports[j].start) })
+	return exports, nil
+}
+
+// readPastExport describes the export in the given folder, and checks if the
+// folder is an export folder.
+func readPastExport(s opsys.OS, exportPath string) (pastExport, bool, error) {
+	export := pastExport{path: exportPath}
+	summaryPath := path.Join(exportPath, _runSummaryFilename)
+	b, err := afero.ReadFile(s, summaryPath)
+	if os.IsNotExist(err) {
+		info, err := s.Stat(path.Join(exportPath, _resumeFilename))
+		if os.IsNotExist(err) {
+			return export, false, nil
+		}
+		if err != nil {
+			return export, false, errors.Wrapf(err, "check resume manifest of export folder %q", exportPath)
+		}
+		export.start = info.ModTime()
+		return export, true, nil
+	}
+	if err != nil {
+		return export, false, errors.Wrapf(err, "read file %q", summaryPath)
+	}
+	var summary runSummary
+	if err := json.Unmarshal(b, &summary); err != nil {
+		return export, false, errors.Wrapf(err, "decode file %q - FIX: move the folder out of %q if it is not an export folder", summaryPath, path.Dir(exportPath))
+	}
+	export.start = summary.Start
+	export.full = len(summary.Errors) == 0 && summary.RemainingChats == 0 && !summary.Options.filtered()
+	return export, true, nil
+}
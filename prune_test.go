@@ -0,0 +1,144 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2020, time.March, 31, 15, 34, 5, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2020, time.March, d, 2, 0, 0, 0, time.UTC) }
+	writeSummary := func(fs afero.Fs, name string, summary runSummary) {
+		b, err := json.Marshal(summary)
+		assert.NilError(t, err)
+		assert.NilError(t, afero.WriteFile(fs, path.Join("backups", name, _runSummaryFilename), b, 0644))
+		assert.NilError(t, afero.WriteFile(fs, path.Join("backups", name, _resumeFilename), []byte("{}"), 0644))
+	}
+	setupExports := func(fs afero.Fs) {
+		writeSummary(fs, "2020-03-01", runSummary{Start: day(1)})
+		writeSummary(fs, "2020-03-08", runSummary{Start: day(8)})
+		writeSummary(fs, "2020-03-15", runSummary{Start: day(15)})
+		writeSummary(fs, "2020-03-02", runSummary{Start: day(2), Options: options{Recent: 20}})
+		writeSummary(fs, "2020-03-29", runSummary{Start: day(29), Options: options{Recent: 20}})
+		writeSummary(fs, "2020-03-22", runSummary{Start: day(22), Errors: []string{"export chats: this is a DB error"}})
+		writeSummary(fs, "2020-03-23", runSummary{Start: day(23), RemainingChats: 4})
+		afero.WriteFile(fs, "backups/2020-03-09/"+_resumeFilename, []byte("{}"), 0644)
+		fs.Chtimes("backups/2020-03-09/"+_resumeFilename, day(9), day(9))
+		afero.WriteFile(fs, "backups/notes/notes.txt", nil, 0644)
+		afero.WriteFile(fs, "backups/README.txt", nil, 0644)
+	}
+
+	tests := []struct {
+		msg      string
+		opts     pruneOptions
+		setupFs  func(afero.Fs)
+		wantLeft []string
+		wantErr  string
+	}{
+		{
+			msg:      "keep full exports",
+			opts:     pruneOptions{Dir: "backups", Keep: 2},
+			setupFs:  setupExports,
+			wantLeft: []string{"2020-03-02", "2020-03-08", "2020-03-09", "2020-03-15", "2020-03-22", "2020-03-23", "2020-03-29", "README.txt", "notes"},
+		},
+		{
+			msg:      "max age of partial exports",
+			opts:     pruneOptions{Dir: "backups", MaxAge: 14},
+			setupFs:  setupExports,
+			wantLeft: []string{"2020-03-01", "2020-03-08", "2020-03-15", "2020-03-22", "2020-03-23", "2020-03-29", "README.txt", "notes"},
+		},
+		{
+			msg:      "both",
+			opts:     pruneOptions{Dir: "backups", Keep: 1, MaxAge: 7},
+			setupFs:  setupExports,
+			wantLeft: []string{"2020-03-15", "2020-03-29", "README.txt", "notes"},
+		},
+		{
+			msg:      "dry run",
+			opts:     pruneOptions{Dir: "backups", Keep: 1, MaxAge: 7, DryRun: true},
+			setupFs:  setupExports,
+			wantLeft: []string{"2020-03-01", "2020-03-02", "2020-03-08", "2020-03-09", "2020-03-15", "2020-03-22", "2020-03-23", "2020-03-29", "README.txt", "notes"},
+		},
+		{
+			msg:  "filtered export",
+			opts: pruneOptions{Dir: "backups", Keep: 1},
+			setupFs: func(fs afero.Fs) {
+				writeSummary(fs, "2020-03-01", runSummary{Start: day(1)})
+				writeSummary(fs, "2020-03-08", runSummary{Start: day(8)})
+				writeSummary(fs, "2020-03-15", runSummary{Start: day(15), Options: options{Match: "(?i)invoice"}})
+			},
+			wantLeft: []string{"2020-03-08", "2020-03-15"},
+		},
+		{
+			msg:     "no retention",
+			opts:    pruneOptions{Dir: "backups"},
+			setupFs: setupExports,
+			wantErr: "nothing to prune - FIX: pass --keep",
+		},
+		{
+			msg:     "no folder",
+			opts:    pruneOptions{Dir: "backups", Keep: 1},
+			setupFs: func(afero.Fs) {},
+			wantErr: `read directory "backups"`,
+		},
+		{
+			msg:  "bad run summary",
+			opts: pruneOptions{Dir: "backups", Keep: 1},
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backups/2020-03-01/"+_runSummaryFilename, []byte("{"), 0644)
+			},
+			wantErr: `decode file "backups/2020-03-01/run-summary.json" - FIX: move the folder out of "backups" if it is not an export folder`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			tt.setupFs(fs)
+			s := opsys.NewOS(fs, nil, nil)
+
+			err := prune(tt.opts, s, now)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			infos, err := afero.ReadDir(fs, "backups")
+			assert.NilError(t, err)
+			var left []string
+			for _, info := range infos {
+				left = append(left, info.Name())
+			}
+			sort.Strings(left)
+			assert.DeepEqual(t, tt.wantLeft, left)
+		})
+	}
+}
+
+func TestPruneRemoveError(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NilError(t, afero.WriteFile(fs, "backups/2020-03-01/"+_runSummaryFilename, []byte(`{"start":"2020-03-01T02:00:00Z","options":{"Recent":20}}`), 0644))
+	s := removeErrOS{OS: opsys.NewOS(fs, nil, nil)}
+	err := prune(pruneOptions{Dir: "backups", MaxAge: 1}, s, time.Date(2020, time.March, 31, 0, 0, 0, 0, time.UTC))
+	assert.Error(t, err, `remove export folder "backups/2020-03-01": this is a file system error`)
+}
+
+// removeErrOS is an OS which fails to remove files.
+type removeErrOS struct {
+	opsys.OS
+}
+
+func (removeErrOS) RemoveAll(string) error {
+	return errors.New("this is a file system error")
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// recentChats selects the given number of the given chats whose last
+// messages are the most recent, by ID. It returns nil to select all chats if
+// the number is 0. Chats keep their order, so that their folders are named as
+// in full exports.
+func recentChats(chats []chatdb.Chat, n int) (map[int]bool, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("--recent %d is negative - FIX: pass the number of chats to export, or 0 to export all chats", n)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	byRecency := append([]chatdb.Chat(nil), chats...)
+	sort.SliceStable(byRecency, func(i, j int) bool {
+		return byRecency[i].LastMessageDate.After(byRecency[j].LastMessageDate)
+	})
+	if n > len(byRecency) {
+		n = len(byRecency)
+	}
+	recent := make(map[int]bool, n)
+	for _, chat := range byRecency[:n] {
+		recent[chat.ID] = true
+	}
+	return recent, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestRecentChats(t *testing.T) {
+	start := time.Date(2020, time.March, 1, 15, 34, 5, 0, time.UTC)
+	chats := []chatdb.Chat{
+		{ID: 1, MessageCount: 10, LastMessageDate: start},
+		{ID: 2, MessageCount: 10, LastMessageDate: start.Add(48 * time.Hour)},
+		{ID: 3, MessageCount: 10, LastMessageDate: start.Add(24 * time.Hour)},
+		{ID: 4},
+	}
+
+	tests := []struct {
+		msg     string
+		n       int
+		want    map[int]bool
+		wantErr string
+	}{
+		{
+			msg: "all chats",
+		},
+		{
+			msg:  "most recent",
+			n:    2,
+			want: map[int]bool{2: true, 3: true},
+		},
+		{
+			msg:  "more than the chats",
+			n:    10,
+			want: map[int]bool{1: true, 2: true, 3: true, 4: true},
+		},
+		{
+			msg:     "negative",
+			n:       -1,
+			wantErr: "--recent -1 is negative - FIX: pass the number of chats to export, or 0 to export all chats",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			recent, err := recentChats(chats, tt.n)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.want, recent)
+		})
+	}
+}
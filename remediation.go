@@ -0,0 +1,36 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+const _issuesURL = "https://github.com/tagatac/bagoup/issues"
+
+// withRemediation adds a fix to errors caused by problems with the Messages
+// database, unless they already suggest one.
+func withRemediation(err error) error {
+	if err == nil || strings.Contains(err.Error(), "FIX:") {
+		return err
+	}
+	var fix string
+	switch {
+	case errors.Is(err, chatdb.ErrPermission):
+		fix = fmt.Sprintf("give your terminal Full Disk Access or copy chat.db to a readable location as described at %s", _readmeURL)
+	case errors.Is(err, chatdb.ErrBusy):
+		fix = fmt.Sprintf("quit Messages, increase --busy-timeout, or copy chat.db while Messages is closed and export the copy as described at %s", _readmeURL)
+	case errors.Is(err, chatdb.ErrSchemaMismatch):
+		fix = fmt.Sprintf("if chat.db was copied from another Mac, pass its version of Mac OS with the --mac-os-version option; otherwise open an issue at %s", _issuesURL)
+	case errors.Is(err, chatdb.ErrRowCorrupt):
+		fix = fmt.Sprintf("copy chat.db again while Messages is closed, in case it was copied while being written; if the problem persists, open an issue at %s", _issuesURL)
+	default:
+		return err
+	}
+	return fmt.Errorf("%w - FIX: %s", err, fix)
+}
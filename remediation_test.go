@@ -0,0 +1,65 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+type causedError struct{ cause error }
+
+func (e causedError) Error() string        { return "this is a DB error" }
+func (e causedError) Is(target error) bool { return target == e.cause }
+
+func TestWithRemediation(t *testing.T) {
+	tests := []struct {
+		msg     string
+		err     error
+		wantErr string
+	}{
+		{
+			msg:     "permission",
+			err:     errors.Wrap(causedError{chatdb.ErrPermission}, "get handle map"),
+			wantErr: "get handle map: this is a DB error - FIX: give your terminal Full Disk Access or copy chat.db to a readable location as described at " + _readmeURL,
+		},
+		{
+			msg:     "busy",
+			err:     causedError{chatdb.ErrBusy},
+			wantErr: "this is a DB error - FIX: quit Messages, increase --busy-timeout, or copy chat.db while Messages is closed and export the copy as described at " + _readmeURL,
+		},
+		{
+			msg:     "schema mismatch",
+			err:     causedError{chatdb.ErrSchemaMismatch},
+			wantErr: "this is a DB error - FIX: if chat.db was copied from another Mac, pass its version of Mac OS with the --mac-os-version option; otherwise open an issue at https://github.com/tagatac/bagoup/issues",
+		},
+		{
+			msg:     "corrupt row",
+			err:     causedError{chatdb.ErrRowCorrupt},
+			wantErr: "this is a DB error - FIX: copy chat.db again while Messages is closed, in case it was copied while being written; if the problem persists, open an issue at https://github.com/tagatac/bagoup/issues",
+		},
+		{
+			msg:     "fix already suggested",
+			err:     errors.Wrap(causedError{chatdb.ErrPermission}, "test DB file - FIX: see the README"),
+			wantErr: "test DB file - FIX: see the README: this is a DB error",
+		},
+		{
+			msg:     "other error",
+			err:     errors.New("this is an OS error"),
+			wantErr: "this is an OS error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			err := withRemediation(tt.err)
+			assert.Error(t, err, tt.wantErr)
+			assert.Assert(t, errors.Is(err, tt.err), "original error not wrapped")
+		})
+	}
+	assert.NilError(t, withRemediation(nil))
+}
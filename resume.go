@@ -0,0 +1,126 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _resumeFilename is the name of the file in the export folder which records
+// the chats which have been completely exported.
+const _resumeFilename = ".bagoup-resume.json"
+
+type (
+	// resumeManifest records the chats which have been completely exported,
+	// indexed by GUID, so that an interrupted export can be resumed.
+	resumeManifest struct {
+		path  string
+		Chats map[string]exportedChat `json:"chats"`
+		// mu guards Chats, since chats are recorded in the background once
+		// their attachments are copied.
+		mu sync.Mutex
+	}
+
+	// exportedChat describes the exported file of a chat, so that it can be
+	// checked before the chat is skipped when resuming.
+	exportedChat struct {
+		Path   string `json:"path"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}
+)
+
+// newResumeManifest returns an empty resume manifest for the given export
+// folder.
+func newResumeManifest(exportPath string) *resumeManifest {
+	return &resumeManifest{
+		path:  path.Join(exportPath, _resumeFilename),
+		Chats: map[string]exportedChat{},
+	}
+}
+
+// loadResumeManifest reads the resume manifest from the given export folder,
+// or returns an empty manifest if there is none.
+func loadResumeManifest(s opsys.OS, exportPath string) (*resumeManifest, error) {
+	m := newResumeManifest(exportPath)
+	f, err := s.Open(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", m.path)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, errors.Wrapf(err, "decode file %q", m.path)
+	}
+	return m, nil
+}
+
+// done checks if the chat with the given GUID was completely exported, and its
+// exported file is unchanged.
+func (m *resumeManifest) done(s opsys.OS, guid string) (bool, error) {
+	m.mu.Lock()
+	chat, ok := m.Chats[guid]
+	m.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	size, sum, err := checksumFile(s, chat.Path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return size == chat.Size && sum == chat.SHA256, nil
+}
+
+// record records the chat with the given GUID as completely exported to the
+// file at the given path, and writes the manifest. Chats exported to folders
+// rather than files are not recorded. It is safe for concurrent use.
+func (m *resumeManifest) record(s opsys.OS, guid, filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := s.Stat(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "stat file %q", filePath)
+	}
+	if info.IsDir() {
+		return nil
+	}
+	size, sum, err := checksumFile(s, filePath)
+	if err != nil {
+		return err
+	}
+	m.Chats[guid] = exportedChat{Path: filePath, Size: size, SHA256: sum}
+	return exporter.WriteFile(s, m.path, writeJSON(m))
+}
+
+func checksumFile(s opsys.OS, filePath string) (int64, string, error) {
+	f, err := s.Open(filePath)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "open file %q", filePath)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "read file %q", filePath)
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
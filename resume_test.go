@@ -0,0 +1,115 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestLoadResumeManifest(t *testing.T) {
+	tests := []struct {
+		msg       string
+		manifest  string
+		wantChats map[string]exportedChat
+		wantErr   string
+	}{
+		{
+			msg:       "no manifest",
+			wantChats: map[string]exportedChat{},
+		},
+		{
+			msg:      "manifest",
+			manifest: `{"chats": {"testguid": {"path": "backup/Novak/testguid.txt", "size": 11, "sha256": "abc123"}}}`,
+			wantChats: map[string]exportedChat{
+				"testguid": {Path: "backup/Novak/testguid.txt", Size: 11, SHA256: "abc123"},
+			},
+		},
+		{
+			msg:      "invalid manifest",
+			manifest: `{"chats": `,
+			wantErr:  `decode file "backup/.bagoup-resume.json"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.manifest != "" {
+				afero.WriteFile(fs, "backup/.bagoup-resume.json", []byte(tt.manifest), 0644)
+			}
+			m, err := loadResumeManifest(opsys.NewOS(fs, nil, nil), "backup")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantChats, m.Chats)
+		})
+	}
+}
+
+func TestResumeManifest(t *testing.T) {
+	tests := []struct {
+		msg      string
+		setupFs  func(afero.Fs)
+		wantDone bool
+	}{
+		{
+			msg: "unchanged",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("message100\n"), 0644)
+			},
+			wantDone: true,
+		},
+		{
+			msg: "changed",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("message200\n"), 0644)
+			},
+		},
+		{
+			msg:     "deleted",
+			setupFs: func(fs afero.Fs) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			s := opsys.NewOS(fs, nil, nil)
+			afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("message100\n"), 0644)
+			m := newResumeManifest("backup")
+			assert.NilError(t, m.record(s, "testguid", "backup/Novak/testguid.txt"))
+			assert.NilError(t, fs.Remove("backup/Novak/testguid.txt"))
+			tt.setupFs(fs)
+
+			m, err := loadResumeManifest(s, "backup")
+			assert.NilError(t, err)
+			done, err := m.done(s, "testguid")
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantDone, done)
+			done, err = m.done(s, "testguid2")
+			assert.NilError(t, err)
+			assert.Assert(t, !done, "unrecorded chat done")
+		})
+	}
+}
+
+func TestResumeManifestRecordFolder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	assert.NilError(t, fs.MkdirAll("backup/novak", os.ModePerm))
+	m := newResumeManifest("backup")
+	assert.NilError(t, m.record(s, "testguid", "backup/novak"))
+	assert.NilError(t, m.record(s, "testguid", ""))
+	assert.Equal(t, 0, len(m.Chats))
+	exist, err := afero.Exists(fs, "backup/.bagoup-resume.json")
+	assert.NilError(t, err)
+	assert.Assert(t, !exist, "manifest written for folder")
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const (
+	_groupChats  = "group"
+	_directChats = "direct"
+	// _vcardLabel is the label which is replaced with the full name on the
+	// vCard given with --self-vcard.
+	_vcardLabel = "@vcard"
+)
+
+// selfLabels are the labels of the messages sent by you, which may differ by
+// export format and by whether chats are group chats, e.g. "Me" in one-to-one
+// chats but your name in group chats.
+type selfLabels struct {
+	format string
+	labels map[string]string
+	// fallback is the label used where no other label is configured.
+	fallback string
+}
+
+// newSelfLabels returns the self labels of the --self-handle, --self-handle-for,
+// and --self-vcard options, for the export format of the options. Labels are
+// configured for group or direct chats, for a format, or for both, e.g.
+// "slack.group".
+func newSelfLabels(opts options, s opsys.OS) (selfLabels, error) {
+	var vcardName string
+	if opts.SelfVCard != "" {
+		var err error
+		if vcardName, err = getVCardName(opts, s); err != nil {
+			return selfLabels{}, err
+		}
+	}
+	resolve := func(label string) (string, error) {
+		if label != _vcardLabel {
+			return label, nil
+		}
+		if vcardName == "" {
+			return "", errors.Errorf("self label %q needs your name - FIX: use --self-vcard to give the vCard with your name", _vcardLabel)
+		}
+		return vcardName, nil
+	}
+	fallback, err := resolve(opts.SelfHandle)
+	if err != nil {
+		return selfLabels{}, err
+	}
+	l := selfLabels{format: opts.Format, labels: map[string]string{}, fallback: fallback}
+	for _, value := range opts.SelfHandles {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return selfLabels{}, errors.Errorf("invalid --self-handle-for value %q - FIX: give a chat type or format and a prefix separated by a colon, e.g. 'group:David'", value)
+		}
+		key, label := parts[0], parts[1]
+		if !validSelfLabelKey(key) {
+			return selfLabels{}, errors.Errorf("invalid --self-handle-for chat type or format %q - FIX: use %s, %s, one of %v, or a format and a chat type separated by a dot, e.g. 'slack.group'", key, _groupChats, _directChats, exporter.Formats())
+		}
+		if l.labels[key], err = resolve(label); err != nil {
+			return selfLabels{}, err
+		}
+	}
+	return l, nil
+}
+
+// getVCardName returns the full name on the vCard at the --self-vcard path.
+func getVCardName(opts options, s opsys.OS) (string, error) {
+	cards, err := s.GetContactMap(opts.SelfVCard)
+	if err != nil {
+		return "", errors.Wrapf(err, "get contacts from vcard file %q", opts.SelfVCard)
+	}
+	keys := make([]string, 0, len(cards))
+	for key := range cards {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	format := chatdb.NameFormat{Order: chatdb.NameOrder(opts.NameOrder), Honorifics: opts.Honorifics}
+	for _, key := range keys {
+		if name := format.FullName(cards[key]); name != "" {
+			return name, nil
+		}
+	}
+	return "", errors.Errorf("no name with a phone number or email address in vcard file %q - FIX: export your own card from Contacts", opts.SelfVCard)
+}
+
+// validSelfLabelKey checks if the given key of --self-handle-for is a chat
+// type, a format, or a format and a chat type.
+func validSelfLabelKey(key string) bool {
+	format, kind := key, ""
+	if i := strings.Index(key, "."); i >= 0 {
+		format, kind = key[:i], key[i+1:]
+		if kind != _groupChats && kind != _directChats {
+			return false
+		}
+	} else if key == _groupChats || key == _directChats {
+		return true
+	}
+	for _, f := range exporter.Formats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns the label of messages sent by you in group chats, or in
+// one-to-one chats. Labels for the format and chat type take precedence over
+// labels for the chat type, which take precedence over labels for the format.
+func (l selfLabels) label(group bool) string {
+	kind := _directChats
+	if group {
+		kind = _groupChats
+	}
+	for _, key := range []string{l.format + "." + kind, kind, l.format} {
+		if label, ok := l.labels[key]; ok {
+			return label
+		}
+	}
+	return l.fallback
+}
+
+// relabel replaces the label of the given message, and of the message which it
+// replied to, where they were sent by you.
+func relabel(msg chatdb.Message, label string) chatdb.Message {
+	if msg.FromMe {
+		msg.Handle = label
+	}
+	if msg.ReplyTo != nil && msg.ReplyTo.FromMe {
+		reply := *msg.ReplyTo
+		reply.Handle = label
+		msg.ReplyTo = &reply
+	}
+	return msg
+}
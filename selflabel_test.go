@@ -0,0 +1,164 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/golang/mock/gomock"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestSelfLabels(t *testing.T) {
+	card := &vcard.Card{
+		"FN": []*vcard.Field{{Value: "David Tagatac"}},
+		"N":  []*vcard.Field{{Value: "Tagatac;David;;;"}},
+	}
+
+	tests := []struct {
+		msg        string
+		opts       options
+		setupMock  func(*mock_opsys.MockOS)
+		wantGroup  string
+		wantDirect string
+		wantErr    string
+	}{
+		{
+			msg:        "self handle only",
+			opts:       options{Format: "txt", SelfHandle: "Me"},
+			wantGroup:  "Me",
+			wantDirect: "Me",
+		},
+		{
+			msg:        "group chats",
+			opts:       options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"group:David"}},
+			wantGroup:  "David",
+			wantDirect: "Me",
+		},
+		{
+			msg:        "format",
+			opts:       options{Format: "slack", SelfHandle: "Me", SelfHandles: []string{"slack:david", "direct:Me too"}},
+			wantGroup:  "david",
+			wantDirect: "Me too",
+		},
+		{
+			msg:        "format and chat type",
+			opts:       options{Format: "slack", SelfHandle: "Me", SelfHandles: []string{"slack.group:david", "group:David", "txt.direct:I"}},
+			wantGroup:  "david",
+			wantDirect: "Me",
+		},
+		{
+			msg:  "vcard",
+			opts: options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"group:@vcard"}, SelfVCard: "me.vcf"},
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().GetContactMap("me.vcf").Return(map[string]*vcard.Card{"+14155555555": card, "david@example.com": card}, nil)
+			},
+			wantGroup:  "David Tagatac",
+			wantDirect: "Me",
+		},
+		{
+			msg:  "vcard self handle",
+			opts: options{Format: "txt", SelfHandle: "@vcard", SelfVCard: "me.vcf"},
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().GetContactMap("me.vcf").Return(map[string]*vcard.Card{"+14155555555": card}, nil)
+			},
+			wantGroup:  "David Tagatac",
+			wantDirect: "David Tagatac",
+		},
+		{
+			msg:     "vcard without file",
+			opts:    options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"group:@vcard"}},
+			wantErr: `self label "@vcard" needs your name - FIX: use --self-vcard to give the vCard with your name`,
+		},
+		{
+			msg:  "vcard error",
+			opts: options{Format: "txt", SelfHandle: "Me", SelfVCard: "me.vcf"},
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().GetContactMap("me.vcf").Return(nil, errors.New("this is an os error"))
+			},
+			wantErr: `get contacts from vcard file "me.vcf": this is an os error`,
+		},
+		{
+			msg:  "empty vcard",
+			opts: options{Format: "txt", SelfHandle: "Me", SelfVCard: "me.vcf"},
+			setupMock: func(osMock *mock_opsys.MockOS) {
+				osMock.EXPECT().GetContactMap("me.vcf").Return(map[string]*vcard.Card{}, nil)
+			},
+			wantErr: `no name with a phone number or email address in vcard file "me.vcf" - FIX: export your own card from Contacts`,
+		},
+		{
+			msg:     "missing prefix",
+			opts:    options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"group"}},
+			wantErr: `invalid --self-handle-for value "group" - FIX: give a chat type or format and a prefix separated by a colon, e.g. 'group:David'`,
+		},
+		{
+			msg:     "unknown chat type",
+			opts:    options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"txt.channel:David"}},
+			wantErr: `invalid --self-handle-for chat type or format "txt.channel"`,
+		},
+		{
+			msg:     "unknown format",
+			opts:    options{Format: "txt", SelfHandle: "Me", SelfHandles: []string{"pdf:David"}},
+			wantErr: `invalid --self-handle-for chat type or format "pdf"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			if tt.setupMock != nil {
+				tt.setupMock(osMock)
+			}
+			l, err := newSelfLabels(tt.opts, osMock)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantGroup, l.label(true))
+			assert.Equal(t, tt.wantDirect, l.label(false))
+		})
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	tests := []struct {
+		msg  string
+		in   chatdb.Message
+		want chatdb.Message
+	}{
+		{
+			msg:  "from me",
+			in:   chatdb.Message{Handle: "Me", FromMe: true, Text: "Want to play tennis?"},
+			want: chatdb.Message{Handle: "David", FromMe: true, Text: "Want to play tennis?"},
+		},
+		{
+			msg:  "from others",
+			in:   chatdb.Message{Handle: "Novak", Text: "Sure"},
+			want: chatdb.Message{Handle: "Novak", Text: "Sure"},
+		},
+		{
+			msg:  "reply to me",
+			in:   chatdb.Message{Handle: "Novak", Text: "Sure", ReplyTo: &chatdb.Reply{ID: 1, Handle: "Me", FromMe: true, Text: "Want to play tennis?"}},
+			want: chatdb.Message{Handle: "Novak", Text: "Sure", ReplyTo: &chatdb.Reply{ID: 1, Handle: "David", FromMe: true, Text: "Want to play tennis?"}},
+		},
+		{
+			msg:  "reply to others",
+			in:   chatdb.Message{Handle: "Me", FromMe: true, Text: "Great", ReplyTo: &chatdb.Reply{ID: 2, Handle: "Novak", Text: "Sure"}},
+			want: chatdb.Message{Handle: "David", FromMe: true, Text: "Great", ReplyTo: &chatdb.Reply{ID: 2, Handle: "Novak", Text: "Sure"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, relabel(tt.in, "David"))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/assets"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+	"github.com/tagatac/bagoup/server"
+)
+
+type serveOptions struct {
+	Addr        string `long:"addr" description:"Address on which to serve the viewer" default:"localhost:8080"`
+	FromDB      bool   `long:"from-db" description:"Browse the chats in the chat database, opened read-only, instead of those in the export folder"`
+	RegionHints bool   `long:"region-hints" description:"Show the country or region of the phone number of each chat with a handle without a contact, e.g. to identify old international contacts"`
+}
+
+// serve serves a viewer for browsing the chats in the export folder, or in the
+// chat database, until it fails.
+func serve(opts options, serveOpts serveOptions, s opsys.OS, cdb chatdb.ChatDB) error {
+	handler, err := newViewer(opts, serveOpts, s, cdb)
+	if err != nil {
+		return err
+	}
+	logging.Infof("Serving the viewer at http://%s", serveOpts.Addr)
+	return errors.Wrapf(http.ListenAndServe(serveOpts.Addr, handler), "serve on %q", serveOpts.Addr)
+}
+
+func newViewer(opts options, serveOpts serveOptions, s opsys.OS, cdb chatdb.ChatDB) (http.Handler, error) {
+	page, err := assets.New(s, opts.AssetsDir).HTMLTemplate("serve.html")
+	if err != nil {
+		return nil, errors.Wrap(err, "load viewer template")
+	}
+	if !serveOpts.FromDB {
+		exist, err := s.FileExist(opts.ExportPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "check export path %q", opts.ExportPath)
+		}
+		if !exist {
+			return nil, fmt.Errorf("export folder %q does not exist - FIX: specify the export path with the --export-path option, or browse the chat database with the --from-db option", opts.ExportPath)
+		}
+		return newCollatedViewer(opts, serveOpts, server.NewExportSource(s, opts.ExportPath), page)
+	}
+	macOSVersion, err := getMacOSVersion(opts, s)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := getContacts(opts, s)
+	if err != nil {
+		return nil, err
+	}
+	src, err := server.NewDBSource(s, cdb, contacts, macOSVersion)
+	if err != nil {
+		return nil, errors.Wrap(err, "read chat database")
+	}
+	return newCollatedViewer(opts, serveOpts, src, page)
+}
+
+// newCollatedViewer returns a viewer for the given source, listing its chats
+// in the alphabetical order of the language given by the options, if any, and
+// with the regions of their phone numbers if requested.
+func newCollatedViewer(opts options, serveOpts serveOptions, src server.Source, page *template.Template) (http.Handler, error) {
+	if serveOpts.RegionHints {
+		src = server.NewRegionSource(src)
+	}
+	if opts.Collate != "" {
+		var err error
+		if src, err = server.NewCollatedSource(src, opts.Collate); err != nil {
+			return nil, errors.Wrap(err, "parse collation language")
+		}
+	}
+	return server.New(src, page), nil
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewViewer(t *testing.T) {
+	tests := []struct {
+		msg        string
+		collate    string
+		serveOpts  serveOptions
+		setupFs    func(afero.Fs)
+		setupMocks func(*mock_chatdb.MockChatDB)
+		wantChats  string
+		wantErr    string
+	}{
+		{
+			msg: "export folder",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("[2020-03-01 15:34:05] Novak: message100\n"), 0644)
+			},
+			wantChats: `[{"id":"Novak/testguid.txt","name":"Novak"}]`,
+		},
+		{
+			msg:     "collated export folder",
+			collate: "en",
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/Novak/testguid.txt", []byte("[2020-03-01 15:34:05] Novak: message100\n"), 0644)
+				afero.WriteFile(fs, "backup/émile/testguid2.txt", []byte("[2020-03-01 15:34:05] émile: message200\n"), 0644)
+			},
+			wantChats: `[{"id":"émile/testguid2.txt","name":"émile","initial":"E"},{"id":"Novak/testguid.txt","name":"Novak","initial":"N"}]`,
+		},
+		{
+			msg:       "region hints",
+			serveOpts: serveOptions{RegionHints: true},
+			setupFs: func(fs afero.Fs) {
+				afero.WriteFile(fs, "backup/+381111111111/testguid.txt", []byte("[2020-03-01 15:34:05] +381111111111: message100\n"), 0644)
+			},
+			wantChats: `[{"id":"+381111111111/testguid.txt","name":"+381111111111","region":"🇷🇸 Serbia"}]`,
+		},
+		{
+			msg:     "bad collation language",
+			collate: "not a language",
+			setupFs: func(fs afero.Fs) {
+				fs.MkdirAll("backup", 0755)
+			},
+			wantErr: `parse collation language: unknown language "not a language"`,
+		},
+		{
+			msg:     "missing export folder",
+			wantErr: `export folder "backup" does not exist`,
+		},
+		{
+			msg:       "chat database",
+			serveOpts: serveOptions{FromDB: true},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "Novak"}}, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+				)
+			},
+			wantChats: `[{"id":"1","name":"Novak"}]`,
+		},
+		{
+			msg:       "chat database error",
+			serveOpts: serveOptions{FromDB: true},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: "read chat database: get handle map: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(dbMock)
+			}
+			fs := afero.NewMemMapFs()
+			if tt.setupFs != nil {
+				tt.setupFs(fs)
+			}
+			opts := options{
+				DBPath:     "chat.db",
+				ExportPath: "backup",
+				Collate:    tt.collate,
+			}
+			h, err := newViewer(opts, tt.serveOpts, opsys.NewOS(fs, fs.Stat, nil), dbMock)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Assert(t, strings.Contains(w.Body.String(), "<title>bagoup</title>"), w.Body.String())
+
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/chats", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantChats+"\n", w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"sort"
+
+	"github.com/tagatac/bagoup/collation"
+)
+
+type collatedSource struct {
+	Source
+	locale string
+}
+
+// NewCollatedSource returns a Source which lists the chats of the given source
+// by name in the alphabetical order of the language with the given tag, e.g.
+// "en" or "sv", with the initials under which the viewer groups them.
+func NewCollatedSource(src Source, locale string) (Source, error) {
+	if _, err := collation.New(locale); err != nil {
+		return nil, err
+	}
+	return collatedSource{Source: src, locale: locale}, nil
+}
+
+func (c collatedSource) Chats() ([]Chat, error) {
+	chats, err := c.Source.Chats()
+	if err != nil {
+		return nil, err
+	}
+	// Collators are not safe for concurrent use, so each request gets its
+	// own.
+	collator, err := collation.New(c.locale)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]Chat, len(chats))
+	for i, chat := range chats {
+		chat.Initial = collator.Initial(chat.Name)
+		sorted[i] = chat
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return collator.Compare(sorted[i].Name, sorted[j].Name) < 0 })
+	return sorted, nil
+}
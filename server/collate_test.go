@@ -0,0 +1,60 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestCollatedSourceChats(t *testing.T) {
+	fs := testExportFs(t)
+	assert.NilError(t, afero.WriteFile(fs, "backup/émile/iMessage;-;emile@example.com.txt", []byte("[2020-03-02 10:00:00] émile: Salut\n"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Åsa/iMessage;-;asa@example.com.txt", []byte("[2020-03-02 10:00:00] Åsa: Hej\n"), 0644))
+
+	tests := []struct {
+		locale    string
+		wantChats []Chat
+		wantErr   string
+	}{
+		{
+			locale: "en",
+			wantChats: []Chat{
+				{ID: "Åsa/iMessage;-;asa@example.com.txt", Name: "Åsa", Initial: "A"},
+				{ID: "émile/iMessage;-;emile@example.com.txt", Name: "émile", Initial: "E"},
+				{ID: "Jelena/iMessage;-;jelena@example.com.txt", Name: "Jelena", Initial: "J"},
+				{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak", Initial: "N"},
+			},
+		},
+		{
+			locale: "sv",
+			wantChats: []Chat{
+				{ID: "émile/iMessage;-;emile@example.com.txt", Name: "émile", Initial: "E"},
+				{ID: "Jelena/iMessage;-;jelena@example.com.txt", Name: "Jelena", Initial: "J"},
+				{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak", Initial: "N"},
+				{ID: "Åsa/iMessage;-;asa@example.com.txt", Name: "Åsa", Initial: "Å"},
+			},
+		},
+		{
+			locale:  "not a language",
+			wantErr: `unknown language "not a language"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			src, err := NewCollatedSource(NewExportSource(fs, "backup"), tt.locale)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			chats, err := src.Chats()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantChats, chats)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const (
+	_datetimeLayout = "2006-01-02 15:04:05"
+	// _searchPageSize is the number of messages read from the database at a
+	// time when searching.
+	_searchPageSize = 1000
+)
+
+type dbSource struct {
+	s            opsys.OS
+	cdb          chatdb.ChatDB
+	macOSVersion *semver.Version
+	handleMap    map[int]string
+	chats        []Chat
+	// attachments maps message IDs to their attachments.
+	attachments map[int][]chatdb.Attachment
+	// attachmentPaths maps attachment names to the paths of the attachments.
+	attachmentPaths map[string]string
+}
+
+// NewDBSource returns a Source which reads the chats from the given Messages
+// database. Handles are resolved to names using the given contact resolver,
+// if any, and dates are decoded as for the given release of Mac OS, if any.
+func NewDBSource(s opsys.OS, cdb chatdb.ChatDB, contacts chatdb.ContactResolver, macOSVersion *semver.Version) (Source, error) {
+	handleMap, err := cdb.GetHandleMap(contacts)
+	if err != nil {
+		return nil, errors.Wrap(err, "get handle map")
+	}
+	dbChats, err := cdb.GetChats(contacts)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chats")
+	}
+	chats := make([]Chat, len(dbChats))
+	for i, chat := range dbChats {
+		chats[i] = Chat{ID: strconv.Itoa(chat.ID), Name: chat.DisplayName, Pinned: chat.Pinned, Archived: chat.Archived}
+	}
+	attachments, err := cdb.GetAttachmentPaths()
+	if err != nil {
+		return nil, errors.Wrap(err, "get attachment paths")
+	}
+	attachmentPaths := map[string]string{}
+	for _, atts := range attachments {
+		for _, att := range atts {
+			if att.Filename != "" {
+				attachmentPaths[attachmentName(att)] = att.Filename
+			}
+		}
+	}
+	return &dbSource{
+		s:               s,
+		cdb:             cdb,
+		macOSVersion:    macOSVersion,
+		handleMap:       handleMap,
+		chats:           chats,
+		attachments:     attachments,
+		attachmentPaths: attachmentPaths,
+	}, nil
+}
+
+func (d *dbSource) Chats() ([]Chat, error) {
+	return d.chats, nil
+}
+
+func (d *dbSource) Messages(chatID, pageToken string, limit int) ([]Message, string, error) {
+	id, err := strconv.Atoi(chatID)
+	if err != nil {
+		return nil, "", errors.Errorf("invalid chat ID %q", chatID)
+	}
+	dbMsgs, nextPage, err := d.cdb.GetMessagesPage(id, pageToken, limit, d.handleMap, d.macOSVersion)
+	if err != nil {
+		return nil, "", err
+	}
+	msgs := make([]Message, len(dbMsgs))
+	for i, msg := range dbMsgs {
+		msgs[i] = d.convertMessage(msg)
+	}
+	return msgs, nextPage, nil
+}
+
+func (d *dbSource) Attachments(chatID string) ([]string, error) {
+	id, err := strconv.Atoi(chatID)
+	if err != nil {
+		return nil, errors.Errorf("invalid chat ID %q", chatID)
+	}
+	msgIDs, err := d.cdb.GetMessageIDs(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get message IDs for chat ID %d", id)
+	}
+	var names []string
+	for _, msgID := range msgIDs {
+		names = append(names, d.attachmentNames(msgID)...)
+	}
+	return names, nil
+}
+
+func (d *dbSource) Attachment(chatID, name string) (io.ReadSeekCloser, error) {
+	attPath, ok := d.attachmentPaths[name]
+	if !ok {
+		return nil, errors.Errorf("unknown attachment %q", name)
+	}
+	attPath, err := d.s.ExpandHome(attPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "expand attachment path %q", attPath)
+	}
+	f, err := d.s.Open(attPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", attPath)
+	}
+	return f, nil
+}
+
+func (d *dbSource) Search(query string, limit int) ([]ChatMessage, error) {
+	query = strings.ToLower(query)
+	var results []ChatMessage
+	for _, chat := range d.chats {
+		pageToken := ""
+		for {
+			msgs, nextPage, err := d.Messages(chat.ID, pageToken, _searchPageSize)
+			if err != nil {
+				return nil, errors.Wrapf(err, "get messages of chat %q", chat.Name)
+			}
+			for _, msg := range msgs {
+				if !strings.Contains(strings.ToLower(msg.Text), query) {
+					continue
+				}
+				results = append(results, ChatMessage{Chat: chat, Message: msg})
+				if len(results) == limit {
+					return results, nil
+				}
+			}
+			if nextPage == "" {
+				break
+			}
+			pageToken = nextPage
+		}
+	}
+	return results, nil
+}
+
+func (d *dbSource) convertMessage(msg chatdb.Message) Message {
+	date := msg.Date.Format(_datetimeLayout)
+	if msg.DateSource == chatdb.DateUnknown {
+		date = "date unknown"
+	}
+	text := strings.TrimSpace(strings.ReplaceAll(msg.Text, "\ufffc", ""))
+	if msg.Edited {
+		text += " (edited)"
+	}
+	if len(msg.Hints) > 0 {
+		text += fmt.Sprintf(" (%s)", strings.Join(msg.Hints, ", "))
+	}
+	if msg.ReplyTo != nil {
+		text = fmt.Sprintf("%s%s: %s\n%s", _replyPrefix, msg.ReplyTo.Handle, msg.ReplyTo.Text, text)
+	}
+	return Message{
+		Date:        date,
+		Sender:      msg.Handle,
+		Text:        text,
+		Attachments: d.attachmentNames(msg.ID),
+		Payment:     msg.Payment,
+	}
+}
+
+func (d *dbSource) attachmentNames(msgID int) []string {
+	var names []string
+	for _, att := range d.attachments[msgID] {
+		if att.Filename != "" {
+			names = append(names, attachmentName(att))
+		}
+	}
+	return names
+}
+
+// attachmentName names an attachment uniquely, keeping the extension of its
+// file so that its type can be determined from its name.
+func attachmentName(att chatdb.Attachment) string {
+	return fmt.Sprintf("%d-%s", att.ID, path.Base(att.Filename))
+}
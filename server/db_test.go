@@ -0,0 +1,159 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+var _testDate = time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local)
+
+func TestNewDBSource(t *testing.T) {
+	tests := []struct {
+		msg        string
+		setupMocks func(*mock_chatdb.MockChatDB)
+		wantErr    string
+	}{
+		{
+			msg: "success",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(map[int]string{10: "Novak"}, nil),
+					dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "Novak", Pinned: true}}, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+				)
+			},
+		},
+		{
+			msg: "handle map error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: "get handle map: this is a DB error",
+		},
+		{
+			msg: "chats error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetChats(nil).Return(nil, errors.New("this is a DB error")),
+				)
+			},
+			wantErr: "get chats: this is a DB error",
+		},
+		{
+			msg: "attachment paths error",
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil),
+					dbMock.EXPECT().GetChats(nil).Return(nil, nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, errors.New("this is a DB error")),
+				)
+			},
+			wantErr: "get attachment paths: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			tt.setupMocks(dbMock)
+			src, err := NewDBSource(opsys.NewOS(afero.NewMemMapFs(), nil, nil), dbMock, nil, nil)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			chats, err := src.Chats()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []Chat{{ID: "1", Name: "Novak", Pinned: true}}, chats)
+		})
+	}
+}
+
+func TestDBSource(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	handleMap := map[int]string{10: "Novak"}
+	dbMsgs := []chatdb.Message{
+		{ID: 100, Date: _testDate, Handle: "Novak", Text: "Want to play tennis?"},
+		{ID: 101, Date: _testDate, Handle: "Me", Text: "\ufffcLook at this", Edited: true, ReplyTo: &chatdb.Reply{ID: 100, Handle: "Novak", Text: "Want to play tennis?"}},
+		{ID: 102, DateSource: chatdb.DateUnknown, Handle: "Novak", Text: "Nice", Hints: []string{"sent with Digital Touch"}},
+	}
+	gomock.InOrder(
+		dbMock.EXPECT().GetHandleMap(nil).Return(handleMap, nil),
+		dbMock.EXPECT().GetChats(nil).Return([]chatdb.Chat{{ID: 1, GUID: "testguid", DisplayName: "Novak"}}, nil),
+		dbMock.EXPECT().GetAttachmentPaths().Return(map[int][]chatdb.Attachment{
+			101: {{ID: 7, Filename: "~/Library/Messages/Attachments/IMG_0001.jpeg"}},
+		}, nil),
+	)
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, nil, nil)
+	src, err := NewDBSource(s, dbMock, nil, nil)
+	assert.NilError(t, err)
+
+	wantMsgs := []Message{
+		{Date: "2020-03-01 15:34:05", Sender: "Novak", Text: "Want to play tennis?"},
+		{Date: "2020-03-01 15:34:05", Sender: "Me", Text: "> In reply to Novak: Want to play tennis?\nLook at this (edited)", Attachments: []string{"7-IMG_0001.jpeg"}},
+		{Date: "date unknown", Sender: "Novak", Text: "Nice (sent with Digital Touch)"},
+	}
+
+	t.Run("messages", func(t *testing.T) {
+		dbMock.EXPECT().GetMessagesPage(1, "", 2, handleMap, nil).Return(dbMsgs[:2], "1583076845-101", nil)
+		msgs, nextPage, err := src.Messages("1", "", 2)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, wantMsgs[:2], msgs)
+		assert.Equal(t, "1583076845-101", nextPage)
+
+		_, _, err = src.Messages("abc", "", 2)
+		assert.Error(t, err, `invalid chat ID "abc"`)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		gomock.InOrder(
+			dbMock.EXPECT().GetMessagesPage(1, "", 1000, handleMap, nil).Return(dbMsgs[:2], "1583076845-101", nil),
+			dbMock.EXPECT().GetMessagesPage(1, "1583076845-101", 1000, handleMap, nil).Return(dbMsgs[2:], "", nil),
+		)
+		results, err := src.Search("NICE", 10)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []ChatMessage{{Chat: Chat{ID: "1", Name: "Novak"}, Message: wantMsgs[2]}}, results)
+
+		dbMock.EXPECT().GetMessagesPage(1, "", 1000, handleMap, nil).Return(nil, "", errors.New("this is a DB error"))
+		_, err = src.Search("nice", 10)
+		assert.Error(t, err, `get messages of chat "Novak": this is a DB error`)
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		dbMock.EXPECT().GetMessageIDs(1).Return([]int{100, 101, 102}, nil)
+		names, err := src.Attachments("1")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"7-IMG_0001.jpeg"}, names)
+
+		attPath, err := s.ExpandHome("~/Library/Messages/Attachments/IMG_0001.jpeg")
+		assert.NilError(t, err)
+		assert.NilError(t, afero.WriteFile(fs, attPath, []byte("jpeg"), 0644))
+		f, err := src.Attachment("1", "7-IMG_0001.jpeg")
+		assert.NilError(t, err)
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		assert.NilError(t, err)
+		assert.Equal(t, "jpeg", string(b))
+
+		_, err = src.Attachment("1", "8-IMG_0002.jpeg")
+		assert.Error(t, err, `unknown attachment "8-IMG_0002.jpeg"`)
+	})
+}
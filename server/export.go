@@ -0,0 +1,209 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// _messageLineRE matches the first line of a message in a txt chat file, e.g.
+// "[2020-03-01 15:34:05] Novak: Want to play tennis?".
+var _messageLineRE = regexp.MustCompile(`^\[([^\]]*)\] ([^:]*): (.*)$`)
+
+const (
+	_replyPrefix  = "> In reply to "
+	_noticePrefix = "--- "
+	_noticeSuffix = " ---"
+)
+
+type exportSource struct {
+	fs         afero.Fs
+	exportPath string
+}
+
+// NewExportSource returns a Source which reads the chats exported in the txt
+// format to the given export folder.
+func NewExportSource(fs afero.Fs, exportPath string) Source {
+	return exportSource{fs: fs, exportPath: exportPath}
+}
+
+func (e exportSource) Chats() ([]Chat, error) {
+	chats := []Chat{}
+	err := afero.Walk(e.fs, e.exportPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == "attachments" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || path.Ext(p) != ".txt" {
+			return nil
+		}
+		id, err := filepath.Rel(e.exportPath, p)
+		if err != nil {
+			return err
+		}
+		id = filepath.ToSlash(id)
+		name := path.Dir(id)
+		if name == "." {
+			name = strings.TrimSuffix(id, ".txt")
+		}
+		chats = append(chats, Chat{ID: id, Name: name})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "walk export folder %q", e.exportPath)
+	}
+	sort.SliceStable(chats, func(i, j int) bool { return chats[i].Name < chats[j].Name })
+	return chats, nil
+}
+
+func (e exportSource) Messages(chatID, pageToken string, limit int) ([]Message, string, error) {
+	start := 0
+	if pageToken != "" {
+		var err error
+		if start, err = strconv.Atoi(pageToken); err != nil || start < 0 {
+			return nil, "", errors.Errorf("invalid page token %q", pageToken)
+		}
+	}
+	msgs, err := e.readChat(chatID)
+	if err != nil {
+		return nil, "", err
+	}
+	if start >= len(msgs) {
+		return nil, "", nil
+	}
+	end := start + limit
+	if end >= len(msgs) {
+		return msgs[start:], "", nil
+	}
+	return msgs[start:end], strconv.Itoa(end), nil
+}
+
+func (e exportSource) Attachments(chatID string) ([]string, error) {
+	dirPath, err := e.chatPath(chatID)
+	if err != nil {
+		return nil, err
+	}
+	dirPath = path.Join(path.Dir(dirPath), "attachments")
+	infos, err := afero.ReadDir(e.fs, dirPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read directory %q", dirPath)
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (e exportSource) Attachment(chatID, name string) (io.ReadSeekCloser, error) {
+	chatPath, err := e.chatPath(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, errors.Errorf("invalid attachment name %q", name)
+	}
+	attPath := path.Join(path.Dir(chatPath), "attachments", name)
+	f, err := e.fs.Open(attPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", attPath)
+	}
+	return f, nil
+}
+
+func (e exportSource) Search(query string, limit int) ([]ChatMessage, error) {
+	chats, err := e.Chats()
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(query)
+	var results []ChatMessage
+	for _, chat := range chats {
+		msgs, err := e.readChat(chat.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if !strings.Contains(strings.ToLower(msg.Text), query) {
+				continue
+			}
+			results = append(results, ChatMessage{Chat: chat, Message: msg})
+			if len(results) == limit {
+				return results, nil
+			}
+		}
+	}
+	return results, nil
+}
+
+// chatPath returns the path of the chat file with the given ID, which must be
+// a txt file inside the export folder.
+func (e exportSource) chatPath(chatID string) (string, error) {
+	clean := path.Clean("/" + chatID)[1:]
+	if clean != chatID || path.Ext(chatID) != ".txt" {
+		return "", errors.Errorf("invalid chat ID %q", chatID)
+	}
+	return path.Join(e.exportPath, chatID), nil
+}
+
+// readChat parses the messages of the chat file with the given ID. Lines which
+// do not start a message continue the text of the previous message, quoted
+// replies are added to the text of the message after them, and participant
+// markers become notices.
+func (e exportSource) readChat(chatID string) ([]Message, error) {
+	chatPath, err := e.chatPath(chatID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := e.fs.Open(chatPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file %q", chatPath)
+	}
+	defer f.Close()
+	var msgs []Message
+	var reply string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, _replyPrefix):
+			reply = line
+		case strings.HasPrefix(line, _noticePrefix) && strings.HasSuffix(line, _noticeSuffix):
+			msgs = append(msgs, Message{Text: strings.TrimSuffix(strings.TrimPrefix(line, _noticePrefix), _noticeSuffix)})
+		case _messageLineRE.MatchString(line):
+			m := _messageLineRE.FindStringSubmatch(line)
+			text := m[3]
+			if reply != "" {
+				text = reply + "\n" + text
+				reply = ""
+			}
+			msgs = append(msgs, Message{Date: m[1], Sender: m[2], Text: text})
+		case len(msgs) > 0:
+			msgs[len(msgs)-1].Text += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read file %q", chatPath)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+const _testChat = `--- Participants at this point: Jelena, Novak ---
+[2020-03-01 15:34:05] Novak: Want to play tennis?
+Tomorrow at 9
+> In reply to Novak: Want to play tennis?
+[2020-03-01 15:35:05 (delivered)] Jelena: Sure
+[date unknown] Me: See you there
+`
+
+func testExportFs(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/iMessage;+;chat1.txt", []byte(_testChat), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/attachments/IMG_0001.jpeg", []byte("jpeg"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/attachments/notes.txt", []byte("notes"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Jelena/iMessage;-;jelena@example.com.txt", []byte("[2020-03-02 10:00:00] Jelena: Good game\n"), 0644))
+	assert.NilError(t, afero.WriteFile(fs, "backup/Jelena/words.json", []byte("{}"), 0644))
+	return fs
+}
+
+func TestExportSourceChats(t *testing.T) {
+	src := NewExportSource(testExportFs(t), "backup")
+	chats, err := src.Chats()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []Chat{
+		{ID: "Jelena/iMessage;-;jelena@example.com.txt", Name: "Jelena"},
+		{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak"},
+	}, chats)
+}
+
+func TestExportSourceMessages(t *testing.T) {
+	allMsgs := []Message{
+		{Text: "Participants at this point: Jelena, Novak"},
+		{Date: "2020-03-01 15:34:05", Sender: "Novak", Text: "Want to play tennis?\nTomorrow at 9"},
+		{Date: "2020-03-01 15:35:05 (delivered)", Sender: "Jelena", Text: "> In reply to Novak: Want to play tennis?\nSure"},
+		{Date: "date unknown", Sender: "Me", Text: "See you there"},
+	}
+
+	tests := []struct {
+		msg          string
+		chatID       string
+		pageToken    string
+		limit        int
+		wantMsgs     []Message
+		wantNextPage string
+		wantErr      string
+	}{
+		{
+			msg:      "all messages",
+			chatID:   "Novak/iMessage;+;chat1.txt",
+			limit:    10,
+			wantMsgs: allMsgs,
+		},
+		{
+			msg:          "first page",
+			chatID:       "Novak/iMessage;+;chat1.txt",
+			limit:        2,
+			wantMsgs:     allMsgs[:2],
+			wantNextPage: "2",
+		},
+		{
+			msg:       "last page",
+			chatID:    "Novak/iMessage;+;chat1.txt",
+			pageToken: "2",
+			limit:     2,
+			wantMsgs:  allMsgs[2:],
+		},
+		{
+			msg:       "past the end",
+			chatID:    "Novak/iMessage;+;chat1.txt",
+			pageToken: "10",
+			limit:     2,
+		},
+		{
+			msg:       "invalid page token",
+			chatID:    "Novak/iMessage;+;chat1.txt",
+			pageToken: "abc",
+			limit:     2,
+			wantErr:   `invalid page token "abc"`,
+		},
+		{
+			msg:     "chat outside the export folder",
+			chatID:  "../secrets.txt",
+			limit:   2,
+			wantErr: `invalid chat ID "../secrets.txt"`,
+		},
+		{
+			msg:     "not a chat file",
+			chatID:  "Jelena/words.json",
+			limit:   2,
+			wantErr: `invalid chat ID "Jelena/words.json"`,
+		},
+		{
+			msg:     "missing chat",
+			chatID:  "Marian/iMessage;-;marian@example.com.txt",
+			limit:   2,
+			wantErr: `open file "backup/Marian/iMessage;-;marian@example.com.txt"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			src := NewExportSource(testExportFs(t), "backup")
+			msgs, nextPage, err := src.Messages(tt.chatID, tt.pageToken, tt.limit)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tt.wantMsgs, msgs)
+			assert.Equal(t, tt.wantNextPage, nextPage)
+		})
+	}
+}
+
+func TestExportSourceAttachments(t *testing.T) {
+	src := NewExportSource(testExportFs(t), "backup")
+	names, err := src.Attachments("Novak/iMessage;+;chat1.txt")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"IMG_0001.jpeg", "notes.txt"}, names)
+	names, err = src.Attachments("Jelena/iMessage;-;jelena@example.com.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(names))
+
+	f, err := src.Attachment("Novak/iMessage;+;chat1.txt", "IMG_0001.jpeg")
+	assert.NilError(t, err)
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	assert.NilError(t, err)
+	assert.Equal(t, "jpeg", string(b))
+
+	_, err = src.Attachment("Novak/iMessage;+;chat1.txt", "../iMessage;+;chat1.txt")
+	assert.ErrorContains(t, err, `invalid attachment name "../iMessage;+;chat1.txt"`)
+	_, err = src.Attachment("Novak/iMessage;+;chat1.txt", "missing.jpeg")
+	assert.ErrorContains(t, err, `open file "backup/Novak/attachments/missing.jpeg"`)
+}
+
+func TestExportSourceSearch(t *testing.T) {
+	src := NewExportSource(testExportFs(t), "backup")
+	results, err := src.Search("TENNIS", 10)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []ChatMessage{
+		{
+			Chat:    Chat{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak"},
+			Message: Message{Date: "2020-03-01 15:34:05", Sender: "Novak", Text: "Want to play tennis?\nTomorrow at 9"},
+		},
+		{
+			Chat:    Chat{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak"},
+			Message: Message{Date: "2020-03-01 15:35:05 (delivered)", Sender: "Jelena", Text: "> In reply to Novak: Want to play tennis?\nSure"},
+		},
+	}, results)
+	results, err = src.Search("tennis", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(results))
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// _dateLayouts are the accepted formats of the since and until parameters.
+// Dates without a time zone are in local time, like the dates of messages.
+var _dateLayouts = []string{time.RFC3339, _datetimeLayout, "2006-01-02"}
+
+// messageFilter selects messages by their dates, senders, and text. The zero
+// value keeps all messages.
+type messageFilter struct {
+	since, until time.Time
+	sender       string
+	match        *regexp.Regexp
+}
+
+// parseFilter reads a message filter from the since, until, sender, and match
+// query parameters.
+func parseFilter(q url.Values) (messageFilter, error) {
+	var f messageFilter
+	var err error
+	if f.since, err = parseDate(q.Get("since")); err != nil {
+		return messageFilter{}, errors.Wrap(err, "parse since")
+	}
+	if f.until, err = parseDate(q.Get("until")); err != nil {
+		return messageFilter{}, errors.Wrap(err, "parse until")
+	}
+	f.sender = q.Get("sender")
+	if match := q.Get("match"); match != "" {
+		if f.match, err = regexp.Compile(match); err != nil {
+			return messageFilter{}, errors.Wrapf(err, "compile match %q", match)
+		}
+	}
+	return f, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range _dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid date %q - use e.g. 2020-03-01 or 2020-03-01T15:34:05Z", s)
+}
+
+// keep checks if the message passes the filter. Messages sent since the since
+// date, and before the until date, are kept; messages whose dates are unknown
+// are dropped if either is set. Senders are compared ignoring case.
+func (f messageFilter) keep(msg Message) bool {
+	if !f.since.IsZero() || !f.until.IsZero() {
+		if len(msg.Date) < len(_datetimeLayout) {
+			return false
+		}
+		date, err := time.ParseInLocation(_datetimeLayout, msg.Date[:len(_datetimeLayout)], time.Local)
+		if err != nil || date.Before(f.since) || (!f.until.IsZero() && !date.Before(f.until)) {
+			return false
+		}
+	}
+	if f.sender != "" && !strings.EqualFold(f.sender, msg.Sender) {
+		return false
+	}
+	return f.match == nil || f.match.MatchString(msg.Text)
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestMessageFilter(t *testing.T) {
+	msgs := []Message{
+		{Date: "2020-03-01 15:34:05", Sender: "Novak", Text: "Want to play tennis?"},
+		{Date: "2020-03-02 09:00:00 (delivered)", Sender: "Jelena", Text: "Sure"},
+		{Date: "date unknown", Sender: "Me", Text: "See you there"},
+		{Text: "Participants at this point: Jelena, Novak"},
+	}
+
+	tests := []struct {
+		msg      string
+		query    string
+		wantMsgs []Message
+		wantErr  string
+	}{
+		{
+			msg:      "no filter",
+			wantMsgs: msgs,
+		},
+		{
+			msg:      "since",
+			query:    "since=2020-03-02",
+			wantMsgs: msgs[1:2],
+		},
+		{
+			msg:      "until",
+			query:    "until=2020-03-01 15:34:06",
+			wantMsgs: msgs[:1],
+		},
+		{
+			msg:      "until is exclusive",
+			query:    "until=2020-03-01 15:34:05",
+			wantMsgs: nil,
+		},
+		{
+			msg:      "sender",
+			query:    "sender=jelena",
+			wantMsgs: msgs[1:2],
+		},
+		{
+			msg:      "match",
+			query:    "match=(?i)^s",
+			wantMsgs: msgs[1:3],
+		},
+		{
+			msg:     "invalid date",
+			query:   "since=yesterday",
+			wantErr: `parse since: invalid date "yesterday"`,
+		},
+		{
+			msg:     "invalid regular expression",
+			query:   "match=(",
+			wantErr: `compile match "("`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			assert.NilError(t, err)
+			f, err := parseFilter(q)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			var kept []Message
+			for _, msg := range msgs {
+				if f.keep(msg) {
+					kept = append(kept, msg)
+				}
+			}
+			assert.DeepEqual(t, tt.wantMsgs, kept)
+		})
+	}
+}
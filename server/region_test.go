@@ -0,0 +1,51 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestPhoneRegion(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{number: "+381111111111", want: "🇷🇸 Serbia"},
+		{number: "+14155555555", want: "🇺🇸 United States"},
+		{number: "+14165555555", want: "🇨🇦 Canada"},
+		{number: "+18765555555", want: "🇯🇲 Jamaica"},
+		{number: "+447700900123", want: "🇬🇧 United Kingdom"},
+		{number: "+77012345678", want: "🇰🇿 Kazakhstan"},
+		{number: "+74951234567", want: "🇷🇺 Russia"},
+		{number: "+38344123456", want: "🇽🇰 Kosovo"},
+		{number: "+8001234567"},
+		{number: "+1 (415) 555-5555"},
+		{number: "jelena@example.com"},
+		{number: "Novak"},
+		{number: "+123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			assert.Equal(t, tt.want, phoneRegion(tt.number))
+		})
+	}
+}
+
+func TestRegionSourceChats(t *testing.T) {
+	fs := testExportFs(t)
+	assert.NilError(t, afero.WriteFile(fs, "backup/+381111111111/iMessage;-;+381111111111.txt", []byte("[2020-03-02 10:00:00] +381111111111: Zdravo\n"), 0644))
+
+	chats, err := NewRegionSource(NewExportSource(fs, "backup")).Chats()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []Chat{
+		{ID: "+381111111111/iMessage;-;+381111111111.txt", Name: "+381111111111", Region: "🇷🇸 Serbia"},
+		{ID: "Jelena/iMessage;-;jelena@example.com.txt", Name: "Jelena"},
+		{ID: "Novak/iMessage;+;chat1.txt", Name: "Novak"},
+	}, chats)
+}
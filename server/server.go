@@ -0,0 +1,290 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package server provides an HTTP handler for browsing chats in a web browser,
+// with a chat list, a message view which loads older messages as it is
+// scrolled, attachment previews, and search. The viewer is built on a JSON API
+// which other tools can also use to list chats, fetch and filter messages, and
+// stream exports. Chats are read from a Source, either an export folder or the
+// Messages database.
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+)
+
+const (
+	_defaultPageSize   = 100
+	_maxPageSize       = 1000
+	_maxSearchResults  = 200
+	_minSearchQueryLen = 2
+)
+
+type (
+	// Source reads chats, e.g. from an export folder or the Messages
+	// database.
+	Source interface {
+		// Chats lists the chats.
+		Chats() ([]Chat, error)
+		// Messages returns up to limit messages of the chat with the given
+		// ID, in order, starting after the given page token, and the page
+		// token of the next page. The next page token is empty after the
+		// last page.
+		Messages(chatID, pageToken string, limit int) ([]Message, string, error)
+		// Attachments lists the names of the attachments of the chat with
+		// the given ID.
+		Attachments(chatID string) ([]string, error)
+		// Attachment opens the attachment of the chat with the given ID and
+		// name.
+		Attachment(chatID, name string) (io.ReadSeekCloser, error)
+		// Search returns up to limit messages containing the given query,
+		// ignoring case.
+		Search(query string, limit int) ([]ChatMessage, error)
+	}
+
+	// Chat is an entry in the chat list. Whether chats are pinned or
+	// archived is only known when reading from the Messages database, the
+	// initial under which the viewer groups the chat is only set for
+	// collated sources, and the region of a chat named by a phone number is
+	// only set for region sources.
+	Chat struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Pinned   bool   `json:"pinned,omitempty"`
+		Archived bool   `json:"archived,omitempty"`
+		Initial  string `json:"initial,omitempty"`
+		Region   string `json:"region,omitempty"`
+	}
+
+	// Message is a message in a chat. Notices, e.g. changes of the
+	// participants of group chats, have no sender. The payment sent with
+	// Apple Pay in the message, if any, is only known when reading from the
+	// Messages database.
+	Message struct {
+		Date        string          `json:"date"`
+		Sender      string          `json:"sender"`
+		Text        string          `json:"text"`
+		Attachments []string        `json:"attachments,omitempty"`
+		Payment     *chatdb.Payment `json:"payment,omitempty"`
+	}
+
+	// ChatMessage is a message with the chat which contains it, e.g. as found
+	// by a search.
+	ChatMessage struct {
+		Chat    Chat    `json:"chat"`
+		Message Message `json:"message"`
+	}
+
+	// page is a page of messages, with the token of the next page.
+	page struct {
+		Messages []Message `json:"messages"`
+		NextPage string    `json:"next_page"`
+	}
+
+	// errorResponse describes a failed request.
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	server struct {
+		src  Source
+		page *template.Template
+	}
+)
+
+// New returns a handler serving the chats of the given source, with the
+// viewer page rendered from the given template.
+func New(src Source, page *template.Template) http.Handler {
+	s := server{src: src, page: page}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/chats", s.handleChats)
+	mux.HandleFunc("/api/messages", s.handleMessages)
+	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("/api/attachments", s.handleAttachments)
+	mux.HandleFunc("/api/export", s.handleExport)
+	mux.HandleFunc("/attachment", s.handleAttachment)
+	return mux
+}
+
+func (s server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.page.Execute(w, nil); err != nil {
+		logging.Errorf("render viewer page: %s", err)
+	}
+}
+
+func (s server) handleChats(w http.ResponseWriter, r *http.Request) {
+	chats, err := s.src.Chats()
+	if err != nil {
+		writeError(w, errors.Wrap(err, "list chats"), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, chats)
+}
+
+func (s server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := _defaultPageSize
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > _maxPageSize {
+			writeError(w, errors.Errorf("invalid limit %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+	filter, err := parseFilter(q)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	msgs, nextPage, err := s.src.Messages(q.Get("chat"), q.Get("page"), limit)
+	if err != nil {
+		writeError(w, errors.Wrapf(err, "get messages of chat %q", q.Get("chat")), http.StatusInternalServerError)
+		return
+	}
+	kept := []Message{}
+	for _, msg := range msgs {
+		if filter.keep(msg) {
+			kept = append(kept, msg)
+		}
+	}
+	writeJSON(w, page{Messages: kept, NextPage: nextPage})
+}
+
+func (s server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if len([]rune(query)) < _minSearchQueryLen {
+		writeError(w, errors.Errorf("search query %q is too short", query), http.StatusBadRequest)
+		return
+	}
+	results, err := s.src.Search(query, _maxSearchResults)
+	if err != nil {
+		writeError(w, errors.Wrapf(err, "search for %q", query), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []ChatMessage{}
+	}
+	writeJSON(w, results)
+}
+
+// handleExport streams the messages of all chats, or of the given chat, which
+// pass the filter, as a JSON object per line. If reading the messages fails
+// part way through, the stream ends with a JSON object describing the error.
+func (s server) handleExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseFilter(q)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	chats, err := s.src.Chats()
+	if err != nil {
+		writeError(w, errors.Wrap(err, "list chats"), http.StatusInternalServerError)
+		return
+	}
+	if chatID := q.Get("chat"); chatID != "" {
+		var found []Chat
+		for _, chat := range chats {
+			if chat.ID == chatID {
+				found = append(found, chat)
+			}
+		}
+		if len(found) == 0 {
+			writeError(w, errors.Errorf("unknown chat %q", chatID), http.StatusNotFound)
+			return
+		}
+		chats = found
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for _, chat := range chats {
+		pageToken := ""
+		for {
+			msgs, nextPage, err := s.src.Messages(chat.ID, pageToken, _maxPageSize)
+			if err != nil {
+				err = errors.Wrapf(err, "get messages of chat %q", chat.ID)
+				logging.Errorf("%s", err)
+				enc.Encode(errorResponse{Error: err.Error()})
+				return
+			}
+			for _, msg := range msgs {
+				if !filter.keep(msg) {
+					continue
+				}
+				if err := enc.Encode(ChatMessage{Chat: chat, Message: msg}); err != nil {
+					logging.Errorf("write response: %s", err)
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if nextPage == "" {
+				break
+			}
+			pageToken = nextPage
+		}
+	}
+}
+
+func (s server) handleAttachments(w http.ResponseWriter, r *http.Request) {
+	chatID := r.URL.Query().Get("chat")
+	names, err := s.src.Attachments(chatID)
+	if err != nil {
+		writeError(w, errors.Wrapf(err, "list attachments of chat %q", chatID), http.StatusInternalServerError)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	writeJSON(w, names)
+}
+
+func (s server) handleAttachment(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("name")
+	f, err := s.src.Attachment(q.Get("chat"), name)
+	if err != nil {
+		writeError(w, errors.Wrapf(err, "open attachment %q", name), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, name, time.Time{}, f)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error, code int) {
+	logging.Errorf("%s", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: err.Error()}); err != nil {
+		logging.Errorf("write response: %s", err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/assert"
+)
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		msg             string
+		target          string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+		wantErr         string
+	}{
+		{
+			msg:             "viewer page",
+			target:          "/",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "<h1>viewer</h1>",
+		},
+		{
+			msg:      "unknown page",
+			target:   "/unknown",
+			wantCode: http.StatusNotFound,
+			wantBody: "404 page not found\n",
+		},
+		{
+			msg:             "chats",
+			target:          "/api/chats",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `[{"id":"Jelena/iMessage;-;jelena@example.com.txt","name":"Jelena"},{"id":"Novak/iMessage;+;chat1.txt","name":"Novak"}]` + "\n",
+		},
+		{
+			msg:             "messages",
+			target:          "/api/messages?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&limit=1&page=3",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"messages":[{"date":"date unknown","sender":"Me","text":"See you there"}],"next_page":""}` + "\n",
+		},
+		{
+			msg:             "no messages",
+			target:          "/api/messages?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&page=10",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"messages":[],"next_page":""}` + "\n",
+		},
+		{
+			msg:             "filtered messages",
+			target:          "/api/messages?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&sender=jelena",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `{"messages":[{"date":"2020-03-01 15:35:05 (delivered)","sender":"Jelena","text":"\u003e In reply to Novak: Want to play tennis?\nSure"}],"next_page":""}` + "\n",
+		},
+		{
+			msg:      "invalid filter",
+			target:   "/api/messages?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&since=yesterday",
+			wantCode: http.StatusBadRequest,
+			wantErr:  `parse since: invalid date "yesterday"`,
+		},
+		{
+			msg:      "invalid limit",
+			target:   "/api/messages?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&limit=0",
+			wantCode: http.StatusBadRequest,
+			wantErr:  `invalid limit "0"`,
+		},
+		{
+			msg:      "invalid chat",
+			target:   "/api/messages?chat=..%2Fsecrets.txt",
+			wantCode: http.StatusInternalServerError,
+			wantErr:  `get messages of chat "../secrets.txt": invalid chat ID "../secrets.txt"`,
+		},
+		{
+			msg:             "search",
+			target:          "/api/search?q=good",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `[{"chat":{"id":"Jelena/iMessage;-;jelena@example.com.txt","name":"Jelena"},"message":{"date":"2020-03-02 10:00:00","sender":"Jelena","text":"Good game"}}]` + "\n",
+		},
+		{
+			msg:             "no search results",
+			target:          "/api/search?q=golf",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        "[]\n",
+		},
+		{
+			msg:      "short search query",
+			target:   "/api/search?q=g",
+			wantCode: http.StatusBadRequest,
+			wantErr:  `search query "g" is too short`,
+		},
+		{
+			msg:             "export",
+			target:          "/api/export?since=2020-03-01T15:35:00%2B00:00&until=2020-03-03",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody: `{"chat":{"id":"Jelena/iMessage;-;jelena@example.com.txt","name":"Jelena"},"message":{"date":"2020-03-02 10:00:00","sender":"Jelena","text":"Good game"}}
+{"chat":{"id":"Novak/iMessage;+;chat1.txt","name":"Novak"},"message":{"date":"2020-03-01 15:35:05 (delivered)","sender":"Jelena","text":"\u003e In reply to Novak: Want to play tennis?\nSure"}}
+`,
+		},
+		{
+			msg:             "export chat",
+			target:          "/api/export?chat=Jelena%2FiMessage%3B-%3Bjelena%40example.com.txt",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"chat":{"id":"Jelena/iMessage;-;jelena@example.com.txt","name":"Jelena"},"message":{"date":"2020-03-02 10:00:00","sender":"Jelena","text":"Good game"}}` + "\n",
+		},
+		{
+			msg:      "export unknown chat",
+			target:   "/api/export?chat=Marian%2FiMessage%3B-%3Bmarian%40example.com.txt",
+			wantCode: http.StatusNotFound,
+			wantErr:  `unknown chat "Marian/iMessage;-;marian@example.com.txt"`,
+		},
+		{
+			msg:      "export with invalid filter",
+			target:   "/api/export?match=(",
+			wantCode: http.StatusBadRequest,
+			wantErr:  `compile match "("`,
+		},
+		{
+			msg:             "attachments",
+			target:          "/api/attachments?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `["IMG_0001.jpeg","notes.txt"]` + "\n",
+		},
+		{
+			msg:             "attachment",
+			target:          "/attachment?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&name=IMG_0001.jpeg",
+			wantCode:        http.StatusOK,
+			wantContentType: "image/jpeg",
+			wantBody:        "jpeg",
+		},
+		{
+			msg:      "missing attachment",
+			target:   "/attachment?chat=Novak%2FiMessage%3B%2B%3Bchat1.txt&name=IMG_0002.jpeg",
+			wantCode: http.StatusNotFound,
+			wantErr:  `open attachment "IMG_0002.jpeg": open file "backup/Novak/attachments/IMG_0002.jpeg"`,
+		},
+	}
+
+	page := template.Must(template.New("serve.html").Parse("<h1>viewer</h1>"))
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			h := New(NewExportSource(testExportFs(t), "backup"), page)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantContentType != "" {
+				assert.Equal(t, tt.wantContentType, w.Header().Get("Content-Type"))
+			}
+			if tt.wantErr != "" {
+				var resp errorResponse
+				assert.NilError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Assert(t, strings.HasPrefix(resp.Error, tt.wantErr), resp.Error)
+				return
+			}
+			assert.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
+
+func TestServerExportError(t *testing.T) {
+	fs := testExportFs(t)
+	longLine := "[2020-03-03 10:00:00] Marian: " + strings.Repeat("a", 2*1024*1024) + "\n"
+	assert.NilError(t, afero.WriteFile(fs, "backup/Novak/iMessage;-;marian@example.com.txt", []byte(longLine), 0644))
+	h := New(NewExportSource(fs, "backup"), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 6, len(lines))
+	var resp errorResponse
+	assert.NilError(t, json.Unmarshal([]byte(lines[5]), &resp))
+	assert.ErrorContains(t, errors.New(resp.Error), `get messages of chat "Novak/iMessage;-;marian@example.com.txt": read file`)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// shortcutOptions are the arguments of the shortcut command, which are given
+// positionally so that they are easy to pass from the Run Shell Script action
+// of Shortcuts or from do shell script in AppleScript.
+type shortcutOptions struct {
+	Args struct {
+		Chat   string `positional-arg-name:"chat" description:"Name of the chat to export, e.g. a contact's full name or the name of a group chat"`
+		Folder string `positional-arg-name:"folder" description:"Folder in which to create the export folder, e.g. '~/Documents/Messages'"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// shortcutResult is written to standard output as JSON by the shortcut command,
+// for Shortcuts or AppleScript to show or act on.
+type shortcutResult struct {
+	// Status is succeeded, partial if some items could not be exported, e.g.
+	// missing attachments, or failed.
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	ExportPath string `json:"export_path"`
+	Chats      int    `json:"chats"`
+	Messages   int    `json:"messages"`
+}
+
+// runShortcut exports the chats with the name given to the shortcut command
+// into a new export folder, named after the time of the export, in the given
+// folder, and writes the outcome to the given writer as JSON. The outcome of
+// the export is only reported in the JSON, so that the shortcut command
+// succeeds whenever it can report it.
+func runShortcut(opts options, shortcutOpts shortcutOptions, s opsys.OS, cdb chatdb.ChatDB, now time.Time, w io.Writer) error {
+	folder, err := s.ExpandHome(shortcutOpts.Args.Folder)
+	if err != nil {
+		return errors.Wrapf(err, "expand folder %q", shortcutOpts.Args.Folder)
+	}
+	opts.Chat = shortcutOpts.Args.Chat
+	opts.ExportPath = path.Join(folder, "bagoup-"+now.Format("2006-01-02-150405"))
+	summary, err := bagoup(opts, s, cdb)
+	result := shortcutResult{
+		Status:     "succeeded",
+		ExportPath: opts.ExportPath,
+		Chats:      summary.Chats,
+		Messages:   summary.Messages,
+	}
+	if err != nil {
+		result.Status, result.Error = "failed", withRemediation(err).Error()
+	} else if summary.partial() {
+		result.Status = "partial"
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return errors.Wrap(enc.Encode(result), "write result")
+}
+
+// chatsNamed returns the chats which are named, ignoring case, as selected
+// with --chat.
+func chatsNamed(chats []chatdb.Chat, name string) ([]chatdb.Chat, error) {
+	var named []chatdb.Chat
+	for _, chat := range chats {
+		if strings.EqualFold(chat.DisplayName, name) {
+			named = append(named, chat)
+		}
+	}
+	if len(named) == 0 {
+		return nil, fmt.Errorf("no chat named %q - FIX: give the name of the chat folder of a full export, e.g. the contact's full name with --contacts-path", name)
+	}
+	return named, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestRunShortcut(t *testing.T) {
+	tenDotFifteen := "10.15"
+	now := time.Date(2020, time.March, 31, 15, 34, 5, 0, time.UTC)
+	chats := []chatdb.Chat{
+		{ID: 1, GUID: "testguid", DisplayName: "Novak Djokovic"},
+		{ID: 2, GUID: "testguid2", DisplayName: "Rafael Nadal"},
+	}
+
+	tests := []struct {
+		msg        string
+		chat       string
+		setupMock  func(*mock_chatdb.MockChatDB)
+		setupFs    func(afero.Fs)
+		wantResult shortcutResult
+		wantFile   string
+	}{
+		{
+			msg:  "succeeded",
+			chat: "novak djokovic",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil)
+				dbMock.EXPECT().GetDanglingJoins()
+				dbMock.EXPECT().GetChats(nil).Return(chats, nil)
+				dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{100}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return(nil, nil)
+				dbMock.EXPECT().GetMessage(100, nil, gomock.Any()).Return(testMessage(100, "message%d"), nil)
+			},
+			setupFs: func(afero.Fs) {},
+			wantResult: shortcutResult{
+				Status:     "succeeded",
+				ExportPath: "Messages/bagoup-2020-03-31-153405",
+				Chats:      1,
+				Messages:   1,
+			},
+			wantFile: "Messages/bagoup-2020-03-31-153405/Novak Djokovic/testguid.txt",
+		},
+		{
+			msg:  "no chat with the name",
+			chat: "Roger Federer",
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetSyncedMessageCount().Return(0, nil)
+				dbMock.EXPECT().GetDanglingJoins()
+				dbMock.EXPECT().GetChats(nil).Return(chats, nil)
+			},
+			setupFs: func(afero.Fs) {},
+			wantResult: shortcutResult{
+				Status:     "failed",
+				Error:      `export chats: no chat named "Roger Federer" - FIX: give the name of the chat folder of a full export, e.g. the contact's full name with --contacts-path`,
+				ExportPath: "Messages/bagoup-2020-03-31-153405",
+			},
+		},
+		{
+			msg:       "export folder exists",
+			chat:      "Novak Djokovic",
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+			setupFs: func(fs afero.Fs) {
+				fs.MkdirAll("Messages/bagoup-2020-03-31-153405", 0755)
+			},
+			wantResult: shortcutResult{
+				Status:     "failed",
+				Error:      `export folder "Messages/bagoup-2020-03-31-153405" already exists - FIX: move it, specify a different export path with the --export-path option, or resume an interrupted export with the --resume option`,
+				ExportPath: "Messages/bagoup-2020-03-31-153405",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			tt.setupMock(dbMock)
+			fs := afero.NewMemMapFs()
+			tt.setupFs(fs)
+			s := opsys.NewOS(fs, fs.Stat, nil)
+			opts := options{DBPath: "chat.db", Format: "txt", SelfHandle: "Me", MacOSVersion: &tenDotFifteen, SkipSpaceCheck: true}
+			var shortcutOpts shortcutOptions
+			shortcutOpts.Args.Chat = tt.chat
+			shortcutOpts.Args.Folder = "Messages"
+
+			var b bytes.Buffer
+			assert.NilError(t, runShortcut(opts, shortcutOpts, s, dbMock, now, &b))
+			var result shortcutResult
+			assert.NilError(t, json.Unmarshal(b.Bytes(), &result))
+			assert.DeepEqual(t, tt.wantResult, result)
+			if tt.wantFile != "" {
+				exist, err := afero.Exists(fs, tt.wantFile)
+				assert.NilError(t, err)
+				assert.Assert(t, exist, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestRunShortcutWriteError(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	s := opsys.NewOS(fs, fs.Stat, nil)
+	var shortcutOpts shortcutOptions
+	shortcutOpts.Args.Chat = "Novak Djokovic"
+	shortcutOpts.Args.Folder = "Messages"
+	afero.WriteFile(s, "Messages/bagoup-2020-03-31-153405", nil, 0644)
+	err := runShortcut(options{}, shortcutOpts, s, nil, time.Date(2020, time.March, 31, 15, 34, 5, 0, time.UTC), errWriter{})
+	assert.Error(t, err, "write result: this is a write error")
+}
+
+// errWriter fails to write.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("this is a write error")
+}
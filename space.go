@@ -0,0 +1,81 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _messageOverhead estimates the number of bytes written for each message
+// besides its text in each format, e.g. its date and sender, or its email
+// headers.
+var _messageOverhead = map[string]int64{
+	"txt":    40,
+	"mbox":   300,
+	"slack":  100,
+	"matrix": 400,
+}
+
+// checkFreeSpace estimates the size of the export of the given chats, and
+// checks that the volume of the export folder has that much free space, so
+// that a long export does not fail halfway through. Chats already exported
+// according to the resume manifest are not counted. The check is skipped if
+// the size or the free space cannot be determined.
+func checkFreeSpace(s opsys.OS, cdb chatdb.ChatDB, opts options, chats []chatdb.Chat, manifest *resumeManifest) error {
+	if opts.SkipSpaceCheck || len(chats) == 0 {
+		return nil
+	}
+	sizes, err := cdb.GetChatSizes()
+	if err != nil {
+		logging.Warnf("estimate export size - skipping the free space check: %s", err)
+		return nil
+	}
+	var need int64
+	for _, chat := range chats {
+		if _, ok := manifest.Chats[chat.GUID]; ok {
+			continue
+		}
+		// Clones take no space until they are modified.
+		need += chatExportSize(sizes[chat.ID], opts.Format, opts.CopyAttachments && !opts.CloneAttachments)
+	}
+	free, err := s.FreeSpace(opts.ExportPath)
+	if err != nil {
+		logging.Warnf("check free space at %q - skipping the free space check: %s", opts.ExportPath, err)
+		return nil
+	}
+	logging.Debugf("export needs about %s of the %s free at %q", formatBytes(need), formatBytes(free), opts.ExportPath)
+	if need > free {
+		return fmt.Errorf("export needs about %s but only %s is free at %q - FIX: free up space, export to another drive with --export-path, or skip this check with --skip-space-check", formatBytes(need), formatBytes(free), opts.ExportPath)
+	}
+	return nil
+}
+
+// chatExportSize estimates the number of bytes written for a chat of the
+// given size in the given format, including its attachments if they are
+// copied.
+func chatExportSize(size chatdb.ChatSize, format string, attachments bool) int64 {
+	n := size.TextBytes + int64(size.Messages)*_messageOverhead[format]
+	if attachments {
+		n += size.AttachmentBytes
+	}
+	return n
+}
+
+// formatBytes formats the given number of bytes for people, e.g. "1.5 GB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
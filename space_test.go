@@ -0,0 +1,137 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/opsys/mock_opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	chats := []chatdb.Chat{{ID: 1, GUID: "testguid"}, {ID: 2, GUID: "testguid2"}}
+	sizes := map[int]chatdb.ChatSize{
+		1: {Messages: 100, TextBytes: 6000, AttachmentBytes: 2000000},
+		2: {Messages: 10, TextBytes: 600},
+	}
+
+	tests := []struct {
+		msg        string
+		opts       options
+		chats      []chatdb.Chat
+		exported   map[string]exportedChat
+		setupMocks func(*mock_opsys.MockOS, *mock_chatdb.MockChatDB)
+		wantErr    string
+	}{
+		{
+			msg:   "enough space",
+			opts:  options{ExportPath: "backup", Format: "txt"},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(11000), nil)
+			},
+		},
+		{
+			msg:   "not enough space",
+			opts:  options{ExportPath: "backup", Format: "mbox"},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(11000), nil)
+			},
+			wantErr: `export needs about 39.6 kB but only 11.0 kB is free at "backup" - FIX: free up space, export to another drive with --export-path, or skip this check with --skip-space-check`,
+		},
+		{
+			msg:   "attachments",
+			opts:  options{ExportPath: "backup", Format: "txt", CopyAttachments: true},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(1000000), nil)
+			},
+			wantErr: `export needs about 2.0 MB but only 1.0 MB is free at "backup"`,
+		},
+		{
+			msg:   "cloned attachments",
+			opts:  options{ExportPath: "backup", Format: "txt", CopyAttachments: true, CloneAttachments: true},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(1000000), nil)
+			},
+		},
+		{
+			msg:      "resumed export",
+			opts:     options{ExportPath: "backup", Format: "txt", CopyAttachments: true},
+			chats:    chats,
+			exported: map[string]exportedChat{"testguid": {Path: "backup/testdisplayname/testguid.txt"}},
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(1000), nil)
+			},
+		},
+		{
+			msg:   "size error",
+			opts:  options{ExportPath: "backup", Format: "txt"},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(nil, errors.New("this is a DB error"))
+			},
+		},
+		{
+			msg:   "free space error",
+			opts:  options{ExportPath: "backup", Format: "txt"},
+			chats: chats,
+			setupMocks: func(osMock *mock_opsys.MockOS, dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+				osMock.EXPECT().FreeSpace("backup").Return(int64(0), errors.New("this is an exec error"))
+			},
+		},
+		{
+			msg:   "skipped",
+			opts:  options{ExportPath: "backup", Format: "txt", SkipSpaceCheck: true},
+			chats: chats,
+		},
+		{
+			msg:  "no chats",
+			opts: options{ExportPath: "backup", Format: "txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			osMock := mock_opsys.NewMockOS(ctrl)
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(osMock, dbMock)
+			}
+			manifest := newResumeManifest("backup")
+			for guid, chat := range tt.exported {
+				manifest.Chats[guid] = chat
+			}
+
+			err := checkFreeSpace(osMock, dbMock, tt.opts, tt.chats, manifest)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "999 B", formatBytes(999))
+	assert.Equal(t, "1.5 kB", formatBytes(1500))
+	assert.Equal(t, "2.0 MB", formatBytes(2000000))
+	assert.Equal(t, "1.2 TB", formatBytes(1200000000000))
+}
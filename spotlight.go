@@ -0,0 +1,67 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const _spotlightDateLayout = "2006-01-02"
+
+// spotlightMetadata describes an exported chat for Spotlight, with the
+// participants and senders other than the owner of the database as its
+// authors, the handle of a one-on-one chat as a keyword, and the number and
+// date range of its messages as its description.
+func spotlightMetadata(chat chatdb.Chat, members []string, msgs []chatdb.Message, selfHandle string) opsys.SpotlightMetadata {
+	var authors []string
+	seen := map[string]bool{selfHandle: true, "": true}
+	addAuthor := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			authors = append(authors, name)
+		}
+	}
+	for _, member := range members {
+		addAuthor(member)
+	}
+	var first, last string
+	for _, msg := range msgs {
+		addAuthor(msg.Handle)
+		if msg.DateSource == chatdb.DateUnknown {
+			continue
+		}
+		if first == "" {
+			first = msg.Date.Format(_spotlightDateLayout)
+		}
+		last = msg.Date.Format(_spotlightDateLayout)
+	}
+
+	description := fmt.Sprintf("%d messages", len(msgs))
+	if len(msgs) == 1 {
+		description = "1 message"
+	}
+	if len(authors) > 0 {
+		description += " with " + strings.Join(authors, ", ")
+	}
+	if first != "" {
+		description += fmt.Sprintf(" from %s to %s", first, last)
+	}
+
+	var keywords []string
+	// The GUIDs of one-on-one chats end in the handle of the other
+	// participant, e.g. "iMessage;-;+3815555555555".
+	if parts := strings.Split(chat.GUID, ";"); len(parts) == 3 && parts[1] == "-" && !seen[parts[2]] {
+		keywords = append(keywords, parts[2])
+	}
+	return opsys.SpotlightMetadata{
+		Title:       chat.DisplayName,
+		Description: description,
+		Authors:     authors,
+		Keywords:    keywords,
+	}
+}
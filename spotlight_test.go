@@ -0,0 +1,73 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestSpotlightMetadata(t *testing.T) {
+	later := time.Date(2020, time.March, 5, 9, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		msg     string
+		chat    chatdb.Chat
+		members []string
+		msgs    []chatdb.Message
+		want    opsys.SpotlightMetadata
+	}{
+		{
+			msg:     "one-on-one chat",
+			chat:    chatdb.Chat{GUID: "iMessage;-;+3815555555555", DisplayName: "Novak Djokovic"},
+			members: []string{"Novak"},
+			msgs: []chatdb.Message{
+				{Date: _testDate, Handle: "Novak", Text: "Want to play tennis?"},
+				{Date: later, Handle: "Me", Text: "Sure"},
+			},
+			want: opsys.SpotlightMetadata{
+				Title:       "Novak Djokovic",
+				Description: "2 messages with Novak from 2020-03-01 to 2020-03-05",
+				Authors:     []string{"Novak"},
+				Keywords:    []string{"+3815555555555"},
+			},
+		},
+		{
+			msg:     "group chat with a former participant",
+			chat:    chatdb.Chat{GUID: "iMessage;+;chat123", DisplayName: "Tennis"},
+			members: []string{"Novak", "Jelena"},
+			msgs: []chatdb.Message{
+				{Date: _testDate, Handle: "Marian", Text: "Hi"},
+				{DateSource: chatdb.DateUnknown, Handle: "Novak", Text: "Hello"},
+			},
+			want: opsys.SpotlightMetadata{
+				Title:       "Tennis",
+				Description: "2 messages with Novak, Jelena, Marian from 2020-03-01 to 2020-03-01",
+				Authors:     []string{"Novak", "Jelena", "Marian"},
+			},
+		},
+		{
+			msg:  "one message with an unknown date",
+			chat: chatdb.Chat{GUID: "SMS;-;novak@example.com", DisplayName: "novak@example.com"},
+			msgs: []chatdb.Message{
+				{DateSource: chatdb.DateUnknown, Handle: "Me", Text: "Hello?"},
+			},
+			want: opsys.SpotlightMetadata{
+				Title:       "novak@example.com",
+				Description: "1 message",
+				Keywords:    []string{"novak@example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.want, spotlightMetadata(tt.chat, tt.members, tt.msgs, "Me"))
+		})
+	}
+}
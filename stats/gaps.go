@@ -0,0 +1,49 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// Gap is a period without messages in a chat which was active before and
+// after it, which may mean that messages were lost, e.g. when moving to a new
+// device. Start and End are the dates of the messages before and after the
+// gap, and Before and After count the messages within the minimum gap length
+// before and after it.
+type Gap struct {
+	Start  time.Time
+	End    time.Time
+	Before int
+	After  int
+}
+
+// Days returns the length of the gap in whole days.
+func (g Gap) Days() int {
+	return int(g.End.Sub(g.Start) / (24 * time.Hour))
+}
+
+// FindGaps returns the gaps of at least minGap between the given message
+// dates, in order, with at least minActive messages within minGap both before
+// and after them. Gaps in chats which are only occasionally active are not
+// suspicious, so they are not returned.
+func FindGaps(dates []time.Time, minGap time.Duration, minActive int) []Gap {
+	sorted := append([]time.Time{}, dates...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	var gaps []Gap
+	for i := 1; i < len(sorted); i++ {
+		start, end := sorted[i-1], sorted[i]
+		if end.Sub(start) < minGap {
+			continue
+		}
+		first := sort.Search(i, func(j int) bool { return !sorted[j].Before(start.Add(-minGap)) })
+		last := sort.Search(len(sorted), func(j int) bool { return sorted[j].After(end.Add(minGap)) })
+		gap := Gap{Start: start, End: end, Before: i - first, After: last - i}
+		if gap.Before >= minActive && gap.After >= minActive {
+			gaps = append(gaps, gap)
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// dailyDates returns a date on each of the given number of days from the
+// given date.
+func dailyDates(from time.Time, days int) []time.Time {
+	dates := make([]time.Time, days)
+	for i := range dates {
+		dates[i] = from.AddDate(0, 0, i)
+	}
+	return dates
+}
+
+func TestFindGaps(t *testing.T) {
+	jan := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	jun := time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC)
+	month := 30 * 24 * time.Hour
+
+	tests := []struct {
+		msg      string
+		dates    []time.Time
+		wantGaps []Gap
+	}{
+		{
+			msg:   "no messages",
+			dates: nil,
+		},
+		{
+			msg:   "gap in active chat",
+			dates: append(dailyDates(jun, 10), dailyDates(jan, 10)...),
+			wantGaps: []Gap{
+				{Start: jan.AddDate(0, 0, 9), End: jun, Before: 10, After: 10},
+			},
+		},
+		{
+			msg:   "gap in occasional chat",
+			dates: append(dailyDates(jan, 10), dailyDates(jun, 3)...),
+		},
+		{
+			msg:   "short gap",
+			dates: append(dailyDates(jan, 10), dailyDates(jan.AddDate(0, 0, 25), 10)...),
+		},
+		{
+			msg:   "activity long before gap",
+			dates: append(append(dailyDates(jan, 10), jan.AddDate(0, 2, 0)), dailyDates(jun, 10)...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.DeepEqual(t, tt.wantGaps, FindGaps(tt.dates, month, 10))
+		})
+	}
+}
+
+func TestGapDays(t *testing.T) {
+	start := time.Date(2020, time.January, 10, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 142, Gap{Start: start, End: time.Date(2020, time.June, 1, 11, 0, 0, 0, time.UTC)}.Days())
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+// Package stats provides statistics about exported chats, e.g. activity
+// heatmaps.
+package stats
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_cellSize   = 10
+	_cellGap    = 2
+	_labelWidth = 40
+	_yearGap    = 10
+	_weeks      = 54
+)
+
+// _palette holds the cell colors for increasing activity levels, in the style
+// of GitHub's contribution graph.
+var _palette = []color.RGBA{
+	{0xeb, 0xed, 0xf0, 0xff},
+	{0x9b, 0xe9, 0xa8, 0xff},
+	{0x40, 0xc4, 0x63, 0xff},
+	{0x30, 0xa1, 0x4e, 0xff},
+	{0x21, 0x6e, 0x39, 0xff},
+}
+
+// Heatmap counts messages per day, for rendering as a calendar heatmap with
+// one row of weeks per year.
+type Heatmap struct {
+	counts    map[time.Time]int
+	max       int
+	firstYear int
+	lastYear  int
+}
+
+// NewHeatmap returns an empty Heatmap.
+func NewHeatmap() *Heatmap {
+	return &Heatmap{counts: make(map[time.Time]int)}
+}
+
+// Add counts a message sent at the given time.
+func (h *Heatmap) Add(t time.Time) {
+	d := day(t)
+	h.counts[d]++
+	if h.counts[d] > h.max {
+		h.max = h.counts[d]
+	}
+	if h.firstYear == 0 || d.Year() < h.firstYear {
+		h.firstYear = d.Year()
+	}
+	if d.Year() > h.lastYear {
+		h.lastYear = d.Year()
+	}
+}
+
+// Empty reports whether no messages have been counted.
+func (h *Heatmap) Empty() bool {
+	return len(h.counts) == 0
+}
+
+// WriteSVG renders the heatmap as an SVG image, with year labels and a tooltip
+// for each day.
+func (h *Heatmap) WriteSVG(w io.Writer) error {
+	width, height := h.size(_labelWidth)
+	if _, err := fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"10\">\n", width, height); err != nil {
+		return errors.Wrap(err, "write SVG header")
+	}
+	for year := h.firstYear; year <= h.lastYear && !h.Empty(); year++ {
+		if _, err := fmt.Fprintf(w, "<text x=\"0\" y=\"%d\">%d</text>\n", h.yearTop(year)+_cellSize, year); err != nil {
+			return errors.Wrapf(err, "write label for year %d", year)
+		}
+	}
+	var err error
+	h.eachDay(func(d time.Time, count int) {
+		if err != nil {
+			return
+		}
+		x, y := h.position(d, _labelWidth)
+		c := _palette[h.level(count)]
+		_, err = fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\"><title>%s: %d messages</title></rect>\n", x, y, _cellSize, _cellSize, c.R, c.G, c.B, d.Format("2006-01-02"), count)
+	})
+	if err != nil {
+		return errors.Wrap(err, "write SVG cell")
+	}
+	_, err = fmt.Fprintln(w, "</svg>")
+	return errors.Wrap(err, "write SVG footer")
+}
+
+// WritePNG renders the heatmap as a PNG image. Year labels are omitted.
+func (h *Heatmap) WritePNG(w io.Writer) error {
+	width, height := h.size(0)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	h.eachDay(func(d time.Time, count int) {
+		x, y := h.position(d, 0)
+		draw.Draw(img, image.Rect(x, y, x+_cellSize, y+_cellSize), &image.Uniform{_palette[h.level(count)]}, image.Point{}, draw.Src)
+	})
+	return errors.Wrap(png.Encode(w, img), "encode PNG")
+}
+
+// eachDay calls fn for every day in the years spanned by the heatmap, in
+// order.
+func (h *Heatmap) eachDay(fn func(d time.Time, count int)) {
+	if h.Empty() {
+		return
+	}
+	end := time.Date(h.lastYear+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for d := time.Date(h.firstYear, time.January, 1, 0, 0, 0, 0, time.UTC); d.Before(end); d = d.AddDate(0, 0, 1) {
+		fn(d, h.counts[d])
+	}
+}
+
+// position returns the top-left corner of the cell for the given day, with
+// one column per week and one row per weekday.
+func (h *Heatmap) position(d time.Time, labelWidth int) (int, int) {
+	jan1 := time.Date(d.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	week := (d.YearDay() - 1 + int(jan1.Weekday())) / 7
+	return labelWidth + week*(_cellSize+_cellGap), h.yearTop(d.Year()) + int(d.Weekday())*(_cellSize+_cellGap)
+}
+
+// yearTop returns the top edge of the row of weeks for the given year.
+func (h *Heatmap) yearTop(year int) int {
+	return (year - h.firstYear) * (7*(_cellSize+_cellGap) + _yearGap)
+}
+
+func (h *Heatmap) size(labelWidth int) (int, int) {
+	if h.Empty() {
+		return 0, 0
+	}
+	years := h.lastYear - h.firstYear + 1
+	return labelWidth + _weeks*(_cellSize+_cellGap), years*(7*(_cellSize+_cellGap)+_yearGap) - _yearGap
+}
+
+// level returns the index into the palette for the given count, scaled
+// relative to the busiest day.
+func (h *Heatmap) level(count int) int {
+	if count == 0 || h.max == 0 {
+		return 0
+	}
+	levels := len(_palette) - 1
+	return (count*levels + h.max - 1) / h.max
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,58 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestHeatmap(t *testing.T) {
+	h := NewHeatmap()
+	assert.Assert(t, h.Empty())
+	h.Add(time.Date(2019, time.December, 31, 23, 0, 0, 0, time.Local))
+	h.Add(time.Date(2020, time.March, 1, 15, 34, 5, 0, time.Local))
+	h.Add(time.Date(2020, time.March, 1, 15, 36, 12, 0, time.Local))
+	h.Add(time.Date(2020, time.March, 1, 16, 0, 0, 0, time.Local))
+	h.Add(time.Date(2020, time.March, 1, 17, 0, 0, 0, time.Local))
+	assert.Assert(t, !h.Empty())
+
+	t.Run("SVG", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NilError(t, h.WriteSVG(&b))
+		svg := b.String()
+		assert.Assert(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="688" height="178"`), svg[:100])
+		assert.Assert(t, strings.Contains(svg, `<text x="0" y="10">2019</text>`))
+		assert.Assert(t, strings.Contains(svg, `<text x="0" y="104">2020</text>`))
+		// 2019-12-31 was a Tuesday in the 53rd week of 2019.
+		assert.Assert(t, strings.Contains(svg, `<rect x="664" y="24" width="10" height="10" fill="#9be9a8"><title>2019-12-31: 1 messages</title></rect>`))
+		// 2020-03-01 was a Sunday in the 10th week of 2020.
+		assert.Assert(t, strings.Contains(svg, `<rect x="148" y="94" width="10" height="10" fill="#216e39"><title>2020-03-01: 4 messages</title></rect>`))
+		assert.Equal(t, 366+365, strings.Count(svg, "<rect "))
+		assert.Assert(t, strings.HasSuffix(svg, "</svg>\n"))
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		var b bytes.Buffer
+		assert.NilError(t, h.WritePNG(&b))
+		img, err := png.Decode(&b)
+		assert.NilError(t, err)
+		assert.Equal(t, 648, img.Bounds().Dx())
+		assert.Equal(t, 178, img.Bounds().Dy())
+		r, g, bl, _ := img.At(108+1, 94+1).RGBA()
+		assert.Equal(t, [3]uint32{0x21, 0x6e, 0x39}, [3]uint32{r >> 8, g >> 8, bl >> 8})
+	})
+}
+
+func TestHeatmapLevel(t *testing.T) {
+	h := &Heatmap{max: 10}
+	for count, want := range []int{0, 1, 1, 2, 2, 2, 3, 3, 4, 4, 4} {
+		assert.Equal(t, want, h.level(count), "count %d", count)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"strings"
+	"unicode"
+)
+
+// _scriptLanguages are the languages of texts in scripts which are mainly
+// used for one language, by the script's Unicode range table.
+var _scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Armenian, "hy"},
+	{unicode.Georgian, "ka"},
+}
+
+// _latinStopwords are common words which are distinctive of languages written
+// in the Latin script, by ISO 639-1 code.
+var _latinStopwords = map[string]map[string]bool{
+	"en": makeSet(strings.Fields("the and is are you that this with have was what for not but to at on it of i'm")),
+	"es": makeSet(strings.Fields("el los las que es por para con una pero como está qué muy")),
+	"fr": makeSet(strings.Fields("le les des est et une pour pas que qui avec dans je tu c'est")),
+	"de": makeSet(strings.Fields("der die das und ist nicht ich du ein eine mit auf zu wir")),
+	"it": makeSet(strings.Fields("il che non è per una sono gli della ma ci ho hai anche")),
+	"pt": makeSet(strings.Fields("o os que não é uma com para você está mas muito eu isso")),
+	"nl": makeSet(strings.Fields("de het een en is niet ik je dat van op met wat")),
+	"sv": makeSet(strings.Fields("och är det att jag du inte en på som med har vad")),
+}
+
+// DetectLanguage guesses the language of a text, returning its ISO 639-1 code,
+// e.g. "en", or an empty string if it cannot tell, e.g. for short texts or
+// emoji. Texts in scripts used mainly for one language are identified by their
+// script, Cyrillic texts as Russian unless they have Ukrainian letters, and
+// texts in the Latin script by their common words.
+func DetectLanguage(text string) string {
+	counts := map[string]int{}
+	var latin, cyrillic, kana int
+	ukrainian := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+			continue
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+			ukrainian = ukrainian || strings.ContainsRune("іїєґІЇЄҐ", r)
+			continue
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+			continue
+		}
+		for _, sl := range _scriptLanguages {
+			if unicode.Is(sl.script, r) {
+				counts[sl.language]++
+				break
+			}
+		}
+	}
+	if kana > 0 {
+		// Japanese is written with kana and Chinese characters.
+		counts["ja"] = kana + counts["zh"]
+		delete(counts, "zh")
+	}
+	if ukrainian {
+		counts["uk"] = cyrillic
+	} else if cyrillic > 0 {
+		counts["ru"] = cyrillic
+	}
+	best, bestCount := "", 0
+	for language, count := range counts {
+		if count > bestCount || count == bestCount && language < best {
+			best, bestCount = language, count
+		}
+	}
+	if latin > bestCount {
+		return latinLanguage(text)
+	}
+	return best
+}
+
+// latinLanguage guesses the language of a text in the Latin script from the
+// number of its words which are common in each language. It returns an empty
+// string if no language has more common words in the text than the others.
+func latinLanguage(text string) string {
+	scores := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isWordSeparator) {
+		for language, stopwords := range _latinStopwords {
+			if stopwords[word] {
+				scores[language]++
+			}
+		}
+	}
+	best, bestScore, tied := "", 0, false
+	for language, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = language, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		text string
+		want string
+	}{
+		{msg: "English", text: "Want to play tennis at 5?", want: "en"},
+		{msg: "Spanish", text: "¿Qué tal el partido? Está muy bien", want: "es"},
+		{msg: "French", text: "C'est pour le match de demain", want: "fr"},
+		{msg: "German", text: "Ich bin nicht da, du musst die Karten holen", want: "de"},
+		{msg: "Swedish", text: "Jag har inte tid och det är sent", want: "sv"},
+		{msg: "other Cyrillic languages as Russian", text: "Хоћеш да играмо тенис?", want: "ru"},
+		{msg: "Ukrainian", text: "Ти їдеш на матч?", want: "uk"},
+		{msg: "Japanese", text: "明日テニスをしましょう", want: "ja"},
+		{msg: "Chinese", text: "明天打网球吗", want: "zh"},
+		{msg: "Korean", text: "내일 테니스 칠래?", want: "ko"},
+		{msg: "Greek", text: "Θέλεις να παίξουμε τένις;", want: "el"},
+		{msg: "mostly Arabic", text: "هل تريد أن تلعب التنس OK", want: "ar"},
+		{msg: "no common words", text: "Tennis tomorrow?", want: ""},
+		{msg: "tied common words", text: "de het the and", want: ""},
+		{msg: "emoji", text: "🎾😀", want: ""},
+		{msg: "empty", text: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetectLanguage(tt.text))
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// _topN is the number of words and emoji reported for each participant.
+const _topN = 10
+
+var _stopwords = makeSet(strings.Fields(`
+a about after again all am an and any are as at be because been before being
+but by can could did do does doing don't for from had has have having he her
+here hers him his how i i'm if in into is it it's its just me my no not now of
+off on once only or other our ours out over own same she should so some such
+than that that's the their theirs them then there these they this those to too
+under until up very was we were what when where which while who whom why will
+with would you you're your yours
+`))
+
+// Count is the number of occurrences of a word or emoji.
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ParticipantStats summarizes the messages sent by one participant in a chat.
+type ParticipantStats struct {
+	Participant   string  `json:"participant"`
+	Messages      int     `json:"messages"`
+	AverageLength float64 `json:"average_length"`
+	TopWords      []Count `json:"top_words"`
+	TopEmoji      []Count `json:"top_emoji"`
+	// Languages are the languages of the participant's messages, by ISO
+	// 639-1 code, e.g. "en", as detected by DetectLanguage. Messages whose
+	// language cannot be detected are not counted.
+	Languages []Count `json:"languages"`
+	// Kinds are the kinds of the participant's messages, e.g. "emoji" for
+	// messages which are only emoji.
+	Kinds []Count `json:"kinds"`
+}
+
+// WordStats counts words and emoji per participant.
+type WordStats struct {
+	participants map[string]*participant
+}
+
+type participant struct {
+	messages  int
+	chars     int
+	words     map[string]int
+	emoji     map[string]int
+	languages map[string]int
+	kinds     map[string]int
+}
+
+// NewWordStats returns an empty WordStats.
+func NewWordStats() *WordStats {
+	return &WordStats{participants: make(map[string]*participant)}
+}
+
+// Add counts the words and emoji in a message of the given kind sent by the
+// given participant, and the message's language and kind. Common English words
+// are not counted.
+func (ws *WordStats) Add(handle, kind, text string) {
+	p, ok := ws.participants[handle]
+	if !ok {
+		p = &participant{words: make(map[string]int), emoji: make(map[string]int), languages: make(map[string]int), kinds: make(map[string]int)}
+		ws.participants[handle] = p
+	}
+	p.messages++
+	p.kinds[kind]++
+	p.chars += utf8.RuneCountInString(text)
+	if language := DetectLanguage(text); language != "" {
+		p.languages[language]++
+	}
+	for _, r := range text {
+		if isEmoji(r) {
+			p.emoji[string(r)]++
+		}
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isWordSeparator) {
+		word = strings.Trim(word, "'’")
+		if utf8.RuneCountInString(word) < 2 || _stopwords[word] || isNumber(word) {
+			continue
+		}
+		p.words[word]++
+	}
+}
+
+// Report returns the statistics for each participant, ordered by number of
+// messages sent.
+func (ws *WordStats) Report() []ParticipantStats {
+	report := make([]ParticipantStats, 0, len(ws.participants))
+	for handle, p := range ws.participants {
+		report = append(report, ParticipantStats{
+			Participant:   handle,
+			Messages:      p.messages,
+			AverageLength: float64(p.chars) / float64(p.messages),
+			TopWords:      top(p.words),
+			TopEmoji:      top(p.emoji),
+			Languages:     top(p.languages),
+			Kinds:         top(p.kinds),
+		})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Messages != report[j].Messages {
+			return report[i].Messages > report[j].Messages
+		}
+		return report[i].Participant < report[j].Participant
+	})
+	return report
+}
+
+// WriteJSON writes the report as an indented JSON array.
+func WriteJSON(w io.Writer, report []ParticipantStats) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(report), "encode JSON")
+}
+
+// WriteCSV writes the report as CSV with one row per participant. Top words,
+// emoji, languages, and kinds are listed like "tennis:12 dinner:5".
+func WriteCSV(w io.Writer, report []ParticipantStats) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{{"participant", "messages", "average_length", "top_words", "top_emoji", "languages", "kinds"}}
+	for _, ps := range report {
+		records = append(records, []string{
+			ps.Participant,
+			strconv.Itoa(ps.Messages),
+			strconv.FormatFloat(ps.AverageLength, 'f', 1, 64),
+			formatCounts(ps.TopWords),
+			formatCounts(ps.TopEmoji),
+			formatCounts(ps.Languages),
+			formatCounts(ps.Kinds),
+		})
+	}
+	return errors.Wrap(cw.WriteAll(records), "write CSV")
+}
+
+// WriteHTML writes the report as an HTML page with the given title, using the
+// given template, which is executed with the fields Title and Report.
+func WriteHTML(w io.Writer, tmpl *template.Template, title string, report []ParticipantStats) error {
+	return errors.Wrap(tmpl.Execute(w, struct {
+		Title  string
+		Report []ParticipantStats
+	}{title, report}), "execute HTML template")
+}
+
+// top returns the most frequent values, ties broken alphabetically.
+func top(counts map[string]int) []Count {
+	var sorted []Count
+	for value, count := range counts {
+		sorted = append(sorted, Count{Value: value, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	if len(sorted) > _topN {
+		sorted = sorted[:_topN]
+	}
+	return sorted
+}
+
+func formatCounts(counts []Count) string {
+	strs := make([]string, len(counts))
+	for i, c := range counts {
+		strs[i] = fmt.Sprintf("%s:%d", c.Value, c.Count)
+	}
+	return strings.Join(strs, " ")
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\'' && r != '’'
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if !unicode.IsNumber(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmoji reports whether the rune is in one of the main emoji blocks. Skin
+// tone modifiers, joiners, and variation selectors are not counted, so
+// multi-rune emoji are counted by their component emoji.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		return false
+	case r >= 0x1F300 && r <= 0x1FAFF,
+		r >= 0x2600 && r <= 0x27BF,
+		r >= 0x1F000 && r <= 0x1F2FF:
+		return true
+	}
+	return false
+}
+
+func makeSet(strs []string) map[string]bool {
+	set := make(map[string]bool, len(strs))
+	for _, s := range strs {
+		set[s] = true
+	}
+	return set
+}
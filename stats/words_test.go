@@ -0,0 +1,115 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/assets"
+	"gotest.tools/v3/assert"
+)
+
+func testReport() []ParticipantStats {
+	ws := NewWordStats()
+	ws.Add("Novak", "text", "Want to play tennis? 🎾")
+	ws.Add("Novak", "text", "Tennis at 5, then dinner 🎾🍝")
+	ws.Add("Novak", "emoji", "🎾")
+	ws.Add("Me", "text", "I'm in! Dinner's on me 😀")
+	return ws.Report()
+}
+
+func TestWordStats(t *testing.T) {
+	assert.DeepEqual(t, []ParticipantStats{
+		{
+			Participant:   "Novak",
+			Messages:      3,
+			AverageLength: 50.0 / 3,
+			TopWords:      []Count{{"tennis", 2}, {"dinner", 1}, {"play", 1}, {"want", 1}},
+			TopEmoji:      []Count{{"🎾", 3}, {"🍝", 1}},
+			Languages:     []Count{{"en", 2}},
+			Kinds:         []Count{{"text", 2}, {"emoji", 1}},
+		},
+		{
+			Participant:   "Me",
+			Messages:      1,
+			AverageLength: 24,
+			TopWords:      []Count{{"dinner's", 1}},
+			TopEmoji:      []Count{{"😀", 1}},
+			Languages:     []Count{{"en", 1}},
+			Kinds:         []Count{{"text", 1}},
+		},
+	}, testReport())
+}
+
+func TestTop(t *testing.T) {
+	counts := map[string]int{}
+	for i := 0; i < 2*_topN; i++ {
+		counts[fmt.Sprintf("word%02d", i)] = i % 3
+	}
+	got := top(counts)
+	assert.Equal(t, _topN, len(got))
+	assert.DeepEqual(t, Count{"word02", 2}, got[0])
+	assert.DeepEqual(t, Count{"word05", 2}, got[1])
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, WriteJSON(&b, testReport()[1:]))
+	assert.Equal(t, `[
+  {
+    "participant": "Me",
+    "messages": 1,
+    "average_length": 24,
+    "top_words": [
+      {
+        "value": "dinner's",
+        "count": 1
+      }
+    ],
+    "top_emoji": [
+      {
+        "value": "😀",
+        "count": 1
+      }
+    ],
+    "languages": [
+      {
+        "value": "en",
+        "count": 1
+      }
+    ],
+    "kinds": [
+      {
+        "value": "text",
+        "count": 1
+      }
+    ]
+  }
+]
+`, b.String())
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, WriteCSV(&b, testReport()))
+	assert.Equal(t, `participant,messages,average_length,top_words,top_emoji,languages,kinds
+Novak,3,16.7,tennis:2 dinner:1 play:1 want:1,🎾:3 🍝:1,en:2,text:2 emoji:1
+Me,1,24.0,dinner's:1,😀:1,en:1,text:1
+`, b.String())
+}
+
+func TestWriteHTML(t *testing.T) {
+	tmpl, err := assets.New(afero.NewMemMapFs(), "").HTMLTemplate("stats.html")
+	assert.NilError(t, err)
+	var b bytes.Buffer
+	assert.NilError(t, WriteHTML(&b, tmpl, "Novak & Me", testReport()))
+	html := b.String()
+	assert.Assert(t, strings.Contains(html, "<title>Novak &amp; Me</title>"))
+	assert.Assert(t, strings.Contains(html, "<tr><td>Novak</td><td>3</td><td>16.7</td><td>tennis (2) dinner (1) play (1) want (1) </td><td>🎾 (3) 🍝 (1) </td><td>en (2) </td><td>text (2) emoji (1) </td></tr>"))
+	assert.Assert(t, strings.Contains(html, "<td>dinner&#39;s (1) </td>"))
+}
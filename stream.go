@@ -0,0 +1,146 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// _stdout is where --stdout writes the selected chat.
+var _stdout io.Writer = os.Stdout
+
+// streamChat writes the single chat selected with --handle, and optionally
+// --only-groups or --only-direct, to the given writer in the txt format,
+// without creating an export folder. Attachments are not copied, but shared
+// contact cards, calendar invites, and audio transcripts are summarized as in
+// an export.
+func streamChat(opts options, s opsys.OS, cdb chatdb.ChatDB, w io.Writer) error {
+	if opts.Handle == "" {
+		return errors.New("--stdout writes a single chat - FIX: select it with --handle, e.g. --handle '+14155555555'")
+	}
+	if opts.Format != "txt" {
+		return fmt.Errorf("--stdout writes the txt format, not %s - FIX: omit --format", opts.Format)
+	}
+	filter, err := newMessageFilter(opts)
+	if err != nil {
+		return err
+	}
+	labels, err := newSelfLabels(opts, s)
+	if err != nil {
+		return err
+	}
+	macOSVersion, err := getMacOSVersion(opts, s)
+	if err != nil {
+		return err
+	}
+	contacts, err := getContacts(opts, s)
+	if err != nil {
+		return err
+	}
+	handleMap, err := cdb.GetHandleMap(contacts)
+	if err != nil {
+		return errors.Wrap(err, "get handle map")
+	}
+	chats, err := cdb.GetChatsForHandle(opts.Handle, contacts)
+	if err != nil {
+		return errors.Wrapf(err, "get chats for handle %q", opts.Handle)
+	}
+	var selected []chatdb.Chat
+	var participantIDs []int
+	for _, chat := range chats {
+		ids, err := cdb.GetParticipants(chat.ID)
+		if err != nil {
+			return errors.Wrapf(err, "get participants for chat ID %d", chat.ID)
+		}
+		if keepChatKind(opts, ids) {
+			selected, participantIDs = append(selected, chat), ids
+		}
+	}
+	switch len(selected) {
+	case 0:
+		return fmt.Errorf("no chat with handle %q to write - FIX: check the handle as stored in the Messages database, e.g. '+14155555555'", opts.Handle)
+	case 1:
+	default:
+		names := make([]string, len(selected))
+		for i, chat := range selected {
+			names[i] = fmt.Sprintf("%q", chat.DisplayName)
+		}
+		return fmt.Errorf("--stdout writes a single chat, but handle %q is in %d chats: %s - FIX: narrow the selection with --only-direct or --only-groups", opts.Handle, len(selected), strings.Join(names, ", "))
+	}
+	chat := selected[0]
+
+	messageIDs, err := cdb.GetMessageIDs(chat.ID)
+	if err != nil {
+		return errors.Wrapf(err, "get message IDs for chat ID %d", chat.ID)
+	}
+	selfLabel := labels.label(len(participantIDs) > 1)
+	chatHandleMap := handleMap
+	if len(participantIDs) > 1 {
+		chatHandleMap = cdb.DisambiguateHandles(handleMap, participantIDs)
+	}
+	msgs := make([]chatdb.Message, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		msg, err := cdb.GetMessage(messageID, chatHandleMap, macOSVersion)
+		if err != nil {
+			return errors.Wrapf(err, "get message with ID %d", messageID)
+		}
+		if !opts.OriginHints {
+			msg.Hints = nil
+		}
+		msgs = append(msgs, relabel(msg, selfLabel))
+	}
+	timeline := getParticipantTimeline(msgs, participantIDs, chatHandleMap)
+	if opts.DedupWindow > 0 {
+		msgs = dedupServiceFallbacks(msgs, time.Duration(opts.DedupWindow)*time.Second, cdb.CanonicalHandle)
+	}
+	if filter.active() {
+		msgs = filter.apply(msgs)
+	}
+	attachments, err := cdb.GetAttachmentPaths()
+	if err != nil {
+		return errors.Wrap(err, "get attachment paths")
+	}
+
+	members := []string{selfLabel}
+	for _, id := range participantIDs {
+		members = append(members, chatHandleMap[id])
+	}
+	exp := exporter.NewTxtStream(w, "standard output")
+	if _, err := exp.Begin(exporter.Chat{Chat: chat, Members: members, Participants: timeline}); err != nil {
+		return errors.Wrapf(err, "begin writing chat %q", chat.GUID)
+	}
+	for _, msg := range msgs {
+		msgAttachments := attachments[msg.ID]
+		expired, err := isExpiredAudio(s, msg, msgAttachments)
+		if err != nil {
+			return errors.Wrapf(err, "check expiration of message with ID %d", msg.ID)
+		}
+		if expired {
+			msg.Text = insertSummaries(msg.Text, []string{_expiredAudioSummary})
+			msgAttachments = nil
+		}
+		msg.Text, err = exportAttachments(s, msg.Text, msgAttachments, "", nil, opts.OCR)
+		if err != nil {
+			return errors.Wrapf(err, "summarize attachments for message with ID %d", msg.ID)
+		}
+		if err := exp.WriteMessage(msg); err != nil {
+			return errors.Wrapf(err, "write message with ID %d", msg.ID)
+		}
+	}
+	if err := exp.Finish(); err != nil {
+		return errors.Wrapf(err, "finish writing chat %q", chat.GUID)
+	}
+	logging.Infof("%d messages of chat %q written to standard output", len(msgs), chat.DisplayName)
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestStreamChat(t *testing.T) {
+	tenDotFifteen := "10.15"
+	defaultOpts := options{
+		Format:       "txt",
+		SelfHandle:   "Me",
+		MacOSVersion: &tenDotFifteen,
+		Handle:       "+14155555555",
+	}
+	direct := chatdb.Chat{ID: 1, GUID: "iMessage;-;+14155555555", DisplayName: "Novak"}
+	group := chatdb.Chat{ID: 2, GUID: "iMessage;+;chat123456", DisplayName: "Doubles"}
+
+	tests := []struct {
+		msg        string
+		opts       options
+		setupMocks func(*mock_chatdb.MockChatDB)
+		want       string
+		wantErr    string
+	}{
+		{
+			msg:  "one chat",
+			opts: defaultOpts,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(map[int]string{10: "Novak"}, nil),
+					dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return([]chatdb.Chat{direct}, nil),
+					dbMock.EXPECT().GetParticipants(1).Return([]int{10}, nil),
+					dbMock.EXPECT().GetMessageIDs(1).Return([]int{1, 2}, nil),
+					dbMock.EXPECT().GetMessage(1, map[int]string{10: "Novak"}, semver.MustParse("10.15")).Return(testMessage(1, "message %d"), nil),
+					dbMock.EXPECT().GetMessage(2, map[int]string{10: "Novak"}, semver.MustParse("10.15")).Return(testMessage(2, "message %d: \ufffc"), nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(map[int][]chatdb.Attachment{
+						2: {{ID: 1, Filename: "/attachments/jane.vcf", MIMEType: "text/vcard"}},
+					}, nil),
+				)
+			},
+			want: "[2020-03-01 15:34:05] Novak: message 1\n[2020-03-01 15:34:05] Novak: message 2: Shared contact: Jane Doe, +14155555555\n",
+		},
+		{
+			msg:  "chat selected by kind",
+			opts: options{Format: "txt", SelfHandle: "Me", MacOSVersion: &tenDotFifteen, Handle: "+14155555555", OnlyDirect: true},
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				gomock.InOrder(
+					dbMock.EXPECT().GetHandleMap(nil).Return(map[int]string{10: "Novak"}, nil),
+					dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return([]chatdb.Chat{direct, group}, nil),
+					dbMock.EXPECT().GetParticipants(1).Return([]int{10}, nil),
+					dbMock.EXPECT().GetParticipants(2).Return([]int{10, 11}, nil),
+					dbMock.EXPECT().GetMessageIDs(1).Return([]int{1}, nil),
+					dbMock.EXPECT().GetMessage(1, map[int]string{10: "Novak"}, semver.MustParse("10.15")).Return(testMessage(1, "message %d"), nil),
+					dbMock.EXPECT().GetAttachmentPaths().Return(nil, nil),
+				)
+			},
+			want: "[2020-03-01 15:34:05] Novak: message 1\n",
+		},
+		{
+			msg:     "no handle",
+			opts:    options{Format: "txt"},
+			wantErr: "--stdout writes a single chat - FIX: select it with --handle",
+		},
+		{
+			msg:     "other format",
+			opts:    options{Format: "mbox", Handle: "+14155555555"},
+			wantErr: "--stdout writes the txt format, not mbox - FIX: omit --format",
+		},
+		{
+			msg:  "no chat",
+			opts: defaultOpts,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return(nil, nil)
+			},
+			wantErr: `no chat with handle "+14155555555" to write`,
+		},
+		{
+			msg:  "several chats",
+			opts: defaultOpts,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return([]chatdb.Chat{direct, group}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return([]int{10}, nil)
+				dbMock.EXPECT().GetParticipants(2).Return([]int{10, 11}, nil)
+			},
+			wantErr: `--stdout writes a single chat, but handle "+14155555555" is in 2 chats: "Novak", "Doubles" - FIX: narrow the selection with --only-direct or --only-groups`,
+		},
+		{
+			msg:  "get chats error",
+			opts: defaultOpts,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: `get chats for handle "+14155555555": this is a DB error`,
+		},
+		{
+			msg:  "get message error",
+			opts: defaultOpts,
+			setupMocks: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+				dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return([]chatdb.Chat{direct}, nil)
+				dbMock.EXPECT().GetParticipants(1).Return([]int{10}, nil)
+				dbMock.EXPECT().GetMessageIDs(1).Return([]int{1}, nil)
+				dbMock.EXPECT().GetMessage(1, nil, semver.MustParse("10.15")).Return(chatdb.Message{}, errors.New("this is a DB error"))
+			},
+			wantErr: "get message with ID 1: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(dbMock)
+			}
+			fs := afero.NewMemMapFs()
+			afero.WriteFile(fs, "/attachments/jane.vcf", []byte("BEGIN:VCARD\nVERSION:3.0\nFN:Jane Doe\nTEL:+14155555555\nEND:VCARD\n"), 0644)
+			s := opsys.NewOS(fs, nil, nil)
+
+			var b bytes.Buffer
+			err := streamChat(tt.opts, s, dbMock, &b)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.want, b.String())
+			exist, err := afero.DirExists(fs, "backup")
+			assert.NilError(t, err)
+			assert.Assert(t, !exist, "export folder created")
+		})
+	}
+}
+
+func TestBagoupStdout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dbMock := mock_chatdb.NewMockChatDB(ctrl)
+	dbMock.EXPECT().GetHandleMap(nil).Return(nil, nil)
+	dbMock.EXPECT().GetChatsForHandle("+14155555555", nil).Return(nil, nil)
+	tenDotFifteen := "10.15"
+	opts := options{Format: "txt", MacOSVersion: &tenDotFifteen, Handle: "+14155555555", Stdout: true, ExportPath: "backup"}
+
+	var b bytes.Buffer
+	defer func(w io.Writer) { _stdout = w }(_stdout)
+	_stdout = &b
+	_, err := bagoup(opts, opsys.NewOS(afero.NewMemMapFs(), nil, nil), dbMock)
+	assert.ErrorContains(t, err, `no chat with handle "+14155555555" to write`)
+}
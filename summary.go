@@ -0,0 +1,119 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/exporter"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+const _runSummaryFilename = "run-summary.json"
+
+// runSummary describes an export run, for automated backup pipelines to check
+// its outcome.
+type runSummary struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Options      options   `json:"options"`
+	Chats        int       `json:"chats"`
+	SkippedChats int       `json:"skipped_chats"`
+	ResumedChats int       `json:"resumed_chats"`
+	// SmallChats are the chats skipped for having fewer messages than
+	// --min-messages.
+	SmallChats         []smallChat `json:"small_chats,omitempty"`
+	Messages           int         `json:"messages"`
+	Attachments        int         `json:"attachments"`
+	AttachmentProblems int         `json:"attachment_problems"`
+	Errors             []string    `json:"errors"`
+	// UndecodableMessages are the IDs of the messages whose attributed body
+	// or payload could not be fully decoded.
+	UndecodableMessages []int `json:"undecodable_messages,omitempty"`
+	// AttachmentsFromPhotos is the number of attachments which were missing
+	// from Messages and were found in the --photos-library instead.
+	AttachmentsFromPhotos int `json:"attachments_from_photos,omitempty"`
+	// AttachmentsFromICloud is the number of attachments which were only
+	// stored in iCloud and were downloaded with --icloud-download.
+	AttachmentsFromICloud int `json:"attachments_from_icloud,omitempty"`
+	// ICloudSync is set if Messages in iCloud has synced the database, which
+	// may then not include the full history of the chats.
+	ICloudSync bool `json:"icloud_sync"`
+	// RemainingChats is the number of chats left to export when the run
+	// stopped after --max-duration.
+	RemainingChats int `json:"remaining_chats,omitempty"`
+	// DanglingJoins counts the rows of chat.db which refer to messages,
+	// attachments, or participants which are missing from it.
+	DanglingJoins *danglingJoins `json:"dangling_joins,omitempty"`
+}
+
+// smallChat is a chat which was skipped for having too few messages.
+type smallChat struct {
+	GUID     string `json:"guid"`
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+}
+
+// partial checks if the run finished but skipped items which could not be
+// exported: attachments which are missing or corrupt, or messages which could
+// not be fully decoded, or left chats for --resume after --max-duration.
+func (s runSummary) partial() bool {
+	return s.AttachmentProblems > 0 || len(s.UndecodableMessages) > 0 || s.RemainingChats > 0
+}
+
+// line summarizes the run in a line, e.g. "5 messages in 2 chats exported to
+// folder "backup"; 1 attachment missing or corrupt".
+func (s runSummary) line() string {
+	line := fmt.Sprintf("%d messages in %d chats exported to folder %q", s.Messages, s.Chats, s.Options.ExportPath)
+	var notes []string
+	if s.SkippedChats > 0 {
+		notes = append(notes, fmt.Sprintf("%d chats skipped", s.SkippedChats))
+	}
+	if s.ResumedChats > 0 {
+		notes = append(notes, fmt.Sprintf("%d chats exported before", s.ResumedChats))
+	}
+	if s.RemainingChats > 0 {
+		notes = append(notes, fmt.Sprintf("%d chats left for --resume", s.RemainingChats))
+	}
+	if s.AttachmentProblems > 0 {
+		notes = append(notes, fmt.Sprintf("%d attachments missing or corrupt", s.AttachmentProblems))
+	}
+	if n := len(s.UndecodableMessages); n > 0 {
+		notes = append(notes, fmt.Sprintf("%d messages not fully decoded", n))
+	}
+	if s.DanglingJoins != nil && s.DanglingJoins.Messages > 0 {
+		notes = append(notes, fmt.Sprintf("%d messages missing from chat.db", s.DanglingJoins.Messages))
+	}
+	if len(notes) == 0 {
+		return line
+	}
+	return line + "; " + strings.Join(notes, ", ")
+}
+
+// writeRunSummary writes the summary into the export folder, creating the
+// folder if the export failed before doing so.
+func writeRunSummary(s opsys.OS, exportPath string, summary runSummary) error {
+	if summary.Errors == nil {
+		summary.Errors = []string{}
+	}
+	if err := s.MkdirAll(exportPath, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "create directory %q", exportPath)
+	}
+	return exporter.WriteFile(s, path.Join(exportPath, _runSummaryFilename), writeJSON(summary))
+}
+
+func writeJSON(v interface{}) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
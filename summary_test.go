@@ -0,0 +1,214 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteRunSummary(t *testing.T) {
+	start := time.Date(2020, 3, 1, 15, 34, 5, 0, time.UTC)
+	summary := runSummary{
+		Start:              start,
+		End:                start.Add(time.Minute),
+		Options:            options{ExportPath: "backup", Format: "txt"},
+		Chats:              2,
+		SkippedChats:       1,
+		Messages:           10,
+		Attachments:        3,
+		AttachmentProblems: 1,
+	}
+
+	tests := []struct {
+		msg         string
+		roFs        bool
+		errors      []string
+		smallChats  []smallChat
+		undecodable []int
+		icloudSync  bool
+		wantJSON    map[string]interface{}
+		wantErr     string
+	}{
+		{
+			msg: "successful export",
+			wantJSON: map[string]interface{}{
+				"start":               "2020-03-01T15:34:05Z",
+				"end":                 "2020-03-01T15:35:05Z",
+				"chats":               2.0,
+				"skipped_chats":       1.0,
+				"resumed_chats":       0.0,
+				"messages":            10.0,
+				"attachments":         3.0,
+				"attachment_problems": 1.0,
+				"errors":              []interface{}{},
+				"icloud_sync":         false,
+			},
+		},
+		{
+			msg:    "failed export",
+			errors: []string{"get chats: this is a DB error"},
+			wantJSON: map[string]interface{}{
+				"start":               "2020-03-01T15:34:05Z",
+				"end":                 "2020-03-01T15:35:05Z",
+				"chats":               2.0,
+				"skipped_chats":       1.0,
+				"resumed_chats":       0.0,
+				"messages":            10.0,
+				"attachments":         3.0,
+				"attachment_problems": 1.0,
+				"errors":              []interface{}{"get chats: this is a DB error"},
+				"icloud_sync":         false,
+			},
+		},
+		{
+			msg:        "small chats",
+			smallChats: []smallChat{{GUID: "testguid", Name: "testdisplayname", Messages: 1}},
+			wantJSON: map[string]interface{}{
+				"start":         "2020-03-01T15:34:05Z",
+				"end":           "2020-03-01T15:35:05Z",
+				"chats":         2.0,
+				"skipped_chats": 1.0,
+				"resumed_chats": 0.0,
+				"small_chats": []interface{}{
+					map[string]interface{}{"guid": "testguid", "name": "testdisplayname", "messages": 1.0},
+				},
+				"messages":            10.0,
+				"attachments":         3.0,
+				"attachment_problems": 1.0,
+				"errors":              []interface{}{},
+				"icloud_sync":         false,
+			},
+		},
+		{
+			msg:         "undecodable messages",
+			undecodable: []int{192, 200},
+			wantJSON: map[string]interface{}{
+				"start":                "2020-03-01T15:34:05Z",
+				"end":                  "2020-03-01T15:35:05Z",
+				"chats":                2.0,
+				"skipped_chats":        1.0,
+				"resumed_chats":        0.0,
+				"messages":             10.0,
+				"attachments":          3.0,
+				"attachment_problems":  1.0,
+				"errors":               []interface{}{},
+				"icloud_sync":          false,
+				"undecodable_messages": []interface{}{192.0, 200.0},
+			},
+		},
+		{
+			msg:        "icloud sync",
+			icloudSync: true,
+			wantJSON: map[string]interface{}{
+				"start":               "2020-03-01T15:34:05Z",
+				"end":                 "2020-03-01T15:35:05Z",
+				"chats":               2.0,
+				"skipped_chats":       1.0,
+				"resumed_chats":       0.0,
+				"messages":            10.0,
+				"attachments":         3.0,
+				"attachment_problems": 1.0,
+				"errors":              []interface{}{},
+				"icloud_sync":         true,
+			},
+		},
+		{
+			msg:     "read-only filesystem",
+			roFs:    true,
+			wantErr: `create directory "backup"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if tt.roFs {
+				fs = afero.NewReadOnlyFs(fs)
+			}
+			s := opsys.NewOS(fs, nil, nil)
+			summary.Errors = tt.errors
+			summary.SmallChats = tt.smallChats
+			summary.UndecodableMessages = tt.undecodable
+			summary.ICloudSync = tt.icloudSync
+
+			err := writeRunSummary(s, "backup", summary)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			b, err := afero.ReadFile(fs, "backup/run-summary.json")
+			assert.NilError(t, err)
+			var actual map[string]interface{}
+			assert.NilError(t, json.Unmarshal(b, &actual))
+			options, ok := actual["options"].(map[string]interface{})
+			assert.Assert(t, ok)
+			assert.Equal(t, "backup", options["ExportPath"])
+			delete(actual, "options")
+			assert.DeepEqual(t, tt.wantJSON, actual)
+		})
+	}
+}
+
+func TestRunSummaryLine(t *testing.T) {
+	tests := []struct {
+		msg         string
+		summary     runSummary
+		wantLine    string
+		wantPartial bool
+	}{
+		{
+			msg:      "complete export",
+			summary:  runSummary{Options: options{ExportPath: "backup"}, Chats: 2, Messages: 10},
+			wantLine: `10 messages in 2 chats exported to folder "backup"`,
+		},
+		{
+			msg:      "skipped and resumed chats",
+			summary:  runSummary{Options: options{ExportPath: "backup"}, Chats: 2, SkippedChats: 3, ResumedChats: 1, Messages: 10},
+			wantLine: `10 messages in 2 chats exported to folder "backup"; 3 chats skipped, 1 chats exported before`,
+		},
+		{
+			msg:         "stopped after max duration",
+			summary:     runSummary{Options: options{ExportPath: "backup"}, Chats: 2, ResumedChats: 1, RemainingChats: 4, Messages: 10},
+			wantLine:    `10 messages in 2 chats exported to folder "backup"; 1 chats exported before, 4 chats left for --resume`,
+			wantPartial: true,
+		},
+		{
+			msg: "partial export",
+			summary: runSummary{
+				Options:             options{ExportPath: "backup"},
+				Chats:               2,
+				Messages:            10,
+				AttachmentProblems:  1,
+				UndecodableMessages: []int{4, 7},
+			},
+			wantLine:    `10 messages in 2 chats exported to folder "backup"; 1 attachments missing or corrupt, 2 messages not fully decoded`,
+			wantPartial: true,
+		},
+		{
+			msg:         "undecodable messages",
+			summary:     runSummary{Options: options{ExportPath: "backup"}, Chats: 1, Messages: 1, UndecodableMessages: []int{1}},
+			wantLine:    `1 messages in 1 chats exported to folder "backup"; 1 messages not fully decoded`,
+			wantPartial: true,
+		},
+		{
+			msg:      "missing messages",
+			summary:  runSummary{Options: options{ExportPath: "backup"}, Chats: 1, Messages: 1, DanglingJoins: &danglingJoins{Messages: 2, Attachments: 1}},
+			wantLine: `1 messages in 1 chats exported to folder "backup"; 2 messages missing from chat.db`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.wantLine, tt.summary.line())
+			assert.Equal(t, tt.wantPartial, tt.summary.partial())
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+)
+
+// _unknownSendersDir is the folder in the export folder into which chats from
+// unknown senders are exported with --unknown-senders.
+const _unknownSendersDir = "unknown-senders"
+
+// directHandle returns the handle of the other participant of the given chat,
+// parsed from its GUID, e.g. "+14155555555" for "iMessage;-;+14155555555", or
+// an empty string if it is not a one-to-one chat.
+func directHandle(chat chatdb.Chat) string {
+	service, identifier, group := chatdb.ParseGUID(chat.GUID)
+	if group || service == "" {
+		return ""
+	}
+	return identifier
+}
+
+// senderClassifier picks out one-to-one chats from unknown senders, e.g. short
+// codes sending verification codes and spam from numbers which are not in the
+// contacts.
+type senderClassifier struct {
+	pattern  *regexp.Regexp
+	contacts chatdb.ContactResolver
+}
+
+// newSenderClassifier returns a classifier for the --unknown-senders options,
+// or nil if chats from unknown senders are exported with the others.
+func newSenderClassifier(opts options, contacts chatdb.ContactResolver) (*senderClassifier, error) {
+	if !opts.UnknownSenders {
+		return nil, nil
+	}
+	c := &senderClassifier{contacts: contacts}
+	if opts.UnknownPattern != "" {
+		var err error
+		if c.pattern, err = regexp.Compile(opts.UnknownPattern); err != nil {
+			return nil, errors.Wrapf(err, "compile --unknown-senders-pattern pattern %q", opts.UnknownPattern)
+		}
+	}
+	return c, nil
+}
+
+// dir returns the folder within the export folder in which to export the given
+// chat: the unknown senders folder if it is a one-to-one chat with a handle
+// matching the pattern, or with a handle which is not in the contacts, if
+// contacts were given.
+func (c *senderClassifier) dir(chat chatdb.Chat) string {
+	if c == nil {
+		return ""
+	}
+	handle := directHandle(chat)
+	if handle == "" {
+		return ""
+	}
+	if c.pattern != nil && c.pattern.MatchString(handle) {
+		return _unknownSendersDir
+	}
+	if c.contacts != nil && c.contacts.Contact(handle) == nil {
+		return _unknownSendersDir
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/tagatac/bagoup/chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestSenderClassifier(t *testing.T) {
+	contacts := chatdb.ContactMap{
+		"+14155555555": &vcard.Card{"FN": []*vcard.Field{{Value: "Rafael Nadal"}}},
+		"72975":        &vcard.Card{"FN": []*vcard.Field{{Value: "Bank"}}},
+	}
+
+	tests := []struct {
+		msg      string
+		opts     options
+		contacts chatdb.ContactResolver
+		guid     string
+		wantDir  string
+		wantErr  string
+	}{
+		{
+			msg:  "disabled",
+			opts: options{UnknownPattern: "^[0-9]{3,6}$"},
+			guid: "SMS;-;72975",
+		},
+		{
+			msg:     "short code",
+			opts:    options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			guid:    "SMS;-;72975",
+			wantDir: "unknown-senders",
+		},
+		{
+			msg:      "short code in contacts",
+			opts:     options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			contacts: contacts,
+			guid:     "SMS;-;72975",
+			wantDir:  "unknown-senders",
+		},
+		{
+			msg:      "number in contacts",
+			opts:     options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			contacts: contacts,
+			guid:     "iMessage;-;+14155555555",
+		},
+		{
+			msg:      "number not in contacts",
+			opts:     options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			contacts: contacts,
+			guid:     "iMessage;-;+16505555555",
+			wantDir:  "unknown-senders",
+		},
+		{
+			msg:  "number without contacts",
+			opts: options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			guid: "iMessage;-;+16505555555",
+		},
+		{
+			msg:      "group chat",
+			opts:     options{UnknownSenders: true, UnknownPattern: "^[0-9]{3,6}$"},
+			contacts: contacts,
+			guid:     "iMessage;+;chat738582366",
+		},
+		{
+			msg:     "bad pattern",
+			opts:    options{UnknownSenders: true, UnknownPattern: "("},
+			wantErr: `compile --unknown-senders-pattern pattern "("`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			c, err := newSenderClassifier(tt.opts, tt.contacts)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantDir, c.dir(chatdb.Chat{GUID: tt.guid}))
+		})
+	}
+}
+
+func TestDirectHandle(t *testing.T) {
+	for guid, want := range map[string]string{
+		"iMessage;-;+14155555555": "+14155555555",
+		"SMS;-;novak@example.com": "novak@example.com",
+		"iMessage;+;chat123456":   "",
+		"testguid":                "",
+	} {
+		assert.Equal(t, want, directHandle(chatdb.Chat{GUID: guid}), guid)
+	}
+}
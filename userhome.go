@@ -0,0 +1,28 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/logging"
+	"github.com/tagatac/bagoup/opsys"
+)
+
+// checkUserHome checks that the given home folder of another user has a
+// Messages folder, and notes whose chats are being exported.
+func checkUserHome(s opsys.OS, home string) error {
+	messagesPath := path.Join(home, "Library", "Messages")
+	exist, err := s.FileExist(messagesPath)
+	if err != nil {
+		return errors.Wrapf(err, "check Messages folder %q - FIX: run bagoup with sudo, and give your terminal full disk access: %s", messagesPath, _readmeURL)
+	}
+	if !exist {
+		return fmt.Errorf("folder %q does not exist - FIX: specify the home folder of a user of Messages with the --user-home option, e.g. /Users/jelena", messagesPath)
+	}
+	logging.Warnf("exporting the chats of the user with home folder %q - make sure that they have consented", home)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/tagatac/bagoup/opsys"
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckUserHome(t *testing.T) {
+	tests := []struct {
+		msg     string
+		setupFs func(afero.Fs)
+		osStat  func(string) (os.FileInfo, error)
+		wantErr string
+	}{
+		{
+			msg: "Messages folder",
+			setupFs: func(fs afero.Fs) {
+				fs.MkdirAll("/Users/jelena/Library/Messages", os.ModePerm)
+			},
+		},
+		{
+			msg:     "missing Messages folder",
+			setupFs: func(fs afero.Fs) {},
+			wantErr: `folder "/Users/jelena/Library/Messages" does not exist - FIX: specify the home folder of a user of Messages with the --user-home option`,
+		},
+		{
+			msg:     "unreadable Messages folder",
+			setupFs: func(fs afero.Fs) {},
+			osStat:  func(string) (os.FileInfo, error) { return nil, os.ErrPermission },
+			wantErr: `check Messages folder "/Users/jelena/Library/Messages" - FIX: run bagoup with sudo`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			tt.setupFs(fs)
+			osStat := fs.Stat
+			if tt.osStat != nil {
+				osStat = tt.osStat
+			}
+			err := checkUserHome(opsys.NewOSWithHome(fs, osStat, nil, "/Users/jelena"), "/Users/jelena")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
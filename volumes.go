@@ -0,0 +1,93 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/logging"
+)
+
+// volumePlanner splits the export into volume folders, e.g. volume-1, of at
+// most about the size given with --volume-size, e.g. for FAT32 drives or
+// optical media. Chats are added to the current volume in the order in which
+// they are exported until one does not fit, which starts the next volume, so
+// that each chat stays in a single volume with its attachments. Chats exported
+// into the same folder, e.g. the chats with one contact, share a volume.
+//
+// The sizes of the chats are estimated from all of their messages and
+// attachments, so they are upper bounds for exports which filter messages,
+// e.g. with --match, and the volumes of such exports are less full.
+type volumePlanner struct {
+	limit       int64
+	sizes       map[int]chatdb.ChatSize
+	format      string
+	attachments bool
+	volume      int
+	// used are the estimated sizes of the volumes, by number.
+	used map[int]int64
+	// dirs are the volumes of the folders in which chats were exported.
+	dirs map[string]int
+}
+
+// newVolumePlanner returns a planner for --volume-size, or nil if the export
+// is not split into volumes.
+func newVolumePlanner(cdb chatdb.ChatDB, opts options) (*volumePlanner, error) {
+	if opts.VolumeSize == 0 {
+		return nil, nil
+	}
+	if opts.VolumeSize < 0 {
+		return nil, errors.Errorf("invalid volume size %g - FIX: pass a positive number of gigabytes to --volume-size, e.g. 4", opts.VolumeSize)
+	}
+	if opts.Format == "slack" {
+		return nil, errors.New("Slack exports keep the folders of all of the chats in one workspace folder, which --volume-size cannot split - FIX: export in another format with --format, or remove --volume-size")
+	}
+	if opts.Resume {
+		return nil, errors.New("--volume-size cannot tell how full the volumes of an interrupted export are - FIX: export into a new export folder without --resume")
+	}
+	sizes, err := cdb.GetChatSizes()
+	if err != nil {
+		return nil, errors.Wrap(err, "estimate the sizes of the chats for --volume-size")
+	}
+	return &volumePlanner{
+		limit:  int64(opts.VolumeSize * 1e9),
+		sizes:  sizes,
+		format: opts.Format,
+		// Clones become copies when the volumes are moved to other drives.
+		attachments: opts.CopyAttachments || opts.CloneAttachments,
+		volume:      1,
+		used:        map[int]int64{},
+		dirs:        map[string]int{},
+	}, nil
+}
+
+// dir returns the folder within the export folder in which to export the given
+// chat, which is exported into its own folder, with the given name, in the
+// given folder within its volume. A chat is exported into the volume of an
+// earlier chat exported into the same folder, or else into the current volume,
+// starting the next volume if the chat does not fit into the current one.
+func (v *volumePlanner) dir(chat chatdb.Chat, dir, folder string) string {
+	if v == nil {
+		return dir
+	}
+	size := chatExportSize(v.sizes[chat.ID], v.format, v.attachments)
+	chatDir := path.Join(dir, folder)
+	n, ok := v.dirs[chatDir]
+	if !ok {
+		if v.used[v.volume] > 0 && v.used[v.volume]+size > v.limit {
+			v.volume++
+		}
+		n = v.volume
+		v.dirs[chatDir] = n
+	}
+	v.used[n] += size
+	volume := fmt.Sprintf("volume-%d", n)
+	if size > v.limit {
+		logging.Warnf("chat %q needs about %s, more than --volume-size, so %q is larger than the others - FIX: move some of its attachments to another volume by hand, or pass a larger --volume-size", chat.DisplayName, formatBytes(size), volume)
+	}
+	return path.Join(volume, dir)
+}
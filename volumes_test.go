@@ -0,0 +1,117 @@
+// Copyright (C) 2020 David Tagatac <david@tagatac.net>
+// See main.go for usage terms.
+
+package main
+
+import (
+	"path"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/tagatac/bagoup/chatdb"
+	"github.com/tagatac/bagoup/chatdb/mock_chatdb"
+	"gotest.tools/v3/assert"
+)
+
+func TestVolumePlanner(t *testing.T) {
+	sizes := map[int]chatdb.ChatSize{
+		1: {Messages: 10, TextBytes: 600, AttachmentBytes: 2000},
+		2: {Messages: 10, TextBytes: 600},
+		3: {Messages: 100, TextBytes: 6000},
+		4: {Messages: 10, TextBytes: 600, AttachmentBytes: 500},
+	}
+	chats := []chatdb.Chat{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+	chatDirs := []string{"Novak", "Federer", "Nadal", "Borg"}
+
+	tests := []struct {
+		msg       string
+		opts      options
+		setupMock func(*mock_chatdb.MockChatDB)
+		chatDirs  []string
+		wantDirs  []string
+		wantErr   string
+	}{
+		{
+			msg:       "no volumes",
+			opts:      options{Format: "txt"},
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+			wantDirs:  []string{"Novak", "Federer", "Nadal", "Borg"},
+		},
+		{
+			msg:  "text only",
+			opts: options{Format: "txt", VolumeSize: 0.000002},
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+			},
+			wantDirs: []string{"volume-1/Novak", "volume-1/Federer", "volume-2/Nadal", "volume-3/Borg"},
+		},
+		{
+			msg:  "shared folder",
+			opts: options{Format: "txt", VolumeSize: 0.000002},
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+			},
+			chatDirs: []string{"Novak", "Federer", "Nadal", "Novak"},
+			wantDirs: []string{"volume-1/Novak", "volume-1/Federer", "volume-2/Nadal", "volume-1/Novak"},
+		},
+		{
+			msg:  "with attachments",
+			opts: options{Format: "txt", VolumeSize: 0.000004, CloneAttachments: true},
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(sizes, nil)
+			},
+			wantDirs: []string{"volume-1/Novak", "volume-1/Federer", "volume-2/Nadal", "volume-3/Borg"},
+		},
+		{
+			msg:       "negative size",
+			opts:      options{VolumeSize: -1},
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+			wantErr:   "invalid volume size -1 - FIX: pass a positive number of gigabytes to --volume-size, e.g. 4",
+		},
+		{
+			msg:       "Slack",
+			opts:      options{Format: "slack", VolumeSize: 4},
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+			wantErr:   "Slack exports keep the folders of all of the chats in one workspace folder, which --volume-size cannot split - FIX: export in another format with --format, or remove --volume-size",
+		},
+		{
+			msg:       "resume",
+			opts:      options{Format: "txt", VolumeSize: 4, Resume: true},
+			setupMock: func(*mock_chatdb.MockChatDB) {},
+			wantErr:   "--volume-size cannot tell how full the volumes of an interrupted export are - FIX: export into a new export folder without --resume",
+		},
+		{
+			msg:  "DB error",
+			opts: options{Format: "txt", VolumeSize: 4},
+			setupMock: func(dbMock *mock_chatdb.MockChatDB) {
+				dbMock.EXPECT().GetChatSizes().Return(nil, errors.New("this is a DB error"))
+			},
+			wantErr: "estimate the sizes of the chats for --volume-size: this is a DB error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dbMock := mock_chatdb.NewMockChatDB(ctrl)
+			tt.setupMock(dbMock)
+
+			v, err := newVolumePlanner(dbMock, tt.opts)
+			if tt.wantErr != "" {
+				assert.Error(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			if tt.chatDirs == nil {
+				tt.chatDirs = chatDirs
+			}
+			var dirs []string
+			for i, chat := range chats {
+				dirs = append(dirs, path.Join(v.dir(chat, "", tt.chatDirs[i]), tt.chatDirs[i]))
+			}
+			assert.DeepEqual(t, tt.wantDirs, dirs)
+		})
+	}
+}